@@ -0,0 +1,494 @@
+package wavecarve
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// AIFF-C compression types that can be read and written
+const (
+	AiffCompressionNone    = "NONE" // big-endian PCM
+	AiffCompressionSowt    = "sowt" // little-endian PCM
+	AiffCompressionFloat32 = "fl32" // big-endian 32-bit IEEE float
+	AiffCompressionFloat64 = "fl64" // big-endian 64-bit IEEE float
+)
+
+// The timestamp of the AIFF-C version 1 specification, used in the FVER chunk
+const aifcVersion1 = 0xA2805140
+
+// Text chunks in AIFF files, and the corresponding LIST/INFO keys
+var aiffTextChunks = []struct{ id, infoKey string }{
+	{"NAME", InfoTitle},
+	{"AUTH", InfoArtist},
+	{"(c) ", InfoCopyright},
+	{"ANNO", InfoComment},
+}
+
+// Convert an 80-bit IEEE 754 extended precision number, as used for the sample rate in AIFF files, to a float64
+func extendedToFloat64(b []byte) float64 {
+	sign := 1.0
+	if b[0]&0x80 != 0 {
+		sign = -1.0
+	}
+	exponent := int(binary.BigEndian.Uint16(b[0:2]) & 0x7FFF)
+	mantissa := binary.BigEndian.Uint64(b[2:10])
+	if exponent == 0 && mantissa == 0 {
+		return 0
+	}
+	// The mantissa has an explicit integer bit, so it is scaled by 2^-63
+	return sign * math.Ldexp(float64(mantissa), exponent-16383-63)
+}
+
+// Convert a float64 to an 80-bit IEEE 754 extended precision number
+func float64ToExtended(f float64) [10]byte {
+	var b [10]byte
+	if f == 0 {
+		return b
+	}
+	var signBit uint16
+	if f < 0 {
+		signBit = 0x8000
+		f = -f
+	}
+	fraction, exponent := math.Frexp(f) // f = fraction * 2^exponent, with fraction in [0.5, 1)
+	binary.BigEndian.PutUint16(b[0:2], signBit|uint16(exponent-1+16383))
+	binary.BigEndian.PutUint64(b[2:10], uint64(math.Ldexp(fraction, 64)))
+	return b
+}
+
+// readPString reads a Pascal style string (a count byte followed by the text, padded to an even length)
+// and returns the string and the number of bytes used
+func readPString(b []byte) (string, int) {
+	if len(b) == 0 {
+		return "", 0
+	}
+	n := int(b[0])
+	if 1+n > len(b) {
+		n = len(b) - 1
+	}
+	used := 1 + n
+	if used%2 == 1 {
+		used++
+	}
+	return string(b[1 : 1+n]), used
+}
+
+// appendPString appends a Pascal style string, padded to an even length
+func appendPString(buf *bytes.Buffer, s string) {
+	if len(s) > 255 {
+		s = s[:255]
+	}
+	buf.WriteByte(byte(len(s)))
+	buf.WriteString(s)
+	if (1+len(s))%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+// ReadAiffFile reads an AIFF or AIFF-C file (with the NONE, sowt, fl32 or fl64 compression types)
+// and returns one slice of samples in the range [-1, 1] per channel, just like ReadWavFileFloat64.
+// The header describes the same audio format as a .wav file, and the markers, loops and text
+// chunks are available as cue points, sampler loops and LIST/INFO entries in the Metadata field.
+// If the file is truncated, the audio data that could be read is returned together
+// with an ErrTruncated error, which can be treated as a warning.
+func ReadAiffFile(filePath string) ([][]float64, WAVHeader, error) {
+	// Open the .aiff file
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, WAVHeader{}, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	// Read the FORM header
+	var formHeader [12]byte
+	if _, err := io.ReadFull(r, formHeader[:]); err != nil {
+		return nil, WAVHeader{}, err
+	}
+	if string(formHeader[0:4]) != "FORM" {
+		return nil, WAVHeader{}, ErrNotAIFF
+	}
+	formType := string(formHeader[8:12])
+	if formType != "AIFF" && formType != "AIFC" {
+		return nil, WAVHeader{}, ErrNotAIFF
+	}
+
+	var (
+		header      WAVHeader
+		compression = AiffCompressionNone
+		foundComm   bool
+		data        []byte
+		dataErr     error
+		markers     = make(map[int16]CuePoint)
+		markerOrder []int16
+		inst        []byte
+		metadata    = NewWavMetadata()
+	)
+
+	// Walk the chunks
+	for {
+		var chunkHeader [8]byte
+		if _, err := io.ReadFull(r, chunkHeader[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, WAVHeader{}, err
+		}
+		id := string(chunkHeader[0:4])
+		size := int64(binary.BigEndian.Uint32(chunkHeader[4:8]))
+		paddedSize := size + size&1
+
+		if id == "SSND" {
+			// The SSND chunk starts with an offset and a block size, followed by the audio data
+			var ssndHeader [8]byte
+			if _, err := io.ReadFull(r, ssndHeader[:]); err != nil {
+				return nil, WAVHeader{}, err
+			}
+			offset := int64(binary.BigEndian.Uint32(ssndHeader[0:4]))
+			if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+				return nil, WAVHeader{}, err
+			}
+			want := size - 8 - offset
+			if want > MaxDataSize {
+				return nil, WAVHeader{}, fmt.Errorf("the audio data is too large to be read into memory (the limit is %d bytes)", MaxDataSize)
+			}
+			data, err = io.ReadAll(io.LimitReader(r, want))
+			if err != nil {
+				return nil, WAVHeader{}, err
+			}
+			if int64(len(data)) < want {
+				dataErr = ErrTruncated{Want: want, Got: int64(len(data))}
+				break
+			}
+			if size&1 == 1 {
+				if _, err := io.CopyN(io.Discard, r, 1); err != nil {
+					break
+				}
+			}
+			continue
+		}
+
+		// The other chunks are small enough to be read into memory
+		if size > MaxMetadataChunkSize {
+			if _, err := io.CopyN(io.Discard, r, paddedSize); err != nil {
+				break
+			}
+			continue
+		}
+		chunk := make([]byte, paddedSize)
+		if n, err := io.ReadFull(r, chunk); err != nil && int64(n) < size {
+			return nil, WAVHeader{}, err
+		}
+		chunk = chunk[:size]
+
+		switch id {
+		case "COMM":
+			if len(chunk) < 18 {
+				return nil, WAVHeader{}, fmt.Errorf("the COMM chunk is too small: %d bytes", len(chunk))
+			}
+			numChannels := binary.BigEndian.Uint16(chunk[0:2])
+			sampleSize := binary.BigEndian.Uint16(chunk[6:8])
+			sampleRate := extendedToFloat64(chunk[8:18])
+			if formType == "AIFC" && len(chunk) >= 22 {
+				compression = string(chunk[18:22])
+			}
+			if numChannels == 0 || sampleRate <= 0 || sampleRate > math.MaxUint32 {
+				return nil, WAVHeader{}, fmt.Errorf("invalid COMM chunk: %d channels at %g Hz", numChannels, sampleRate)
+			}
+			audioFormat := uint16(WaveFormatPCM)
+			switch compression {
+			case AiffCompressionNone, AiffCompressionSowt:
+			case AiffCompressionFloat32, "FL32":
+				compression, audioFormat, sampleSize = AiffCompressionFloat32, WaveFormatIEEEFloat, 32
+			case AiffCompressionFloat64, "FL64":
+				compression, audioFormat, sampleSize = AiffCompressionFloat64, WaveFormatIEEEFloat, 64
+			default:
+				return nil, WAVHeader{}, fmt.Errorf("unsupported AIFF-C compression type: %q", compression)
+			}
+			header = NewWAVHeader(audioFormat, numChannels, uint32(math.Round(sampleRate)), sampleSize)
+			foundComm = true
+		case "MARK":
+			if len(chunk) < 2 {
+				continue
+			}
+			count := int(binary.BigEndian.Uint16(chunk[0:2]))
+			pos := 2
+			for i := 0; i < count && pos+6 < len(chunk); i++ {
+				markerID := int16(binary.BigEndian.Uint16(chunk[pos : pos+2]))
+				position := binary.BigEndian.Uint32(chunk[pos+2 : pos+6])
+				name, used := readPString(chunk[pos+6:])
+				markers[markerID] = CuePoint{ID: uint32(uint16(markerID)), Position: position, Label: name}
+				markerOrder = append(markerOrder, markerID)
+				pos += 6 + used
+			}
+		case "INST":
+			inst = chunk
+		default:
+			for _, text := range aiffTextChunks {
+				if id == text.id {
+					metadata.SetInfo(text.infoKey, cString(chunk))
+				}
+			}
+		}
+	}
+
+	if !foundComm {
+		return nil, WAVHeader{}, errors.New("no COMM chunk found")
+	}
+	if data == nil {
+		return nil, WAVHeader{}, errors.New("no SSND chunk found")
+	}
+
+	// Markers are exposed as cue points, and the loops of the INST chunk as sampler loops
+	for _, markerID := range markerOrder {
+		metadata.CuePoints = append(metadata.CuePoints, markers[markerID])
+	}
+	if len(inst) >= 20 {
+		metadata.Sampler = &SamplerInfo{MIDIUnityNote: uint32(int8(inst[0]))}
+		if header.SampleRate > 0 {
+			metadata.Sampler.SamplePeriod = uint32(1e9 / float64(header.SampleRate))
+		}
+		for _, loop := range [][]byte{inst[8:14], inst[14:20]} {
+			playMode := binary.BigEndian.Uint16(loop[0:2])
+			begin, okBegin := markers[int16(binary.BigEndian.Uint16(loop[2:4]))]
+			end, okEnd := markers[int16(binary.BigEndian.Uint16(loop[4:6]))]
+			if playMode == 0 || !okBegin || !okEnd || end.Position <= begin.Position {
+				continue
+			}
+			loopType := uint32(0) // forward
+			if playMode == 2 {
+				loopType = 1 // alternating
+			}
+			// The end marker in AIFF is after the last sample frame of the loop, while the end of a smpl loop is inclusive
+			metadata.Sampler.Loops = append(metadata.Sampler.Loops, SampleLoop{
+				CuePointID: begin.ID,
+				Type:       loopType,
+				Start:      begin.Position,
+				End:        end.Position - 1,
+			})
+		}
+	}
+	if len(metadata.Info) > 0 || len(metadata.CuePoints) > 0 || metadata.Sampler != nil {
+		header.Metadata = metadata
+	}
+
+	// Convert the audio data to float64s
+	order := binary.ByteOrder(binary.BigEndian)
+	if compression == AiffCompressionSowt {
+		order = binary.LittleEndian
+	}
+	float64s, err := bytesToFloat64s(data, header.SampleFormat(), header.BytesPerSample(), order, false)
+	if err != nil {
+		return nil, WAVHeader{}, err
+	}
+	return DeinterleaveFloat64s(float64s, int(header.NumChannels)), header, dataErr
+}
+
+// WriteAiffFile writes an AIFF file with big-endian PCM samples, or an AIFF-C
+// file with the fl32 or fl64 compression type if the header is for IEEE float samples.
+// The LIST/INFO entries, cue points and sampler loops in the Metadata field are written
+// as text chunks, markers and loops. PCM samples are quantized with DefaultQuantizeOptions,
+// and if any samples clipped, an ErrClipped error is returned after the file has been written.
+func WriteAiffFile(filePath string, channels [][]float64, header WAVHeader) error {
+	return writeAiffFile(filePath, channels, header, DefaultQuantizeOptions)
+}
+
+// writeAiffFile writes an AIFF or AIFF-C file, depending on the sample format in the header
+func writeAiffFile(filePath string, channels [][]float64, header WAVHeader, options QuantizeOptions) error {
+	compression := AiffCompressionNone
+	if header.SampleFormat() == WaveFormatIEEEFloat {
+		compression = AiffCompressionFloat32
+		if header.BitsPerSample == 64 {
+			compression = AiffCompressionFloat64
+		}
+		return writeAiff(filePath, channels, header, "AIFC", compression, options)
+	}
+	return writeAiff(filePath, channels, header, "AIFF", compression, options)
+}
+
+// WriteAiffcFile writes an AIFF-C file with the given compression type, which can be
+// AiffCompressionNone, AiffCompressionSowt, AiffCompressionFloat32 or AiffCompressionFloat64.
+// The bit depth for PCM samples is taken from the header.
+func WriteAiffcFile(filePath string, channels [][]float64, header WAVHeader, compression string) error {
+	return writeAiff(filePath, channels, header, "AIFC", compression, DefaultQuantizeOptions)
+}
+
+// writeAiff writes an AIFF or AIFF-C file
+func writeAiff(filePath string, channels [][]float64, header WAVHeader, formType, compression string, options QuantizeOptions) error {
+	if len(channels) == 0 {
+		return errors.New("no channels to write")
+	}
+
+	// Find the sample format and byte order for the compression type
+	audioFormat, bits, order := uint16(WaveFormatPCM), header.BitsPerSample, binary.ByteOrder(binary.BigEndian)
+	compressionName := "not compressed"
+	switch compression {
+	case AiffCompressionNone:
+	case AiffCompressionSowt:
+		order = binary.LittleEndian
+		compressionName = ""
+	case AiffCompressionFloat32:
+		audioFormat, bits, compressionName = WaveFormatIEEEFloat, 32, "32-bit floating point"
+	case AiffCompressionFloat64:
+		audioFormat, bits, compressionName = WaveFormatIEEEFloat, 64, "64-bit floating point"
+	default:
+		return fmt.Errorf("unsupported AIFF-C compression type: %q", compression)
+	}
+	bytesPerSample := (int(bits) + 7) / 8
+	q := NewQuantizer(bytesPerSample*8, len(channels), options)
+	data, err := float64sToBytes(InterleaveFloat64s(channels), audioFormat, bytesPerSample, order, false, q)
+	if err != nil {
+		return err
+	}
+	numSampleFrames := len(data) / (bytesPerSample * len(channels))
+
+	var body bytes.Buffer
+	be := binary.BigEndian
+	body.WriteString(formType)
+
+	// FVER, which is required for AIFF-C
+	if formType == "AIFC" {
+		fver := make([]byte, 4)
+		be.PutUint32(fver, aifcVersion1)
+		appendAiffChunk(&body, "FVER", fver)
+	}
+
+	// COMM
+	var comm bytes.Buffer
+	binary.Write(&comm, be, uint16(len(channels)))
+	binary.Write(&comm, be, uint32(numSampleFrames))
+	binary.Write(&comm, be, bits)
+	sampleRate := float64ToExtended(float64(header.SampleRate))
+	comm.Write(sampleRate[:])
+	if formType == "AIFC" {
+		comm.WriteString(compression)
+		appendPString(&comm, compressionName)
+	}
+	appendAiffChunk(&body, "COMM", comm.Bytes())
+
+	// Text chunks, markers and loops
+	if m := header.Metadata; m != nil {
+		for _, text := range aiffTextChunks {
+			if value := m.InfoValue(text.infoKey); value != "" {
+				appendAiffChunk(&body, text.id, []byte(value))
+			}
+		}
+		mark, inst := m.aiffMarkers()
+		if mark != nil {
+			appendAiffChunk(&body, "MARK", mark)
+		}
+		if inst != nil {
+			appendAiffChunk(&body, "INST", inst)
+		}
+	}
+
+	// SSND, with an offset and block size of 0
+	ssnd := make([]byte, 8, 8+len(data))
+	ssnd = append(ssnd, data...)
+	appendAiffChunk(&body, "SSND", ssnd)
+
+	// Open the .aiff file
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var formHeader [8]byte
+	copy(formHeader[0:4], "FORM")
+	be.PutUint32(formHeader[4:8], uint32(body.Len()))
+	if _, err := file.Write(formHeader[:]); err != nil {
+		return err
+	}
+	if _, err := file.Write(body.Bytes()); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	// Report the samples that clipped
+	return q.err()
+}
+
+// appendAiffChunk appends a chunk with a big-endian size, followed by a pad byte if needed
+func appendAiffChunk(buf *bytes.Buffer, id string, data []byte) {
+	buf.WriteString(id)
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+	if len(data)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+// aiffMarkers returns the contents of a MARK chunk for the cue points and the
+// loop points, and the contents of an INST chunk for the first two sampler loops.
+// Either may be nil.
+func (m *WavMetadata) aiffMarkers() ([]byte, []byte) {
+	type marker struct {
+		id       int16
+		position uint32
+		name     string
+	}
+	var markers []marker
+	nextID := int16(1)
+	addMarker := func(position uint32, name string) int16 {
+		markers = append(markers, marker{nextID, position, name})
+		nextID++
+		return nextID - 1
+	}
+	for _, cue := range m.CuePoints {
+		addMarker(cue.Position, cue.Label)
+	}
+	// Loop points use existing markers at the same position, if there are any
+	findOrAddMarker := func(position uint32, name string) int16 {
+		for _, mk := range markers {
+			if mk.position == position {
+				return mk.id
+			}
+		}
+		return addMarker(position, name)
+	}
+
+	// The first loop is the sustain loop and the second loop is the release loop
+	var inst []byte
+	if m.Sampler != nil && len(m.Sampler.Loops) > 0 {
+		inst = make([]byte, 20)
+		inst[0] = byte(m.Sampler.MIDIUnityNote)
+		inst[3] = 127 // high note
+		inst[4] = 1   // low velocity
+		inst[5] = 127 // high velocity
+		for i, loop := range m.Sampler.Loops {
+			if i == 2 {
+				break
+			}
+			playMode := uint16(1) // forward
+			if loop.Type == 1 {
+				playMode = 2 // forward/backward
+			}
+			begin := findOrAddMarker(loop.Start, fmt.Sprintf("loop %d start", i+1))
+			end := findOrAddMarker(loop.End+1, fmt.Sprintf("loop %d end", i+1))
+			p := inst[8+i*6 : 14+i*6]
+			binary.BigEndian.PutUint16(p[0:2], playMode)
+			binary.BigEndian.PutUint16(p[2:4], uint16(begin))
+			binary.BigEndian.PutUint16(p[4:6], uint16(end))
+		}
+	}
+
+	if len(markers) == 0 {
+		return nil, inst
+	}
+	var mark bytes.Buffer
+	binary.Write(&mark, binary.BigEndian, uint16(len(markers)))
+	for _, mk := range markers {
+		binary.Write(&mark, binary.BigEndian, mk.id)
+		binary.Write(&mark, binary.BigEndian, mk.position)
+		appendPString(&mark, mk.name)
+	}
+	return mark.Bytes(), inst
+}
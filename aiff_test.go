@@ -0,0 +1,162 @@
+package wavecarve
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtended(t *testing.T) {
+	tests := []struct {
+		f    float64
+		want [10]byte
+	}{
+		{0, [10]byte{}},
+		{8000, [10]byte{0x40, 0x0b, 0xfa}},
+		{22050, [10]byte{0x40, 0x0d, 0xac, 0x44}},
+		{44100, [10]byte{0x40, 0x0e, 0xac, 0x44}},
+		{48000, [10]byte{0x40, 0x0e, 0xbb, 0x80}},
+		{96000, [10]byte{0x40, 0x0f, 0xbb, 0x80}},
+		{11025.5, [10]byte{0x40, 0x0c, 0xac, 0x46}},
+		{-1.5, [10]byte{0xbf, 0xff, 0xc0}},
+	}
+	for _, tt := range tests {
+		if got := float64ToExtended(tt.f); got != tt.want {
+			t.Errorf("float64ToExtended(%v) is % x, want % x", tt.f, got, tt.want)
+		}
+		if got := extendedToFloat64(tt.want[:]); got != tt.f {
+			t.Errorf("extendedToFloat64(% x) is %v, want %v", tt.want, got, tt.f)
+		}
+	}
+}
+
+func TestAiffRoundTrip(t *testing.T) {
+	tests := []struct {
+		name          string
+		compression   string // "" for an AIFF file
+		audioFormat   uint16
+		bitsPerSample uint16
+	}{
+		{"test.aiff", "", WaveFormatPCM, 8},
+		{"test.aiff", "", WaveFormatPCM, 16},
+		{"test.aif", "", WaveFormatPCM, 24},
+		{"test.aiff", "", WaveFormatPCM, 32},
+		{"test.aifc", AiffCompressionNone, WaveFormatPCM, 16},
+		{"test.aifc", AiffCompressionSowt, WaveFormatPCM, 16},
+		{"test.aifc", AiffCompressionSowt, WaveFormatPCM, 24},
+		{"test.aifc", AiffCompressionFloat32, WaveFormatIEEEFloat, 32},
+		{"test.aifc", AiffCompressionFloat64, WaveFormatIEEEFloat, 64},
+	}
+	for _, tt := range tests {
+		// Samples that can be stored exactly with the bit depth, so that they are read back unchanged
+		bits := tt.bitsPerSample
+		if tt.audioFormat == WaveFormatIEEEFloat {
+			bits = 16
+		}
+		scale := float64(int64(1) << (bits - 1))
+		channels := make([][]float64, 2)
+		for c := range channels {
+			channels[c] = make([]float64, 1001)
+			for i := range channels[c] {
+				channels[c][i] = math.Round(0.6*math.Sin(float64(i)*0.01*float64(c+1))*scale) / scale
+			}
+		}
+		header := NewWAVHeader(tt.audioFormat, 2, 44100, tt.bitsPerSample)
+		filePath := filepath.Join(t.TempDir(), tt.name)
+		var err error
+		if tt.compression == "" {
+			err = WriteAiffFile(filePath, channels, header)
+		} else {
+			err = WriteAiffcFile(filePath, channels, header, tt.compression)
+		}
+		if err != nil {
+			t.Fatalf("%s %s %d bits: %v", tt.name, tt.compression, tt.bitsPerSample, err)
+		}
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		formType := "AIFF"
+		if tt.compression != "" {
+			formType = "AIFC"
+		}
+		if string(data[8:12]) != formType || !bytes.Contains(data, []byte("COMM")) || !bytes.Contains(data, []byte(tt.compression)) {
+			t.Errorf("%s %s %d bits: the file is not an %s file with the %q compression type", tt.name, tt.compression, tt.bitsPerSample, formType, tt.compression)
+		}
+
+		read, readHeader, err := ReadAiffFile(filePath)
+		if err != nil {
+			t.Fatalf("%s %s %d bits: %v", tt.name, tt.compression, tt.bitsPerSample, err)
+		}
+		if readHeader.SampleRate != 44100 || readHeader.SampleFormat() != tt.audioFormat || readHeader.BitsPerSample != tt.bitsPerSample {
+			t.Errorf("%s %s %d bits: read format %d with %d bits at %d Hz", tt.name, tt.compression, tt.bitsPerSample, readHeader.SampleFormat(), readHeader.BitsPerSample, readHeader.SampleRate)
+		}
+		if len(read) != len(channels) || len(read[0]) != len(channels[0]) {
+			t.Fatalf("%s %s %d bits: read %d channel(s) of %d samples", tt.name, tt.compression, tt.bitsPerSample, len(read), len(read[0]))
+		}
+		for c := range channels {
+			for i, want := range channels[c] {
+				if got := read[c][i]; got != want {
+					t.Fatalf("%s %s %d bits: sample %d of channel %d is %v, want %v", tt.name, tt.compression, tt.bitsPerSample, i, c, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestAiffMarkersAndLoops(t *testing.T) {
+	metadata := NewWavMetadata()
+	metadata.SetInfo(InfoTitle, "Title")
+	metadata.SetInfo(InfoArtist, "Artist")
+	metadata.AddCuePoint(100, "Start")
+	metadata.AddCuePoint(500, "Middle")
+	metadata.Sampler = &SamplerInfo{MIDIUnityNote: 64}
+	metadata.Sampler.Loops = []SampleLoop{
+		{Type: 0, Start: 100, End: 399},
+		{Type: 1, Start: 500, End: 899},
+		{Type: 0, Start: 0, End: 99}, // AIFF files only have a sustain and a release loop
+	}
+	header := NewWAVHeader(WaveFormatPCM, 1, 16000, 16)
+	header.Metadata = metadata
+	filePath := filepath.Join(t.TempDir(), "test.aiff")
+	if err := WriteAiffFile(filePath, [][]float64{make([]float64, 1000)}, header); err != nil {
+		t.Fatal(err)
+	}
+	_, readHeader, err := ReadAiffFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := readHeader.Metadata
+	if m == nil {
+		t.Fatal("no metadata was read")
+	}
+	if m.InfoValue(InfoTitle) != "Title" || m.InfoValue(InfoArtist) != "Artist" {
+		t.Errorf("got the text chunks %v", m.Info)
+	}
+
+	// The cue points come first, followed by the markers for the loop ends, and the loop starts reuse the cue points
+	wantCues := []CuePoint{{ID: 1, Position: 100, Label: "Start"}, {ID: 2, Position: 500, Label: "Middle"}, {ID: 3, Position: 400, Label: "loop 1 end"}, {ID: 4, Position: 900, Label: "loop 2 end"}}
+	if len(m.CuePoints) != len(wantCues) {
+		t.Fatalf("got the cue points %+v, want %+v", m.CuePoints, wantCues)
+	}
+	for i, cue := range m.CuePoints {
+		if cue != wantCues[i] {
+			t.Errorf("cue point %d is %+v, want %+v", i, cue, wantCues[i])
+		}
+	}
+
+	if m.Sampler == nil || m.Sampler.MIDIUnityNote != 64 || m.Sampler.SamplePeriod != 62500 {
+		t.Fatalf("got the sampler info %+v", m.Sampler)
+	}
+	wantLoops := []SampleLoop{{CuePointID: 1, Type: 0, Start: 100, End: 399}, {CuePointID: 2, Type: 1, Start: 500, End: 899}}
+	if len(m.Sampler.Loops) != len(wantLoops) {
+		t.Fatalf("got the loops %+v, want %+v", m.Sampler.Loops, wantLoops)
+	}
+	for i, loop := range m.Sampler.Loops {
+		if loop != wantLoops[i] {
+			t.Errorf("loop %d is %+v, want %+v", i, loop, wantLoops[i])
+		}
+	}
+}
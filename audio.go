@@ -0,0 +1,304 @@
+package wavecarve
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"time"
+)
+
+// Speaker positions for the channel mask of an Audio or a WAVE_FORMAT_EXTENSIBLE header.
+// The channels are stored in the order of these bits.
+const (
+	SpeakerFrontLeft          = 0x1
+	SpeakerFrontRight         = 0x2
+	SpeakerFrontCenter        = 0x4
+	SpeakerLowFrequency       = 0x8
+	SpeakerBackLeft           = 0x10
+	SpeakerBackRight          = 0x20
+	SpeakerFrontLeftOfCenter  = 0x40
+	SpeakerFrontRightOfCenter = 0x80
+	SpeakerBackCenter         = 0x100
+	SpeakerSideLeft           = 0x200
+	SpeakerSideRight          = 0x400
+	SpeakerTopCenter          = 0x800
+	SpeakerTopFrontLeft       = 0x1000
+	SpeakerTopFrontCenter     = 0x2000
+	SpeakerTopFrontRight      = 0x4000
+	SpeakerTopBackLeft        = 0x8000
+	SpeakerTopBackCenter      = 0x10000
+	SpeakerTopBackRight       = 0x20000
+)
+
+// Audio is audio data with one slice of samples in the range [-1, 1] per channel,
+// together with everything that is needed to process it and to write it to a file
+type Audio struct {
+	Channels   [][]float64
+	SampleRate uint32
+
+	// ChannelMask holds the speaker positions of the channels (like SpeakerFrontLeft),
+	// or 0 if the layout is not known. Files with a channel mask are written as
+	// WAVE_FORMAT_EXTENSIBLE files.
+	ChannelMask uint32
+
+	// The sample format (WaveFormatPCM or WaveFormatIEEEFloat) and bit depth that is used when the
+	// audio is written. When the audio is read from a file, this is the format of the file.
+	// If BitsPerSample is 0, 16-bit PCM is used.
+	SampleFormat  uint16
+	BitsPerSample uint16
+
+	// Metadata that is written together with the audio, or nil
+	Metadata *WavMetadata
+}
+
+// NewAudio returns silent audio with the given number of channels, samples per channel and sample rate,
+// which is written as 16-bit PCM
+func NewAudio(numChannels, frames int, sampleRate uint32) *Audio {
+	channels := make([][]float64, numChannels)
+	for c := range channels {
+		channels[c] = make([]float64, frames)
+	}
+	return &Audio{Channels: channels, SampleRate: sampleRate, SampleFormat: WaveFormatPCM, BitsPerSample: 16}
+}
+
+// AudioFromFloat64s returns audio for one slice of samples in the range [-1, 1] per channel, in the format of the header
+func AudioFromFloat64s(channels [][]float64, header WAVHeader) *Audio {
+	return &Audio{
+		Channels:      channels,
+		SampleRate:    header.SampleRate,
+		ChannelMask:   header.ChannelMask,
+		SampleFormat:  header.SampleFormat(),
+		BitsPerSample: header.BitsPerSample,
+		Metadata:      header.Metadata,
+	}
+}
+
+// AudioFromInt16s returns audio for interleaved 16-bit samples, as returned by ReadWavFile,
+// with the sample rate, channel count and metadata of the header
+func AudioFromInt16s(int16s []int16, header WAVHeader) *Audio {
+	audio := AudioFromFloat64s(int16ChannelsToFloat64s(Deinterleave(int16s, int(header.NumChannels))), header)
+	audio.SampleFormat, audio.BitsPerSample = WaveFormatPCM, 16
+	return audio
+}
+
+// ReadAudio reads a .wav, .aif, .aiff, .aifc or .flac file, just like ReadWavFileFloat64.
+// If the file is truncated, the audio data that could be read is returned together
+// with an ErrTruncated error, which can be treated as a warning.
+func ReadAudio(filePath string) (*Audio, error) {
+	channels, header, err := ReadWavFileFloat64(filePath)
+	if channels == nil {
+		return nil, err
+	}
+	return AudioFromFloat64s(channels, header), err
+}
+
+// WriteAudio writes the audio to a .wav, .aif, .aiff, .aifc or .flac file, just like
+// WriteWavFileFloat64, in the format given by the SampleFormat and BitsPerSample fields.
+// If any samples clipped, an ErrClipped error is returned after the file has been written.
+func WriteAudio(filePath string, audio *Audio) error {
+	return WriteAudioWithOptions(filePath, audio, DefaultQuantizeOptions)
+}
+
+// WriteAudioWithOptions is like WriteAudio, but integer samples are quantized
+// with the given clipping, dither and noise shaping options.
+func WriteAudioWithOptions(filePath string, audio *Audio, options QuantizeOptions) error {
+	if audio.NumChannels() == 0 {
+		return errors.New("no channels to write")
+	}
+	return WriteWavFileFloat64WithOptions(filePath, audio.Channels, audio.Header(), options)
+}
+
+// NumChannels returns the number of channels
+func (a *Audio) NumChannels() int {
+	return len(a.Channels)
+}
+
+// Frames returns the number of samples per channel. If the channels differ in length, the shortest length is used.
+func (a *Audio) Frames() int {
+	if len(a.Channels) == 0 {
+		return 0
+	}
+	frames := len(a.Channels[0])
+	for _, channel := range a.Channels[1:] {
+		if len(channel) < frames {
+			frames = len(channel)
+		}
+	}
+	return frames
+}
+
+// Duration returns the duration of the audio
+func (a *Audio) Duration() time.Duration {
+	return a.Header().Duration(a.Frames())
+}
+
+// Header returns a header that describes the audio, for writing it to a file
+func (a *Audio) Header() WAVHeader {
+	format, bits := a.SampleFormat, a.BitsPerSample
+	if bits == 0 {
+		format, bits = WaveFormatPCM, 16
+	}
+	header := NewWAVHeader(format, uint16(a.NumChannels()), a.SampleRate, bits)
+	if a.ChannelMask != 0 {
+		header.ChannelMask = a.ChannelMask
+		header.MakeExtensible()
+	}
+	header.Metadata = a.Metadata
+	return header
+}
+
+// Int16s returns the audio as interleaved 16-bit samples, together with a header for 16-bit PCM,
+// which is the form that is used by ReadWavFile and WriteWavFile. The samples are quantized with
+// DefaultQuantizeOptions, and if any samples clipped, an ErrClipped error is returned together with them.
+func (a *Audio) Int16s() ([]int16, WAVHeader, error) {
+	header := a.Header()
+	header.BitsPerSample = 16
+	if header.AudioFormat == WaveFormatExtensible {
+		header.SubFormat = SubFormatGUID(WaveFormatPCM)
+		header.ValidBitsPerSample = 16
+	} else {
+		header.AudioFormat = WaveFormatPCM
+	}
+	header.BlockAlign = header.NumChannels * 2
+	header.ByteRate = header.SampleRate * uint32(header.BlockAlign)
+
+	q := NewQuantizer(16, a.NumChannels(), DefaultQuantizeOptions)
+	return float64sToInt16s(InterleaveFloat64s(a.Channels), q), header, q.err()
+}
+
+// WithChannels returns a copy of the audio with other samples, but with the same
+// sample rate, channel layout, sample format and a copy of the metadata
+func (a *Audio) WithChannels(channels [][]float64) *Audio {
+	audio := *a
+	audio.Channels = channels
+	audio.Metadata = a.Metadata.Clone()
+	return &audio
+}
+
+// WithResizedChannels is like WithChannels, but for samples that have been made shorter or longer,
+// like by carving. The positions of the cue points and loops in the metadata are scaled to the new
+// length, and the ones that end up past the end are removed.
+func (a *Audio) WithResizedChannels(channels [][]float64) *Audio {
+	audio := a.WithChannels(channels)
+	if frames := a.Frames(); frames > 0 {
+		audio.Metadata.scalePositions(float64(audio.Frames())/float64(frames), audio.Frames())
+	}
+	return audio
+}
+
+// Resample returns a copy of the audio at the given sample rate, using Resample for each channel.
+// The positions in the metadata, like cue points, loops and the bext time reference, are moved to the new rate.
+func (a *Audio) Resample(sampleRate uint32, quality ResampleQuality) *Audio {
+	resampled := a.WithChannels(ResampleChannels(a.Channels, a.SampleRate, sampleRate, quality))
+	resampled.SampleRate = sampleRate
+	resampled.Metadata.resample(a.SampleRate, sampleRate, resampled.Frames())
+	return resampled
+}
+
+// Spectrograms creates one full-precision spectrogram per channel, with the given STFT options
+func (a *Audio) Spectrograms(options SpectrogramOptions) ([]*Spectrogram, error) {
+	spectrograms := make([]*Spectrogram, a.NumChannels())
+	for c, channel := range a.Channels {
+		spectrogram, err := NewSpectrogram(channel, a.SampleRate, options)
+		if err != nil {
+			return nil, err
+		}
+		spectrograms[c] = spectrogram
+	}
+	return spectrograms, nil
+}
+
+// AudioFromSpectrograms creates audio from one full-precision spectrogram per channel, with the
+// sample rate of the first spectrogram. The phase is found with DefaultPhaseOptions, which keeps
+// the phase of the spectrograms by default. The samples are not quantized.
+func AudioFromSpectrograms(spectrograms []*Spectrogram) (*Audio, error) {
+	return AudioFromSpectrogramsWithPhase(spectrograms, DefaultPhaseOptions)
+}
+
+// AudioFromSpectrogramsWithPhase is like AudioFromSpectrograms, but the phase is found with
+// the given phase options, unless their Method is PhaseKeep
+func AudioFromSpectrogramsWithPhase(spectrograms []*Spectrogram, phase PhaseOptions) (*Audio, error) {
+	if len(spectrograms) == 0 {
+		return nil, errors.New("no spectrograms to create audio from")
+	}
+	audio := NewAudio(0, 0, spectrograms[0].SampleRate)
+	audio.Channels = make([][]float64, len(spectrograms))
+	for c, spectrogram := range spectrograms {
+		if phase.Method != PhaseKeep {
+			var err error
+			if spectrogram, err = spectrogram.RetrievePhase(phase); err != nil {
+				return nil, fmt.Errorf("channel %d: %w", c, err)
+			}
+		}
+		audio.Channels[c] = spectrogram.Samples()
+	}
+	return audio, nil
+}
+
+// CreateSpectrograms creates one spectrogram per channel, just like CreateSpectrogramFromAudio,
+// but from the float64 samples, without converting them to 16 bits first
+func (a *Audio) CreateSpectrograms() ([]*image.RGBA, error) {
+	imgs := make([]*image.RGBA, a.NumChannels())
+	for c, channel := range a.Channels {
+		img, err := createSpectrogram(channel, DefaultSpectrogramOptions)
+		if err != nil {
+			return nil, err
+		}
+		imgs[c] = img
+	}
+	return imgs, nil
+}
+
+// CreateAudioFromSpectrograms creates audio with the given sample rate from one spectrogram per
+// channel, just like CreateAudioFromSpectrogram. The samples are not quantized, so resynthesised
+// audio that overshoots is kept until the audio is written.
+func CreateAudioFromSpectrograms(imgs []*image.RGBA, sampleRate uint32) (*Audio, error) {
+	return CreateAudioFromSpectrogramsWithPhase(imgs, sampleRate, DefaultPhaseOptions)
+}
+
+// CreateAudioFromSpectrogramsWithPhase is like CreateAudioFromSpectrograms, but the phase is found
+// with the given phase options, unless their Method is PhaseKeep
+func CreateAudioFromSpectrogramsWithPhase(imgs []*image.RGBA, sampleRate uint32, phase PhaseOptions) (*Audio, error) {
+	if len(imgs) == 0 {
+		return nil, errors.New("no spectrograms to create audio from")
+	}
+	audio := NewAudio(0, 0, sampleRate)
+	audio.Channels = make([][]float64, len(imgs))
+	for c, img := range imgs {
+		channel, err := createAudio(img, DefaultSpectrogramOptions, phase)
+		if err != nil {
+			return nil, fmt.Errorf("channel %d: %w", c, err)
+		}
+		audio.Channels[c] = channel
+	}
+	return audio, nil
+}
+
+// CarveAudio creates one full-precision spectrogram per channel with DefaultSpectrogramOptions,
+// removes seams from them with CarveSpectrograms to reduce their width by the given percentage,
+// and creates audio from the carved spectrograms. The carved audio is returned together with
+// images of the carved spectrograms. The sample format and channel layout are kept, and the positions
+// in the metadata are scaled to the new length, like with WithResizedChannels.
+func CarveAudio(audio *Audio, newWidthInPercentage float64) (*Audio, []*image.RGBA, error) {
+	spectrograms, err := audio.Spectrograms(DefaultSpectrogramOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	carved, err := CarveSpectrograms(spectrograms, newWidthInPercentage)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not carve seams: %w", err)
+	}
+	mapping, err := DefaultMagnitudeMapping.Resolve(carved)
+	if err != nil {
+		return nil, nil, err
+	}
+	imgs := make([]*image.RGBA, len(carved))
+	for c, spectrogram := range carved {
+		imgs[c] = spectrogram.toRGBA(mapping)
+	}
+	carvedAudio, err := AudioFromSpectrograms(carved)
+	if err != nil {
+		return nil, nil, err
+	}
+	return audio.WithResizedChannels(carvedAudio.Channels), imgs, nil
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"image"
 	"image/draw"
+	"math"
+	"math/cmplx"
 
 	"github.com/esimov/caire"
 )
@@ -35,3 +37,222 @@ func CarveSeams(img *image.RGBA, newWidthInPercentage float64) (*image.RGBA, err
 
 	return resizedRGBA, nil
 }
+
+// CarveSeamsChannels removes seams from one spectrogram per channel. The
+// spectrograms are stacked and carved as one image, so that the same number
+// of columns is removed from every channel and they stay time-aligned.
+func CarveSeamsChannels(imgs []*image.RGBA, newWidthInPercentage float64) ([]*image.RGBA, error) {
+	if len(imgs) == 0 {
+		return []*image.RGBA{}, nil
+	}
+	carved, err := CarveSeams(StackSpectrograms(imgs), newWidthInPercentage)
+	if err != nil {
+		return nil, err
+	}
+	return SplitSpectrogram(carved, len(imgs))
+}
+
+// CarveSpectrograms removes seams from one full-precision spectrogram per channel to reduce their
+// width by the given percentage. Unlike CarveSeamsChannels, the bins themselves are removed, so
+// no precision is lost. The seams are found in the magnitudes, in dB, and the spectrograms are
+// stacked while the seams are found, so that all channels stay time-aligned. The lengths of the
+// carved spectrograms are reduced by one hop per removed column. The derivatives of the phase are
+// carved together with the bins, and the phase is integrated from them again, so that the phase
+// stays consistent across the seams instead of jumping where columns have been removed.
+func CarveSpectrograms(spectrograms []*Spectrogram, newWidthInPercentage float64) ([]*Spectrogram, error) {
+	if len(spectrograms) == 0 {
+		return []*Spectrogram{}, nil
+	}
+	width := spectrograms[0].Columns()
+	for _, s := range spectrograms[1:] {
+		if s.Columns() != width {
+			return nil, fmt.Errorf("the spectrograms have different widths, %d and %d", width, s.Columns())
+		}
+	}
+	newWidth := int(float64(width) * newWidthInPercentage / 100.0)
+	if newWidth < 1 || newWidth > width {
+		return nil, fmt.Errorf("can not carve %d columns to %d columns", width, newWidth)
+	}
+
+	// Stack the bins, their phase derivatives and their levels as rows of columns
+	var rows [][]carvedBin
+	var levels [][]float64
+	for _, s := range spectrograms {
+		timeDeriv, freqDeriv := phaseDerivatives(s.Bins, s.Options)
+		for y := 0; y < s.Options.Bins(); y++ {
+			row := make([]carvedBin, width)
+			for x, column := range s.Bins {
+				row[x] = carvedBin{cmplx.Abs(column[y]), timeDeriv[x][y], freqDeriv[x][y]}
+			}
+			rows = append(rows, row)
+		}
+		levels = append(levels, s.levels()...)
+	}
+
+	rows = carveRows(rows, levels, newWidth)
+
+	// Split the rows into one spectrogram per channel again
+	carved := make([]*Spectrogram, len(spectrograms))
+	y := 0
+	for i, s := range spectrograms {
+		c := *s
+		mags := make([][]float64, newWidth)
+		timeDeriv := make([][]float64, newWidth)
+		freqDeriv := make([][]float64, newWidth)
+		for x := range mags {
+			mags[x] = make([]float64, s.Options.Bins())
+			timeDeriv[x] = make([]float64, s.Options.Bins())
+			freqDeriv[x] = make([]float64, s.Options.Bins())
+			for bin := range mags[x] {
+				b := rows[y+bin][x]
+				mags[x][bin], timeDeriv[x][bin], freqDeriv[x][bin] = b.magnitude, b.timeDeriv, b.freqDeriv
+			}
+		}
+		c.Bins = integratePhase(mags, timeDeriv, freqDeriv, s.Options)
+		y += s.Options.Bins()
+		c.Length -= (width - newWidth) * s.Options.hopSize()
+		if c.Length < 0 {
+			c.Length = 0
+		}
+		carved[i] = &c
+	}
+	return carved, nil
+}
+
+// carvedBin is a bin of a spectrogram that is being carved, with the derivatives of its phase
+type carvedBin struct {
+	magnitude, timeDeriv, freqDeriv float64
+}
+
+// carveRows removes vertical seams of the lowest energy from rows of values, until the rows are
+// newWidth long. The energy is the gradient of the levels, which are carved together with the values.
+func carveRows[T any](rows [][]T, levels [][]float64, newWidth int) [][]T {
+	if len(rows) == 0 {
+		return rows
+	}
+	height, width := len(rows), len(rows[0])
+
+	// The seams are removed from the positions of the values, which are then picked from the rows at the end.
+	// The energy only changes next to a removed seam, so it is computed once and then updated.
+	positions := make([][]int32, height)
+	energy := make([][]int32, height)
+	cost := make([][]int32, height)
+	for y := range energy {
+		positions[y] = make([]int32, width)
+		energy[y] = make([]int32, width)
+		cost[y] = make([]int32, width)
+		for x := range energy[y] {
+			positions[y][x] = int32(x)
+			energy[y][x] = gradient(levels, x, y, width)
+		}
+	}
+
+	seam := make([]int, height)
+	for ; width > newWidth; width-- {
+		// Find the cumulative cost of the cheapest seam that ends in each pixel
+		copy(cost[0], energy[0][:width])
+		for y := 1; y < height; y++ {
+			// The width is at least 2 here, since newWidth is at least 1
+			above, row, e := cost[y-1][:width], cost[y][:width], energy[y][:width]
+			row[0] = e[0] + minInt32(above[0], above[1])
+			for x := 1; x < width-1; x++ {
+				row[x] = e[x] + minInt32(minInt32(above[x-1], above[x]), above[x+1])
+			}
+			row[width-1] = e[width-1] + minInt32(above[width-1], above[width-2])
+		}
+
+		// Trace the cheapest seam back from the last row
+		x := 0
+		for i := 1; i < width; i++ {
+			if cost[height-1][i] < cost[height-1][x] {
+				x = i
+			}
+		}
+		for y := height - 1; y >= 0; y-- {
+			seam[y] = x
+			if y > 0 {
+				best := x
+				if x > 0 && cost[y-1][x-1] < cost[y-1][best] {
+					best = x - 1
+				}
+				if x < width-1 && cost[y-1][x+1] < cost[y-1][best] {
+					best = x + 1
+				}
+				x = best
+			}
+		}
+
+		// Remove the seam
+		for y, x := range seam {
+			positions[y] = append(positions[y][:x], positions[y][x+1:]...)
+			levels[y] = append(levels[y][:x], levels[y][x+1:]...)
+			energy[y] = append(energy[y][:x], energy[y][x+1:]...)
+		}
+
+		// Update the energy next to the seam, where the neighbours of the pixels have changed
+		for y, x := range seam {
+			from, to := x-1, x
+			for _, n := range [2]int{y - 1, y + 1} {
+				if n >= 0 && n < height {
+					if seam[n]-1 < from {
+						from = seam[n] - 1
+					}
+					if seam[n] > to {
+						to = seam[n]
+					}
+				}
+			}
+			for x := from; x <= to; x++ {
+				if x >= 0 && x < width-1 {
+					energy[y][x] = gradient(levels, x, y, width-1)
+				}
+			}
+		}
+	}
+
+	// Pick the values that are left
+	for y, row := range rows {
+		for x, position := range positions[y] {
+			row[x] = row[position]
+		}
+		rows[y] = row[:width]
+	}
+	return rows
+}
+
+// The energy is stored as integers in steps of 1/gradientScale, capped at maxGradient, so that
+// the cost of a seam can not overflow and the cheapest seam can be found without branches
+const (
+	gradientScale = 16
+	maxGradient   = 1 << 14
+)
+
+// gradient returns the sum of the absolute horizontal and vertical differences
+// of the levels around the given position, in rows that are width long
+func gradient(levels [][]float64, x, y, width int) int32 {
+	left, right, up, down := x, x, y, y
+	if x > 0 {
+		left--
+	}
+	if x < width-1 {
+		right++
+	}
+	if y > 0 {
+		up--
+	}
+	if y < len(levels)-1 {
+		down++
+	}
+	g := (math.Abs(levels[y][right]-levels[y][left]) + math.Abs(levels[down][x]-levels[up][x])) * gradientScale
+	if !(g < maxGradient) {
+		return maxGradient
+	}
+	return int32(g)
+}
+
+func minInt32(a, b int32) int32 {
+	if a < b {
+		return a
+	}
+	return b
+}
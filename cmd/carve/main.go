@@ -1,79 +1,114 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
-	"image/png"
 	"os"
 
 	"github.com/xyproto/wavecarve"
+	"github.com/xyproto/wavecarve/internal/transformflags"
 )
 
 func main() {
-	fmt.Print("Reading input.wav...")
-
-	audioInts, header, err := wavecarve.ReadWavFile("input.wav")
+	flags := transformflags.Register(flag.CommandLine, true)
+	flag.Usage = flags.Usage(os.Args[0], "[input file] [output file]")
+	flag.Parse()
+	config, err := flags.Config()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Println("ok")
-	fmt.Print("Creating spectrogram...")
+	// The input and output files can be given as arguments.
+	// .aif, .aiff, .aifc and .flac files are supported too, by extension.
+	inputFile, outputFile := "input.wav", "output.wav"
+	if flag.NArg() > 0 {
+		inputFile = flag.Arg(0)
+	}
+	if flag.NArg() > 1 {
+		outputFile = flag.Arg(1)
+	}
 
-	// A larger FFT size will give better frequency resolution
-	const fftSize = 512
+	fmt.Printf("Reading %s...", inputFile)
 
-	spectrogram, err := wavecarve.CreateSpectrogramFromAudio(audioInts)
-	if err != nil {
+	audio, err := wavecarve.ReadAudio(inputFile)
+	if errors.As(err, new(wavecarve.ErrTruncated)) {
+		// Use the audio data that could be read
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	} else if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
 	fmt.Println("ok")
-	fmt.Print("Seam carving the spectrogram...")
+	fmt.Print("Creating spectrograms...")
 
-	carvedImage, err := wavecarve.CarveSeams(spectrogram, 50.0) // Reduce the width of the spectrogram by 50%
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not carve seams: %s\n", err)
-		os.Exit(1)
+	audio = carve(audio, config.Transform, config.Encoding)
+
+	// Render the output at the requested sample rate
+	if config.SampleRate != 0 && config.SampleRate != audio.SampleRate {
+		fmt.Printf("Resampling from %d Hz to %d Hz...", audio.SampleRate, config.SampleRate)
+		audio = audio.Resample(config.SampleRate, wavecarve.ResampleHigh)
+		fmt.Println("ok")
 	}
 
-	fmt.Println("ok")
-	fmt.Print("Writing carved.png...")
+	fmt.Printf("Writing %s...", outputFile)
 
-	// Create the output file
-	carvedImageFile, err := os.Create("carved.png")
-	if err != nil {
+	// Write the audio data to the output file. Resynthesised audio often overshoots,
+	// and the samples that clipped are reported.
+	err = wavecarve.WriteAudioWithOptions(outputFile, audio, config.Quantize)
+	if errors.As(err, new(wavecarve.ErrClipped)) {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	} else if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
+	} else {
+		fmt.Println("ok")
 	}
-	defer carvedImageFile.Close()
+}
 
-	// Encode the image to the output file
-	err = png.Encode(carvedImageFile, carvedImage)
+// carve removes seams from representations of the audio, like spectrograms, from the given transform,
+// writes an image of them to carved.png and returns the carved audio
+func carve(audio *wavecarve.Audio, transform wavecarve.Transform, encoding wavecarve.ImageEncoding) *wavecarve.Audio {
+	representations, err := transform.Analyze(audio)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
 	fmt.Println("ok")
-	fmt.Print("Creating audio from carved spectrogram...")
+	fmt.Print("Seam carving the spectrograms...")
 
-	// Convert the image back to audio data
-	audioInts, err = wavecarve.CreateAudioFromSpectrogram(carvedImage)
+	// The seams are removed from the full-precision representations, not from images of them
+	carvedRepresentations, err := transform.Carve(representations, 50.0) // Reduce the width of the spectrograms by 50%
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not carve seams: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("ok")
+	fmt.Print("Writing carved.png...")
+
+	// Write the image, with one spectrogram per channel stacked on top of each other,
+	// and the metadata that is needed for converting it back to audio
+	err = wavecarve.WriteTransformImage("carved.png", transform, carvedRepresentations, encoding)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
 	fmt.Println("ok")
-	fmt.Print("Writing output.wav...")
+	fmt.Print("Creating audio from carved spectrograms...")
 
-	// Write the audio data to the output file
-	if err = wavecarve.WriteWavFile("output.wav", audioInts, header); err != nil {
+	// Convert the representations back to audio data, with the same format and metadata as the input
+	carved, err := transform.Synthesize(carvedRepresentations)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
 	fmt.Println("ok")
+	// The cue points and loops are moved along with the carved audio
+	return audio.WithResizedChannels(carved.Channels)
 }
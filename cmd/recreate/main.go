@@ -1,56 +1,158 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
-	"github.com/xyproto/wavecarve"
 	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xyproto/wavecarve"
+	"github.com/xyproto/wavecarve/internal/transformflags"
 )
 
 func main() {
-	fmt.Print("Reading input.wav...")
-
-	audioInts, header, err := wavecarve.ReadWavFile("input.wav")
+	flags := transformflags.Register(flag.CommandLine, true)
+	flag.Usage = flags.Usage(os.Args[0], "[input file] [output file]")
+	flag.Parse()
+	config, err := flags.Config()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Println("ok")
-	fmt.Printf("First 10 audio samples: %v\n", audioInts[:10]) // Print first 10 samples
-	fmt.Printf("Header: %+v\n", header)                        // Print header data
+	// The input and output files can be given as arguments.
+	// .aif, .aiff, .aifc and .flac files are supported too, by extension.
+	inputFile, outputFile := "input.wav", "output.wav"
+	if flag.NArg() > 0 {
+		inputFile = flag.Arg(0)
+	}
+	if flag.NArg() > 1 {
+		outputFile = flag.Arg(1)
+	}
+
+	// A spectrogram image that was written by cmd/spectrogram or cmd/carve can be given instead of audio
+	var audio *wavecarve.Audio
+	switch strings.ToLower(filepath.Ext(inputFile)) {
+	case ".png", ".tif", ".tiff", ".bmp":
+		audio = audioFromImage(inputFile, config)
+	default:
+		audio = recreateAudio(inputFile, config.Transform, config.Encoding)
+	}
+
+	// Render the output at the requested sample rate
+	if config.SampleRate != 0 && config.SampleRate != audio.SampleRate {
+		fmt.Printf("Resampling from %d Hz to %d Hz...", audio.SampleRate, config.SampleRate)
+		audio = audio.Resample(config.SampleRate, wavecarve.ResampleHigh)
+		fmt.Println("ok")
+	}
+
+	fmt.Printf("Writing %s...", outputFile)
 
-	fmt.Print("Creating spectrogram...")
+	// Write the audio data to the output file. Resynthesised audio often overshoots,
+	// and the samples that clipped are reported.
+	err = wavecarve.WriteAudioWithOptions(outputFile, audio, config.Quantize)
+	if errors.As(err, new(wavecarve.ErrClipped)) {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	} else {
+		fmt.Println("ok")
+	}
+}
+
+// recreateAudio reads audio, converts it to images of representations from the given transform,
+// like spectrograms, and back, and returns the recreated audio
+func recreateAudio(inputFile string, transform wavecarve.Transform, encoding wavecarve.ImageEncoding) *wavecarve.Audio {
+	fmt.Printf("Reading %s...", inputFile)
+
+	audio, err := wavecarve.ReadAudio(inputFile)
+	if errors.As(err, new(wavecarve.ErrTruncated)) {
+		// Use the audio data that could be read
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
 
-	// A larger FFT size will give better frequency resolution
-	const fftSize = 512
+	fmt.Println("ok")
+	fmt.Printf("First 10 audio samples: %v\n", firstSamples(audio))                                       // Print first 10 samples of the first channel
+	fmt.Printf("%d channel(s) of %v at %d Hz\n", audio.NumChannels(), audio.Duration(), audio.SampleRate) // Print the audio format
+
+	fmt.Print("Creating spectrograms...")
 
-	spectrogram, err := wavecarve.CreateSpectrogramFromAudio(audioInts)
+	representations, err := transform.Analyze(audio)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	images, header, err := transform.Images(representations, encoding)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
 	fmt.Println("ok")
-	fmt.Print("Creating audio from spectrogram...")
+	fmt.Print("Creating audio from spectrograms...")
 
-	// Convert the image back to audio data
-	audioInts, err = wavecarve.CreateAudioFromSpectrogram(spectrogram)
+	// Convert the images back to audio data, with the same format and metadata as the input
+	representations, err = transform.FromImage(wavecarve.StackImages(images), header)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	recreated, err := transform.Synthesize(representations)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
+	audio = audio.WithChannels(recreated.Channels)
 
 	fmt.Println("ok")
-	fmt.Printf("First 10 audio samples after conversion: %v\n", audioInts[:10]) // Print first 10 samples
-	fmt.Printf("Header: %+v\n", header)                                         // Print header data
+	fmt.Printf("First 10 audio samples after conversion: %v\n", firstSamples(audio)) // Print first 10 samples of the first channel
+	return audio
+}
+
+// firstSamples returns the first 10 samples of the first channel, or fewer if the audio is shorter
+func firstSamples(audio *wavecarve.Audio) []float64 {
+	if len(audio.Channels) == 0 {
+		return nil
+	}
+	samples := audio.Channels[0]
+	if len(samples) > 10 {
+		samples = samples[:10]
+	}
+	return samples
+}
 
-	fmt.Print("Writing output.wav...")
+// audioFromImage reads a spectrogram image, with its metadata, and converts it to audio with
+// the transform that the metadata names, from the configured ones if it is one of them
+func audioFromImage(inputFile string, config *transformflags.Config) *wavecarve.Audio {
+	fmt.Printf("Reading %s...", inputFile)
 
-	// Write the audio data to the output file
-	if err = wavecarve.WriteWavFile("output.wav", audioInts, header); err != nil {
+	transform, representations, err := wavecarve.ReadTransformImage(inputFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	// The phase and band inversion options are not stored in the image, so they are taken from the flags
+	if transform, err = config.Lookup(transform.Name()); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("ok")
+	fmt.Print("Creating audio from spectrograms...")
+
+	audio, err := transform.Synthesize(representations)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
 	fmt.Println("ok")
+	fmt.Printf("%d channel(s) of %v at %d Hz\n", audio.NumChannels(), audio.Duration(), audio.SampleRate) // Print the audio format
+	return audio
 }
@@ -1,47 +1,100 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
-	"image/png"
+	"image"
 	"os"
 
 	"github.com/xyproto/wavecarve"
+	"github.com/xyproto/wavecarve/internal/transformflags"
 )
 
 func main() {
-	fmt.Print("Reading input.wav...")
-
-	audioInts, _, err := wavecarve.ReadWavFile("input.wav")
+	flags := transformflags.Register(flag.CommandLine, false)
+	flag.Usage = flags.Usage(os.Args[0], "[input file] [output image]")
+	flag.Parse()
+	config, err := flags.Config()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Println("ok")
-	fmt.Print("Creating spectrogram...")
+	// The input file and the output image can be given as arguments.
+	// .aif, .aiff, .aifc and .flac files are supported too, by extension,
+	// and the image is written as a TIFF file if it ends with .tif or .tiff.
+	inputFile, outputFile := "input.wav", "spectrogram.png"
+	if flag.NArg() > 0 {
+		inputFile = flag.Arg(0)
+	}
+	if flag.NArg() > 1 {
+		outputFile = flag.Arg(1)
+	}
 
-	// A larger FFT size will give better frequency resolution
-	const fftSize = 512
+	fmt.Printf("Reading %s...", inputFile)
 
-	spectrogram, err := wavecarve.CreateSpectrogramFromAudio(audioInts)
-	if err != nil {
+	audio, err := wavecarve.ReadAudio(inputFile)
+	if errors.As(err, new(wavecarve.ErrTruncated)) {
+		// Use the audio data that could be read
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	} else if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
 	fmt.Println("ok")
-	fmt.Print("Writing spectogram.png...")
+	fmt.Printf("%d channel(s) of %v at %d Hz\n", audio.NumChannels(), audio.Duration(), audio.SampleRate)
+	switch config.Name {
+	case "bands":
+		fmt.Printf("Each column is %.1f ms and each row is one of %d %s bands\n",
+			wavecarve.ColumnTime(1, config.Spectrogram.HopSize, audio.SampleRate)*1000, config.Filterbank.Bands, config.Filterbank.Scale)
+	case "cqt":
+		fmt.Printf("Each row is one of %d constant-Q bins, with %d bins per octave\n", config.ConstantQ.Rows(audio.SampleRate), config.ConstantQ.BinsPerOctave)
+	case "mdct":
+		fmt.Printf("Each column is %.1f ms and each row is one of %d MDCT coefficients, %.2f Hz apart\n",
+			wavecarve.ColumnTime(1, config.MDCT.Bins(), audio.SampleRate)*1000, config.MDCT.Bins(),
+			wavecarve.BinFrequency(1, config.MDCT.FrameSize, audio.SampleRate))
+	case "wpt":
+		fmt.Printf("Each column is %.1f ms and each row is one of %d %s wavelet packets, %.2f Hz wide\n",
+			wavecarve.ColumnTime(1, config.WaveletPacket.Bins(), audio.SampleRate)*1000, config.WaveletPacket.Bins(), config.WaveletPacket.Wavelet,
+			float64(audio.SampleRate)/2/float64(config.WaveletPacket.Bins()))
+	case "cwt":
+		fmt.Printf("Each column is %.1f ms and each row is one of %d CWT voices, with %d voices per octave\n",
+			wavecarve.ColumnTime(1, config.Morlet.HopSize, audio.SampleRate)*1000, len(config.Morlet.CentreFrequencies(audio.SampleRate)), config.Morlet.VoicesPerOctave)
+	case "stft":
+		fmt.Printf("Each column is %.1f ms and each row is %.2f Hz\n",
+			wavecarve.ColumnTime(1, config.Spectrogram.HopSize, audio.SampleRate)*1000,
+			wavecarve.BinFrequency(1, config.Spectrogram.TransformSize(), audio.SampleRate))
+	}
+	fmt.Print("Creating spectrograms...")
 
-	// Create the output file
-	spectrogramImageFile, err := os.Create("spectrogram.png")
+	var representations []wavecarve.Representation
+	var morletScalograms []*wavecarve.MorletScalogram
+	if config.Morlet != nil {
+		morletScalograms, err = audio.MorletScalograms(*config.Morlet)
+	} else {
+		representations, err = config.Transform.Analyze(audio)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
-	defer spectrogramImageFile.Close()
 
-	// Encode the image to the output file
-	err = png.Encode(spectrogramImageFile, spectrogram)
+	fmt.Println("ok")
+	fmt.Printf("Writing %s...", outputFile)
+
+	// Write the image, with one spectrogram per channel stacked on top of each other,
+	// and the metadata that is needed for converting it back to audio.
+	// Morlet scalograms can not be converted back, so they are written without metadata.
+	if config.Morlet != nil {
+		var imgs []image.Image
+		if imgs, err = wavecarve.MorletScalogramImagesWithMapping(morletScalograms, config.Encoding, config.Mapping); err == nil {
+			err = wavecarve.WriteImageFile(outputFile, wavecarve.StackImages(imgs))
+		}
+	} else {
+		err = wavecarve.WriteTransformImage(outputFile, config.Transform, representations, config.Encoding)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
@@ -0,0 +1,145 @@
+package wavecarve
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+// coefficientPlane is a spectrogram of signed, real-valued coefficients, with one column per time
+// step and one row per band, like an MDCTSpectrogram or a WaveletPacketSpectrogram. They only differ
+// in the transform and the gain, so their levels, images and carving are shared.
+type coefficientPlane[S any] interface {
+	Columns() int
+	// plane returns the coefficients as columns, the number of rows and what the coefficients are divided by in images
+	plane() (coefficients [][]float64, rows int, gain float64)
+	// kind returns what the spectrogram is called in error messages
+	kind() string
+	// header returns the header for an image of the spectrogram with the given encoding and mapping
+	header(encoding ImageEncoding, mapping MagnitudeMapping) SpectrogramHeader
+	// sameOptions returns true if the other spectrogram was created with the same options
+	sameOptions(other S) bool
+	// withCoefficients returns a copy of the spectrogram with the given coefficients, where the
+	// length has been reduced by the given number of removed columns
+	withCoefficients(coefficients [][]float64, removedColumns int) S
+}
+
+// planeLevels returns the magnitude of each coefficient in dB relative to full scale, as rows of columns
+func planeLevels[S coefficientPlane[S]](s S) [][]float64 {
+	coefficients, rows, gain := s.plane()
+	levels := make([][]float64, rows)
+	for y := range levels {
+		levels[y] = make([]float64, len(coefficients))
+		for x, column := range coefficients {
+			levels[y][x] = magnitudeToDB(math.Abs(column[y]) / gain)
+		}
+	}
+	return levels
+}
+
+// planeImages converts one spectrogram per channel to images with the given encoding and mapping, and
+// returns them together with the header that is needed for converting them back. Only EncodingSigned8
+// and EncodingSigned16 can be used, which store the sign of each coefficient together with its magnitude.
+func planeImages[S coefficientPlane[S]](spectrograms []S, encoding ImageEncoding, mapping MagnitudeMapping) ([]image.Image, SpectrogramHeader, error) {
+	if len(spectrograms) == 0 {
+		return nil, SpectrogramHeader{}, errors.New("no spectrograms")
+	}
+	if encoding != EncodingSigned8 && encoding != EncodingSigned16 {
+		return nil, SpectrogramHeader{}, fmt.Errorf("the %s encoding can not be used for %ss, only %s and %s", encoding, spectrograms[0].kind(), EncodingSigned8, EncodingSigned16)
+	}
+	if err := mapping.Validate(); err != nil {
+		return nil, SpectrogramHeader{}, err
+	}
+	var levels []float64
+	for _, s := range spectrograms {
+		if s.Columns() != spectrograms[0].Columns() || !s.sameOptions(spectrograms[0]) {
+			return nil, SpectrogramHeader{}, errors.New("the spectrograms of all channels must have the same size and options")
+		}
+		if mapping.Auto {
+			coefficients, _, gain := s.plane()
+			for _, column := range coefficients {
+				for _, val := range column {
+					levels = append(levels, magnitudeToDB(math.Abs(val)/gain))
+				}
+			}
+		}
+	}
+	if mapping.Auto {
+		mapping = mapping.resolve(levels)
+	}
+	imgs := make([]image.Image, len(spectrograms))
+	for i, s := range spectrograms {
+		coefficients, rows, gain := s.plane()
+		if encoding == EncodingSigned16 {
+			imgs[i] = encodeSigned16(coefficients, rows, gain, mapping)
+		} else {
+			imgs[i] = encodeSigned8(coefficients, rows, gain, mapping)
+		}
+	}
+	header := spectrograms[0].header(encoding, mapping)
+	header.Channels = len(spectrograms)
+	return imgs, header, nil
+}
+
+// planeFromImage converts an image of one channel back to the coefficients of the given spectrogram,
+// which must already have the options from the header, after the header has been validated
+func planeFromImage[S coefficientPlane[S]](img image.Image, header SpectrogramHeader, s S) S {
+	encoding, _ := header.ImageEncoding()
+	mapping, _ := header.Mapping()
+	_, _, gain := s.plane()
+	return s.withCoefficients(fromSigned(img, encoding == EncodingSigned16, gain, mapping), 0)
+}
+
+// carvePlanes removes seams from one spectrogram per channel to reduce their width by the given
+// percentage. The seams are found in the levels of the coefficients, and the coefficients themselves
+// are removed, since there is no phase that has to be kept consistent.
+func carvePlanes[S coefficientPlane[S]](spectrograms []S, newWidthInPercentage float64) ([]S, error) {
+	if len(spectrograms) == 0 {
+		return []S{}, nil
+	}
+	width := spectrograms[0].Columns()
+	for _, s := range spectrograms[1:] {
+		if s.Columns() != width {
+			return nil, fmt.Errorf("the spectrograms have different widths, %d and %d", width, s.Columns())
+		}
+	}
+	newWidth := int(float64(width) * newWidthInPercentage / 100.0)
+	if newWidth < 1 || newWidth > width {
+		return nil, fmt.Errorf("can not carve %d columns to %d columns", width, newWidth)
+	}
+
+	// Stack the coefficients and their levels as rows of columns
+	var rows [][]float64
+	var levels [][]float64
+	for _, s := range spectrograms {
+		coefficients, bands, _ := s.plane()
+		for y := 0; y < bands; y++ {
+			row := make([]float64, width)
+			for x, column := range coefficients {
+				row[x] = column[y]
+			}
+			rows = append(rows, row)
+		}
+		levels = append(levels, planeLevels(s)...)
+	}
+
+	rows = carveRows(rows, levels, newWidth)
+
+	// Split the rows into one spectrogram per channel again
+	carved := make([]S, len(spectrograms))
+	y := 0
+	for i, s := range spectrograms {
+		_, bands, _ := s.plane()
+		coefficients := make([][]float64, newWidth)
+		for x := range coefficients {
+			coefficients[x] = make([]float64, bands)
+			for k := range coefficients[x] {
+				coefficients[x][k] = rows[y+k][x]
+			}
+		}
+		y += bands
+		carved[i] = s.withCoefficients(coefficients, width-newWidth)
+	}
+	return carved, nil
+}
@@ -0,0 +1,571 @@
+package wavecarve
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"math/cmplx"
+
+	"github.com/mjibson/go-dsp/fft"
+)
+
+// The name of the constant-Q transform in the SpectrogramHeader
+const constantQTransform = "cqt"
+
+// The lowest centre frequency of DefaultConstantQOptions, which is C1
+const defaultConstantQMinFrequency = 32.703195662574829
+
+// ConstantQOptions configures the constant-Q transform of a ConstantQSpectrogram
+type ConstantQOptions struct {
+	// BinsPerOctave is the number of bins in each octave, like 12 for one bin per semitone
+	BinsPerOctave int
+
+	// MinFrequency is the centre frequency of the lowest bin, in Hz, and the centre frequencies
+	// of the other bins are BinsPerOctave per octave above it, up to MaxFrequency. A MaxFrequency
+	// of 0 places the highest bin one bin below the Nyquist frequency.
+	MinFrequency float64
+	MaxFrequency float64
+}
+
+// DefaultConstantQOptions have one bin per semitone, from C1 up to the Nyquist frequency
+var DefaultConstantQOptions = ConstantQOptions{BinsPerOctave: 12, MinFrequency: defaultConstantQMinFrequency}
+
+// Validate returns an error if the options can not be used for audio with the given sample rate
+func (o ConstantQOptions) Validate(sampleRate uint32) error {
+	nyquist := float64(sampleRate) / 2
+	switch {
+	case o.BinsPerOctave < 1:
+		return fmt.Errorf("invalid number of bins per octave %d, there must be at least one", o.BinsPerOctave)
+	case !(o.MinFrequency > 0) || math.IsInf(o.MinFrequency, 0):
+		return fmt.Errorf("invalid lowest frequency %g Hz, it must be above 0 Hz", o.MinFrequency)
+	case o.MaxFrequency < 0 || math.IsNaN(o.MaxFrequency):
+		return fmt.Errorf("invalid highest frequency %g Hz", o.MaxFrequency)
+	case o.MaxFrequency >= nyquist:
+		return fmt.Errorf("the highest frequency %g Hz must be below the Nyquist frequency of %g Hz", o.MaxFrequency, nyquist)
+	case o.maxFrequency(sampleRate) < o.MinFrequency:
+		return fmt.Errorf("invalid frequency range from %g Hz to %g Hz", o.MinFrequency, o.maxFrequency(sampleRate))
+	}
+	return nil
+}
+
+// maxFrequency returns the highest centre frequency that is allowed, with the default filled in
+func (o ConstantQOptions) maxFrequency(sampleRate uint32) float64 {
+	if o.MaxFrequency == 0 {
+		return float64(sampleRate) / 2 * math.Exp2(-1/float64(o.BinsPerOctave))
+	}
+	return o.MaxFrequency
+}
+
+// CentreFrequencies returns the centre frequency of each row of a ConstantQSpectrogram, in Hz. The
+// first row is 0 Hz, and holds everything below MinFrequency, and the last row is the Nyquist
+// frequency, and holds everything above the highest bin, so that the transform can be inverted exactly.
+func (o ConstantQOptions) CentreFrequencies(sampleRate uint32) []float64 {
+	centres := []float64{0}
+	maxFrequency := o.maxFrequency(sampleRate)
+	for k := 0; ; k++ {
+		f := o.MinFrequency * math.Exp2(float64(k)/float64(o.BinsPerOctave))
+		// Allow for rounding errors when the highest frequency is a bin
+		if f > maxFrequency*(1+1e-9) {
+			break
+		}
+		centres = append(centres, f)
+	}
+	return append(centres, float64(sampleRate)/2)
+}
+
+// Rows returns the number of rows of a ConstantQSpectrogram, which is the number of bins
+// between MinFrequency and MaxFrequency, and a row for 0 Hz and one for the Nyquist frequency
+func (o ConstantQOptions) Rows(sampleRate uint32) int {
+	return len(o.CentreFrequencies(sampleRate))
+}
+
+// Columns returns the smallest number of columns of a ConstantQSpectrogram of length samples.
+// Every row has the same number of columns, so that the spectrogram is an image, and the number
+// is given by the widest bin, which is the highest one, so that every bin can be inverted exactly.
+// Doubling the length of the audio doubles the number of columns.
+func (o ConstantQOptions) Columns(length int, sampleRate uint32) int {
+	if length < 1 {
+		return 0
+	}
+	return newNSGTFrame(o, sampleRate, length).minColumns()
+}
+
+// maxBandwidth returns the widest frequency range of a window of the transform, in Hz, from the centre
+// frequency of the row below to the centre frequency of the row above. The widest window has about
+// length * maxBandwidth / sampleRate FFT bins, which is the smallest number of columns.
+func (o ConstantQOptions) maxBandwidth(sampleRate uint32) float64 {
+	centres := o.CentreFrequencies(sampleRate)
+	widest := 0.0
+	for j := range centres {
+		lower, upper := centres[j], centres[j]
+		if j > 0 {
+			lower = centres[j-1]
+		}
+		if j < len(centres)-1 {
+			upper = centres[j+1]
+		}
+		widest = math.Max(widest, upper-lower)
+	}
+	return widest
+}
+
+// nsgtFrame holds the windows of the non-stationary Gabor transform that the constant-Q transform is
+// based on. The windows are defined in the frequency domain, on the bins of an FFT of the whole audio,
+// from 0 Hz up to the Nyquist frequency. Each window rises like half a Hann window from the centre
+// frequency of the row below to its own centre frequency, and falls to the centre frequency of the row
+// above, so the windows add up to 1 at every frequency. That makes the frame painless, which means that
+// the transform can be inverted exactly, by dividing by the sum of the squared windows.
+type nsgtFrame struct {
+	starts  []int       // the first FFT bin of each window
+	weights [][]float64 // the weights of the FFT bins of each window, from the first bin
+	centres []int       // the FFT bin at the centre frequency of each window, which the row is demodulated by
+	length  int         // the number of samples, which is the size of the FFT
+}
+
+// newNSGTFrame returns the windows for audio of the given length, which must be at least 1
+func newNSGTFrame(o ConstantQOptions, sampleRate uint32, length int) *nsgtFrame {
+	centres := o.CentreFrequencies(sampleRate)
+	binWidth := float64(sampleRate) / float64(length)
+	last := length / 2 // the FFT bin of the Nyquist frequency, or just below it
+	f := &nsgtFrame{
+		starts:  make([]int, len(centres)),
+		weights: make([][]float64, len(centres)),
+		centres: make([]int, len(centres)),
+		length:  length,
+	}
+	for j, centre := range centres {
+		lower, upper := centre, centre
+		if j > 0 {
+			lower = centres[j-1]
+		}
+		if j < len(centres)-1 {
+			upper = centres[j+1]
+		}
+		first := int(math.Floor(lower/binWidth)) + 1
+		if j == 0 {
+			first = 0
+		}
+		end := int(math.Ceil(upper/binWidth)) - 1
+		if j == len(centres)-1 || end > last {
+			end = last
+		}
+		var weights []float64
+		for m := first; m <= end; m++ {
+			freq := float64(m) * binWidth
+			w := 1.0
+			if freq < centre {
+				w = 0.5 - 0.5*math.Cos(math.Pi*(freq-lower)/(centre-lower))
+			} else if freq > centre {
+				w = 0.5 + 0.5*math.Cos(math.Pi*(freq-centre)/(upper-centre))
+			}
+			weights = append(weights, w)
+		}
+		c := int(math.Round(centre / binWidth))
+		if c > last {
+			c = last
+		}
+		f.starts[j], f.weights[j], f.centres[j] = first, weights, c
+	}
+	return f
+}
+
+// minColumns returns the number of FFT bins of the widest window, which is the smallest number of
+// columns that keeps the windows from overlapping themselves when the rows are demodulated
+func (f *nsgtFrame) minColumns() int {
+	columns := 1
+	for _, weights := range f.weights {
+		if len(weights) > columns {
+			columns = len(weights)
+		}
+	}
+	return columns
+}
+
+// analyze returns the constant-Q coefficients of the samples, as the given number of columns of one
+// value per window. Each row is the part of the spectrum under its window, moved down to 0 Hz and
+// transformed back to the time domain with an inverse FFT of the number of columns. The values are
+// scaled so that a full scale sinusoid at the centre of a window has a magnitude of 0.5, like in
+// the images of a Spectrogram.
+func (f *nsgtFrame) analyze(float64s []float64, columns int) [][]complex128 {
+	spectrum := fft.FFTReal(float64s)
+	scale := complex(float64(columns)/float64(f.length), 0)
+	bins := make([][]complex128, columns)
+	for i := range bins {
+		bins[i] = make([]complex128, len(f.weights))
+	}
+	buf := make([]complex128, columns)
+	for j, weights := range f.weights {
+		for p := range buf {
+			buf[p] = 0
+		}
+		for i, w := range weights {
+			m := f.starts[j] + i
+			buf[f.position(j, m, columns)] += spectrum[m] * complex(w, 0)
+		}
+		for i, val := range fft.IFFT(buf) {
+			bins[i][j] = val * scale
+		}
+	}
+	return bins
+}
+
+// synthesize returns the samples from constant-Q coefficients that are returned by analyze. The
+// spectrum under each window is found with an FFT of the row, weighted with the window again and
+// added up, and dividing by the sum of the squared windows then gives back the spectrum of the audio.
+func (f *nsgtFrame) synthesize(bins [][]complex128) []float64 {
+	columns := len(bins)
+	half := make([]complex128, f.length/2+1)
+	windowSum := make([]float64, len(half))
+	if columns == 0 {
+		return make([]float64, f.length)
+	}
+	scale := complex(float64(f.length)/float64(columns), 0)
+	row := make([]complex128, columns)
+	for j, weights := range f.weights {
+		for i, column := range bins {
+			row[i] = 0
+			if j < len(column) {
+				row[i] = column[j]
+			}
+		}
+		buf := fft.FFT(row)
+		for i, w := range weights {
+			m := f.starts[j] + i
+			half[m] += buf[f.position(j, m, columns)] * scale * complex(w, 0)
+			windowSum[m] += w * w
+		}
+	}
+
+	// Restore the Hermitian-symmetric spectrum of the real samples
+	spectrum := make([]complex128, f.length)
+	for m, val := range half {
+		if windowSum[m] > 0 {
+			val /= complex(windowSum[m], 0)
+		}
+		spectrum[m] = val
+		if m > 0 && f.length-m > m {
+			spectrum[f.length-m] = cmplx.Conj(val)
+		}
+	}
+	float64s := make([]float64, f.length)
+	for i, val := range fft.IFFT(spectrum) {
+		float64s[i] = real(val)
+	}
+	return float64s
+}
+
+// position returns where FFT bin m of window j is placed in a row of the given number of columns,
+// when the row is moved down so that the centre frequency of the window is at 0 Hz
+func (f *nsgtFrame) position(j, m, columns int) int {
+	return ((m-f.centres[j])%columns + columns) % columns
+}
+
+// ConstantQSpectrogram is a spectrogram with bins that are spaced logarithmically in frequency, with
+// the same number of bins in every octave, and with bandwidths that are proportional to their centre
+// frequencies, like the notes of a musical scale. Harmonics stay vertical structures, and transposing
+// audio shifts the spectrogram vertically. The transform is a non-stationary Gabor transform, which
+// can be inverted exactly. The phase of each bin is relative to its centre frequency, so a steady
+// partial has a steady phase, which keeps it coherent when columns are removed by carving.
+type ConstantQSpectrogram struct {
+	// Bins holds one slice of Options.Rows() complex values per column, from 0 Hz at index 0
+	// up to the Nyquist frequency. Each column is Length / Columns() samples long.
+	Bins [][]complex128
+
+	Options    ConstantQOptions
+	SampleRate uint32
+	Length     int
+}
+
+// NewConstantQSpectrogram creates a constant-Q spectrogram from samples in the range [-1, 1],
+// with the smallest number of columns that gives an exact inverse
+func NewConstantQSpectrogram(float64s []float64, sampleRate uint32, options ConstantQOptions) (*ConstantQSpectrogram, error) {
+	if err := options.Validate(sampleRate); err != nil {
+		return nil, err
+	}
+	s := &ConstantQSpectrogram{Options: options, SampleRate: sampleRate, Length: len(float64s)}
+	if len(float64s) == 0 {
+		return s, nil
+	}
+	f := newNSGTFrame(options, sampleRate, len(float64s))
+	s.Bins = f.analyze(float64s, f.minColumns())
+	return s, nil
+}
+
+// ConstantQSpectrograms creates one constant-Q spectrogram per channel, with the given options
+func (a *Audio) ConstantQSpectrograms(options ConstantQOptions) ([]*ConstantQSpectrogram, error) {
+	spectrograms := make([]*ConstantQSpectrogram, a.NumChannels())
+	for c, channel := range a.Channels {
+		spectrogram, err := NewConstantQSpectrogram(channel, a.SampleRate, options)
+		if err != nil {
+			return nil, err
+		}
+		spectrograms[c] = spectrogram
+	}
+	return spectrograms, nil
+}
+
+// AudioFromConstantQSpectrograms creates audio from one constant-Q spectrogram per channel, with
+// the sample rate of the first spectrogram. The samples are not quantized.
+func AudioFromConstantQSpectrograms(spectrograms []*ConstantQSpectrogram) (*Audio, error) {
+	if len(spectrograms) == 0 {
+		return nil, errors.New("no spectrograms to create audio from")
+	}
+	audio := NewAudio(0, 0, spectrograms[0].SampleRate)
+	audio.Channels = make([][]float64, len(spectrograms))
+	for c, spectrogram := range spectrograms {
+		audio.Channels[c] = spectrogram.Samples()
+	}
+	return audio, nil
+}
+
+// Columns returns the number of columns of the constant-Q spectrogram
+func (s *ConstantQSpectrogram) Columns() int {
+	return len(s.Bins)
+}
+
+// Samples creates audio from the constant-Q spectrogram with the inverse transform. If the
+// spectrogram has not been modified, this gives back the samples it was created from, to within
+// floating point precision. The samples are not clipped, so they may be outside of the range [-1, 1].
+func (s *ConstantQSpectrogram) Samples() []float64 {
+	if s.Length < 1 {
+		return []float64{}
+	}
+	return newNSGTFrame(s.Options, s.SampleRate, s.Length).synthesize(s.Bins)
+}
+
+// levels returns the magnitude of each bin in dB relative to full scale, as rows of columns
+func (s *ConstantQSpectrogram) levels() [][]float64 {
+	rows := make([][]float64, s.Options.Rows(s.SampleRate))
+	for y := range rows {
+		rows[y] = make([]float64, len(s.Bins))
+		for x, column := range s.Bins {
+			rows[y][x] = magnitudeToDB(cmplx.Abs(column[y]))
+		}
+	}
+	return rows
+}
+
+// ToImage converts the constant-Q spectrogram to an image with one column per time step and one row
+// per bin, with 0 Hz at the top, like Spectrogram.ToImage, with DefaultMagnitudeMapping. Only
+// EncodingRGBA8 and EncodingRGBA16 can be used, since the phase is needed for the inverse.
+func (s *ConstantQSpectrogram) ToImage(encoding ImageEncoding) (image.Image, error) {
+	mapping := DefaultMagnitudeMapping
+	if err := mapping.Validate(); err != nil {
+		return nil, err
+	}
+	if mapping.Auto {
+		mapping = mapping.resolve(appendLevels(nil, s.Bins, 1))
+	}
+	return s.toImage(encoding, mapping)
+}
+
+// toImage encodes the constant-Q spectrogram with the given encoding and mapping, which must not be automatic
+func (s *ConstantQSpectrogram) toImage(encoding ImageEncoding, mapping MagnitudeMapping) (image.Image, error) {
+	switch encoding {
+	case EncodingRGBA8:
+		return encodeRGBA(s.Bins, s.Options.Rows(s.SampleRate), 1, mapping), nil
+	case EncodingRGBA16:
+		return encodeRGBA64(s.Bins, s.Options.Rows(s.SampleRate), 1, mapping), nil
+	}
+	return nil, fmt.Errorf("the %s encoding can not be used for constant-Q spectrograms, only %s and %s", encoding, EncodingRGBA8, EncodingRGBA16)
+}
+
+// header returns the header for an image of the constant-Q spectrogram with the given encoding and mapping
+func (s *ConstantQSpectrogram) header(encoding ImageEncoding, mapping MagnitudeMapping) SpectrogramHeader {
+	return SpectrogramHeader{
+		Version:        SpectrogramHeaderVersion,
+		Encoding:       encoding.String(),
+		Channels:       1,
+		Length:         s.Length,
+		SampleRate:     s.SampleRate,
+		MinDB:          mapping.Floor,
+		MaxDB:          mapping.Ceiling,
+		Curve:          mapping.Curve.String(),
+		CurveParameter: mapping.CurveParameter,
+		MinFrequency:   s.Options.MinFrequency,
+		MaxFrequency:   s.Options.MaxFrequency,
+		Transform:      constantQTransform,
+		BinsPerOctave:  s.Options.BinsPerOctave,
+	}
+}
+
+// ConstantQSpectrogramImages converts one constant-Q spectrogram per channel to images with the given
+// encoding and DefaultMagnitudeMapping, and returns them together with the header that is needed for
+// converting them back, like SpectrogramImages
+func ConstantQSpectrogramImages(spectrograms []*ConstantQSpectrogram, encoding ImageEncoding) ([]image.Image, SpectrogramHeader, error) {
+	return ConstantQSpectrogramImagesWithMapping(spectrograms, encoding, DefaultMagnitudeMapping)
+}
+
+// ConstantQSpectrogramImagesWithMapping is like ConstantQSpectrogramImages, but with the given magnitude mapping
+func ConstantQSpectrogramImagesWithMapping(spectrograms []*ConstantQSpectrogram, encoding ImageEncoding, mapping MagnitudeMapping) ([]image.Image, SpectrogramHeader, error) {
+	if len(spectrograms) == 0 {
+		return nil, SpectrogramHeader{}, errors.New("no spectrograms")
+	}
+	if err := mapping.Validate(); err != nil {
+		return nil, SpectrogramHeader{}, err
+	}
+	var levels []float64
+	for _, s := range spectrograms {
+		first := spectrograms[0]
+		if s.Columns() != first.Columns() || s.Options != first.Options || s.SampleRate != first.SampleRate {
+			return nil, SpectrogramHeader{}, errors.New("the spectrograms of all channels must have the same size and options")
+		}
+		if mapping.Auto {
+			levels = appendLevels(levels, s.Bins, 1)
+		}
+	}
+	if mapping.Auto {
+		mapping = mapping.resolve(levels)
+	}
+	imgs := make([]image.Image, len(spectrograms))
+	for i, s := range spectrograms {
+		img, err := s.toImage(encoding, mapping)
+		if err != nil {
+			return nil, SpectrogramHeader{}, err
+		}
+		imgs[i] = img
+	}
+	header := spectrograms[0].header(encoding, mapping)
+	header.Channels = len(spectrograms)
+	return imgs, header, nil
+}
+
+// validateConstantQ returns an error if a valid header is not for a ConstantQSpectrogram,
+// or if its options do not match an image of the given size
+func (h SpectrogramHeader) validateConstantQ(width, height int) error {
+	if h.Transform != constantQTransform {
+		return errors.New("the spectrogram metadata is not for a constant-Q spectrogram")
+	}
+	encoding, _ := h.ImageEncoding()
+	options, err := h.ConstantQOptions()
+	if err != nil {
+		return err
+	}
+	if encoding != EncodingRGBA8 && encoding != EncodingRGBA16 {
+		return fmt.Errorf("the %s encoding can not be used for constant-Q spectrograms, only %s and %s", encoding, EncodingRGBA8, EncodingRGBA16)
+	}
+	// The number of bins and the size of the windows are given by the metadata, so they are checked
+	// against the size of the image before the bins and the windows are created. The widest window
+	// has at least length * maxBandwidth / sampleRate - 2 FFT bins, which is a lower bound for the columns.
+	octaves := math.Log2(options.maxFrequency(h.SampleRate) / options.MinFrequency)
+	if float64(options.BinsPerOctave)*octaves > float64(height) {
+		return fmt.Errorf("the image is %d pixels high, but the metadata gives more constant-Q bins than that", height)
+	}
+	if float64(h.Length)*options.maxBandwidth(h.SampleRate)/float64(h.SampleRate)-2 > float64(width) {
+		return fmt.Errorf("the image is %d pixels wide, which is too narrow for the %d samples in the metadata", width, h.Length)
+	}
+	rows, columns := options.Rows(h.SampleRate), options.Columns(h.Length, h.SampleRate)
+	switch {
+	case height != h.Channels*rows:
+		return fmt.Errorf("the image is %d pixels high, but the metadata gives %d channels of %d constant-Q bins", height, h.Channels, rows)
+	case width < columns:
+		// More columns than needed still give an exact inverse, which is what carving leaves behind
+		return fmt.Errorf("the image is %d pixels wide, but the metadata gives at least %d columns for %d samples", width, columns, h.Length)
+	}
+	return nil
+}
+
+// ConstantQSpectrogramFromImage converts an image that was created with ConstantQSpectrogram.ToImage
+// back to a constant-Q spectrogram, with the options of the header, which is validated against the
+// size of the image
+func ConstantQSpectrogramFromImage(img image.Image, header SpectrogramHeader) (*ConstantQSpectrogram, error) {
+	if err := validateChannel(img, header, header.validateConstantQ); err != nil {
+		return nil, err
+	}
+	encoding, _ := header.ImageEncoding()
+	options, _ := header.ConstantQOptions()
+	mapping, _ := header.Mapping()
+	var bins [][]complex128
+	if encoding == EncodingRGBA8 {
+		bins = fromRGBA(img, 1, mapping)
+	} else {
+		bins = fromRGBA64(img, true, 1, mapping)
+	}
+	return &ConstantQSpectrogram{Bins: bins, Options: options, SampleRate: header.SampleRate, Length: header.Length}, nil
+}
+
+// The duration of the pieces that the seams of CarveConstantQSpectrograms consist of, in seconds
+const constantQSeamDuration = 0.01
+
+// CarveConstantQSpectrograms removes seams from one constant-Q spectrogram per channel to reduce their
+// width by about the given percentage, like CarveSpectrograms. The highest bins need hundreds of columns
+// per second, so the seams are found in blocks of columns that are about 10 ms long, in the average level
+// of each block, and whole blocks are removed from each row. The complex values are carved directly,
+// since their phase is relative to the centre frequency of each bin. The lengths are reduced by the same
+// percentage, which keeps the number of columns enough for the inverse.
+func CarveConstantQSpectrograms(spectrograms []*ConstantQSpectrogram, newWidthInPercentage float64) ([]*ConstantQSpectrogram, error) {
+	if len(spectrograms) == 0 {
+		return []*ConstantQSpectrogram{}, nil
+	}
+	width := spectrograms[0].Columns()
+	for _, s := range spectrograms[1:] {
+		if s.Columns() != width {
+			return nil, fmt.Errorf("the spectrograms have different widths, %d and %d", width, s.Columns())
+		}
+	}
+
+	// The columns after the last whole block are kept
+	blockSize := 1
+	if first := spectrograms[0]; first.Length > 0 {
+		blockSize = int(math.Round(constantQSeamDuration * float64(first.SampleRate) * float64(width) / float64(first.Length)))
+	}
+	if blockSize < 1 || blockSize > width {
+		blockSize = 1
+	}
+	blocks := width / blockSize
+	newBlocks := int(float64(blocks) * newWidthInPercentage / 100.0)
+	if newBlocks < 1 || newBlocks > blocks {
+		return nil, fmt.Errorf("can not carve %d columns to %d columns", width, int(float64(width)*newWidthInPercentage/100.0))
+	}
+	newWidth := width - (blocks-newBlocks)*blockSize
+
+	// Stack the blocks of bins and their average levels as rows of blocks
+	var rows [][][]complex128
+	var levels [][]float64
+	for _, s := range spectrograms {
+		for y, rowLevels := range s.levels() {
+			row := make([][]complex128, blocks)
+			blockLevels := make([]float64, blocks)
+			for b := range row {
+				row[b] = make([]complex128, blockSize)
+				for i := range row[b] {
+					row[b][i] = s.Bins[b*blockSize+i][y]
+					blockLevels[b] += rowLevels[b*blockSize+i] / float64(blockSize)
+				}
+			}
+			rows = append(rows, row)
+			levels = append(levels, blockLevels)
+		}
+	}
+
+	rows = carveRows(rows, levels, newBlocks)
+
+	// Split the rows into one spectrogram per channel again
+	carved := make([]*ConstantQSpectrogram, len(spectrograms))
+	y := 0
+	for i, s := range spectrograms {
+		c := *s
+		numRows := s.Options.Rows(s.SampleRate)
+		c.Bins = make([][]complex128, newWidth)
+		for x := range c.Bins {
+			c.Bins[x] = make([]complex128, numRows)
+			for row := range c.Bins[x] {
+				if x < newBlocks*blockSize {
+					c.Bins[x][row] = rows[y+row][x/blockSize][x%blockSize]
+				} else {
+					c.Bins[x][row] = s.Bins[blocks*blockSize+x-newBlocks*blockSize][row]
+				}
+			}
+		}
+		y += numRows
+
+		// The columns stay as long as they were, and a shorter length needs fewer columns
+		c.Length = int(float64(s.Length) * float64(newWidth) / float64(width))
+		for c.Length > 0 && s.Options.Columns(c.Length, s.SampleRate) > newWidth {
+			c.Length--
+		}
+		carved[i] = &c
+	}
+	return carved, nil
+}
@@ -0,0 +1,124 @@
+package wavecarve
+
+import (
+	"image"
+	"testing"
+)
+
+func TestConstantQPerfectReconstruction(t *testing.T) {
+	tests := []struct {
+		name    string
+		options ConstantQOptions
+	}{
+		{"default", DefaultConstantQOptions},
+		{"quarter tones", ConstantQOptions{BinsPerOctave: 24, MinFrequency: 100}},
+		{"octaves up to 4 kHz", ConstantQOptions{BinsPerOctave: 1, MinFrequency: 50, MaxFrequency: 4000}},
+	}
+	audio := testAudio(2)
+	for _, tt := range tests {
+		spectrograms, err := audio.ConstantQSpectrograms(tt.options)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		recreated, err := AudioFromConstantQSpectrograms(spectrograms)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		// The transform is one FFT of the whole signal, so the rounding errors are about 1e-12
+		if difference := maxDifference(audio, recreated); difference > 1e-11 {
+			t.Errorf("%s: the largest difference is %g", tt.name, difference)
+		}
+	}
+}
+
+func TestConstantQImageRoundTrip(t *testing.T) {
+	tests := []struct {
+		encoding  ImageEncoding
+		tolerance float64
+	}{
+		{EncodingRGBA8, 0.05},
+		{EncodingRGBA16, 5e-4},
+	}
+	audio := testAudio(1)
+	spectrograms, err := audio.ConstantQSpectrograms(DefaultConstantQOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		imgs, header, err := ConstantQSpectrogramImages(spectrograms, tt.encoding)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.encoding, err)
+		}
+		spectrogram, err := ConstantQSpectrogramFromImage(pngRoundTrip(t, imgs[0]), header)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.encoding, err)
+		}
+		recreated := audio.WithChannels([][]float64{spectrogram.Samples()})
+		if difference := maxDifference(audio, recreated); difference > tt.tolerance {
+			t.Errorf("%s: the largest difference is %g", tt.encoding, difference)
+		}
+	}
+}
+
+func TestCarveConstantQSpectrograms(t *testing.T) {
+	// The seams are found in blocks of about 10 ms, and the bins are narrow enough for blocks of one column
+	audio := testAudio(2)
+	spectrograms, err := audio.ConstantQSpectrograms(ConstantQOptions{BinsPerOctave: 192, MinFrequency: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	width := spectrograms[0].Columns()
+	tests := []struct {
+		name       string
+		percentage float64
+		columns    int
+	}{
+		{"unchanged", 100, width},
+		{"half", 50, width / 2},
+		{"one column", oneColumn(width), 1},
+	}
+	for _, tt := range tests {
+		carved, err := CarveConstantQSpectrograms(spectrograms, tt.percentage)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for c, s := range carved {
+			if s.Columns() != tt.columns {
+				t.Errorf("%s: channel %d has %d columns, want %d", tt.name, c, s.Columns(), tt.columns)
+			}
+		}
+		recreated, err := AudioFromConstantQSpectrograms(carved)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if recreated.NumChannels() != 2 || recreated.Frames() > audio.Frames() {
+			t.Errorf("%s: got %d channels of %d samples from %d samples", tt.name, recreated.NumChannels(), recreated.Frames(), audio.Frames())
+		}
+	}
+}
+
+func TestConstantQHostileLength(t *testing.T) {
+	// A length that would need terabytes of windows, for an image that is 4 pixels wide
+	spectrograms, err := testAudio(1).ConstantQSpectrograms(DefaultConstantQOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgs, header, err := ConstantQSpectrogramImages(spectrograms, EncodingRGBA16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := subImage(imgs[0], image.Rect(0, 0, 4, imgs[0].Bounds().Dy()))
+	tests := []struct {
+		name   string
+		length int
+	}{
+		{"larger than MaxDataSize", 1 << 40},
+		{"too long for the width", 1 << 20},
+	}
+	for _, tt := range tests {
+		header.Length = tt.length
+		if _, err := ConstantQSpectrogramFromImage(img, header); err == nil {
+			t.Errorf("%s: a length of %d samples was accepted for a 4 pixels wide image", tt.name, tt.length)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package wavecarve
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/mjibson/go-dsp/fft"
+)
+
+// The centre frequency of the Morlet wavelet, in radians per standard deviation of its envelope,
+// when Omega0 is 0. This gives about 1 cycle per standard deviation.
+const defaultMorletOmega0 = 6.0
+
+// MorletOptions configures the continuous wavelet transform of a MorletScalogram
+type MorletOptions struct {
+	// VoicesPerOctave is the number of rows in each octave
+	VoicesPerOctave int
+
+	// MinFrequency is the centre frequency of the lowest row, in Hz, and MaxFrequency is the highest
+	// one. A MaxFrequency of 0 places the highest row one row below the Nyquist frequency.
+	MinFrequency float64
+	MaxFrequency float64
+
+	// Omega0 is the centre frequency of the wavelet, which trades time resolution for frequency
+	// resolution, like the bins per octave of a ConstantQSpectrogram, or 0 for the default of 6
+	Omega0 float64
+
+	// HopSize is the number of samples between two columns
+	HopSize int
+}
+
+// DefaultMorletOptions have 12 rows per octave, from C1 up to the Nyquist frequency, and a column every 128 samples
+var DefaultMorletOptions = MorletOptions{VoicesPerOctave: 12, MinFrequency: defaultConstantQMinFrequency, HopSize: 128}
+
+// Validate returns an error if the options can not be used for audio with the given sample rate
+func (o MorletOptions) Validate(sampleRate uint32) error {
+	nyquist := float64(sampleRate) / 2
+	switch {
+	case o.VoicesPerOctave < 1:
+		return fmt.Errorf("invalid number of voices per octave %d, there must be at least one", o.VoicesPerOctave)
+	case !(o.MinFrequency > 0) || math.IsInf(o.MinFrequency, 0):
+		return fmt.Errorf("invalid lowest frequency %g Hz, it must be above 0 Hz", o.MinFrequency)
+	case o.MaxFrequency < 0 || math.IsNaN(o.MaxFrequency):
+		return fmt.Errorf("invalid highest frequency %g Hz", o.MaxFrequency)
+	case o.MaxFrequency >= nyquist:
+		return fmt.Errorf("the highest frequency %g Hz must be below the Nyquist frequency of %g Hz", o.MaxFrequency, nyquist)
+	case o.maxFrequency(sampleRate) < o.MinFrequency:
+		return fmt.Errorf("invalid frequency range from %g Hz to %g Hz", o.MinFrequency, o.maxFrequency(sampleRate))
+	case o.Omega0 < 0 || math.IsNaN(o.Omega0) || math.IsInf(o.Omega0, 0):
+		return fmt.Errorf("invalid Morlet omega0 %g, it can not be negative", o.Omega0)
+	case o.HopSize < 1:
+		return fmt.Errorf("invalid hop size %d, it must be at least 1", o.HopSize)
+	}
+	return nil
+}
+
+// maxFrequency returns the highest centre frequency that is allowed, with the default filled in
+func (o MorletOptions) maxFrequency(sampleRate uint32) float64 {
+	if o.MaxFrequency == 0 {
+		return float64(sampleRate) / 2 * math.Exp2(-1/float64(o.VoicesPerOctave))
+	}
+	return o.MaxFrequency
+}
+
+// omega0 returns the centre frequency of the wavelet, with the default filled in
+func (o MorletOptions) omega0() float64 {
+	if o.Omega0 == 0 {
+		return defaultMorletOmega0
+	}
+	return o.Omega0
+}
+
+// CentreFrequencies returns the centre frequency of each row of a MorletScalogram, in Hz, from the lowest one
+func (o MorletOptions) CentreFrequencies(sampleRate uint32) []float64 {
+	var centres []float64
+	maxFrequency := o.maxFrequency(sampleRate)
+	for k := 0; ; k++ {
+		f := o.MinFrequency * math.Exp2(float64(k)/float64(o.VoicesPerOctave))
+		// Allow for rounding errors when the highest frequency is a row
+		if f > maxFrequency*(1+1e-9) {
+			break
+		}
+		centres = append(centres, f)
+	}
+	return centres
+}
+
+// Columns returns the number of columns of a MorletScalogram of the given number of samples
+func (o MorletOptions) Columns(length int) int {
+	if length <= 0 {
+		return 0
+	}
+	return (length + o.HopSize - 1) / o.HopSize
+}
+
+// MorletScalogram holds the continuous wavelet transform of audio with the analytic Morlet wavelet, at
+// centre frequencies that are spaced logarithmically, like a ConstantQSpectrogram. Every row is computed
+// at every sample, so transients are as sharp as the wavelet of each row allows, and the columns are
+// taken every HopSize samples. The transform is very redundant, and is only meant for visualisation:
+// there is no inverse.
+type MorletScalogram struct {
+	// Bins holds one slice of complex values per column, one per centre frequency, from the lowest
+	// one at index 0. A full scale sinusoid at a centre frequency has a magnitude of 0.5.
+	Bins [][]complex128
+
+	Options    MorletOptions
+	SampleRate uint32
+	Length     int
+}
+
+// NewMorletScalogram creates a Morlet scalogram from samples in the range [-1, 1]. The wavelets are
+// applied in the frequency domain, with a single FFT of the whole audio, which is padded with enough
+// zeros to keep the end of the audio from wrapping around to the start.
+func NewMorletScalogram(float64s []float64, sampleRate uint32, options MorletOptions) (*MorletScalogram, error) {
+	if err := options.Validate(sampleRate); err != nil {
+		return nil, err
+	}
+	s := &MorletScalogram{Options: options, SampleRate: sampleRate, Length: len(float64s)}
+	columns := options.Columns(len(float64s))
+	if columns == 0 {
+		return s, nil
+	}
+	centres := options.CentreFrequencies(sampleRate)
+	omega0 := options.omega0()
+
+	// Pad with four standard deviations of the widest wavelet, to a power of two number of columns
+	padding := int(math.Ceil(4 * omega0 / (2 * math.Pi * options.MinFrequency) * float64(sampleRate)))
+	paddedColumns := 1
+	for paddedColumns*options.HopSize < len(float64s)+padding {
+		paddedColumns *= 2
+	}
+	n := paddedColumns * options.HopSize
+	padded := make([]float64, n)
+	copy(padded, float64s)
+	spectrum := fft.FFTReal(padded)
+
+	s.Bins = make([][]complex128, columns)
+	for i := range s.Bins {
+		s.Bins[i] = make([]complex128, len(centres))
+	}
+	folded := make([]complex128, paddedColumns)
+	for j, centre := range centres {
+		// Only the columns are needed, so the spectrum is folded to their rate, which is exact
+		for i := range folded {
+			folded[i] = 0
+		}
+		for m := 0; m <= n/2; m++ {
+			freq := float64(m) * float64(sampleRate) / float64(n)
+			x := omega0*freq/centre - omega0
+			if x < -40 || x > 40 {
+				continue
+			}
+			// The analytic wavelet has no negative frequencies, and a gain of 1 at its centre frequency
+			folded[m%paddedColumns] += spectrum[m] * complex(math.Exp(-0.5*x*x), 0)
+		}
+		for i, val := range fft.IFFT(folded) {
+			if i >= columns {
+				break
+			}
+			s.Bins[i][j] = val / complex(float64(options.HopSize), 0)
+		}
+	}
+	return s, nil
+}
+
+// MorletScalograms creates one Morlet scalogram per channel, with the given options
+func (a *Audio) MorletScalograms(options MorletOptions) ([]*MorletScalogram, error) {
+	scalograms := make([]*MorletScalogram, a.NumChannels())
+	for c, channel := range a.Channels {
+		scalogram, err := NewMorletScalogram(channel, a.SampleRate, options)
+		if err != nil {
+			return nil, err
+		}
+		scalograms[c] = scalogram
+	}
+	return scalograms, nil
+}
+
+// Columns returns the number of columns of the Morlet scalogram
+func (s *MorletScalogram) Columns() int {
+	return len(s.Bins)
+}
+
+// ToImage converts the Morlet scalogram to an image with one column per hop and one row per centre
+// frequency, with the lowest one at the top, with DefaultMagnitudeMapping, like Spectrogram.ToImage.
+// EncodingRGBA8, EncodingRGBA16 and EncodingGray16 can be used, and an EncodingRGBA8 image can be
+// carved with CarveSeams.
+func (s *MorletScalogram) ToImage(encoding ImageEncoding) (image.Image, error) {
+	imgs, err := MorletScalogramImages([]*MorletScalogram{s}, encoding)
+	if err != nil {
+		return nil, err
+	}
+	return imgs[0], nil
+}
+
+// MorletScalogramImages converts one Morlet scalogram per channel to images with the given encoding and
+// DefaultMagnitudeMapping. If the mapping is automatic, the same range is picked for all of the channels.
+// The images have no SpectrogramHeader, since they can not be converted back to audio.
+func MorletScalogramImages(scalograms []*MorletScalogram, encoding ImageEncoding) ([]image.Image, error) {
+	return MorletScalogramImagesWithMapping(scalograms, encoding, DefaultMagnitudeMapping)
+}
+
+// MorletScalogramImagesWithMapping is like MorletScalogramImages, but with the given magnitude mapping
+func MorletScalogramImagesWithMapping(scalograms []*MorletScalogram, encoding ImageEncoding, mapping MagnitudeMapping) ([]image.Image, error) {
+	if len(scalograms) == 0 {
+		return nil, errors.New("no scalograms")
+	}
+	if err := mapping.Validate(); err != nil {
+		return nil, err
+	}
+	if mapping.Auto {
+		var levels []float64
+		for _, s := range scalograms {
+			levels = appendLevels(levels, s.Bins, 1)
+		}
+		mapping = mapping.resolve(levels)
+	}
+	imgs := make([]image.Image, len(scalograms))
+	for i, s := range scalograms {
+		rows := len(s.Options.CentreFrequencies(s.SampleRate))
+		switch encoding {
+		case EncodingRGBA8:
+			imgs[i] = encodeRGBA(s.Bins, rows, 1, mapping)
+		case EncodingRGBA16:
+			imgs[i] = encodeRGBA64(s.Bins, rows, 1, mapping)
+		case EncodingGray16:
+			imgs[i] = encodeGray16(s.Bins, rows, 1, mapping)
+		default:
+			return nil, fmt.Errorf("the %s encoding can not be used for Morlet scalograms, only %s, %s and %s", encoding, EncodingRGBA8, EncodingRGBA16, EncodingGray16)
+		}
+	}
+	return imgs, nil
+}
@@ -0,0 +1,409 @@
+package wavecarve
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/cmplx"
+	"strings"
+)
+
+// ImageEncoding selects how the bins of a Spectrogram are stored in the pixels of an image
+type ImageEncoding int
+
+const (
+	// EncodingRGBA8 stores the magnitude in dB in red, the phase in green and the level of
+	// the loudest bin of the column in blue, with 8 bits each. This is the encoding of
+	// CreateSpectrogramFromAudio.
+	EncodingRGBA8 ImageEncoding = iota
+
+	// EncodingRGBA16 stores the same channels as EncodingRGBA8, but with 16 bits each, in an
+	// *image.RGBA64. The steps of the magnitude and the phase are 256 times finer.
+	EncodingRGBA16
+
+	// EncodingGray16 stores only the magnitude in dB, with 16 bits, in an *image.Gray16.
+	// The phase is lost, so audio that is created from it has zero phase.
+	EncodingGray16
+
+	// EncodingIF8 stores the magnitude in red, the time derivative of the phase (the instantaneous
+	// frequency deviation of each bin) in green and the level of the loudest bin of the column in
+	// blue, with 8 bits each. The phase is integrated from the derivative when the image is decoded,
+	// so it stays consistent when columns are removed or repeated, like when the image is carved.
+	EncodingIF8
+
+	// EncodingIF16 is EncodingIF8 with 16 bits per channel, in an *image.RGBA64
+	EncodingIF16
+
+	// EncodingIFGD8 is EncodingIF8 with the frequency derivative of the phase (the local group
+	// delay) in blue, instead of the level of the loudest bin. The phase is then integrated over
+	// both time and frequency, which keeps the bins of each partial coherent.
+	EncodingIFGD8
+
+	// EncodingIFGD16 is EncodingIFGD8 with 16 bits per channel, in an *image.RGBA64
+	EncodingIFGD16
+
+	// EncodingSigned8 stores a real, signed value per bin, like the coefficients of an MDCTSpectrogram
+	// or a WaveletPacketSpectrogram, as a shade of grey in an *image.RGBA. Mid-grey (128) is 0, and the
+	// magnitude, which is mapped like the magnitudes of the other encodings, is added to it for positive
+	// values and subtracted for negative ones, with 7 bits each. The image can be edited and carved like
+	// any other image.
+	EncodingSigned8
+
+	// EncodingSigned16 is EncodingSigned8 with 16 bits, in an *image.Gray16, where 32768 is 0
+	EncodingSigned16
+)
+
+var encodingNames = [...]string{
+	EncodingRGBA8:    "rgba8",
+	EncodingRGBA16:   "rgba16",
+	EncodingGray16:   "gray16",
+	EncodingIF8:      "if8",
+	EncodingIF16:     "if16",
+	EncodingIFGD8:    "ifgd8",
+	EncodingIFGD16:   "ifgd16",
+	EncodingSigned8:  "signed8",
+	EncodingSigned16: "signed16",
+}
+
+// The default range of magnitudes, in dB relative to full scale, that is stored in the images
+const (
+	minDB = -140.0
+	maxDB = 0.0
+)
+
+// String returns the name of the encoding, as accepted by ParseImageEncoding
+func (e ImageEncoding) String() string {
+	if e >= 0 && int(e) < len(encodingNames) {
+		return encodingNames[e]
+	}
+	return fmt.Sprintf("ImageEncoding(%d)", int(e))
+}
+
+// ParseImageEncoding returns the image encoding with the given name, like "rgba16"
+func ParseImageEncoding(name string) (ImageEncoding, error) {
+	for e, encodingName := range encodingNames {
+		if strings.EqualFold(name, encodingName) {
+			return ImageEncoding(e), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown image encoding %q, expected one of: %s", name, strings.Join(encodingNames[:], ", "))
+}
+
+// DetectImageEncoding returns the encoding that matches the type of the image, as it is returned
+// by ToImage or when the image is read from a PNG or TIFF file with ReadImageFile. The phase
+// derivative encodings have the same image types as EncodingRGBA8 and EncodingRGBA16, and the
+// signed encodings have the same image types as EncodingRGBA8 and EncodingGray16, so they can
+// only be told apart by the SpectrogramHeader.
+func DetectImageEncoding(img image.Image) (ImageEncoding, error) {
+	switch img.(type) {
+	case *image.RGBA, *image.NRGBA:
+		return EncodingRGBA8, nil
+	case *image.RGBA64, *image.NRGBA64:
+		return EncodingRGBA16, nil
+	case *image.Gray16:
+		return EncodingGray16, nil
+	}
+	return 0, fmt.Errorf("can not detect the spectrogram encoding of an image of type %T", img)
+}
+
+// ToImage converts the spectrogram to an image with one column per frame and one row per
+// frequency bin, with 0 Hz at the top. The magnitudes are divided by the gain of the window,
+// so that a full scale sinusoid is at about -6 dB, and mapped to pixel values with
+// DefaultMagnitudeMapping, which keeps the range from -140 dB to 0 dB by default.
+// Images are an export format: the spectrogram itself keeps the full precision.
+func (s *Spectrogram) ToImage(encoding ImageEncoding) (image.Image, error) {
+	return s.ToImageWithMapping(encoding, DefaultMagnitudeMapping)
+}
+
+// ToImageWithMapping converts the spectrogram to an image, like ToImage, with the given magnitude mapping.
+// If the mapping is automatic, the range is picked from this spectrogram, just like Header does.
+func (s *Spectrogram) ToImageWithMapping(encoding ImageEncoding, mapping MagnitudeMapping) (image.Image, error) {
+	mapping, err := mapping.Resolve([]*Spectrogram{s})
+	if err != nil {
+		return nil, err
+	}
+	switch encoding {
+	case EncodingRGBA8:
+		return s.toRGBA(mapping), nil
+	case EncodingRGBA16:
+		return s.toRGBA64(mapping), nil
+	case EncodingGray16:
+		return s.toGray16(mapping), nil
+	case EncodingIF8, EncodingIFGD8:
+		return s.toPhaseDerivativeRGBA(mapping, encoding == EncodingIFGD8), nil
+	case EncodingIF16, EncodingIFGD16:
+		return s.toPhaseDerivativeRGBA64(mapping, encoding == EncodingIFGD16), nil
+	case EncodingSigned8, EncodingSigned16:
+		return nil, fmt.Errorf("the %s encoding is for real-valued transforms, like the MDCT and wavelet packets, and can not be used for spectrograms", encoding)
+	}
+	return nil, fmt.Errorf("unknown image encoding %d", int(encoding))
+}
+
+// SpectrogramFromImage converts an image of one spectrogram that was created with ToImage back to a
+// spectrogram, with the encoding, STFT options, sample rate, length and magnitude mapping of the header,
+// which can be created with Spectrogram.Header. The header is validated against the size of the image.
+func SpectrogramFromImage(img image.Image, header SpectrogramHeader) (*Spectrogram, error) {
+	if err := validateChannel(img, header, header.validateSpectrogram); err != nil {
+		return nil, err
+	}
+	encoding, _ := header.ImageEncoding()
+	options, _ := header.Options()
+	mapping, _ := header.Mapping()
+	gain := windowGain(options.Window.Coefficients(options.FFTSize, options.WindowParameter))
+	var frames [][]complex128
+	switch encoding {
+	case EncodingRGBA8:
+		frames = fromRGBA(img, gain, mapping)
+	case EncodingRGBA16, EncodingGray16:
+		frames = fromRGBA64(img, encoding == EncodingRGBA16, gain, mapping)
+	default:
+		wide := encoding == EncodingIF16 || encoding == EncodingIFGD16
+		groupDelay := encoding == EncodingIFGD8 || encoding == EncodingIFGD16
+		frames = fromPhaseDerivativeImage(img, wide, groupDelay, options, mapping)
+	}
+	return &Spectrogram{Bins: frames, Options: options, SampleRate: header.SampleRate, Length: header.Length}, nil
+}
+
+// SpectrogramImages converts one spectrogram per channel to images with the given encoding and
+// DefaultMagnitudeMapping, and returns them together with the header that is needed for converting
+// them back with SpectrogramsFromImages. If the mapping is automatic, the same range is picked for all
+// of the channels. The spectrograms of all channels must have the same options and number of columns.
+func SpectrogramImages(spectrograms []*Spectrogram, encoding ImageEncoding) ([]image.Image, SpectrogramHeader, error) {
+	return SpectrogramImagesWithMapping(spectrograms, encoding, DefaultMagnitudeMapping)
+}
+
+// SpectrogramImagesWithMapping is like SpectrogramImages, but with the given magnitude mapping
+func SpectrogramImagesWithMapping(spectrograms []*Spectrogram, encoding ImageEncoding, mapping MagnitudeMapping) ([]image.Image, SpectrogramHeader, error) {
+	if len(spectrograms) == 0 {
+		return nil, SpectrogramHeader{}, errors.New("no spectrograms")
+	}
+	for _, s := range spectrograms[1:] {
+		if s.Columns() != spectrograms[0].Columns() || s.Options != spectrograms[0].Options {
+			return nil, SpectrogramHeader{}, errors.New("the spectrograms of all channels must have the same size and options")
+		}
+	}
+	mapping, err := mapping.Resolve(spectrograms)
+	if err != nil {
+		return nil, SpectrogramHeader{}, err
+	}
+	imgs := make([]image.Image, len(spectrograms))
+	for i, spectrogram := range spectrograms {
+		img, err := spectrogram.ToImageWithMapping(encoding, mapping)
+		if err != nil {
+			return nil, SpectrogramHeader{}, err
+		}
+		imgs[i] = img
+	}
+	header := spectrograms[0].header(encoding, mapping)
+	header.Channels = len(spectrograms)
+	return imgs, header, nil
+}
+
+// SpectrogramsFromImages converts one image per channel back to spectrograms, just like SpectrogramFromImage
+func SpectrogramsFromImages(imgs []image.Image, header SpectrogramHeader) ([]*Spectrogram, error) {
+	header.Channels = 1
+	spectrograms := make([]*Spectrogram, len(imgs))
+	for i, img := range imgs {
+		spectrogram, err := SpectrogramFromImage(img, header)
+		if err != nil {
+			return nil, fmt.Errorf("channel %d: %w", i, err)
+		}
+		spectrograms[i] = spectrogram
+	}
+	return spectrograms, nil
+}
+
+// toRGBA encodes the spectrogram with EncodingRGBA8 and the given mapping, which must not be automatic
+func (s *Spectrogram) toRGBA(mapping MagnitudeMapping) *image.RGBA {
+	// The magnitudes are divided by the window gain, so that a full scale sinusoid is at about -6 dB
+	gain := windowGain(s.Options.Window.Coefficients(s.Options.FFTSize, s.Options.WindowParameter))
+	return encodeRGBA(s.Bins, s.Options.Bins(), gain, mapping)
+}
+
+// encodeRGBA encodes columns of complex values with EncodingRGBA8, in an image with the given
+// number of rows, after dividing the magnitudes by the gain
+func encodeRGBA(bins [][]complex128, rows int, gain float64, mapping MagnitudeMapping) *image.RGBA {
+	// Create a new image with one column per frame and one row per frequency bin
+	img := image.NewRGBA(image.Rect(0, 0, len(bins), rows))
+
+	// Iterate over the frames
+	for i, fftFrame := range bins {
+		// Find the loudest bin in the frame, which shows the volume
+		volume := mapping.toByte(maxMagnitude(fftFrame) / gain)
+
+		// Set the pixels in the image
+		for j, val := range fftFrame {
+			// Map the magnitude of the FFT value to the range of 0-255
+			mag := mapping.toByte(cmplx.Abs(val) / gain)
+
+			// Compute the phase of the FFT value and normalize it
+			phase := cmplx.Phase(val)
+			// Map phase from [-pi, pi] to [0, 255]
+			phase = (phase + math.Pi) * 255 / (2 * math.Pi)
+
+			// Set the pixel in the image, red for magnitude, green for phase, blue for volume
+			img.Set(i, j, color.RGBA{mag, uint8(phase), volume, 255})
+		}
+	}
+
+	return img
+}
+
+// fromRGBA decodes the bins of an image with EncodingRGBA8, with the given mapping,
+// and multiplies the magnitudes by the gain that they were divided by when they were encoded
+func fromRGBA(img image.Image, gain float64, mapping MagnitudeMapping) [][]complex128 {
+	bounds := img.Bounds()
+
+	// Create a slice to hold the FFT frames
+	frames := make([][]complex128, bounds.Dx())
+
+	// Iterate over the pixels in the image
+	for x := range frames {
+		// Create a slice to hold the FFT frame
+		frames[x] = make([]complex128, bounds.Dy())
+
+		for y := range frames[x] {
+			// Get the pixel color
+			r, g, _, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+
+			// Shift right by 8 bits
+			r = r >> 8
+			g = g >> 8
+
+			// Compute the linear magnitude from the red value
+			mag := mapping.fromByte(uint8(r)) * gain
+
+			// Compute the phase from the green value
+			// Map phase from [0, 255] to [-pi, pi]
+			phase := float64(g)*2.0*math.Pi/255.0 - math.Pi
+
+			// Add the FFT value to the frame
+			frames[x][y] = cmplx.Rect(mag, phase)
+		}
+	}
+
+	return frames
+}
+
+// toRGBA64 encodes the spectrogram with EncodingRGBA16 and the given mapping, which must not be automatic
+func (s *Spectrogram) toRGBA64(mapping MagnitudeMapping) *image.RGBA64 {
+	gain := windowGain(s.Options.Window.Coefficients(s.Options.FFTSize, s.Options.WindowParameter))
+	return encodeRGBA64(s.Bins, s.Options.Bins(), gain, mapping)
+}
+
+// encodeRGBA64 encodes columns of complex values with EncodingRGBA16, like encodeRGBA
+func encodeRGBA64(bins [][]complex128, rows int, gain float64, mapping MagnitudeMapping) *image.RGBA64 {
+	img := image.NewRGBA64(image.Rect(0, 0, len(bins), rows))
+	for i, fftFrame := range bins {
+		// Find the loudest bin in the frame, which shows the volume
+		volume := mapping.toUint16(maxMagnitude(fftFrame) / gain)
+		for j, val := range fftFrame {
+			mag := mapping.toUint16(cmplx.Abs(val) / gain)
+			img.SetRGBA64(i, j, color.RGBA64{mag, phaseToUint16(cmplx.Phase(val)), volume, 0xffff})
+		}
+	}
+	return img
+}
+
+// toGray16 encodes the spectrogram with EncodingGray16 and the given mapping, which must not be automatic
+func (s *Spectrogram) toGray16(mapping MagnitudeMapping) *image.Gray16 {
+	gain := windowGain(s.Options.Window.Coefficients(s.Options.FFTSize, s.Options.WindowParameter))
+	return encodeGray16(s.Bins, s.Options.Bins(), gain, mapping)
+}
+
+// encodeGray16 encodes columns of complex values with EncodingGray16, like encodeRGBA
+func encodeGray16(bins [][]complex128, rows int, gain float64, mapping MagnitudeMapping) *image.Gray16 {
+	img := image.NewGray16(image.Rect(0, 0, len(bins), rows))
+	for i, fftFrame := range bins {
+		for j, val := range fftFrame {
+			img.SetGray16(i, j, color.Gray16{mapping.toUint16(cmplx.Abs(val) / gain)})
+		}
+	}
+	return img
+}
+
+// fromRGBA64 decodes the bins of an image with EncodingRGBA16, or with EncodingGray16 if withPhase
+// is false, with the full 16 bits of each channel and the given mapping and gain, like fromRGBA
+func fromRGBA64(img image.Image, withPhase bool, gain float64, mapping MagnitudeMapping) [][]complex128 {
+	bounds := img.Bounds()
+	frames := make([][]complex128, bounds.Dx())
+	for x := range frames {
+		frames[x] = make([]complex128, bounds.Dy())
+		for y := range frames[x] {
+			r, g, _, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			mag := mapping.fromUint16(uint16(r)) * gain
+			phase := 0.0
+			if withPhase {
+				phase = uint16ToPhase(uint16(g))
+			}
+			frames[x][y] = cmplx.Rect(mag, phase)
+		}
+	}
+	return frames
+}
+
+// encodeSigned8 encodes columns of real values with EncodingSigned8, in an image with the given
+// number of rows, after dividing the values by the gain
+func encodeSigned8(columns [][]float64, rows int, gain float64, mapping MagnitudeMapping) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, len(columns), rows))
+	for i, column := range columns {
+		for j, val := range column {
+			v := mapping.toSignedByte(val / gain)
+			img.SetRGBA(i, j, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+// encodeSigned16 encodes columns of real values with EncodingSigned16, like encodeSigned8
+func encodeSigned16(columns [][]float64, rows int, gain float64, mapping MagnitudeMapping) *image.Gray16 {
+	img := image.NewGray16(image.Rect(0, 0, len(columns), rows))
+	for i, column := range columns {
+		for j, val := range column {
+			img.SetGray16(i, j, color.Gray16{mapping.toSignedUint16(val / gain)})
+		}
+	}
+	return img
+}
+
+// fromSigned decodes the values of an image with EncodingSigned8, or with EncodingSigned16 if wide is
+// true, and multiplies them by the gain that they were divided by when they were encoded. The grey
+// level of the pixels is used, so that images that have been converted to colour still decode.
+func fromSigned(img image.Image, wide bool, gain float64, mapping MagnitudeMapping) [][]float64 {
+	bounds := img.Bounds()
+	columns := make([][]float64, bounds.Dx())
+	for x := range columns {
+		columns[x] = make([]float64, bounds.Dy())
+		for y := range columns[x] {
+			v := color.Gray16Model.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray16).Y
+			if wide {
+				columns[x][y] = mapping.fromSignedUint16(v) * gain
+			} else {
+				columns[x][y] = mapping.fromSignedByte(uint8(v>>8)) * gain
+			}
+		}
+	}
+	return columns
+}
+
+// phaseToByte maps a phase in the range [-pi, pi] to the range 0-255
+func phaseToByte(phase float64) uint8 {
+	return uint8(math.Round((phase + math.Pi) * 255 / (2 * math.Pi)))
+}
+
+// byteToPhase maps a value in the range 0-255 to a phase in the range [-pi, pi]
+func byteToPhase(v uint8) float64 {
+	return float64(v)*2*math.Pi/255 - math.Pi
+}
+
+// phaseToUint16 maps a phase in the range [-pi, pi] to the range 0-65535
+func phaseToUint16(phase float64) uint16 {
+	return uint16(math.Round((phase + math.Pi) * 0xffff / (2 * math.Pi)))
+}
+
+// uint16ToPhase maps a value in the range 0-65535 to a phase in the range [-pi, pi]
+func uint16ToPhase(v uint16) float64 {
+	return float64(v)*2*math.Pi/0xffff - math.Pi
+}
@@ -0,0 +1,69 @@
+package wavecarve
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotRIFF is returned when a file does not start with "RIFF", "RF64" or "BW64"
+	ErrNotRIFF = errors.New("not a RIFF file")
+
+	// ErrNotWAVE is returned when a RIFF file is not a WAVE file
+	ErrNotWAVE = errors.New("not a WAVE file")
+
+	// ErrNotAIFF is returned when a file is not an AIFF or AIFF-C file
+	ErrNotAIFF = errors.New("not an AIFF or AIFF-C file")
+
+	// ErrNotFLAC is returned when a file does not start with the "fLaC" marker
+	ErrNotFLAC = errors.New("not a FLAC file")
+)
+
+// Limits that protect against malformed or hostile files
+var (
+	// MaxDataSize is the largest data chunk, in bytes, that is read into memory by
+	// ReadWavFile and the other functions that read a whole file at once.
+	// Use a WavReader to read larger files in blocks. SpectrogramHeader.Validate
+	// also uses it as the limit for the float64 samples that a header can give.
+	MaxDataSize int64 = 2 << 30
+
+	// MaxMetadataChunkSize is the largest metadata chunk, in bytes, that is parsed.
+	// Larger metadata chunks are skipped.
+	MaxMetadataChunkSize int64 = 16 << 20
+)
+
+// The largest fmt or ds64 chunk that is accepted
+const maxFormatChunkSize = 64 << 10
+
+// ErrUnsupportedFormat is returned when the sample format of a file is not supported
+type ErrUnsupportedFormat struct {
+	AudioFormat uint16
+	Bits        uint16
+}
+
+func (e ErrUnsupportedFormat) Error() string {
+	return fmt.Sprintf("unsupported audio format %d with %d bits per sample", e.AudioFormat, e.Bits)
+}
+
+// ErrTruncated is returned when a file ends before the audio data does.
+// The functions that return it also return the audio data that could be read,
+// so it can be treated as a warning.
+type ErrTruncated struct {
+	Want int64 // the size of the audio data, in bytes, according to the file
+	Got  int64 // the number of bytes of audio data that could be read
+}
+
+func (e ErrTruncated) Error() string {
+	return fmt.Sprintf("truncated audio data: expected %d bytes, but got %d", e.Want, e.Got)
+}
+
+// ErrClipped is returned when samples were outside of the range [-1, 1] when they were converted
+// to integers, and had to be clamped or soft clipped. The functions that return it also write or
+// return all of the audio data, so it can be treated as a warning.
+type ErrClipped struct {
+	Samples int // the number of samples that clipped
+}
+
+func (e ErrClipped) Error() string {
+	return fmt.Sprintf("%d samples clipped", e.Samples)
+}
@@ -0,0 +1,636 @@
+package wavecarve
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/cmplx"
+	"strings"
+)
+
+// FrequencyScale selects how the bands of a BandSpectrogram are spaced in frequency
+type FrequencyScale int
+
+const (
+	// ScaleMel spaces the bands evenly on the mel scale, which is close to linear below 1 kHz and
+	// logarithmic above it, like the perception of pitch
+	ScaleMel FrequencyScale = iota
+
+	// ScaleBark spaces the bands evenly on the Bark scale of the critical bands of hearing
+	ScaleBark
+
+	// ScaleLog spaces the bands evenly on a logarithmic scale, so that every octave gets the
+	// same number of bands
+	ScaleLog
+)
+
+var scaleNames = [...]string{
+	ScaleMel:  "mel",
+	ScaleBark: "bark",
+	ScaleLog:  "log",
+}
+
+// The lowest frequency of ScaleLog when MinFrequency is 0, in Hz
+const defaultLogMinFrequency = 20.0
+
+// String returns the name of the frequency scale, as accepted by ParseFrequencyScale
+func (s FrequencyScale) String() string {
+	if s >= 0 && int(s) < len(scaleNames) {
+		return scaleNames[s]
+	}
+	return fmt.Sprintf("FrequencyScale(%d)", int(s))
+}
+
+// ParseFrequencyScale returns the frequency scale with the given name, like "mel" or "log"
+func ParseFrequencyScale(name string) (FrequencyScale, error) {
+	for s, scaleName := range scaleNames {
+		if strings.EqualFold(name, scaleName) {
+			return FrequencyScale(s), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown frequency scale %q, expected one of: %s", name, strings.Join(scaleNames[:], ", "))
+}
+
+// toScale converts a frequency in Hz to the scale
+func (s FrequencyScale) toScale(hz float64) float64 {
+	switch s {
+	case ScaleBark:
+		// Traunmüller's formula
+		return 26.81*hz/(1960+hz) - 0.53
+	case ScaleLog:
+		return math.Log2(hz)
+	}
+	return 2595 * math.Log10(1+hz/700)
+}
+
+// fromScale converts a value on the scale back to a frequency in Hz
+func (s FrequencyScale) fromScale(v float64) float64 {
+	switch s {
+	case ScaleBark:
+		return 1960 * (v + 0.53) / (26.28 - v)
+	case ScaleLog:
+		return math.Exp2(v)
+	}
+	return 700 * (math.Pow(10, v/2595) - 1)
+}
+
+// FilterbankOptions configures the bands of a BandSpectrogram
+type FilterbankOptions struct {
+	Scale FrequencyScale
+	Bands int // the number of bands, which is the number of rows of the images
+
+	// MinFrequency and MaxFrequency give the frequency range of the bands, in Hz. A MaxFrequency
+	// of 0 is the Nyquist frequency, and a MinFrequency of 0 is 20 Hz for ScaleLog.
+	MinFrequency float64
+	MaxFrequency float64
+}
+
+// DefaultFilterbankOptions are 128 mel bands from 0 Hz up to the Nyquist frequency
+var DefaultFilterbankOptions = FilterbankOptions{Scale: ScaleMel, Bands: 128}
+
+// Validate returns an error if the filterbank options can not be used for audio with the given sample rate
+func (o FilterbankOptions) Validate(sampleRate uint32) error {
+	nyquist := float64(sampleRate) / 2
+	minFrequency, maxFrequency := o.frequencyRange(sampleRate)
+	switch {
+	case o.Scale < 0 || int(o.Scale) >= len(scaleNames):
+		return fmt.Errorf("unknown frequency scale %d", int(o.Scale))
+	case o.Bands < 1:
+		return fmt.Errorf("invalid number of bands %d, there must be at least one", o.Bands)
+	case o.MinFrequency < 0 || o.MaxFrequency < 0:
+		return fmt.Errorf("invalid frequency range from %g Hz to %g Hz, the frequencies can not be negative", o.MinFrequency, o.MaxFrequency)
+	case !(minFrequency < maxFrequency):
+		return fmt.Errorf("invalid frequency range from %g Hz to %g Hz", minFrequency, maxFrequency)
+	case maxFrequency > nyquist:
+		return fmt.Errorf("the highest frequency %g Hz is above the Nyquist frequency of %g Hz", maxFrequency, nyquist)
+	}
+	return nil
+}
+
+// frequencyRange returns the frequency range of the bands, with the defaults filled in
+func (o FilterbankOptions) frequencyRange(sampleRate uint32) (float64, float64) {
+	minFrequency, maxFrequency := o.MinFrequency, o.MaxFrequency
+	if minFrequency == 0 && o.Scale == ScaleLog {
+		minFrequency = defaultLogMinFrequency
+	}
+	if maxFrequency == 0 {
+		maxFrequency = float64(sampleRate) / 2
+	}
+	return minFrequency, maxFrequency
+}
+
+// CentreFrequencies returns the centre frequency of each band, in Hz, from the lowest to the highest
+func (o FilterbankOptions) CentreFrequencies(sampleRate uint32) []float64 {
+	edges := o.edges(sampleRate)
+	return edges[1 : len(edges)-1]
+}
+
+// edges returns Bands+2 frequencies, evenly spaced on the scale. Band b rises from
+// edges[b] to its centre at edges[b+1] and falls to edges[b+2].
+func (o FilterbankOptions) edges(sampleRate uint32) []float64 {
+	minFrequency, maxFrequency := o.frequencyRange(sampleRate)
+	lo, hi := o.Scale.toScale(minFrequency), o.Scale.toScale(maxFrequency)
+	edges := make([]float64, o.Bands+2)
+	for i := range edges {
+		edges[i] = o.Scale.fromScale(lo + (hi-lo)*float64(i)/float64(o.Bands+1))
+	}
+	return edges
+}
+
+// filterbank holds the triangular filters that map the bins of a spectrogram to bands.
+// Each filter is normalised to a sum of 1, so that a band is a weighted average of the
+// magnitudes of its bins, and only the bins where it is not zero are stored.
+type filterbank struct {
+	starts  []int       // the first bin of each band
+	weights [][]float64 // the weights of the bins of each band, from the first bin
+	bins    int
+}
+
+// newFilterbank returns the filters for the bins of the given STFT options
+func newFilterbank(o FilterbankOptions, options SpectrogramOptions, sampleRate uint32) *filterbank {
+	edges := o.edges(sampleRate)
+	bins := options.Bins()
+	binWidth := float64(sampleRate) / float64(options.TransformSize())
+	fb := &filterbank{starts: make([]int, o.Bands), weights: make([][]float64, o.Bands), bins: bins}
+	for b := range fb.weights {
+		lower, centre, upper := edges[b], edges[b+1], edges[b+2]
+		first := int(math.Ceil(lower / binWidth))
+		var weights []float64
+		sum := 0.0
+		for k := first; k < bins && float64(k)*binWidth <= upper; k++ {
+			f := float64(k) * binWidth
+			var w float64
+			if f <= centre {
+				w = (f - lower) / (centre - lower)
+			} else {
+				w = (upper - f) / (upper - centre)
+			}
+			if w < 0 {
+				w = 0
+			}
+			weights = append(weights, w)
+			sum += w
+		}
+		if sum == 0 {
+			// The band is narrower than a bin, so it uses the bin that is closest to its centre
+			first = int(math.Round(centre / binWidth))
+			if first >= bins {
+				first = bins - 1
+			}
+			weights, sum = []float64{1}, 1
+		}
+		for i := range weights {
+			weights[i] /= sum
+		}
+		fb.starts[b], fb.weights[b] = first, weights
+	}
+	return fb
+}
+
+// apply returns the bands of the given bin magnitudes
+func (fb *filterbank) apply(mags []float64) []float64 {
+	bands := make([]float64, len(fb.weights))
+	for b, weights := range fb.weights {
+		for i, w := range weights {
+			bands[b] += w * mags[fb.starts[b]+i]
+		}
+	}
+	return bands
+}
+
+// transpose returns the bin magnitudes that the transposed filters give for the bands
+func (fb *filterbank) transpose(bands []float64) []float64 {
+	mags := make([]float64, fb.bins)
+	for b, weights := range fb.weights {
+		for i, w := range weights {
+			mags[fb.starts[b]+i] += w * bands[b]
+		}
+	}
+	return mags
+}
+
+// BandInversion selects how the bin magnitudes are estimated from the bands
+type BandInversion int
+
+const (
+	// InversionNNLS finds the non-negative bin magnitudes that give the bands with the least
+	// squared error, with multiplicative updates. This is the most accurate.
+	InversionNNLS BandInversion = iota
+
+	// InversionPseudoInverse uses the Moore-Penrose pseudo-inverse of the filterbank, which is
+	// faster, and sets the negative magnitudes to zero
+	InversionPseudoInverse
+)
+
+var inversionNames = [...]string{
+	InversionNNLS:          "nnls",
+	InversionPseudoInverse: "pinv",
+}
+
+// The number of multiplicative updates of InversionNNLS
+const nnlsIterations = 100
+
+// String returns the name of the band inversion, as accepted by ParseBandInversion
+func (i BandInversion) String() string {
+	if i >= 0 && int(i) < len(inversionNames) {
+		return inversionNames[i]
+	}
+	return fmt.Sprintf("BandInversion(%d)", int(i))
+}
+
+// ParseBandInversion returns the band inversion with the given name, like "nnls" or "pinv"
+func ParseBandInversion(name string) (BandInversion, error) {
+	for i, inversionName := range inversionNames {
+		if strings.EqualFold(name, inversionName) {
+			return BandInversion(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown band inversion %q, expected one of: %s", name, strings.Join(inversionNames[:], ", "))
+}
+
+// pseudoInverse returns a function that maps bands to bin magnitudes with the pseudo-inverse of
+// the filterbank, which is the transposed filterbank times the inverse of the Gram matrix of the filters
+func (fb *filterbank) pseudoInverse() func([]float64) []float64 {
+	n := len(fb.weights)
+	gram := make([][]float64, n)
+	trace := 0.0
+	for a := range gram {
+		gram[a] = make([]float64, n)
+		for b := range gram[a] {
+			// The filters only overlap if their bins overlap
+			for i, w := range fb.weights[a] {
+				if j := fb.starts[a] + i - fb.starts[b]; j >= 0 && j < len(fb.weights[b]) {
+					gram[a][b] += w * fb.weights[b][j]
+				}
+			}
+		}
+		trace += gram[a][a]
+	}
+	// Regularise, for when there are more bands than bins
+	for a := range gram {
+		gram[a][a] += 1e-9 * trace / float64(n)
+	}
+	l := cholesky(gram)
+	return func(bands []float64) []float64 {
+		mags := fb.transpose(choleskySolve(l, bands))
+		for k, mag := range mags {
+			if mag < 0 {
+				mags[k] = 0
+			}
+		}
+		return mags
+	}
+}
+
+// nnls returns the non-negative bin magnitudes that give the bands with the least squared error,
+// with the multiplicative updates of Lee and Seung, starting from the transposed filterbank
+func (fb *filterbank) nnls(bands []float64) []float64 {
+	const eps = 1e-300
+	target := fb.transpose(bands)
+	mags := append([]float64(nil), target...)
+	for iteration := 0; iteration < nnlsIterations; iteration++ {
+		estimate := fb.transpose(fb.apply(mags))
+		for k := range mags {
+			mags[k] *= target[k] / (estimate[k] + eps)
+		}
+	}
+	return mags
+}
+
+// cholesky returns the lower triangular Cholesky factor of a symmetric positive definite matrix
+func cholesky(a [][]float64) [][]float64 {
+	n := len(a)
+	l := make([][]float64, n)
+	for i := range l {
+		l[i] = make([]float64, n)
+		for j := 0; j <= i; j++ {
+			sum := a[i][j]
+			for k := 0; k < j; k++ {
+				sum -= l[i][k] * l[j][k]
+			}
+			if i == j {
+				l[i][i] = math.Sqrt(math.Max(sum, 1e-300))
+			} else {
+				l[i][j] = sum / l[j][j]
+			}
+		}
+	}
+	return l
+}
+
+// choleskySolve solves L L^T x = b for x
+func choleskySolve(l [][]float64, b []float64) []float64 {
+	n := len(l)
+	y := make([]float64, n)
+	for i := 0; i < n; i++ {
+		sum := b[i]
+		for k := 0; k < i; k++ {
+			sum -= l[i][k] * y[k]
+		}
+		y[i] = sum / l[i][i]
+	}
+	x := make([]float64, n)
+	for i := n - 1; i >= 0; i-- {
+		sum := y[i]
+		for k := i + 1; k < n; k++ {
+			sum -= l[k][i] * x[k]
+		}
+		x[i] = sum / l[i][i]
+	}
+	return x
+}
+
+// BandSpectrogram is a spectrogram with the magnitudes of frequency bands on a perceptual or
+// logarithmic scale, like a mel spectrogram, instead of one row per FFT bin. The bands are
+// found from the STFT, and converting back to audio estimates the bin magnitudes from the bands
+// and finds the phase with phase retrieval, so it is approximate.
+type BandSpectrogram struct {
+	// Bands holds one slice of Filterbank.Bands magnitudes per column, from the lowest band to the highest.
+	// The magnitudes are weighted averages of the magnitudes of the bins, so they are not scaled either.
+	Bands [][]float64
+
+	// Filterbank are the options of the bands, and Options are the STFT options
+	Filterbank FilterbankOptions
+	Options    SpectrogramOptions
+
+	// SampleRate is the sample rate of the audio, in Hz, and Length is its number of samples
+	SampleRate uint32
+	Length     int
+}
+
+// Bands returns the band spectrogram of the spectrogram, with the given filterbank options
+func (s *Spectrogram) Bands(options FilterbankOptions) (*BandSpectrogram, error) {
+	if err := options.Validate(s.SampleRate); err != nil {
+		return nil, err
+	}
+	fb := newFilterbank(options, s.Options, s.SampleRate)
+	bands := make([][]float64, len(s.Bins))
+	mags := make([]float64, s.Options.Bins())
+	for i, column := range s.Bins {
+		for k, val := range column {
+			mags[k] = cmplx.Abs(val)
+		}
+		bands[i] = fb.apply(mags)
+	}
+	return &BandSpectrogram{Bands: bands, Filterbank: options, Options: s.Options, SampleRate: s.SampleRate, Length: s.Length}, nil
+}
+
+// BandSpectrograms creates one band spectrogram per channel, with the given STFT and filterbank options
+func (a *Audio) BandSpectrograms(options SpectrogramOptions, filterbank FilterbankOptions) ([]*BandSpectrogram, error) {
+	spectrograms, err := a.Spectrograms(options)
+	if err != nil {
+		return nil, err
+	}
+	bands := make([]*BandSpectrogram, len(spectrograms))
+	for c, spectrogram := range spectrograms {
+		if bands[c], err = spectrogram.Bands(filterbank); err != nil {
+			return nil, err
+		}
+	}
+	return bands, nil
+}
+
+// AudioFromBandSpectrograms creates audio from one band spectrogram per channel, with the given
+// inversion and DefaultPhaseOptions, like BandSpectrogram.Spectrogram. The samples are not quantized.
+func AudioFromBandSpectrograms(spectrograms []*BandSpectrogram, inversion BandInversion) (*Audio, error) {
+	return AudioFromBandSpectrogramsWithPhase(spectrograms, inversion, DefaultPhaseOptions)
+}
+
+// AudioFromBandSpectrogramsWithPhase is like AudioFromBandSpectrograms, but the phase is found
+// with the given phase options
+func AudioFromBandSpectrogramsWithPhase(spectrograms []*BandSpectrogram, inversion BandInversion, phase PhaseOptions) (*Audio, error) {
+	if len(spectrograms) == 0 {
+		return nil, errors.New("no spectrograms to create audio from")
+	}
+	audio := NewAudio(0, 0, spectrograms[0].SampleRate)
+	audio.Channels = make([][]float64, len(spectrograms))
+	for c, b := range spectrograms {
+		spectrogram, err := b.Spectrogram(inversion, phase)
+		if err != nil {
+			return nil, fmt.Errorf("channel %d: %w", c, err)
+		}
+		audio.Channels[c] = spectrogram.Samples()
+	}
+	return audio, nil
+}
+
+// Columns returns the number of columns (frames) of the band spectrogram
+func (b *BandSpectrogram) Columns() int {
+	return len(b.Bands)
+}
+
+// Spectrogram estimates the magnitudes of the bins from the bands with the given inversion,
+// and finds their phase with the given phase options. Since the bands have no phase, PhaseKeep
+// is replaced by PhasePGHI, and InitialPhaseExisting starts from a phase of zero.
+func (b *BandSpectrogram) Spectrogram(inversion BandInversion, phase PhaseOptions) (*Spectrogram, error) {
+	if inversion < 0 || int(inversion) >= len(inversionNames) {
+		return nil, fmt.Errorf("unknown band inversion %d", int(inversion))
+	}
+	if err := b.Filterbank.Validate(b.SampleRate); err != nil {
+		return nil, err
+	}
+	fb := newFilterbank(b.Filterbank, b.Options, b.SampleRate)
+	invert := fb.nnls
+	if inversion == InversionPseudoInverse {
+		invert = fb.pseudoInverse()
+	}
+	bins := make([][]complex128, len(b.Bands))
+	for i, bands := range b.Bands {
+		if len(bands) != b.Filterbank.Bands {
+			return nil, fmt.Errorf("column %d has %d bands, expected %d", i, len(bands), b.Filterbank.Bands)
+		}
+		mags := invert(bands)
+		bins[i] = make([]complex128, len(mags))
+		for k, mag := range mags {
+			bins[i][k] = complex(mag, 0)
+		}
+	}
+	if phase.Method == PhaseKeep {
+		phase.Method = PhasePGHI
+	}
+	if phase.Initial == InitialPhaseExisting {
+		phase.Initial = InitialPhaseZero
+	}
+	s := &Spectrogram{Bins: bins, Options: b.Options, SampleRate: b.SampleRate, Length: b.Length}
+	return s.RetrievePhase(phase)
+}
+
+// levels returns the magnitude of each band in dB relative to full scale, as rows of columns
+func (b *BandSpectrogram) levels() [][]float64 {
+	gain := windowGain(b.Options.Window.Coefficients(b.Options.FFTSize, b.Options.WindowParameter))
+	rows := make([][]float64, b.Filterbank.Bands)
+	for y := range rows {
+		rows[y] = make([]float64, len(b.Bands))
+		for x, column := range b.Bands {
+			rows[y][x] = magnitudeToDB(column[y] / gain)
+		}
+	}
+	return rows
+}
+
+// ToImage converts the band spectrogram to an *image.Gray16 with EncodingGray16 and
+// DefaultMagnitudeMapping, with one row per band and the lowest band at the top, like the
+// images of a Spectrogram. If the mapping is automatic, the range is picked from this spectrogram.
+func (b *BandSpectrogram) ToImage() (*image.Gray16, error) {
+	mapping, err := DefaultMagnitudeMapping.Resolve([]*Spectrogram{b.asSpectrogram()})
+	if err != nil {
+		return nil, err
+	}
+	return b.toGray16(mapping), nil
+}
+
+// asSpectrogram returns a spectrogram with the bands as bins, which has the same levels
+func (b *BandSpectrogram) asSpectrogram() *Spectrogram {
+	bins := make([][]complex128, len(b.Bands))
+	for i, column := range b.Bands {
+		bins[i] = make([]complex128, len(column))
+		for k, mag := range column {
+			bins[i][k] = complex(mag, 0)
+		}
+	}
+	return &Spectrogram{Bins: bins, Options: b.Options, SampleRate: b.SampleRate, Length: b.Length}
+}
+
+// Header returns the header for an image of the band spectrogram with DefaultMagnitudeMapping.
+// If the mapping is automatic, the range is picked from this spectrogram.
+func (b *BandSpectrogram) Header() SpectrogramHeader {
+	mapping, err := DefaultMagnitudeMapping.Resolve([]*Spectrogram{b.asSpectrogram()})
+	if err != nil {
+		// Keep the invalid mapping, which Validate reports
+		mapping = DefaultMagnitudeMapping
+	}
+	return b.header(mapping)
+}
+
+// header returns the header for an image of the band spectrogram with the given mapping
+func (b *BandSpectrogram) header(mapping MagnitudeMapping) SpectrogramHeader {
+	header := b.asSpectrogram().header(EncodingGray16, mapping)
+	header.Scale = b.Filterbank.Scale.String()
+	header.Bands = b.Filterbank.Bands
+	header.MinFrequency = b.Filterbank.MinFrequency
+	header.MaxFrequency = b.Filterbank.MaxFrequency
+	return header
+}
+
+// BandSpectrogramImages converts one band spectrogram per channel to images with BandSpectrogram.ToImage,
+// and returns them together with the header that is needed for converting them back, like SpectrogramImages
+func BandSpectrogramImages(spectrograms []*BandSpectrogram) ([]image.Image, SpectrogramHeader, error) {
+	return BandSpectrogramImagesWithMapping(spectrograms, DefaultMagnitudeMapping)
+}
+
+// BandSpectrogramImagesWithMapping is like BandSpectrogramImages, but with the given magnitude mapping
+func BandSpectrogramImagesWithMapping(spectrograms []*BandSpectrogram, mapping MagnitudeMapping) ([]image.Image, SpectrogramHeader, error) {
+	if len(spectrograms) == 0 {
+		return nil, SpectrogramHeader{}, errors.New("no spectrograms")
+	}
+	asSpectrograms := make([]*Spectrogram, len(spectrograms))
+	for i, b := range spectrograms {
+		if b.Columns() != spectrograms[0].Columns() || b.Options != spectrograms[0].Options || b.Filterbank != spectrograms[0].Filterbank {
+			return nil, SpectrogramHeader{}, errors.New("the spectrograms of all channels must have the same size and options")
+		}
+		asSpectrograms[i] = b.asSpectrogram()
+	}
+	mapping, err := mapping.Resolve(asSpectrograms)
+	if err != nil {
+		return nil, SpectrogramHeader{}, err
+	}
+	imgs := make([]image.Image, len(spectrograms))
+	for i, b := range spectrograms {
+		imgs[i] = b.toGray16(mapping)
+	}
+	header := spectrograms[0].header(mapping)
+	header.Channels = len(spectrograms)
+	return imgs, header, nil
+}
+
+// toGray16 encodes the band spectrogram with EncodingGray16 and the given mapping, which must not be automatic
+func (b *BandSpectrogram) toGray16(mapping MagnitudeMapping) *image.Gray16 {
+	img := image.NewGray16(image.Rect(0, 0, b.Columns(), b.Filterbank.Bands))
+	gain := windowGain(b.Options.Window.Coefficients(b.Options.FFTSize, b.Options.WindowParameter))
+	for i, column := range b.Bands {
+		for j, mag := range column {
+			img.SetGray16(i, j, color.Gray16{mapping.toUint16(mag / gain)})
+		}
+	}
+	return img
+}
+
+// BandSpectrogramFromImage converts an image that was created with BandSpectrogram.ToImage back to
+// a band spectrogram, with the options of the header, which is validated against the size of the image
+func BandSpectrogramFromImage(img image.Image, header SpectrogramHeader) (*BandSpectrogram, error) {
+	if err := validateChannel(img, header, header.validateBands); err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	filterbank, _ := header.FilterbankOptions()
+	options, _ := header.Options()
+	mapping, _ := header.Mapping()
+	gain := windowGain(options.Window.Coefficients(options.FFTSize, options.WindowParameter))
+	bands := make([][]float64, bounds.Dx())
+	for x := range bands {
+		bands[x] = make([]float64, bounds.Dy())
+		for y := range bands[x] {
+			r, _, _, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			bands[x][y] = mapping.fromUint16(uint16(r)) * gain
+		}
+	}
+	return &BandSpectrogram{Bands: bands, Filterbank: *filterbank, Options: options, SampleRate: header.SampleRate, Length: header.Length}, nil
+}
+
+// CarveBandSpectrograms removes seams from one band spectrogram per channel to reduce their width
+// by the given percentage, like CarveSpectrograms. Since the bands are spaced like the perception
+// of pitch, the energy of the seams is closer to how much they are heard than for a Spectrogram.
+func CarveBandSpectrograms(spectrograms []*BandSpectrogram, newWidthInPercentage float64) ([]*BandSpectrogram, error) {
+	if len(spectrograms) == 0 {
+		return []*BandSpectrogram{}, nil
+	}
+	width := spectrograms[0].Columns()
+	for _, b := range spectrograms[1:] {
+		if b.Columns() != width {
+			return nil, fmt.Errorf("the spectrograms have different widths, %d and %d", width, b.Columns())
+		}
+	}
+	newWidth := int(float64(width) * newWidthInPercentage / 100.0)
+	if newWidth < 1 || newWidth > width {
+		return nil, fmt.Errorf("can not carve %d columns to %d columns", width, newWidth)
+	}
+
+	// Stack the bands and their levels as rows of columns
+	var rows [][]float64
+	var levels [][]float64
+	for _, b := range spectrograms {
+		for y := 0; y < b.Filterbank.Bands; y++ {
+			row := make([]float64, width)
+			for x, column := range b.Bands {
+				row[x] = column[y]
+			}
+			rows = append(rows, row)
+		}
+		levels = append(levels, b.levels()...)
+	}
+
+	rows = carveRows(rows, levels, newWidth)
+
+	// Split the rows into one band spectrogram per channel again
+	carved := make([]*BandSpectrogram, len(spectrograms))
+	y := 0
+	for i, b := range spectrograms {
+		c := *b
+		c.Bands = make([][]float64, newWidth)
+		for x := range c.Bands {
+			c.Bands[x] = make([]float64, b.Filterbank.Bands)
+			for band := range c.Bands[x] {
+				c.Bands[x][band] = rows[y+band][x]
+			}
+		}
+		y += b.Filterbank.Bands
+		c.Length -= (width - newWidth) * b.Options.hopSize()
+		if c.Length < 0 {
+			c.Length = 0
+		}
+		carved[i] = &c
+	}
+	return carved, nil
+}
@@ -0,0 +1,283 @@
+package wavecarve
+
+import (
+	"errors"
+	"strings"
+)
+
+// FLAC metadata block types
+const (
+	flacStreamInfo    = 0
+	flacVorbisComment = 4
+)
+
+// Vorbis comment fields in FLAC files, and the corresponding LIST/INFO keys
+var flacVorbisComments = []struct{ field, infoKey string }{
+	{"TITLE", InfoTitle},
+	{"ARTIST", InfoArtist},
+	{"ALBUM", InfoAlbum},
+	{"COMMENT", InfoComment},
+	{"COPYRIGHT", InfoCopyright},
+	{"DATE", InfoCreationDate},
+	{"GENRE", InfoGenre},
+	{"TRACKNUMBER", InfoTrackNumber},
+}
+
+// Block sizes for the 4-bit block size codes in a frame header, where 0 means that the size is stored elsewhere
+var flacBlockSizes = [16]int{0, 192, 576, 1152, 2304, 4608, 0, 0, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768}
+
+// Sample rates for the 4-bit sample rate codes in a frame header, where 0 means that the rate is stored elsewhere
+var flacSampleRates = [16]int{0, 88200, 176400, 192000, 8000, 16000, 22050, 24000, 32000, 44100, 48000, 96000, 0, 0, 0, 0}
+
+// Bits per sample for the 3-bit sample size codes in a frame header, where 0 means that it is stored elsewhere
+var flacSampleSizes = [8]int{0, 8, 12, 0, 16, 20, 24, 32}
+
+// Channel assignments in a frame header, for values 8 and above
+const (
+	flacLeftSide  = 8
+	flacSideRight = 9
+	flacMidSide   = 10
+)
+
+// flacCRC8Table is the CRC-8 table for the polynomial x^8 + x^2 + x^1 + x^0
+var flacCRC8Table = func() [256]uint8 {
+	var table [256]uint8
+	for i := range table {
+		crc := uint8(i)
+		for j := 0; j < 8; j++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// flacCRC16Table is the CRC-16 table for the polynomial x^16 + x^15 + x^2 + x^0
+var flacCRC16Table = func() [256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func flacCRC8(data []byte) uint8 {
+	var crc uint8
+	for _, b := range data {
+		crc = flacCRC8Table[crc^b]
+	}
+	return crc
+}
+
+func flacCRC16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc = crc<<8 ^ flacCRC16Table[uint8(crc>>8)^b]
+	}
+	return crc
+}
+
+// bitReader reads big-endian bit fields from a byte slice
+type bitReader struct {
+	data []byte
+	pos  int // the position in bits
+}
+
+var errFlacEOF = errors.New("unexpected end of FLAC data")
+
+// read reads an unsigned value of n bits (0 to 64)
+func (br *bitReader) read(n int) (uint64, error) {
+	if br.pos+n > len(br.data)*8 {
+		return 0, errFlacEOF
+	}
+	var v uint64
+	for n > 0 {
+		byteIndex, bitOffset := br.pos/8, br.pos%8
+		available := 8 - bitOffset
+		take := available
+		if take > n {
+			take = n
+		}
+		bits := uint64(br.data[byteIndex]>>(available-take)) & (1<<take - 1)
+		v = v<<take | bits
+		br.pos += take
+		n -= take
+	}
+	return v, nil
+}
+
+// readSigned reads a two's complement signed value of n bits
+func (br *bitReader) readSigned(n int) (int64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	v, err := br.read(n)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v<<(64-n)) >> (64 - n), nil
+}
+
+// readUnary reads a unary coded value, which is the number of 0 bits before a 1 bit
+func (br *bitReader) readUnary() (uint64, error) {
+	var n uint64
+	for {
+		if br.pos >= len(br.data)*8 {
+			return 0, errFlacEOF
+		}
+		byteIndex, bitOffset := br.pos/8, br.pos%8
+		rest := br.data[byteIndex] << bitOffset
+		if rest == 0 {
+			n += uint64(8 - bitOffset)
+			br.pos += 8 - bitOffset
+			continue
+		}
+		// Count the leading zeros of the rest of the byte
+		for rest&0x80 == 0 {
+			rest <<= 1
+			n++
+			br.pos++
+		}
+		br.pos++ // the 1 bit
+		return n, nil
+	}
+}
+
+// align skips to the next byte boundary
+func (br *bitReader) align() {
+	br.pos = (br.pos + 7) / 8 * 8
+}
+
+// bitWriter writes big-endian bit fields to a byte slice
+type bitWriter struct {
+	data  []byte
+	acc   uint64 // bits that have not been written to data yet
+	nbits int    // the number of bits in acc
+}
+
+// write writes the lowest n bits of v (n can be 0 to 32)
+func (bw *bitWriter) write(v uint64, n int) {
+	if n == 0 {
+		return
+	}
+	bw.acc = bw.acc<<n | v&(1<<n-1)
+	bw.nbits += n
+	for bw.nbits >= 8 {
+		bw.nbits -= 8
+		bw.data = append(bw.data, byte(bw.acc>>bw.nbits))
+	}
+}
+
+// writeSigned writes a two's complement signed value of n bits
+func (bw *bitWriter) writeSigned(v int64, n int) {
+	bw.write(uint64(v), n)
+}
+
+// writeUnary writes n 0 bits followed by a 1 bit
+func (bw *bitWriter) writeUnary(n uint64) {
+	for n >= 32 {
+		bw.write(0, 32)
+		n -= 32
+	}
+	bw.write(1, int(n)+1)
+}
+
+// align pads with 0 bits up to the next byte boundary
+func (bw *bitWriter) align() {
+	if bw.nbits > 0 {
+		bw.write(0, 8-bw.nbits)
+	}
+}
+
+// bitLen returns the number of bits written so far
+func (bw *bitWriter) bitLen() int {
+	return len(bw.data)*8 + bw.nbits
+}
+
+// appendBits appends all the bits written to other
+func (bw *bitWriter) appendBits(other *bitWriter) {
+	for _, b := range other.data {
+		bw.write(uint64(b), 8)
+	}
+	bw.write(other.acc, other.nbits)
+}
+
+// flacVorbisCommentsToInfo parses the contents of a VORBIS_COMMENT block into the LIST/INFO entries of m
+func flacVorbisCommentsToInfo(block []byte, m *WavMetadata) {
+	br := &bitReader{data: block}
+	readLE32 := func() (int, error) {
+		var v uint32
+		for i := 0; i < 4; i++ {
+			b, err := br.read(8)
+			if err != nil {
+				return 0, err
+			}
+			v |= uint32(b) << (8 * i)
+		}
+		return int(v), nil
+	}
+	vendorLength, err := readLE32()
+	if err != nil || vendorLength > len(block) {
+		return
+	}
+	br.pos += vendorLength * 8
+	count, err := readLE32()
+	if err != nil {
+		return
+	}
+	for i := 0; i < count; i++ {
+		length, err := readLE32()
+		if err != nil || br.pos/8+length > len(block) {
+			return
+		}
+		comment := string(block[br.pos/8 : br.pos/8+length])
+		br.pos += length * 8
+		field, value, found := strings.Cut(comment, "=")
+		if !found {
+			continue
+		}
+		for _, vc := range flacVorbisComments {
+			if strings.EqualFold(field, vc.field) {
+				m.SetInfo(vc.infoKey, value)
+			}
+		}
+	}
+}
+
+// flacInfoToVorbisComments returns the contents of a VORBIS_COMMENT block for the LIST/INFO entries of m
+func flacInfoToVorbisComments(m *WavMetadata) []byte {
+	var comments []string
+	for _, vc := range flacVorbisComments {
+		if value := m.InfoValue(vc.infoKey); value != "" {
+			comments = append(comments, vc.field+"="+value)
+		}
+	}
+	if len(comments) == 0 {
+		return nil
+	}
+	const vendor = "wavecarve"
+	var block []byte
+	appendLE32 := func(v int) {
+		block = append(block, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+	}
+	appendLE32(len(vendor))
+	block = append(block, vendor...)
+	appendLE32(len(comments))
+	for _, comment := range comments {
+		appendLE32(len(comment))
+		block = append(block, comment...)
+	}
+	return block
+}
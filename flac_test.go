@@ -0,0 +1,102 @@
+package wavecarve
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFlacRoundTrip(t *testing.T) {
+	tests := []struct {
+		bitsPerSample uint16
+		numChannels   int
+	}{
+		{8, 1},
+		{8, 2},
+		{16, 1},
+		{16, 2},
+		{24, 1},
+		{24, 2},
+		{32, 1},
+		{32, 2},
+	}
+	for _, tt := range tests {
+		// Samples that can be stored exactly with the bit depth, so that they are read back unchanged
+		scale := float64(int64(1) << (tt.bitsPerSample - 1))
+		channels := make([][]float64, tt.numChannels)
+		for c := range channels {
+			channels[c] = make([]float64, 10000)
+			for i := range channels[c] {
+				channels[c][i] = math.Round(0.6*math.Sin(float64(i)*0.01*float64(c+1))*scale) / scale
+			}
+		}
+		filePath := filepath.Join(t.TempDir(), "test.flac")
+		if err := WriteFlacFile(filePath, channels, NewWAVHeader(1, uint16(tt.numChannels), 44100, tt.bitsPerSample)); err != nil {
+			t.Fatalf("%d bits, %d channel(s): %v", tt.bitsPerSample, tt.numChannels, err)
+		}
+		audio, err := ReadAudio(filePath)
+		if err != nil {
+			t.Fatalf("%d bits, %d channel(s): %v", tt.bitsPerSample, tt.numChannels, err)
+		}
+		if audio.SampleRate != 44100 || audio.BitsPerSample != tt.bitsPerSample || audio.NumChannels() != tt.numChannels {
+			t.Errorf("%d bits, %d channel(s): read %d bits, %d channel(s) at %d Hz", tt.bitsPerSample, tt.numChannels, audio.BitsPerSample, audio.NumChannels(), audio.SampleRate)
+			continue
+		}
+		for c := range channels {
+			if len(audio.Channels[c]) != len(channels[c]) {
+				t.Fatalf("%d bits, %d channel(s): read %d samples, want %d", tt.bitsPerSample, tt.numChannels, len(audio.Channels[c]), len(channels[c]))
+			}
+			for i, want := range channels[c] {
+				if got := audio.Channels[c][i]; got != want {
+					t.Fatalf("%d bits, %d channel(s): sample %d of channel %d is %v, want %v", tt.bitsPerSample, tt.numChannels, i, c, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestFlacHostileStreamInfo(t *testing.T) {
+	// A STREAMINFO block that claims 8 channels of 2^31 samples, with no frames after it
+	bw := &bitWriter{}
+	bw.write(4096, 16) // the smallest block size
+	bw.write(4096, 16) // the largest block size
+	bw.write(0, 24)    // the smallest frame size
+	bw.write(0, 24)    // the largest frame size
+	bw.write(44100, 20)
+	bw.write(7, 3)  // the number of channels minus one
+	bw.write(15, 5) // the bits per sample minus one
+	bw.write(1<<31, 36)
+	bw.align()
+	streamInfo := make([]byte, 34) // the MD5 signature is left as zeros
+	copy(streamInfo, bw.data)
+	data := append([]byte("fLaC\x80\x00\x00\x22"), streamInfo...)
+
+	_, _, err := decodeFlac(data)
+	if !errors.As(err, new(ErrTruncated)) {
+		t.Errorf("got %v, want an ErrTruncated error", err)
+	}
+}
+
+func TestFlacDecodedSizeLimit(t *testing.T) {
+	// A constant compresses to a few bytes per frame, so the limit is for the decoded audio data
+	channels := [][]float64{make([]float64, 100000), make([]float64, 100000)}
+	filePath := filepath.Join(t.TempDir(), "silence.flac")
+	if err := WriteFlacFile(filePath, channels, NewWAVHeader(1, 2, 44100, 16)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(limit int64) { MaxDataSize = limit }(MaxDataSize)
+	MaxDataSize = 100000
+	if int64(len(data)) > MaxDataSize {
+		t.Fatalf("the FLAC file is %d bytes, which is not below the limit", len(data))
+	}
+	if _, _, err := decodeFlac(data); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("got %v, want an error for audio data that is too large", err)
+	}
+}
@@ -0,0 +1,521 @@
+package wavecarve
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+)
+
+// flacStreamInfoBlock is the contents of the STREAMINFO metadata block
+type flacStreamInfoBlock struct {
+	minBlockSize  int
+	maxBlockSize  int
+	sampleRate    int
+	numChannels   int
+	bitsPerSample int
+	totalSamples  uint64
+	md5           [16]byte
+}
+
+// ReadFlacFile reads a FLAC file and returns one slice of samples in the range [-1, 1]
+// per channel, just like ReadWavFileFloat64. The header describes the same audio format
+// as a .wav file, and the Vorbis comments (like TITLE and ARTIST) are available as
+// LIST/INFO entries in the Metadata field. The frame CRCs and the MD5 signature of
+// the decoded audio data are verified.
+func ReadFlacFile(filePath string) ([][]float64, WAVHeader, error) {
+	// Open the .flac file
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, WAVHeader{}, err
+	}
+	defer file.Close()
+
+	// FLAC files are compressed, so all of the file is read into memory
+	data, err := io.ReadAll(io.LimitReader(bufio.NewReader(file), MaxDataSize+1))
+	if err != nil {
+		return nil, WAVHeader{}, err
+	}
+	if int64(len(data)) > MaxDataSize {
+		return nil, WAVHeader{}, fmt.Errorf("the FLAC file is too large to be read into memory (the limit is %d bytes)", MaxDataSize)
+	}
+	return decodeFlac(data)
+}
+
+// decodeFlac decodes a complete FLAC stream
+func decodeFlac(data []byte) ([][]float64, WAVHeader, error) {
+	if len(data) < 4 || string(data[0:4]) != "fLaC" {
+		return nil, WAVHeader{}, ErrNotFLAC
+	}
+
+	// Read the metadata blocks
+	var (
+		info          *flacStreamInfoBlock
+		metadata      = NewWavMetadata()
+		pos           = 4
+		lastBlockSeen bool
+	)
+	for !lastBlockSeen {
+		if pos+4 > len(data) {
+			return nil, WAVHeader{}, errFlacEOF
+		}
+		lastBlockSeen = data[pos]&0x80 != 0
+		blockType := data[pos] & 0x7F
+		length := int(data[pos+1])<<16 | int(data[pos+2])<<8 | int(data[pos+3])
+		pos += 4
+		if pos+length > len(data) {
+			return nil, WAVHeader{}, errFlacEOF
+		}
+		block := data[pos : pos+length]
+		pos += length
+		switch blockType {
+		case flacStreamInfo:
+			if length < 34 {
+				return nil, WAVHeader{}, fmt.Errorf("the STREAMINFO block is too small: %d bytes", length)
+			}
+			br := &bitReader{data: block}
+			fields := make([]uint64, 0, 8)
+			for _, n := range []int{16, 16, 24, 24, 20, 3, 5, 36} {
+				v, _ := br.read(n)
+				fields = append(fields, v)
+			}
+			info = &flacStreamInfoBlock{
+				minBlockSize:  int(fields[0]),
+				maxBlockSize:  int(fields[1]),
+				sampleRate:    int(fields[4]),
+				numChannels:   int(fields[5]) + 1,
+				bitsPerSample: int(fields[6]) + 1,
+				totalSamples:  fields[7],
+			}
+			copy(info.md5[:], block[18:34])
+		case flacVorbisComment:
+			flacVorbisCommentsToInfo(block, metadata)
+		}
+	}
+	if info == nil {
+		return nil, WAVHeader{}, errors.New("no STREAMINFO block found")
+	}
+	if info.sampleRate == 0 || info.bitsPerSample < 4 {
+		return nil, WAVHeader{}, fmt.Errorf("invalid STREAMINFO block: %d Hz with %d bits per sample", info.sampleRate, info.bitsPerSample)
+	}
+
+	// Decode the frames
+	// The sample count of the STREAMINFO block can not be trusted, so the slices are only pre-sized up to
+	// a number of samples that is proportional to the size of the file, and are left to grow beyond that
+	samples := make([][]int64, info.numChannels)
+	capacity := uint64(len(data) / info.numChannels)
+	if info.totalSamples < capacity {
+		capacity = info.totalSamples
+	}
+	for c := range samples {
+		samples[c] = make([]int64, 0, capacity)
+	}
+	// Keep the audio data that could be decoded if the file ends too early
+	truncated := func() ([][]float64, WAVHeader, error) {
+		frameSize := int64(info.numChannels * ((info.bitsPerSample + 7) / 8))
+		err := ErrTruncated{Want: int64(info.totalSamples) * frameSize, Got: int64(len(samples[0])) * frameSize}
+		return flacResult(samples, info, metadata, err)
+	}
+	br := &bitReader{data: data, pos: pos * 8}
+	for br.pos/8 < len(data) {
+		if info.totalSamples > 0 && uint64(len(samples[0])) >= info.totalSamples {
+			break
+		}
+		if err := decodeFlacFrame(br, info, samples); err != nil {
+			if errors.Is(err, errFlacEOF) {
+				return truncated()
+			}
+			return nil, WAVHeader{}, err
+		}
+		// A small file can give a lot of audio data, since a frame of a constant can be a few bytes,
+		// so the decoded audio data has the same limit as the audio data of the other formats
+		if int64(len(samples[0]))*int64(info.numChannels*((info.bitsPerSample+7)/8)) > MaxDataSize {
+			return nil, WAVHeader{}, fmt.Errorf("the audio data is too large to be read into memory (the limit is %d bytes)", MaxDataSize)
+		}
+	}
+	if uint64(len(samples[0])) < info.totalSamples {
+		return truncated()
+	}
+
+	// Verify the MD5 signature of the decoded audio data, if there is one
+	if info.md5 != [16]byte{} {
+		bytesPerSample := (info.bitsPerSample + 7) / 8
+		h := md5.New()
+		buf := make([]byte, 0, len(samples)*bytesPerSample)
+		for i := range samples[0] {
+			buf = buf[:0]
+			for c := range samples {
+				v := samples[c][i]
+				for b := 0; b < bytesPerSample; b++ {
+					buf = append(buf, byte(v>>(8*b)))
+				}
+			}
+			h.Write(buf)
+		}
+		if !bytes.Equal(h.Sum(nil), info.md5[:]) {
+			return nil, WAVHeader{}, errors.New("the MD5 signature of the decoded FLAC audio data does not match")
+		}
+	}
+
+	return flacResult(samples, info, metadata, nil)
+}
+
+// flacResult converts decoded FLAC samples to one slice of float64s per channel and a header
+func flacResult(samples [][]int64, info *flacStreamInfoBlock, metadata *WavMetadata, err error) ([][]float64, WAVHeader, error) {
+	header := NewWAVHeader(WaveFormatPCM, uint16(info.numChannels), uint32(info.sampleRate), uint16((info.bitsPerSample+7)/8*8))
+	if len(metadata.Info) > 0 {
+		header.Metadata = metadata
+	}
+	scale := float64(int64(1) << (info.bitsPerSample - 1))
+	channels := make([][]float64, len(samples))
+	for c := range samples {
+		channels[c] = make([]float64, len(samples[c]))
+		for i, v := range samples[c] {
+			channels[c][i] = float64(v) / scale
+		}
+		samples[c] = nil // free the decoded samples as soon as possible
+	}
+	return channels, header, err
+}
+
+// decodeFlacFrame decodes one frame and appends the samples to one slice per channel
+func decodeFlacFrame(br *bitReader, info *flacStreamInfoBlock, samples [][]int64) error {
+	frameStart := br.pos / 8
+
+	// Frame header
+	sync, err := br.read(15)
+	if err != nil {
+		return err
+	}
+	if sync != 0x7FFC {
+		return fmt.Errorf("invalid FLAC frame sync code at byte %d", frameStart)
+	}
+	if _, err := br.read(1); err != nil { // blocking strategy
+		return err
+	}
+	codes, err := br.read(16)
+	if err != nil {
+		return err
+	}
+	blockSizeCode := int(codes >> 12)
+	sampleRateCode := int(codes >> 8 & 0xF)
+	channelAssignment := int(codes >> 4 & 0xF)
+	sampleSizeCode := int(codes >> 1 & 0x7)
+
+	// The UTF-8 coded frame or sample number
+	first, err := br.read(8)
+	if err != nil {
+		return err
+	}
+	// The number of leading 1 bits in the first byte is the total number of bytes
+	for i := 1; i < bits.LeadingZeros8(^uint8(first)); i++ {
+		if _, err := br.read(8); err != nil {
+			return err
+		}
+	}
+
+	blockSize := flacBlockSizes[blockSizeCode]
+	switch blockSizeCode {
+	case 0:
+		return errors.New("reserved FLAC block size")
+	case 6:
+		v, err := br.read(8)
+		if err != nil {
+			return err
+		}
+		blockSize = int(v) + 1
+	case 7:
+		v, err := br.read(16)
+		if err != nil {
+			return err
+		}
+		blockSize = int(v) + 1
+	}
+	switch sampleRateCode {
+	case 12:
+		_, err = br.read(8)
+	case 13, 14:
+		_, err = br.read(16)
+	case 15:
+		return errors.New("invalid FLAC sample rate")
+	}
+	if err != nil {
+		return err
+	}
+
+	bitsPerSample := info.bitsPerSample
+	if sampleSizeCode != 0 {
+		bitsPerSample = flacSampleSizes[sampleSizeCode]
+		if bitsPerSample == 0 {
+			return errors.New("reserved FLAC sample size")
+		}
+	}
+
+	numChannels := channelAssignment + 1
+	if channelAssignment >= flacLeftSide {
+		if channelAssignment > flacMidSide {
+			return errors.New("reserved FLAC channel assignment")
+		}
+		numChannels = 2
+	}
+	if numChannels != len(samples) {
+		return fmt.Errorf("a FLAC frame has %d channels, but STREAMINFO says %d", numChannels, len(samples))
+	}
+
+	// Check the CRC-8 of the frame header
+	headerEnd := br.pos / 8
+	crc8, err := br.read(8)
+	if err != nil {
+		return err
+	}
+	if uint8(crc8) != flacCRC8(br.data[frameStart:headerEnd]) {
+		return fmt.Errorf("FLAC frame header CRC mismatch at byte %d", frameStart)
+	}
+
+	// Subframes. The side channel has one extra bit.
+	decoded := make([][]int64, numChannels)
+	for c := range decoded {
+		bps := bitsPerSample
+		if (channelAssignment == flacLeftSide && c == 1) ||
+			(channelAssignment == flacSideRight && c == 0) ||
+			(channelAssignment == flacMidSide && c == 1) {
+			bps++
+		}
+		decoded[c] = make([]int64, blockSize)
+		if err := decodeFlacSubframe(br, decoded[c], bps); err != nil {
+			return err
+		}
+	}
+
+	// Frame footer, with the CRC-16 of the whole frame
+	br.align()
+	frameEnd := br.pos / 8
+	crc16, err := br.read(16)
+	if err != nil {
+		return err
+	}
+	if uint16(crc16) != flacCRC16(br.data[frameStart:frameEnd]) {
+		return fmt.Errorf("FLAC frame CRC mismatch at byte %d", frameStart)
+	}
+
+	// Undo the inter-channel decorrelation
+	switch channelAssignment {
+	case flacLeftSide:
+		for i := range decoded[1] {
+			decoded[1][i] = decoded[0][i] - decoded[1][i]
+		}
+	case flacSideRight:
+		for i := range decoded[0] {
+			decoded[0][i] += decoded[1][i]
+		}
+	case flacMidSide:
+		for i := range decoded[0] {
+			mid, side := decoded[0][i]<<1|decoded[1][i]&1, decoded[1][i]
+			decoded[0][i] = (mid + side) >> 1
+			decoded[1][i] = (mid - side) >> 1
+		}
+	}
+
+	// Samples with a different bit depth than the stream are scaled to the bit depth of the stream
+	shift := info.bitsPerSample - bitsPerSample
+	for c := range samples {
+		for _, v := range decoded[c] {
+			if shift > 0 {
+				v <<= shift
+			} else if shift < 0 {
+				v >>= -shift
+			}
+			samples[c] = append(samples[c], v)
+		}
+	}
+	return nil
+}
+
+// decodeFlacSubframe decodes one subframe into out, which has the length of the block size
+func decodeFlacSubframe(br *bitReader, out []int64, bitsPerSample int) error {
+	header, err := br.read(8)
+	if err != nil {
+		return err
+	}
+	if header&0x80 != 0 {
+		return errors.New("invalid FLAC subframe padding")
+	}
+	subframeType := int(header >> 1 & 0x3F)
+
+	// Wasted bits per sample
+	wasted := 0
+	if header&1 != 0 {
+		n, err := br.readUnary()
+		if err != nil {
+			return err
+		}
+		wasted = int(n) + 1
+		bitsPerSample -= wasted
+		if bitsPerSample < 1 {
+			return errors.New("invalid number of wasted bits in a FLAC subframe")
+		}
+	}
+
+	switch {
+	case subframeType == 0: // constant
+		v, err := br.readSigned(bitsPerSample)
+		if err != nil {
+			return err
+		}
+		for i := range out {
+			out[i] = v
+		}
+	case subframeType == 1: // verbatim
+		for i := range out {
+			if out[i], err = br.readSigned(bitsPerSample); err != nil {
+				return err
+			}
+		}
+	case subframeType >= 8 && subframeType <= 12: // fixed predictor
+		order := subframeType - 8
+		if order > len(out) {
+			return errors.New("the FLAC predictor order is larger than the block size")
+		}
+		for i := 0; i < order; i++ {
+			if out[i], err = br.readSigned(bitsPerSample); err != nil {
+				return err
+			}
+		}
+		if err := decodeFlacResidual(br, out, order); err != nil {
+			return err
+		}
+		restoreFixed(out, order)
+	case subframeType >= 32: // LPC
+		order := subframeType - 31
+		if order > len(out) {
+			return errors.New("the FLAC predictor order is larger than the block size")
+		}
+		for i := 0; i < order; i++ {
+			if out[i], err = br.readSigned(bitsPerSample); err != nil {
+				return err
+			}
+		}
+		precision, err := br.read(4)
+		if err != nil {
+			return err
+		}
+		if precision == 15 {
+			return errors.New("invalid FLAC LPC coefficient precision")
+		}
+		shift, err := br.readSigned(5)
+		if err != nil {
+			return err
+		}
+		if shift < 0 {
+			return errors.New("negative FLAC LPC shift")
+		}
+		coefs := make([]int64, order)
+		for i := range coefs {
+			if coefs[i], err = br.readSigned(int(precision) + 1); err != nil {
+				return err
+			}
+		}
+		if err := decodeFlacResidual(br, out, order); err != nil {
+			return err
+		}
+		for i := order; i < len(out); i++ {
+			var sum int64
+			for j, coef := range coefs {
+				sum += coef * out[i-1-j]
+			}
+			out[i] += sum >> uint(shift)
+		}
+	default:
+		return fmt.Errorf("reserved FLAC subframe type: %d", subframeType)
+	}
+
+	if wasted > 0 {
+		for i := range out {
+			out[i] <<= wasted
+		}
+	}
+	return nil
+}
+
+// restoreFixed restores the samples from the residual of a fixed predictor of the given order
+func restoreFixed(out []int64, order int) {
+	for i := order; i < len(out); i++ {
+		switch order {
+		case 1:
+			out[i] += out[i-1]
+		case 2:
+			out[i] += 2*out[i-1] - out[i-2]
+		case 3:
+			out[i] += 3*out[i-1] - 3*out[i-2] + out[i-3]
+		case 4:
+			out[i] += 4*out[i-1] - 6*out[i-2] + 4*out[i-3] - out[i-4]
+		}
+	}
+}
+
+// decodeFlacResidual decodes the Rice coded residual into out[order:]
+func decodeFlacResidual(br *bitReader, out []int64, order int) error {
+	method, err := br.read(2)
+	if err != nil {
+		return err
+	}
+	if method > 1 {
+		return errors.New("reserved FLAC residual coding method")
+	}
+	paramBits, escape := 4, uint64(15)
+	if method == 1 {
+		paramBits, escape = 5, 31
+	}
+	partitionOrder, err := br.read(4)
+	if err != nil {
+		return err
+	}
+	partitions := 1 << partitionOrder
+	if len(out)%partitions != 0 || len(out)/partitions < order {
+		return errors.New("invalid FLAC partition order")
+	}
+	i := order
+	for p := 0; p < partitions; p++ {
+		n := len(out) / partitions
+		if p == 0 {
+			n -= order
+		}
+		param, err := br.read(paramBits)
+		if err != nil {
+			return err
+		}
+		if param == escape {
+			// The residual is stored as signed values of a given number of bits
+			bits, err := br.read(5)
+			if err != nil {
+				return err
+			}
+			for j := 0; j < n; j++ {
+				if out[i], err = br.readSigned(int(bits)); err != nil {
+					return err
+				}
+				i++
+			}
+			continue
+		}
+		for j := 0; j < n; j++ {
+			high, err := br.readUnary()
+			if err != nil {
+				return err
+			}
+			low, err := br.read(int(param))
+			if err != nil {
+				return err
+			}
+			// Undo the zig-zag encoding of the signed value
+			v := high<<param | low
+			out[i] = int64(v>>1) ^ -int64(v&1)
+			i++
+		}
+	}
+	return nil
+}
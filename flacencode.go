@@ -0,0 +1,533 @@
+package wavecarve
+
+import (
+	"bufio"
+	"crypto/md5"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+)
+
+// Settings for the FLAC encoder
+const (
+	flacBlockSize         = 4096 // samples per channel in each frame
+	flacMaxLPCOrder       = 8
+	flacLPCPrecision      = 15 // bits per quantized LPC coefficient
+	flacMaxPartitionOrder = 8
+)
+
+// WriteFlacFile writes one slice of samples in the range [-1, 1] per channel to a FLAC file,
+// with the sample rate and bit depth given by the header. Floating point headers are written
+// as 24-bit FLAC files. The LIST/INFO entries in the Metadata field of the header (like the
+// title and artist) are written as Vorbis comments. The samples are quantized with
+// DefaultQuantizeOptions, and if any samples clipped, an ErrClipped error is returned
+// after the file has been written.
+func WriteFlacFile(filePath string, channels [][]float64, header WAVHeader) error {
+	return writeFlacFile(filePath, channels, header, DefaultQuantizeOptions)
+}
+
+// writeFlacFile writes a FLAC file, with the samples quantized with the given options
+func writeFlacFile(filePath string, channels [][]float64, header WAVHeader, options QuantizeOptions) error {
+	if len(channels) < 1 || len(channels) > 8 {
+		return fmt.Errorf("FLAC files can have 1 to 8 channels, not %d", len(channels))
+	}
+	if header.SampleRate == 0 || header.SampleRate >= 1<<20 {
+		return fmt.Errorf("unsupported sample rate for FLAC files: %d Hz", header.SampleRate)
+	}
+	bitsPerSample := int(header.BitsPerSample)
+	if header.SampleFormat() == WaveFormatIEEEFloat {
+		bitsPerSample = 24
+	} else if bitsPerSample < 4 || bitsPerSample > 32 {
+		return ErrUnsupportedFormat{AudioFormat: header.SampleFormat(), Bits: header.BitsPerSample}
+	}
+
+	frames := len(channels[0])
+	for _, channel := range channels[1:] {
+		if len(channel) < frames {
+			frames = len(channel)
+		}
+	}
+
+	// Create the .flac file
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Write the metadata blocks. STREAMINFO is rewritten when all frames have been written.
+	var comments []byte
+	if header.Metadata != nil {
+		comments = flacInfoToVorbisComments(header.Metadata)
+	}
+	w := bufio.NewWriter(file)
+	w.WriteString("fLaC")
+	streamInfoHeader := []byte{flacStreamInfo, 0, 0, 34}
+	if comments == nil {
+		streamInfoHeader[0] |= 0x80 // the last metadata block
+	}
+	w.Write(streamInfoHeader)
+	w.Write(make([]byte, 34))
+	if comments != nil {
+		w.Write([]byte{0x80 | flacVorbisComment, byte(len(comments) >> 16), byte(len(comments) >> 8), byte(len(comments))})
+		w.Write(comments)
+	}
+
+	// Quantize and write the samples one block at a time, while computing the MD5 signature of the audio data
+	h := md5.New()
+	q := NewQuantizer(bitsPerSample, len(channels), options)
+	bytesPerSample := (bitsPerSample + 7) / 8
+	buf := make([]byte, 0, len(channels)*bytesPerSample)
+	block := make([][]int64, len(channels))
+	for c := range block {
+		block[c] = make([]int64, flacBlockSize)
+	}
+	minFrameSize, maxFrameSize := 0, 0
+	for start, frameNumber := 0, uint64(0); start < frames; start, frameNumber = start+flacBlockSize, frameNumber+1 {
+		end := start + flacBlockSize
+		if end > frames {
+			end = frames
+		}
+		for c := range block {
+			block[c] = block[c][:end-start]
+		}
+		for i := range block[0] {
+			buf = buf[:0]
+			for c := range block {
+				block[c][i] = q.Quantize(channels[c][start+i])
+				for b := 0; b < bytesPerSample; b++ {
+					buf = append(buf, byte(block[c][i]>>(8*b)))
+				}
+			}
+			h.Write(buf)
+		}
+		frame := encodeFlacFrame(block, frameNumber, int(header.SampleRate), bitsPerSample)
+		if minFrameSize == 0 || len(frame) < minFrameSize {
+			minFrameSize = len(frame)
+		}
+		if len(frame) > maxFrameSize {
+			maxFrameSize = len(frame)
+		}
+		if _, err := w.Write(frame); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	// Rewrite the STREAMINFO block, now that the frame sizes and the MD5 signature are known
+	bw := &bitWriter{}
+	bw.write(flacBlockSize, 16)
+	bw.write(flacBlockSize, 16)
+	bw.write(uint64(minFrameSize), 24)
+	bw.write(uint64(maxFrameSize), 24)
+	bw.write(uint64(header.SampleRate), 20)
+	bw.write(uint64(len(channels)-1), 3)
+	bw.write(uint64(bitsPerSample-1), 5)
+	bw.write(uint64(frames)>>32, 4)
+	bw.write(uint64(frames)&math.MaxUint32, 32)
+	streamInfo := append(bw.data, h.Sum(nil)...)
+	if _, err := file.WriteAt(streamInfo, 8); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	// Report the samples that clipped
+	return q.err()
+}
+
+// encodeFlacFrame encodes one block of samples per channel as a FLAC frame
+func encodeFlacFrame(block [][]int64, frameNumber uint64, sampleRate, bitsPerSample int) []byte {
+	blockSize := len(block[0])
+
+	// Encode the subframes, and try the inter-channel decorrelations for stereo audio.
+	// The side channel needs one extra bit, so 32-bit audio is always stored as independent channels.
+	channelAssignment := len(block) - 1
+	subframes := make([]*bitWriter, len(block))
+	for c := range block {
+		subframes[c] = encodeFlacSubframe(block[c], bitsPerSample)
+	}
+	if len(block) == 2 && bitsPerSample < 32 {
+		mid, side := make([]int64, blockSize), make([]int64, blockSize)
+		for i := range side {
+			mid[i] = (block[0][i] + block[1][i]) >> 1
+			side[i] = block[0][i] - block[1][i]
+		}
+		midFrame := encodeFlacSubframe(mid, bitsPerSample)
+		sideFrame := encodeFlacSubframe(side, bitsPerSample+1)
+		left, right := subframes[0], subframes[1]
+		best := left.bitLen() + right.bitLen()
+		if n := left.bitLen() + sideFrame.bitLen(); n < best {
+			best, channelAssignment, subframes = n, flacLeftSide, []*bitWriter{left, sideFrame}
+		}
+		if n := sideFrame.bitLen() + right.bitLen(); n < best {
+			best, channelAssignment, subframes = n, flacSideRight, []*bitWriter{sideFrame, right}
+		}
+		if n := midFrame.bitLen() + sideFrame.bitLen(); n < best {
+			channelAssignment, subframes = flacMidSide, []*bitWriter{midFrame, sideFrame}
+		}
+	}
+
+	// Frame header, with a fixed block size and the frame number
+	blockSizeCode, blockSizeBits := 7, 16
+	switch {
+	case blockSize == flacBlockSize:
+		blockSizeCode, blockSizeBits = 12, 0
+	case blockSize <= 256:
+		blockSizeCode, blockSizeBits = 6, 8
+	}
+	sampleRateCode := 0 // from STREAMINFO
+	for code, rate := range flacSampleRates {
+		if rate == sampleRate {
+			sampleRateCode = code
+			break
+		}
+	}
+	sampleSizeCode := 0 // from STREAMINFO
+	for code, size := range flacSampleSizes {
+		if size == bitsPerSample {
+			sampleSizeCode = code
+			break
+		}
+	}
+	bw := &bitWriter{}
+	bw.write(0xFFF8, 16)
+	bw.write(uint64(blockSizeCode), 4)
+	bw.write(uint64(sampleRateCode), 4)
+	bw.write(uint64(channelAssignment), 4)
+	bw.write(uint64(sampleSizeCode), 3)
+	bw.write(0, 1)
+	for _, b := range appendFlacUTF8(nil, frameNumber) {
+		bw.write(uint64(b), 8)
+	}
+	bw.write(uint64(blockSize-1), blockSizeBits)
+	bw.write(uint64(flacCRC8(bw.data)), 8)
+
+	// Subframes and the frame footer
+	for _, subframe := range subframes {
+		bw.appendBits(subframe)
+	}
+	bw.align()
+	crc16 := flacCRC16(bw.data)
+	return append(bw.data, byte(crc16>>8), byte(crc16))
+}
+
+// appendFlacUTF8 appends v in the UTF-8 like coding that is used for frame numbers
+func appendFlacUTF8(buf []byte, v uint64) []byte {
+	if v < 0x80 {
+		return append(buf, byte(v))
+	}
+	// Count the continuation bytes, which hold 6 bits each
+	n := 1
+	for v >= 1<<(6*n+6-n) {
+		n++
+	}
+	buf = append(buf, byte(uint16(0xFF00)>>(n+1))|byte(v>>(6*n)))
+	for i := n - 1; i >= 0; i-- {
+		buf = append(buf, 0x80|byte(v>>(6*i))&0x3F)
+	}
+	return buf
+}
+
+// encodeFlacSubframe encodes the samples of one channel, using whichever of the constant,
+// fixed, LPC and verbatim subframe types is the smallest
+func encodeFlacSubframe(samples []int64, bitsPerSample int) *bitWriter {
+	bw := &bitWriter{}
+
+	// Constant subframe
+	constant := true
+	for _, v := range samples[1:] {
+		if v != samples[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		bw.write(0, 8)
+		bw.writeSigned(samples[0], bitsPerSample)
+		return bw
+	}
+
+	// Wasted bits are low bits that are 0 in all samples
+	var or int64
+	for _, v := range samples {
+		or |= v
+	}
+	wasted := bits.TrailingZeros64(uint64(or))
+	if wasted > 0 {
+		shifted := make([]int64, len(samples))
+		for i, v := range samples {
+			shifted[i] = v >> wasted
+		}
+		samples = shifted
+		bitsPerSample -= wasted
+	}
+	writeSubframeHeader := func(bw *bitWriter, subframeType int) {
+		if wasted > 0 {
+			bw.write(uint64(subframeType)<<1|1, 8)
+			bw.writeUnary(uint64(wasted - 1))
+		} else {
+			bw.write(uint64(subframeType)<<1, 8)
+		}
+	}
+
+	// Verbatim subframe, which is the fallback
+	best := &bitWriter{}
+	writeSubframeHeader(best, 1)
+	for _, v := range samples {
+		best.writeSigned(v, bitsPerSample)
+	}
+
+	// Fixed predictors
+	residual := make([]int64, len(samples))
+	for order := 0; order <= 4 && order < len(samples); order++ {
+		fixedResidual(samples, order, residual)
+		bw := &bitWriter{}
+		writeSubframeHeader(bw, 8+order)
+		for _, v := range samples[:order] {
+			bw.writeSigned(v, bitsPerSample)
+		}
+		if !encodeFlacResidual(bw, residual, order) || bw.bitLen() >= best.bitLen() {
+			continue
+		}
+		best = bw
+	}
+
+	// LPC predictors
+	for order, coefs := range lpcCoefficients(samples, flacMaxLPCOrder) {
+		order++
+		if order >= len(samples) {
+			break
+		}
+		quantized, shift := quantizeLPC(coefs, flacLPCPrecision)
+		lpcResidual(samples, quantized, shift, residual)
+		bw := &bitWriter{}
+		writeSubframeHeader(bw, 31+order)
+		for _, v := range samples[:order] {
+			bw.writeSigned(v, bitsPerSample)
+		}
+		bw.write(flacLPCPrecision-1, 4)
+		bw.writeSigned(int64(shift), 5)
+		for _, q := range quantized {
+			bw.writeSigned(q, flacLPCPrecision)
+		}
+		if !encodeFlacResidual(bw, residual, order) || bw.bitLen() >= best.bitLen() {
+			continue
+		}
+		best = bw
+	}
+	return best
+}
+
+// fixedResidual computes the residual of the fixed predictor of the given order into residual[order:]
+func fixedResidual(samples []int64, order int, residual []int64) {
+	for i := order; i < len(samples); i++ {
+		switch order {
+		case 0:
+			residual[i] = samples[i]
+		case 1:
+			residual[i] = samples[i] - samples[i-1]
+		case 2:
+			residual[i] = samples[i] - 2*samples[i-1] + samples[i-2]
+		case 3:
+			residual[i] = samples[i] - 3*samples[i-1] + 3*samples[i-2] - samples[i-3]
+		case 4:
+			residual[i] = samples[i] - 4*samples[i-1] + 6*samples[i-2] - 4*samples[i-3] + samples[i-4]
+		}
+	}
+}
+
+// lpcResidual computes the residual of a quantized LPC predictor into residual[len(coefs):]
+func lpcResidual(samples, coefs []int64, shift int, residual []int64) {
+	for i := len(coefs); i < len(samples); i++ {
+		var sum int64
+		for j, coef := range coefs {
+			sum += coef * samples[i-1-j]
+		}
+		residual[i] = samples[i] - sum>>uint(shift)
+	}
+}
+
+// lpcCoefficients returns the LPC coefficients for every order from 1 to maxOrder,
+// computed with the Levinson-Durbin recursion from the autocorrelation of the windowed samples
+func lpcCoefficients(samples []int64, maxOrder int) [][]float64 {
+	n := len(samples)
+	if maxOrder >= n {
+		maxOrder = n - 1
+	}
+	if maxOrder < 1 {
+		return nil
+	}
+
+	// Apply a Welch window and compute the autocorrelation
+	windowed := make([]float64, n)
+	half := float64(n-1) / 2
+	for i, v := range samples {
+		x := (float64(i) - half) / half
+		windowed[i] = float64(v) * (1 - x*x)
+	}
+	autoc := make([]float64, maxOrder+1)
+	for lag := range autoc {
+		for i := lag; i < n; i++ {
+			autoc[lag] += windowed[i] * windowed[i-lag]
+		}
+	}
+	if autoc[0] == 0 {
+		return nil
+	}
+
+	// Levinson-Durbin recursion
+	var coefsPerOrder [][]float64
+	lpc := make([]float64, maxOrder)
+	errorPower := autoc[0]
+	for i := 0; i < maxOrder; i++ {
+		acc := autoc[i+1]
+		for j := 0; j < i; j++ {
+			acc -= lpc[j] * autoc[i-j]
+		}
+		k := acc / errorPower
+		next := make([]float64, i+1)
+		for j := 0; j < i; j++ {
+			next[j] = lpc[j] - k*lpc[i-1-j]
+		}
+		next[i] = k
+		copy(lpc, next)
+		coefsPerOrder = append(coefsPerOrder, next)
+		errorPower *= 1 - k*k
+		if errorPower <= 0 {
+			break
+		}
+	}
+	return coefsPerOrder
+}
+
+// quantizeLPC quantizes LPC coefficients to signed integers of the given precision,
+// and returns them together with the shift that is applied to the prediction
+func quantizeLPC(coefs []float64, precision int) ([]int64, int) {
+	var cmax float64
+	for _, c := range coefs {
+		cmax = math.Max(cmax, math.Abs(c))
+	}
+	shift := 15 // the largest shift that fits in the 5-bit field
+	if cmax > 0 {
+		_, exp := math.Frexp(cmax)
+		shift = precision - 1 - exp
+		if shift > 15 {
+			shift = 15
+		} else if shift < 0 {
+			shift = 0
+		}
+	}
+	qmax := int64(1)<<(precision-1) - 1
+	quantized := make([]int64, len(coefs))
+	var carry float64 // the quantization error is carried over to the next coefficient
+	for i, c := range coefs {
+		v := c*float64(int64(1)<<shift) + carry
+		q := int64(math.Round(v))
+		if q > qmax {
+			q = qmax
+		} else if q < -qmax-1 {
+			q = -qmax - 1
+		}
+		carry = v - float64(q)
+		quantized[i] = q
+	}
+	return quantized, shift
+}
+
+// encodeFlacResidual writes residual[order:] with Rice coding, using the partition order and
+// Rice parameters that give the smallest estimated size. It returns false if the residual
+// does not fit in 32 bits, which FLAC decoders may not support.
+func encodeFlacResidual(bw *bitWriter, residual []int64, order int) bool {
+	n := len(residual)
+
+	// Zig-zag encode the residual, so that it is unsigned
+	u := make([]uint64, n)
+	for i := order; i < n; i++ {
+		r := residual[i]
+		if r > math.MaxInt32 || r < math.MinInt32 {
+			return false
+		}
+		u[i] = uint64(r<<1 ^ r>>63)
+	}
+
+	// Find the largest usable partition order, and the sums of the values in each of its partitions
+	maxPartitionOrder := 0
+	for p := 1; p <= flacMaxPartitionOrder; p++ {
+		if n%(1<<p) != 0 || n>>p <= order {
+			break
+		}
+		maxPartitionOrder = p
+	}
+	sums := make([]uint64, 1<<maxPartitionOrder)
+	for p := range sums {
+		start, end := p*(n>>maxPartitionOrder), (p+1)*(n>>maxPartitionOrder)
+		if p == 0 {
+			start = order
+		}
+		for _, v := range u[start:end] {
+			sums[p] += v
+		}
+	}
+
+	// Estimate the size for each partition order, merging partitions as the order goes down
+	bestOrder, bestParams, bestBits := 0, []int(nil), math.MaxInt
+	for p := maxPartitionOrder; p >= 0; p-- {
+		params := make([]int, len(sums))
+		total := 0
+		for i, sum := range sums {
+			count := n >> p
+			if i == 0 {
+				count -= order
+			}
+			params[i], total = bestRiceParameter(sum, count, total)
+		}
+		if total < bestBits {
+			bestOrder, bestParams, bestBits = p, params, total
+		}
+		if p > 0 {
+			merged := make([]uint64, len(sums)/2)
+			for i := range merged {
+				merged[i] = sums[2*i] + sums[2*i+1]
+			}
+			sums = merged
+		}
+	}
+
+	// Rice parameters above 14 need the 5-bit parameter coding method
+	method, paramBits := 0, 4
+	for _, param := range bestParams {
+		if param > 14 {
+			method, paramBits = 1, 5
+		}
+	}
+	bw.write(uint64(method), 2)
+	bw.write(uint64(bestOrder), 4)
+	i := order
+	for p, param := range bestParams {
+		bw.write(uint64(param), paramBits)
+		end := (p + 1) * (n >> bestOrder)
+		for ; i < end; i++ {
+			bw.writeUnary(u[i] >> param)
+			bw.write(u[i], param)
+		}
+	}
+	return true
+}
+
+// bestRiceParameter returns the Rice parameter with the smallest estimated size for count values
+// with the given sum, together with total plus the estimated size in bits (including the parameter)
+func bestRiceParameter(sum uint64, count, total int) (int, int) {
+	bestParam, bestBits := 0, math.MaxInt
+	for param := 0; param <= 30; param++ {
+		size := 5 + count*(param+1) + int(sum>>param)
+		if size < bestBits {
+			bestParam, bestBits = param, size
+		}
+	}
+	return bestParam, total + bestBits
+}
@@ -5,6 +5,7 @@ go 1.20
 require (
 	github.com/esimov/caire v1.4.7-0.20230331122901-fc01f8e08e9e
 	github.com/mjibson/go-dsp v0.0.0-20180508042940-11479a337f12
+	golang.org/x/image v0.7.0
 )
 
 require (
@@ -16,7 +17,6 @@ require (
 	github.com/go-text/typesetting v0.0.0-20230606200221-26abc51a6c27 // indirect
 	golang.org/x/exp v0.0.0-20230522175609-2e198f4a06a1 // indirect
 	golang.org/x/exp/shiny v0.0.0-20230522175609-2e198f4a06a1 // indirect
-	golang.org/x/image v0.7.0 // indirect
 	golang.org/x/sys v0.8.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
 )
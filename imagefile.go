@@ -0,0 +1,99 @@
+package wavecarve
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// imageFileFormat is an image file format that can be read and written
+type imageFileFormat int
+
+const (
+	formatPNG imageFileFormat = iota
+	formatTIFF
+	formatBMP
+)
+
+// imageFormat returns the image file format of the file path, by extension.
+// TIFF is used for .tif and .tiff, BMP for .bmp and PNG for everything else.
+func imageFormat(filePath string) imageFileFormat {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".tif", ".tiff":
+		return formatTIFF
+	case ".bmp":
+		return formatBMP
+	}
+	return formatPNG
+}
+
+// encodeImage writes an image in the format that matches the file path
+func encodeImage(w io.Writer, filePath string, img image.Image) error {
+	switch imageFormat(filePath) {
+	case formatTIFF:
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+	case formatBMP:
+		return bmp.Encode(w, img)
+	}
+	return png.Encode(w, img)
+}
+
+// decodeImage reads an image in the format that matches the file path
+func decodeImage(r io.Reader, filePath string) (image.Image, error) {
+	var (
+		img image.Image
+		err error
+	)
+	switch imageFormat(filePath) {
+	case formatTIFF:
+		img, err = tiff.Decode(r)
+	case formatBMP:
+		img, err = bmp.Decode(r)
+	default:
+		img, err = png.Decode(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", filePath, err)
+	}
+	return img, nil
+}
+
+// WriteImageFile writes an image, like a spectrogram from Spectrogram.ToImage, to a PNG file,
+// to a TIFF file if the file path ends with .tif or .tiff, or to a BMP file if it ends with .bmp.
+// Images with 16 bits per channel, like the ones of EncodingRGBA16 and EncodingGray16, are written
+// with 16 bits per channel to PNG and TIFF files. BMP files only have 8 bits per channel.
+func WriteImageFile(filePath string, img image.Image) error {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = encodeImage(w, filePath, img)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ReadImageFile reads a PNG file, a TIFF file if the file path ends with .tif or .tiff, or a BMP file
+// if it ends with .bmp. 16-bit images are returned as *image.RGBA64, *image.NRGBA64 or *image.Gray16,
+// with the full precision, and DetectImageEncoding can be used to find their spectrogram encoding.
+func ReadImageFile(filePath string) (image.Image, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decodeImage(bufio.NewReader(f), filePath)
+}
@@ -0,0 +1,285 @@
+// Package transformflags defines the command line flags that configure the transforms, the image
+// encoding and the magnitude mapping, which are shared by cmd/carve, cmd/recreate and cmd/spectrogram
+package transformflags
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/xyproto/wavecarve"
+)
+
+// Flags holds the values of the flags, until they are turned into a Config
+type Flags struct {
+	fs        *flag.FlagSet
+	synthesis bool
+
+	transformName  *string
+	fftSize        *int
+	hopSize        *int
+	windowName     *string
+	mdctWindowName *string
+	waveletName    *string
+	level          *int
+	zeroPadding    *int
+	encodingName   *string
+	floor          *float64
+	ceiling        *float64
+	autoRange      *bool
+	curveName      *string
+	curveParameter *float64
+	scaleName      *string
+	bands          *int
+	binsPerOctave  *int
+	minFrequency   *float64
+	maxFrequency   *float64
+
+	// These are only defined for commands that convert the representations back to audio
+	phaseName     *string
+	iterations    *int
+	initialName   *string
+	inversionName *string
+	sampleRate    *uint
+	dither        *bool
+	softClip      *bool
+}
+
+// Config is the configuration that the flags give
+type Config struct {
+	// Name is the name of the transform, in lowercase, which is "cwt" for the Morlet continuous wavelet transform
+	Name string
+
+	// Transform is the transform with the given name, or nil for the CWT, which can not be converted back to audio
+	Transform wavecarve.Transform
+
+	// Transforms are the built-in transforms, configured with the flags
+	Transforms []wavecarve.Transform
+
+	Encoding      wavecarve.ImageEncoding
+	Mapping       wavecarve.MagnitudeMapping
+	Spectrogram   wavecarve.SpectrogramOptions
+	Filterbank    wavecarve.FilterbankOptions
+	ConstantQ     wavecarve.ConstantQOptions
+	MDCT          wavecarve.MDCTOptions
+	WaveletPacket wavecarve.WaveletPacketOptions
+	Morlet        *wavecarve.MorletOptions // only set for the CWT
+
+	// These are only set for commands that convert the representations back to audio
+	Phase      wavecarve.PhaseOptions
+	Inversion  wavecarve.BandInversion
+	Quantize   wavecarve.QuantizeOptions
+	SampleRate uint32 // the sample rate of the output, or 0 for the sample rate of the input
+}
+
+// Register defines the flags on the given flag set. If synthesis is true, the flags for phase
+// retrieval, band inversion and the output audio are defined too, for commands that convert the
+// representations back to audio, and the CWT, which can not be converted back, is not offered.
+func Register(fs *flag.FlagSet, synthesis bool) *Flags {
+	f := &Flags{fs: fs, synthesis: synthesis}
+	transforms, hop, octave := "mdct (modified discrete cosine transform), wpt (wavelet packet transform) or cwt (Morlet continuous wavelet transform)", "", "constant-Q bins or CWT rows"
+	if synthesis {
+		transforms, hop, octave = "mdct (modified discrete cosine transform) or wpt (wavelet packet transform)", ", or a quarter of it if -phase is not keep", "constant-Q bins"
+	}
+	f.transformName = fs.String("transform", "stft", "the transform: stft (short-time Fourier transform), "+transforms+", or bands or cqt, which -scale also picks")
+	f.fftSize = fs.Int("fft", wavecarve.FFTSize, "the number of samples per frame, which does not have to be a power of two (but must be even for the MDCT)")
+	f.hopSize = fs.Int("hop", 0, "the number of samples between frames, or 0 for the FFT size (frames that do not overlap)"+hop)
+	f.windowName = fs.String("window", "rectangular", "the window function: rectangular, hann, hamming, blackman, flattop, kaiser or gaussian")
+	f.mdctWindowName = fs.String("mdctwindow", "sine", "the window function of the MDCT: sine or kbd")
+	f.waveletName = fs.String("wavelet", wavecarve.DefaultWaveletPacketOptions.Wavelet.String(), "the wavelet of the wavelet packet transform: haar, db1 to db10 or sym1 to sym10")
+	f.level = fs.Int("level", wavecarve.DefaultWaveletPacketOptions.Level, "the number of levels of the wavelet packet transform, which gives 2^level rows")
+	f.zeroPadding = fs.Int("pad", 0, "the number of zeros that are appended to each frame before the FFT")
+	f.encodingName = fs.String("encoding", "rgba8", "the image encoding: rgba8, rgba16 (16 bits per channel), gray16 (16-bit magnitude only), or if8, if16, ifgd8 or ifgd16 (phase derivatives), or signed8 or signed16 for the MDCT and wavelet packets (signed8 by default)")
+	f.floor = fs.Float64("floor", wavecarve.DefaultMagnitudeMapping.Floor, "the level of the darkest pixel value, in dB")
+	f.ceiling = fs.Float64("ceiling", wavecarve.DefaultMagnitudeMapping.Ceiling, "the level of the brightest pixel value, in dB")
+	f.autoRange = fs.Bool("auto", false, "pick the floor and the ceiling from the 1st and 100th percentiles of the levels of the audio")
+	f.curveName = fs.String("curve", "db", "how the magnitudes are mapped to pixel values: db, power or mulaw")
+	f.curveParameter = fs.Float64("curveparam", 0, "the exponent of the power curve or the mu of the mulaw curve, or 0 for the default")
+	f.scaleName = fs.String("scale", "linear", "the frequency scale of the rows: linear (one row per FFT bin), mel, bark or log bands, or cqt (constant-Q bins)")
+	f.bands = fs.Int("bands", wavecarve.DefaultFilterbankOptions.Bands, "the number of mel, bark or log bands")
+	f.binsPerOctave = fs.Int("octave", wavecarve.DefaultConstantQOptions.BinsPerOctave, "the number of "+octave+" per octave")
+	f.minFrequency = fs.Float64("fmin", 0, "the lowest frequency of the bands or constant-Q bins, in Hz, or 0 for the default (C1 for constant-Q bins)")
+	f.maxFrequency = fs.Float64("fmax", 0, "the highest frequency of the bands or constant-Q bins, in Hz, or 0 for the Nyquist frequency")
+	if synthesis {
+		f.phaseName = fs.String("phase", "keep", "how the phase is found: keep (the phase of the spectrogram), griffinlim, fastgriffinlim or pghi")
+		f.iterations = fs.Int("iterations", wavecarve.DefaultPhaseOptions.Iterations, "the largest number of Griffin-Lim iterations")
+		f.initialName = fs.String("initialphase", "random", "the phase that Griffin-Lim starts from: random, zero, existing or pghi")
+		f.inversionName = fs.String("inversion", "nnls", "how the bins are estimated from mel, bark or log bands: nnls or pinv (pseudo-inverse)")
+		f.sampleRate = fs.Uint("rate", 0, "resample the output to this sample rate, in Hz")
+		f.dither = fs.Bool("dither", false, "add TPDF dither when converting to integer samples")
+		f.softClip = fs.Bool("softclip", false, "soft clip samples that overshoot, instead of clamping them")
+	}
+	return f
+}
+
+// Usage returns a usage function for the flag set, with the given arguments after the flags
+func (f *Flags) Usage(name, arguments string) func() {
+	return func() {
+		var synopsis strings.Builder
+		f.fs.VisitAll(func(fl *flag.Flag) {
+			valueName, _ := flag.UnquoteUsage(fl)
+			if valueName == "" {
+				fmt.Fprintf(&synopsis, "[-%s] ", fl.Name)
+			} else {
+				fmt.Fprintf(&synopsis, "[-%s %s] ", fl.Name, valueName)
+			}
+		})
+		fmt.Fprintf(f.fs.Output(), "Usage: %s %s%s\n", name, synopsis.String(), arguments)
+		f.fs.PrintDefaults()
+	}
+}
+
+// Config validates the flags and returns the configuration that they give.
+// It must be called after the flags have been parsed.
+func (f *Flags) Config() (*Config, error) {
+	c := &Config{}
+	var err error
+
+	// Configure how the phase is found when the spectrograms are converted back to audio
+	if f.synthesis {
+		c.Phase = wavecarve.DefaultPhaseOptions
+		if c.Phase.Method, err = wavecarve.ParsePhaseMethod(*f.phaseName); err != nil {
+			return nil, err
+		}
+		if c.Phase.Initial, err = wavecarve.ParseInitialPhase(*f.initialName); err != nil {
+			return nil, err
+		}
+		c.Phase.Iterations = *f.iterations
+		if err := c.Phase.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Configure the short-time Fourier transform that is used for the spectrograms. Phase retrieval
+	// needs overlapping frames, so the frames overlap by 75% by default when the phase is retrieved.
+	hopSize := *f.hopSize
+	if hopSize == 0 {
+		hopSize = *f.fftSize
+		if f.synthesis && c.Phase.Method != wavecarve.PhaseKeep {
+			hopSize = *f.fftSize / 4
+		}
+	}
+	if f.synthesis && c.Phase.Method != wavecarve.PhaseKeep && hopSize >= *f.fftSize {
+		return nil, fmt.Errorf("the %s phase method needs overlapping frames, so -hop must be smaller than -fft", c.Phase.Method)
+	}
+	window, err := wavecarve.ParseWindowFunction(*f.windowName)
+	if err != nil {
+		return nil, err
+	}
+	c.Spectrogram = wavecarve.SpectrogramOptions{FFTSize: *f.fftSize, HopSize: hopSize, Window: window, ZeroPadding: *f.zeroPadding}
+	if err := c.Spectrogram.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Configure the other transforms
+	mdctWindow, err := wavecarve.ParseMDCTWindow(*f.mdctWindowName)
+	if err != nil {
+		return nil, err
+	}
+	c.MDCT = wavecarve.MDCTOptions{FrameSize: *f.fftSize, Window: mdctWindow}
+	wavelet, err := wavecarve.ParseWavelet(*f.waveletName)
+	if err != nil {
+		return nil, err
+	}
+	c.WaveletPacket = wavecarve.WaveletPacketOptions{Wavelet: wavelet, Level: *f.level}
+	c.ConstantQ = wavecarve.ConstantQOptions{BinsPerOctave: *f.binsPerOctave, MinFrequency: *f.minFrequency, MaxFrequency: *f.maxFrequency}
+	if c.ConstantQ.MinFrequency == 0 {
+		c.ConstantQ.MinFrequency = wavecarve.DefaultConstantQOptions.MinFrequency
+	}
+	c.Filterbank = wavecarve.FilterbankOptions{Scale: wavecarve.DefaultFilterbankOptions.Scale, Bands: *f.bands, MinFrequency: *f.minFrequency, MaxFrequency: *f.maxFrequency}
+
+	// Pick the transform by name, where the -scale flag gives frequency bands or constant-Q bins instead of the STFT
+	c.Name = strings.ToLower(*f.transformName)
+	switch scale := strings.ToLower(*f.scaleName); {
+	case scale == "linear":
+	case c.Name != "stft":
+		return nil, fmt.Errorf("the -scale flag can not be used together with -transform %s", *f.transformName)
+	case scale == "cqt":
+		c.Name = "cqt"
+	default:
+		if c.Filterbank.Scale, err = wavecarve.ParseFrequencyScale(*f.scaleName); err != nil {
+			return nil, err
+		}
+		c.Name = "bands"
+	}
+	if f.synthesis {
+		if c.Inversion, err = wavecarve.ParseBandInversion(*f.inversionName); err != nil {
+			return nil, err
+		}
+		if c.Name == "cwt" {
+			return nil, errors.New("the CWT can not be converted back to audio, so it can only be used with cmd/spectrogram")
+		}
+	}
+
+	// The default encoding has a counterpart for the transforms that can not use it
+	encodingName := *f.encodingName
+	if encodingName == "rgba8" {
+		switch c.Name {
+		case "mdct", "wpt":
+			encodingName = "signed8"
+		case "bands":
+			encodingName = "gray16"
+		}
+	}
+	if c.Encoding, err = wavecarve.ParseImageEncoding(encodingName); err != nil {
+		return nil, err
+	}
+
+	// Configure how the magnitudes are mapped to pixel values
+	curve, err := wavecarve.ParseMagnitudeCurve(*f.curveName)
+	if err != nil {
+		return nil, err
+	}
+	c.Mapping = wavecarve.DefaultMagnitudeMapping
+	c.Mapping.Floor, c.Mapping.Ceiling, c.Mapping.Auto = *f.floor, *f.ceiling, *f.autoRange
+	c.Mapping.Curve, c.Mapping.CurveParameter = curve, *f.curveParameter
+	if err := c.Mapping.Validate(); err != nil {
+		return nil, err
+	}
+
+	// The built-in transforms are configured with the flags, and other registered transforms are used as they are
+	c.Transforms = []wavecarve.Transform{
+		wavecarve.STFTTransform{Options: c.Spectrogram, Phase: c.Phase, Mapping: c.Mapping},
+		wavecarve.BandTransform{Options: c.Spectrogram, Filterbank: c.Filterbank, Inversion: c.Inversion, Phase: c.Phase, Mapping: c.Mapping},
+		wavecarve.ConstantQTransform{Options: c.ConstantQ, Mapping: c.Mapping},
+		wavecarve.MDCTTransform{Options: c.MDCT, Mapping: c.Mapping},
+		wavecarve.WaveletPacketTransform{Options: c.WaveletPacket, Mapping: c.Mapping},
+	}
+	if c.Name == "cwt" {
+		// The CWT can not be converted back to audio, so it is not a registered transform, and it has its own default hop size
+		c.Morlet = &wavecarve.MorletOptions{VoicesPerOctave: *f.binsPerOctave, MinFrequency: *f.minFrequency, MaxFrequency: *f.maxFrequency, HopSize: *f.hopSize}
+		if c.Morlet.MinFrequency == 0 {
+			c.Morlet.MinFrequency = wavecarve.DefaultMorletOptions.MinFrequency
+		}
+		if c.Morlet.HopSize == 0 {
+			c.Morlet.HopSize = wavecarve.DefaultMorletOptions.HopSize
+		}
+	} else if c.Transform, err = c.Lookup(c.Name); err != nil {
+		return nil, err
+	}
+
+	// Configure how the resynthesised audio is converted to integer samples
+	if f.synthesis {
+		c.Quantize = wavecarve.DefaultQuantizeOptions
+		if *f.dither {
+			c.Quantize.Dither = wavecarve.DitherTPDF
+		}
+		if *f.softClip {
+			c.Quantize.Clipping = wavecarve.ClipSoft
+		}
+		c.SampleRate = uint32(*f.sampleRate)
+	}
+	return c, nil
+}
+
+// Lookup returns the transform with the given name from the configured ones,
+// or the registered transform with that name
+func (c *Config) Lookup(name string) (wavecarve.Transform, error) {
+	for _, transform := range c.Transforms {
+		if strings.EqualFold(transform.Name(), name) {
+			return transform, nil
+		}
+	}
+	return wavecarve.LookupTransform(name)
+}
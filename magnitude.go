@@ -0,0 +1,295 @@
+package wavecarve
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"sort"
+	"strings"
+)
+
+// MagnitudeCurve selects how the magnitudes between the floor and the ceiling of a
+// MagnitudeMapping are mapped to pixel values
+type MagnitudeCurve int
+
+const (
+	// CurveDB maps the level in dB linearly to the pixel values, so that every step is the same number of dB
+	CurveDB MagnitudeCurve = iota
+
+	// CurvePower maps the linear magnitude to the pixel values with a power law, given by the
+	// exponent in CurveParameter (0.3 if it is 0). Exponents below 1 give more of the pixel
+	// values to the quiet parts, and an exponent of 1 maps the linear magnitude linearly.
+	CurvePower
+
+	// CurveMuLaw maps the linear magnitude to the pixel values with mu-law companding, given by
+	// the mu in CurveParameter (255 if it is 0). Larger values give more of the pixel values to
+	// the quiet parts.
+	CurveMuLaw
+)
+
+var curveNames = [...]string{
+	CurveDB:    "db",
+	CurvePower: "power",
+	CurveMuLaw: "mulaw",
+}
+
+// The curve parameters that are used when CurveParameter is 0
+const (
+	defaultPowerExponent = 0.3
+	defaultMu            = 255.0
+)
+
+// String returns the name of the curve, as accepted by ParseMagnitudeCurve
+func (c MagnitudeCurve) String() string {
+	if c >= 0 && int(c) < len(curveNames) {
+		return curveNames[c]
+	}
+	return fmt.Sprintf("MagnitudeCurve(%d)", int(c))
+}
+
+// ParseMagnitudeCurve returns the magnitude curve with the given name, like "db" or "mulaw"
+func ParseMagnitudeCurve(name string) (MagnitudeCurve, error) {
+	for c, curveName := range curveNames {
+		if strings.EqualFold(name, curveName) {
+			return MagnitudeCurve(c), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown magnitude curve %q, expected one of: %s", name, strings.Join(curveNames[:], ", "))
+}
+
+// MagnitudeMapping describes how the magnitudes of a spectrogram are mapped to pixel values.
+// Magnitudes are relative to full scale, so that a full scale sinusoid is at about -6 dB.
+// Everything below the floor is black and everything above the ceiling is clipped to white.
+// The mapping is stored in the SpectrogramHeader, so that the magnitudes can be decoded exactly.
+type MagnitudeMapping struct {
+	Floor   float64 // the level of the darkest pixel value, in dB
+	Ceiling float64 // the level of the brightest pixel value, in dB
+
+	// Curve is how the magnitudes between the floor and the ceiling are mapped to pixel values,
+	// and CurveParameter is the exponent of CurvePower or the mu of CurveMuLaw
+	Curve          MagnitudeCurve
+	CurveParameter float64
+
+	// If Auto is true, the floor and the ceiling are picked from the levels of the spectrogram
+	// instead, at the given percentiles of all of its bins. A LowPercentile of 1 ignores the
+	// quietest 1% of the bins, and a HighPercentile of 100 keeps the loudest bin from clipping.
+	Auto           bool
+	LowPercentile  float64
+	HighPercentile float64
+}
+
+// DefaultMagnitudeMapping is used by all functions that convert spectrograms to images, like
+// Spectrogram.ToImage, WriteTransformImage and CreateSpectrogramFromAudio. The default maps
+// the range from -140 dB to 0 dB linearly in dB, which is the mapping of earlier versions.
+var DefaultMagnitudeMapping = MagnitudeMapping{Floor: minDB, Ceiling: maxDB, Curve: CurveDB, LowPercentile: 1, HighPercentile: 100}
+
+// AutoMagnitudeMapping returns a mapping that picks the floor and the ceiling from the given
+// percentiles of the levels of the spectrogram, with the given curve
+func AutoMagnitudeMapping(lowPercentile, highPercentile float64, curve MagnitudeCurve) MagnitudeMapping {
+	return MagnitudeMapping{Floor: minDB, Ceiling: maxDB, Curve: curve, Auto: true, LowPercentile: lowPercentile, HighPercentile: highPercentile}
+}
+
+// Validate returns an error if the mapping can not be used
+func (m MagnitudeMapping) Validate() error {
+	switch {
+	case m.Curve < 0 || int(m.Curve) >= len(curveNames):
+		return fmt.Errorf("unknown magnitude curve %d", int(m.Curve))
+	case m.CurveParameter < 0 || math.IsNaN(m.CurveParameter) || math.IsInf(m.CurveParameter, 0):
+		return fmt.Errorf("invalid magnitude curve parameter %g, it must be a positive number", m.CurveParameter)
+	case m.Auto && !(0 <= m.LowPercentile && m.LowPercentile < m.HighPercentile && m.HighPercentile <= 100):
+		return fmt.Errorf("invalid percentiles %g and %g, expected 0 <= low < high <= 100", m.LowPercentile, m.HighPercentile)
+	case !m.Auto && !(m.Floor < m.Ceiling):
+		return fmt.Errorf("invalid dB range from %g to %g, the floor must be below the ceiling", m.Floor, m.Ceiling)
+	case math.IsInf(m.Floor, 0) || math.IsInf(m.Ceiling, 0):
+		return fmt.Errorf("invalid dB range from %g to %g, it must be finite", m.Floor, m.Ceiling)
+	}
+	return nil
+}
+
+// Resolve returns the mapping with the floor and the ceiling picked from the levels of the
+// given spectrograms, if Auto is true. The same mapping is used for all of the channels.
+// Silent spectrograms give a range that ends at the quietest level.
+func (m MagnitudeMapping) Resolve(spectrograms []*Spectrogram) (MagnitudeMapping, error) {
+	if err := m.Validate(); err != nil {
+		return m, err
+	}
+	if !m.Auto {
+		return m, nil
+	}
+	var levels []float64
+	for _, s := range spectrograms {
+		gain := windowGain(s.Options.Window.Coefficients(s.Options.FFTSize, s.Options.WindowParameter))
+		levels = appendLevels(levels, s.Bins, gain)
+	}
+	return m.resolve(levels), nil
+}
+
+// appendLevels appends the levels of columns of complex values, in dB, after dividing the magnitudes by the gain
+func appendLevels(levels []float64, bins [][]complex128, gain float64) []float64 {
+	for _, column := range bins {
+		for _, val := range column {
+			levels = append(levels, magnitudeToDB(cmplx.Abs(val)/gain))
+		}
+	}
+	return levels
+}
+
+// resolve returns the automatic mapping with the floor and the ceiling picked from the given levels, in dB
+func (m MagnitudeMapping) resolve(levels []float64) MagnitudeMapping {
+	resolved := m
+	resolved.Auto = false
+	if len(levels) == 0 {
+		return resolved
+	}
+	sort.Float64s(levels)
+	resolved.Floor = percentile(levels, m.LowPercentile)
+	resolved.Ceiling = percentile(levels, m.HighPercentile)
+	if resolved.Ceiling-resolved.Floor < 1 {
+		// Keep at least 1 dB, so that the mapping is valid
+		resolved.Ceiling = resolved.Floor + 1
+	}
+	return resolved
+}
+
+// fixed returns the mapping with its floor and ceiling, even if it is automatic. This is used for
+// images without metadata, like the ones of CreateSpectrogramFromAudio, where the range is not stored.
+func (m MagnitudeMapping) fixed() MagnitudeMapping {
+	m.Auto = false
+	return m
+}
+
+// percentile returns the given percentile of sorted values, with linear interpolation
+func percentile(sorted []float64, p float64) float64 {
+	pos := p / 100 * float64(len(sorted)-1)
+	i := int(pos)
+	if i >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (pos-float64(i))*(sorted[i+1]-sorted[i])
+}
+
+// curveParameter returns the parameter of the curve, or the default if it is 0
+func (m MagnitudeMapping) curveParameter() float64 {
+	if m.CurveParameter > 0 {
+		return m.CurveParameter
+	}
+	switch m.Curve {
+	case CurvePower:
+		return defaultPowerExponent
+	case CurveMuLaw:
+		return defaultMu
+	}
+	return 0
+}
+
+// encode maps a magnitude relative to full scale to the range [0, 1]
+func (m MagnitudeMapping) encode(mag float64) float64 {
+	db := 20 * math.Log10(mag)
+	if !(db > m.Floor) { // also true for NaN and -Inf
+		return 0
+	} else if db >= m.Ceiling {
+		return 1
+	}
+	if m.Curve == CurveDB {
+		return (db - m.Floor) / (m.Ceiling - m.Floor)
+	}
+	// The linear magnitude, from 0 at the floor to 1 at the ceiling
+	floor := math.Pow(10, (m.Floor-m.Ceiling)/20)
+	u := (math.Pow(10, (db-m.Ceiling)/20) - floor) / (1 - floor)
+	switch m.Curve {
+	case CurvePower:
+		return math.Pow(u, m.curveParameter())
+	case CurveMuLaw:
+		mu := m.curveParameter()
+		return math.Log1p(mu*u) / math.Log1p(mu)
+	}
+	return 0
+}
+
+// decode maps a value in the range [0, 1] back to a magnitude relative to full scale.
+// The darkest pixel value gives the magnitude of the floor, like in earlier versions.
+func (m MagnitudeMapping) decode(v float64) float64 {
+	if m.Curve == CurveDB {
+		return math.Pow(10, (v*(m.Ceiling-m.Floor)+m.Floor)/20)
+	}
+	var u float64
+	switch m.Curve {
+	case CurvePower:
+		u = math.Pow(v, 1/m.curveParameter())
+	case CurveMuLaw:
+		mu := m.curveParameter()
+		u = math.Expm1(v*math.Log1p(mu)) / mu
+	}
+	floor := math.Pow(10, (m.Floor-m.Ceiling)/20)
+	return (u*(1-floor) + floor) * math.Pow(10, m.Ceiling/20)
+}
+
+// toByte maps a magnitude relative to full scale to the range 0-255
+func (m MagnitudeMapping) toByte(mag float64) uint8 {
+	return uint8(math.Round(m.encode(mag) * 255))
+}
+
+// fromByte maps a value in the range 0-255 back to a magnitude relative to full scale
+func (m MagnitudeMapping) fromByte(v uint8) float64 {
+	return m.decode(float64(v) / 255)
+}
+
+// toUint16 maps a magnitude relative to full scale to the range 0-65535
+func (m MagnitudeMapping) toUint16(mag float64) uint16 {
+	return uint16(math.Round(m.encode(mag) * 0xffff))
+}
+
+// fromUint16 maps a value in the range 0-65535 back to a magnitude relative to full scale
+func (m MagnitudeMapping) fromUint16(v uint16) float64 {
+	return m.decode(float64(v) / 0xffff)
+}
+
+// toSignedByte maps a signed value relative to full scale to the range 1-255, with 0 at 128
+func (m MagnitudeMapping) toSignedByte(v float64) uint8 {
+	offset := uint8(math.Round(m.encode(math.Abs(v)) * 127))
+	if v < 0 {
+		return 128 - offset
+	}
+	return 128 + offset
+}
+
+// fromSignedByte maps a value in the range 0-255 back to a signed value relative to full scale.
+// Only 128 gives 0, and 0 is treated like 1.
+func (m MagnitudeMapping) fromSignedByte(v uint8) float64 {
+	switch {
+	case v == 128:
+		return 0
+	case v < 128:
+		offset := 128 - int(v)
+		if offset > 127 {
+			offset = 127
+		}
+		return -m.decode(float64(offset) / 127)
+	}
+	return m.decode(float64(int(v)-128) / 127)
+}
+
+// toSignedUint16 maps a signed value relative to full scale to the range 1-65535, with 0 at 32768
+func (m MagnitudeMapping) toSignedUint16(v float64) uint16 {
+	offset := uint16(math.Round(m.encode(math.Abs(v)) * 0x7fff))
+	if v < 0 {
+		return 0x8000 - offset
+	}
+	return 0x8000 + offset
+}
+
+// fromSignedUint16 maps a value in the range 0-65535 back to a signed value relative to full scale,
+// like fromSignedByte
+func (m MagnitudeMapping) fromSignedUint16(v uint16) float64 {
+	switch {
+	case v == 0x8000:
+		return 0
+	case v < 0x8000:
+		offset := 0x8000 - int(v)
+		if offset > 0x7fff {
+			offset = 0x7fff
+		}
+		return -m.decode(float64(offset) / 0x7fff)
+	}
+	return m.decode(float64(int(v)-0x8000) / 0x7fff)
+}
@@ -0,0 +1,431 @@
+package wavecarve
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"math/cmplx"
+	"strings"
+
+	"github.com/mjibson/go-dsp/fft"
+)
+
+// The name of the modified discrete cosine transform in the SpectrogramHeader
+const mdctTransform = "mdct"
+
+// MDCTWindow selects the window of the modified discrete cosine transform. Both windows
+// fulfil the Princen-Bradley condition, which the time-domain aliasing cancellation needs.
+type MDCTWindow int
+
+const (
+	// MDCTWindowSine is half a period of a sine, which has good frequency selectivity near the peaks
+	MDCTWindowSine MDCTWindow = iota
+
+	// MDCTWindowKBD is the Kaiser-Bessel derived window, which has better rejection far from the peaks,
+	// with the alpha of the Kaiser window given in WindowParameter (4 if it is 0)
+	MDCTWindowKBD
+)
+
+var mdctWindowNames = [...]string{
+	MDCTWindowSine: "sine",
+	MDCTWindowKBD:  "kbd",
+}
+
+// The alpha of the Kaiser-Bessel derived window when WindowParameter is 0
+const defaultKBDAlpha = 4.0
+
+// String returns the name of the MDCT window, as accepted by ParseMDCTWindow
+func (w MDCTWindow) String() string {
+	if w >= 0 && int(w) < len(mdctWindowNames) {
+		return mdctWindowNames[w]
+	}
+	return fmt.Sprintf("MDCTWindow(%d)", int(w))
+}
+
+// ParseMDCTWindow returns the MDCT window with the given name, like "sine" or "kbd"
+func ParseMDCTWindow(name string) (MDCTWindow, error) {
+	for w, windowName := range mdctWindowNames {
+		if strings.EqualFold(name, windowName) {
+			return MDCTWindow(w), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown MDCT window %q, expected one of: %s", name, strings.Join(mdctWindowNames[:], ", "))
+}
+
+// Coefficients returns the window as frameSize coefficients, where frameSize is even. The parameter
+// is the alpha of the Kaiser-Bessel derived window, and the default is used if it is 0.
+func (w MDCTWindow) Coefficients(frameSize int, parameter float64) []float64 {
+	n := frameSize / 2
+	coefficients := make([]float64, frameSize)
+	if w == MDCTWindowKBD {
+		if parameter == 0 {
+			parameter = defaultKBDAlpha
+		}
+		// The cumulative sum of a Kaiser window of n+1 samples, normalised and square rooted
+		cumsum := make([]float64, n+1)
+		sum := 0.0
+		for j := range cumsum {
+			x := 0.0
+			if n > 0 {
+				x = 2*float64(j)/float64(n) - 1
+			}
+			sum += kaiser(x, math.Pi*parameter)
+			cumsum[j] = sum
+		}
+		for i := 0; i < n; i++ {
+			coefficients[i] = math.Sqrt(cumsum[i] / sum)
+			coefficients[frameSize-1-i] = coefficients[i]
+		}
+		return coefficients
+	}
+	for i := range coefficients {
+		coefficients[i] = math.Sin(math.Pi * (float64(i) + 0.5) / float64(frameSize))
+	}
+	return coefficients
+}
+
+// MDCTOptions configures the modified discrete cosine transform of an MDCTSpectrogram
+type MDCTOptions struct {
+	// FrameSize is the number of samples in each frame, which must be even. The frames overlap by
+	// half, and each one gives FrameSize/2 coefficients, so there are as many coefficients as samples.
+	FrameSize int
+
+	// Window is the window that is applied to each frame, both before the transform and after the
+	// inverse, and WindowParameter is the alpha of the Kaiser-Bessel derived window, or 0 for the default
+	Window          MDCTWindow
+	WindowParameter float64
+}
+
+// DefaultMDCTOptions give 512 coefficients per column, with a sine window
+var DefaultMDCTOptions = MDCTOptions{FrameSize: FFTSize, Window: MDCTWindowSine}
+
+// Validate returns an error if the options can not be used for creating an MDCT spectrogram
+func (o MDCTOptions) Validate() error {
+	switch {
+	case o.FrameSize < 2 || o.FrameSize%2 != 0:
+		return fmt.Errorf("invalid MDCT frame size %d, it must be even and at least 2", o.FrameSize)
+	case o.Window < 0 || int(o.Window) >= len(mdctWindowNames):
+		return fmt.Errorf("unknown MDCT window %d", int(o.Window))
+	case o.WindowParameter < 0 || math.IsNaN(o.WindowParameter) || math.IsInf(o.WindowParameter, 0):
+		return fmt.Errorf("invalid window parameter %g, it can not be negative", o.WindowParameter)
+	}
+	return nil
+}
+
+// Bins returns the number of coefficients per column, which is the height of an MDCT spectrogram.
+// This is also the hop size, which is half of the frame size.
+func (o MDCTOptions) Bins() int {
+	return o.FrameSize / 2
+}
+
+// Columns returns the number of columns of an MDCT spectrogram of the given number of samples.
+// The first frame starts one hop before the audio and the last one ends at or after its end, so that
+// every sample is covered by two frames, which is what cancels the aliasing of the inverse transform.
+func (o MDCTOptions) Columns(length int) int {
+	if length <= 0 {
+		return 0
+	}
+	hopSize := o.Bins()
+	return (length+hopSize-1)/hopSize + 1
+}
+
+// maxLength returns the largest number of samples that gives the given number of columns
+func (o MDCTOptions) maxLength(columns int) int {
+	if columns <= 1 {
+		return 0
+	}
+	return (columns - 1) * o.Bins()
+}
+
+// mdctTwiddles holds the factors that turn an FFT of twice the hop size into an MDCT, and back
+type mdctTwiddles struct {
+	pre, post []complex128 // the factors before and after the FFT of the forward transform
+	scale     float64      // the scale of the orthonormal transform, which is the same in both directions
+}
+
+// newMDCTTwiddles returns the twiddle factors for the given hop size. The MDCT is
+// X[k] = scale * sum(x[n] cos(pi/N (n + n0) (k + 1/2))), with N the hop size and n0 = 1/2 + N/2,
+// which is the real part of an FFT of 2N samples when the samples and the bins are rotated.
+func newMDCTTwiddles(hopSize int) *mdctTwiddles {
+	n := float64(hopSize)
+	n0 := 0.5 + n/2
+	t := &mdctTwiddles{
+		pre:   make([]complex128, 2*hopSize),
+		post:  make([]complex128, hopSize),
+		scale: math.Sqrt(2 / n),
+	}
+	for i := range t.pre {
+		t.pre[i] = cmplx.Exp(complex(0, -math.Pi*float64(i)/(2*n)))
+	}
+	for k := range t.post {
+		t.post[k] = cmplx.Exp(complex(0, -math.Pi*n0*(float64(k)+0.5)/n))
+	}
+	return t
+}
+
+// mdct returns the modified discrete cosine transform of the samples, with Bins() coefficients per column
+func mdct(float64s []float64, options MDCTOptions) [][]float64 {
+	hopSize := options.Bins()
+	w := options.Window.Coefficients(options.FrameSize, options.WindowParameter)
+	t := newMDCTTwiddles(hopSize)
+	buf := make([]complex128, options.FrameSize)
+	columns := make([][]float64, options.Columns(len(float64s)))
+	for i := range columns {
+		start := (i - 1) * hopSize
+		for n := range buf {
+			buf[n] = 0
+			if pos := start + n; pos >= 0 && pos < len(float64s) {
+				buf[n] = complex(float64s[pos]*w[n], 0) * t.pre[n]
+			}
+		}
+		spectrum := fft.FFT(buf)
+		columns[i] = make([]float64, hopSize)
+		for k := range columns[i] {
+			columns[i][k] = t.scale * real(spectrum[k]*t.post[k])
+		}
+	}
+	return columns
+}
+
+// imdct returns length samples from the MDCT columns, which are typically created by mdct. Each
+// column is transformed back to a frame with aliased halves, windowed again and overlap-added, and
+// the aliasing of neighbouring frames cancels out, which makes the analysis and synthesis exact.
+func imdct(columns [][]float64, length int, options MDCTOptions) []float64 {
+	hopSize := options.Bins()
+	w := options.Window.Coefficients(options.FrameSize, options.WindowParameter)
+	t := newMDCTTwiddles(hopSize)
+	frameSize := complex(float64(options.FrameSize), 0)
+	buf := make([]complex128, options.FrameSize)
+	float64s := make([]float64, length)
+	for i, column := range columns {
+		for k := range buf {
+			buf[k] = 0
+			if k < hopSize && k < len(column) {
+				// The conjugate of the rotation of the forward transform, without the part that depends on n
+				buf[k] = complex(column[k], 0) * cmplx.Conj(t.post[k]) * t.post[0]
+			}
+		}
+		frame := fft.IFFT(buf)
+		start := (i - 1) * hopSize
+		for n, val := range frame {
+			if pos := start + n; pos >= 0 && pos < length {
+				float64s[pos] += t.scale * real(val*frameSize*cmplx.Conj(t.pre[n]*t.post[0])) * w[n]
+			}
+		}
+	}
+	return float64s
+}
+
+// MDCTSpectrogram holds the real-valued coefficients of the modified discrete cosine transform of audio.
+// The frames overlap by half and there are as many coefficients as samples, but the aliasing that this
+// leaves in each frame cancels out between neighbouring frames, so the transform can be inverted exactly.
+// There is no phase, so a single signed value per bin is all that needs to be stored in an image.
+type MDCTSpectrogram struct {
+	// Coefficients holds one slice of Options.Bins() coefficients per column, from 0 Hz at index 0
+	// up to the Nyquist frequency. Coefficient k of a column is centred at (k + 1/2) / FrameSize
+	// times the sample rate.
+	Coefficients [][]float64
+
+	Options    MDCTOptions
+	SampleRate uint32
+	Length     int
+}
+
+// NewMDCTSpectrogram creates an MDCT spectrogram from samples in the range [-1, 1]
+func NewMDCTSpectrogram(float64s []float64, sampleRate uint32, options MDCTOptions) (*MDCTSpectrogram, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	return &MDCTSpectrogram{
+		Coefficients: mdct(float64s, options),
+		Options:      options,
+		SampleRate:   sampleRate,
+		Length:       len(float64s),
+	}, nil
+}
+
+// MDCTSpectrograms creates one MDCT spectrogram per channel, with the given options
+func (a *Audio) MDCTSpectrograms(options MDCTOptions) ([]*MDCTSpectrogram, error) {
+	spectrograms := make([]*MDCTSpectrogram, a.NumChannels())
+	for c, channel := range a.Channels {
+		spectrogram, err := NewMDCTSpectrogram(channel, a.SampleRate, options)
+		if err != nil {
+			return nil, err
+		}
+		spectrograms[c] = spectrogram
+	}
+	return spectrograms, nil
+}
+
+// AudioFromMDCTSpectrograms creates audio from one MDCT spectrogram per channel, with the sample
+// rate of the first spectrogram. The samples are not quantized.
+func AudioFromMDCTSpectrograms(spectrograms []*MDCTSpectrogram) (*Audio, error) {
+	if len(spectrograms) == 0 {
+		return nil, errors.New("no spectrograms to create audio from")
+	}
+	audio := NewAudio(0, 0, spectrograms[0].SampleRate)
+	audio.Channels = make([][]float64, len(spectrograms))
+	for c, spectrogram := range spectrograms {
+		audio.Channels[c] = spectrogram.Samples()
+	}
+	return audio, nil
+}
+
+// Columns returns the number of columns (frames) of the MDCT spectrogram
+func (s *MDCTSpectrogram) Columns() int {
+	return len(s.Coefficients)
+}
+
+// Samples creates audio from the MDCT spectrogram with the inverse transform. If the spectrogram has
+// not been modified, this gives back the samples it was created from, to within floating point precision.
+// The samples are not clipped, so they may be outside of the range [-1, 1].
+func (s *MDCTSpectrogram) Samples() []float64 {
+	return imdct(s.Coefficients, s.Length, s.Options)
+}
+
+// gain returns what the coefficients are divided by in the images, so that a full scale sinusoid
+// is at up to about -6 dB, depending on its phase, like in the images of a Spectrogram
+func (s *MDCTSpectrogram) gain() float64 {
+	w := s.Options.Window.Coefficients(s.Options.FrameSize, s.Options.WindowParameter)
+	return math.Sqrt(2/float64(s.Options.Bins())) * windowGain(w)
+}
+
+// plane returns the coefficients, the number of rows and the gain of the MDCT spectrogram
+func (s *MDCTSpectrogram) plane() ([][]float64, int, float64) {
+	return s.Coefficients, s.Options.Bins(), s.gain()
+}
+
+// kind returns what an MDCT spectrogram is called in error messages
+func (s *MDCTSpectrogram) kind() string {
+	return "MDCT spectrogram"
+}
+
+// sameOptions returns true if the other MDCT spectrogram was created with the same options
+func (s *MDCTSpectrogram) sameOptions(other *MDCTSpectrogram) bool {
+	return s.Options == other.Options
+}
+
+// withCoefficients returns a copy of the MDCT spectrogram with the given coefficients,
+// which is one hop shorter per removed column
+func (s *MDCTSpectrogram) withCoefficients(coefficients [][]float64, removedColumns int) *MDCTSpectrogram {
+	c := *s
+	c.Coefficients = coefficients
+	c.Length -= removedColumns * s.Options.Bins()
+	if c.Length < 0 {
+		c.Length = 0
+	}
+	return &c
+}
+
+// ToImage converts the MDCT spectrogram to an image with one column per frame and one row per
+// coefficient, with 0 Hz at the top, with DefaultMagnitudeMapping. Only EncodingSigned8 and
+// EncodingSigned16 can be used, which store the sign of each coefficient together with its magnitude.
+func (s *MDCTSpectrogram) ToImage(encoding ImageEncoding) (image.Image, error) {
+	imgs, _, err := planeImages([]*MDCTSpectrogram{s}, encoding, DefaultMagnitudeMapping)
+	if err != nil {
+		return nil, err
+	}
+	return imgs[0], nil
+}
+
+// header returns the header for an image of the MDCT spectrogram with the given encoding and mapping
+func (s *MDCTSpectrogram) header(encoding ImageEncoding, mapping MagnitudeMapping) SpectrogramHeader {
+	return SpectrogramHeader{
+		Version:         SpectrogramHeaderVersion,
+		Encoding:        encoding.String(),
+		Channels:        1,
+		Length:          s.Length,
+		SampleRate:      s.SampleRate,
+		FFTSize:         s.Options.FrameSize,
+		HopSize:         s.Options.Bins(),
+		Window:          s.Options.Window.String(),
+		WindowParameter: s.Options.WindowParameter,
+		MinDB:           mapping.Floor,
+		MaxDB:           mapping.Ceiling,
+		Curve:           mapping.Curve.String(),
+		CurveParameter:  mapping.CurveParameter,
+		Transform:       mdctTransform,
+	}
+}
+
+// MDCTSpectrogramImages converts one MDCT spectrogram per channel to images with the given encoding
+// and DefaultMagnitudeMapping, and returns them together with the header that is needed for
+// converting them back, like SpectrogramImages
+func MDCTSpectrogramImages(spectrograms []*MDCTSpectrogram, encoding ImageEncoding) ([]image.Image, SpectrogramHeader, error) {
+	return MDCTSpectrogramImagesWithMapping(spectrograms, encoding, DefaultMagnitudeMapping)
+}
+
+// MDCTSpectrogramImagesWithMapping is like MDCTSpectrogramImages, but with the given magnitude mapping
+func MDCTSpectrogramImagesWithMapping(spectrograms []*MDCTSpectrogram, encoding ImageEncoding, mapping MagnitudeMapping) ([]image.Image, SpectrogramHeader, error) {
+	return planeImages(spectrograms, encoding, mapping)
+}
+
+// validateMDCT returns an error if a valid header is not for an MDCTSpectrogram,
+// or if its options do not match an image of the given size
+func (h SpectrogramHeader) validateMDCT(width, height int) error {
+	if h.Transform != mdctTransform {
+		return errors.New("the spectrogram metadata is not for an MDCT spectrogram")
+	}
+	encoding, _ := h.ImageEncoding()
+	options, err := h.MDCTOptions()
+	if err != nil {
+		return err
+	}
+	if encoding != EncodingSigned8 && encoding != EncodingSigned16 {
+		return fmt.Errorf("the %s encoding can not be used for MDCT spectrograms, only %s and %s", encoding, EncodingSigned8, EncodingSigned16)
+	}
+	switch {
+	case height != h.Channels*options.Bins():
+		return fmt.Errorf("the image is %d pixels high, but the metadata gives %d channels of %d MDCT coefficients", height, h.Channels, options.Bins())
+	case width != options.Columns(h.Length) && !(h.Length == 0 && width < options.Columns(1)):
+		return fmt.Errorf("the image is %d pixels wide, but the metadata gives %d columns for %d samples", width, options.Columns(h.Length), h.Length)
+	}
+	return nil
+}
+
+// MDCTSpectrogramFromImage converts an image that was created with MDCTSpectrogram.ToImage back to an
+// MDCT spectrogram, with the options of the header, which is validated against the size of the image
+func MDCTSpectrogramFromImage(img image.Image, header SpectrogramHeader) (*MDCTSpectrogram, error) {
+	if err := validateChannel(img, header, header.validateMDCT); err != nil {
+		return nil, err
+	}
+	options, _ := header.MDCTOptions()
+	return planeFromImage(img, header, &MDCTSpectrogram{Options: options, SampleRate: header.SampleRate, Length: header.Length}), nil
+}
+
+// CarveMDCTSpectrograms removes seams from one MDCT spectrogram per channel to reduce their width by the
+// given percentage, like CarveSpectrograms. The seams are found in the levels of the coefficients, and the
+// coefficients themselves are removed, since there is no phase that has to be kept consistent. The aliasing
+// of the frames on either side of a seam no longer cancels out, which is what carving this representation
+// sounds like. The lengths of the carved spectrograms are reduced by one hop per removed column.
+func CarveMDCTSpectrograms(spectrograms []*MDCTSpectrogram, newWidthInPercentage float64) ([]*MDCTSpectrogram, error) {
+	return carvePlanes(spectrograms, newWidthInPercentage)
+}
+
+// CreateMDCTImageFromAudio creates an image of the MDCT coefficients of an []int16, as an alternative to
+// CreateSpectrogramFromAudio. The image is encoded with EncodingSigned8, in shades of grey, and is created
+// with DefaultMDCTOptions. The image has no metadata, so use WriteTransformImage with an MDCTTransform for spectrograms
+// that should be converted back to audio with the exact length.
+func CreateMDCTImageFromAudio(int16s []int16) (*image.RGBA, error) {
+	spectrogram, err := NewMDCTSpectrogram(int16sToFloat64s(int16s), SampleRate, DefaultMDCTOptions)
+	if err != nil {
+		return nil, err
+	}
+	return encodeSigned8(spectrogram.Coefficients, spectrogram.Options.Bins(), spectrogram.gain(), DefaultMagnitudeMapping.fixed()), nil
+}
+
+// CreateAudioFromMDCTImage creates audio from an image that was created with CreateMDCTImageFromAudio, and
+// possibly carved with CarveSeams, with DefaultMDCTOptions. The length of the audio is not stored in the
+// image, so the audio covers all of its columns. The samples are quantized with DefaultQuantizeOptions,
+// and if any samples clipped, an ErrClipped error is returned together with the audio data.
+func CreateAudioFromMDCTImage(img *image.RGBA) ([]int16, error) {
+	s := &MDCTSpectrogram{Options: DefaultMDCTOptions, SampleRate: SampleRate, Length: DefaultMDCTOptions.maxLength(img.Bounds().Dx())}
+	spectrogram, err := MDCTSpectrogramFromImage(img, s.header(EncodingSigned8, DefaultMagnitudeMapping.fixed()))
+	if err != nil {
+		return nil, err
+	}
+	q := NewQuantizer(16, 1, DefaultQuantizeOptions)
+	int16s := float64sToInt16s(spectrogram.Samples(), q)
+	return int16s, q.err()
+}
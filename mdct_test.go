@@ -0,0 +1,94 @@
+package wavecarve
+
+import "testing"
+
+func TestMDCTPerfectReconstruction(t *testing.T) {
+	tests := []struct {
+		name    string
+		options MDCTOptions
+	}{
+		{"default", DefaultMDCTOptions},
+		{"sine", MDCTOptions{FrameSize: 256, Window: MDCTWindowSine}},
+		{"kbd", MDCTOptions{FrameSize: 2048, Window: MDCTWindowKBD}},
+		{"kbd with alpha 6", MDCTOptions{FrameSize: 512, Window: MDCTWindowKBD, WindowParameter: 6}},
+	}
+	audio := testAudio(2)
+	for _, tt := range tests {
+		spectrograms, err := audio.MDCTSpectrograms(tt.options)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		recreated, err := AudioFromMDCTSpectrograms(spectrograms)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if difference := maxDifference(audio, recreated); difference > 1e-12 {
+			t.Errorf("%s: the largest difference is %g", tt.name, difference)
+		}
+	}
+}
+
+func TestMDCTImageRoundTrip(t *testing.T) {
+	tests := []struct {
+		encoding  ImageEncoding
+		tolerance float64
+	}{
+		{EncodingSigned8, 0.05},
+		{EncodingSigned16, 5e-4},
+	}
+	audio := testAudio(1)
+	spectrograms, err := audio.MDCTSpectrograms(DefaultMDCTOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		imgs, header, err := MDCTSpectrogramImages(spectrograms, tt.encoding)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.encoding, err)
+		}
+		spectrogram, err := MDCTSpectrogramFromImage(pngRoundTrip(t, imgs[0]), header)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.encoding, err)
+		}
+		recreated := audio.WithChannels([][]float64{spectrogram.Samples()})
+		if difference := maxDifference(audio, recreated); difference > tt.tolerance {
+			t.Errorf("%s: the largest difference is %g", tt.encoding, difference)
+		}
+	}
+}
+
+func TestCarveMDCTSpectrograms(t *testing.T) {
+	audio := testAudio(2)
+	spectrograms, err := audio.MDCTSpectrograms(MDCTOptions{FrameSize: 512, Window: MDCTWindowSine})
+	if err != nil {
+		t.Fatal(err)
+	}
+	width := spectrograms[0].Columns()
+	tests := []struct {
+		name       string
+		percentage float64
+		columns    int
+	}{
+		{"unchanged", 100, width},
+		{"half", 50, width / 2},
+		{"one column", oneColumn(width), 1},
+	}
+	for _, tt := range tests {
+		carved, err := CarveMDCTSpectrograms(spectrograms, tt.percentage)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for c, s := range carved {
+			if s.Columns() != tt.columns {
+				t.Errorf("%s: channel %d has %d columns, want %d", tt.name, c, s.Columns(), tt.columns)
+			}
+		}
+		recreated, err := AudioFromMDCTSpectrograms(carved)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if recreated.NumChannels() != 2 || recreated.Frames() > audio.Frames() {
+			t.Errorf("%s: got %d channels of %d samples from %d samples", tt.name, recreated.NumChannels(), recreated.Frames(), audio.Frames())
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package wavecarve
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Keys for common LIST/INFO entries
+const (
+	InfoTitle        = "INAM"
+	InfoArtist       = "IART"
+	InfoAlbum        = "IPRD"
+	InfoComment      = "ICMT"
+	InfoCopyright    = "ICOP"
+	InfoCreationDate = "ICRD"
+	InfoGenre        = "IGNR"
+	InfoEngineer     = "IENG"
+	InfoSoftware     = "ISFT"
+	InfoTrackNumber  = "ITRK"
+)
+
+// WavMetadata holds the metadata chunks of a .wav file:
+// LIST/INFO, bext (Broadcast Wave), cue (with labels from LIST/adtl), smpl, id3 and iXML.
+// Other chunks, and LIST chunks of other types than INFO and adtl, are kept as they are in Other.
+type WavMetadata struct {
+	Info      map[string]string // LIST/INFO entries, keyed by their four character ID, like InfoTitle
+	Broadcast *BroadcastExtension
+	CuePoints []CuePoint
+	Sampler   *SamplerInfo
+	ID3       []byte // the raw contents of an id3 chunk
+	IXML      string
+	Other     []RawChunk // chunks that are not parsed, which are written back unchanged
+}
+
+// RawChunk is a chunk that is kept as it is, without being parsed
+type RawChunk struct {
+	ID   [4]byte
+	Data []byte
+}
+
+// String returns the four character ID of the chunk
+func (c RawChunk) String() string {
+	return string(c.ID[:])
+}
+
+// BroadcastExtension is the contents of a bext chunk, as described in EBU Tech 3285
+type BroadcastExtension struct {
+	Description          string
+	Originator           string
+	OriginatorReference  string
+	OriginationDate      string // yyyy-mm-dd
+	OriginationTime      string // hh:mm:ss
+	TimeReference        uint64 // the number of samples since midnight
+	Version              uint16
+	UMID                 [64]byte
+	LoudnessValue        int16
+	LoudnessRange        int16
+	MaxTruePeakLevel     int16
+	MaxMomentaryLoudness int16
+	MaxShortTermLoudness int16
+	CodingHistory        string
+}
+
+// CuePoint is a position in the audio data, with an optional label
+type CuePoint struct {
+	ID       uint32
+	Position uint32 // in sample frames
+	Label    string
+}
+
+// SampleLoop is a loop from a smpl chunk
+type SampleLoop struct {
+	CuePointID uint32
+	Type       uint32 // 0 is forward, 1 is alternating and 2 is backward
+	Start      uint32 // in sample frames
+	End        uint32 // in sample frames, inclusive
+	Fraction   uint32
+	PlayCount  uint32 // 0 means infinite
+}
+
+// SamplerInfo is the contents of a smpl chunk
+type SamplerInfo struct {
+	Manufacturer      uint32
+	Product           uint32
+	SamplePeriod      uint32 // in nanoseconds
+	MIDIUnityNote     uint32
+	MIDIPitchFraction uint32
+	SMPTEFormat       uint32
+	SMPTEOffset       uint32
+	Loops             []SampleLoop
+	SamplerData       []byte
+}
+
+// The size of the fixed part of a bext chunk
+const bextSize = 602
+
+// NewWavMetadata returns empty metadata
+func NewWavMetadata() *WavMetadata {
+	return &WavMetadata{Info: make(map[string]string)}
+}
+
+// InfoValue returns the LIST/INFO entry with the given key, like InfoTitle, or an empty string
+func (m *WavMetadata) InfoValue(key string) string {
+	return m.Info[key]
+}
+
+// SetInfo sets the LIST/INFO entry with the given key, like InfoTitle.
+// An empty value removes the entry.
+func (m *WavMetadata) SetInfo(key, value string) {
+	if value == "" {
+		delete(m.Info, key)
+		return
+	}
+	if m.Info == nil {
+		m.Info = make(map[string]string)
+	}
+	m.Info[key] = value
+}
+
+// AddCuePoint adds a cue point at the given position (in sample frames) and returns its ID
+func (m *WavMetadata) AddCuePoint(position uint32, label string) uint32 {
+	var id uint32 = 1
+	for _, cue := range m.CuePoints {
+		if cue.ID >= id {
+			id = cue.ID + 1
+		}
+	}
+	m.CuePoints = append(m.CuePoints, CuePoint{ID: id, Position: position, Label: label})
+	return id
+}
+
+// AddLoop adds a forward loop from start to end (in sample frames, inclusive) that is played forever
+func (m *WavMetadata) AddLoop(start, end uint32) {
+	if m.Sampler == nil {
+		m.Sampler = &SamplerInfo{MIDIUnityNote: 60}
+	}
+	m.Sampler.Loops = append(m.Sampler.Loops, SampleLoop{Start: start, End: end})
+}
+
+// Clone returns a deep copy of the metadata, or nil if m is nil
+func (m *WavMetadata) Clone() *WavMetadata {
+	if m == nil {
+		return nil
+	}
+	clone := *m
+	if m.Info != nil {
+		clone.Info = make(map[string]string, len(m.Info))
+		for key, value := range m.Info {
+			clone.Info[key] = value
+		}
+	}
+	if m.Broadcast != nil {
+		broadcast := *m.Broadcast
+		clone.Broadcast = &broadcast
+	}
+	clone.CuePoints = append([]CuePoint(nil), m.CuePoints...)
+	if m.Sampler != nil {
+		sampler := *m.Sampler
+		sampler.Loops = append([]SampleLoop(nil), m.Sampler.Loops...)
+		sampler.SamplerData = append([]byte(nil), m.Sampler.SamplerData...)
+		clone.Sampler = &sampler
+	}
+	clone.ID3 = append([]byte(nil), m.ID3...)
+	clone.Other = nil
+	for _, chunk := range m.Other {
+		clone.Other = append(clone.Other, RawChunk{ID: chunk.ID, Data: append([]byte(nil), chunk.Data...)})
+	}
+	return &clone
+}
+
+// scalePositions multiplies the positions of the cue points and loops by the given ratio, for audio that now
+// has the given number of frames. Cue points and loops that start past the end are removed, and loops that
+// end past the end are shortened. The bext time reference is kept, since the start of the audio is kept.
+func (m *WavMetadata) scalePositions(ratio float64, frames int) {
+	if m == nil {
+		return
+	}
+	scale := func(position uint32) uint32 {
+		return uint32(math.Round(float64(position) * ratio))
+	}
+	cuePoints := m.CuePoints[:0]
+	for _, cue := range m.CuePoints {
+		if cue.Position = scale(cue.Position); int64(cue.Position) < int64(frames) {
+			cuePoints = append(cuePoints, cue)
+		}
+	}
+	m.CuePoints = cuePoints
+	if m.Sampler != nil {
+		loops := m.Sampler.Loops[:0]
+		for _, loop := range m.Sampler.Loops {
+			loop.Start, loop.End = scale(loop.Start), scale(loop.End)
+			if int64(loop.Start) >= int64(frames) {
+				continue
+			}
+			if int64(loop.End) >= int64(frames) {
+				loop.End = uint32(frames - 1)
+			}
+			loops = append(loops, loop)
+		}
+		m.Sampler.Loops = loops
+	}
+}
+
+// resample moves the positions in the metadata from one sample rate to another, for resampled audio
+// with the given number of frames
+func (m *WavMetadata) resample(from, to uint32, frames int) {
+	if m == nil || from == 0 || to == 0 {
+		return
+	}
+	ratio := float64(to) / float64(from)
+	m.scalePositions(ratio, frames)
+	if m.Sampler != nil {
+		m.Sampler.SamplePeriod = uint32(math.Round(1e9 / float64(to)))
+	}
+	if m.Broadcast != nil {
+		m.Broadcast.TimeReference = uint64(math.Round(float64(m.Broadcast.TimeReference) * ratio))
+	}
+}
+
+// isMetadataChunk returns true if the chunk ID is one that is kept in WavMetadata, which is every
+// chunk except for the ones that are written by WavWriter, and the chunks that are only padding
+func isMetadataChunk(id string) bool {
+	switch id {
+	case "fmt ", "data", "fact", "ds64", "JUNK", "junk", "PAD ", "FLLR":
+		return false
+	}
+	return true
+}
+
+// cString returns the string up to the first zero byte, without trailing spaces
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimRight(string(b), " ")
+}
+
+// cuePoint returns the cue point with the given ID, adding it if it does not exist
+func (m *WavMetadata) cuePoint(id uint32) *CuePoint {
+	for i := range m.CuePoints {
+		if m.CuePoints[i].ID == id {
+			return &m.CuePoints[i]
+		}
+	}
+	m.CuePoints = append(m.CuePoints, CuePoint{ID: id})
+	return &m.CuePoints[len(m.CuePoints)-1]
+}
+
+// parseChunk parses the contents of a metadata chunk into m
+func (m *WavMetadata) parseChunk(id string, data []byte) {
+	le := binary.LittleEndian
+	switch id {
+	case "LIST":
+		if len(data) < 4 {
+			return
+		}
+		listType := string(data[0:4])
+		if listType != "INFO" && listType != "adtl" {
+			m.addOther(id, data)
+			return
+		}
+		for pos := 4; pos+8 <= len(data); {
+			subID := string(data[pos : pos+4])
+			size := int(le.Uint32(data[pos+4 : pos+8]))
+			pos += 8
+			if size > len(data)-pos {
+				size = len(data) - pos
+			}
+			sub := data[pos : pos+size]
+			switch {
+			case listType == "INFO":
+				m.SetInfo(subID, cString(sub))
+			case listType == "adtl" && (subID == "labl" || subID == "note") && len(sub) >= 4:
+				// The cue chunk may come before or after the LIST/adtl chunk
+				cue := m.cuePoint(le.Uint32(sub[0:4]))
+				if subID == "labl" || cue.Label == "" {
+					cue.Label = cString(sub[4:])
+				}
+			}
+			pos += size + size&1
+		}
+	case "bext":
+		if len(data) < bextSize-180 {
+			return
+		}
+		b := &BroadcastExtension{
+			Description:         cString(data[0:256]),
+			Originator:          cString(data[256:288]),
+			OriginatorReference: cString(data[288:320]),
+			OriginationDate:     cString(data[320:330]),
+			OriginationTime:     cString(data[330:338]),
+			TimeReference:       uint64(le.Uint32(data[338:342])) | uint64(le.Uint32(data[342:346]))<<32,
+			Version:             le.Uint16(data[346:348]),
+		}
+		copy(b.UMID[:], data[348:412])
+		b.LoudnessValue = int16(le.Uint16(data[412:414]))
+		b.LoudnessRange = int16(le.Uint16(data[414:416]))
+		b.MaxTruePeakLevel = int16(le.Uint16(data[416:418]))
+		b.MaxMomentaryLoudness = int16(le.Uint16(data[418:420]))
+		b.MaxShortTermLoudness = int16(le.Uint16(data[420:422]))
+		if len(data) > bextSize {
+			b.CodingHistory = cString(data[bextSize:])
+		}
+		m.Broadcast = b
+	case "cue ":
+		if len(data) < 4 {
+			return
+		}
+		count := int(le.Uint32(data[0:4]))
+		for i := 0; i < count && 4+i*24+24 <= len(data); i++ {
+			p := data[4+i*24 : 4+i*24+24]
+			m.cuePoint(le.Uint32(p[0:4])).Position = le.Uint32(p[20:24])
+		}
+	case "smpl":
+		if len(data) < 36 {
+			return
+		}
+		s := &SamplerInfo{
+			Manufacturer:      le.Uint32(data[0:4]),
+			Product:           le.Uint32(data[4:8]),
+			SamplePeriod:      le.Uint32(data[8:12]),
+			MIDIUnityNote:     le.Uint32(data[12:16]),
+			MIDIPitchFraction: le.Uint32(data[16:20]),
+			SMPTEFormat:       le.Uint32(data[20:24]),
+			SMPTEOffset:       le.Uint32(data[24:28]),
+		}
+		count := int(le.Uint32(data[28:32]))
+		samplerDataSize := int(le.Uint32(data[32:36]))
+		pos := 36
+		for i := 0; i < count && pos+24 <= len(data); i++ {
+			p := data[pos : pos+24]
+			s.Loops = append(s.Loops, SampleLoop{
+				CuePointID: le.Uint32(p[0:4]),
+				Type:       le.Uint32(p[4:8]),
+				Start:      le.Uint32(p[8:12]),
+				End:        le.Uint32(p[12:16]),
+				Fraction:   le.Uint32(p[16:20]),
+				PlayCount:  le.Uint32(p[20:24]),
+			})
+			pos += 24
+		}
+		if samplerDataSize > 0 && pos+samplerDataSize <= len(data) {
+			s.SamplerData = append([]byte{}, data[pos:pos+samplerDataSize]...)
+		}
+		m.Sampler = s
+	case "id3 ", "ID3 ":
+		m.ID3 = append([]byte{}, data...)
+	case "iXML":
+		m.IXML = cString(data)
+	default:
+		m.addOther(id, data)
+	}
+}
+
+// addOther keeps a copy of a chunk that is not parsed
+func (m *WavMetadata) addOther(id string, data []byte) {
+	var chunk RawChunk
+	copy(chunk.ID[:], id)
+	chunk.Data = append([]byte(nil), data...)
+	m.Other = append(m.Other, chunk)
+}
+
+// appendChunk appends a chunk with the given ID and data, followed by a pad byte if needed
+func appendChunk(buf *bytes.Buffer, id string, data []byte) {
+	buf.WriteString(id)
+	binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+	if len(data)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+// encodeChunks returns the metadata as a sequence of RIFF chunks
+func (m *WavMetadata) encodeChunks() []byte {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+
+	// LIST/INFO, with the entries in a predictable order
+	if len(m.Info) > 0 {
+		keys := make([]string, 0, len(m.Info))
+		for key := range m.Info {
+			if len(key) == 4 {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		var list bytes.Buffer
+		list.WriteString("INFO")
+		for _, key := range keys {
+			appendChunk(&list, key, append([]byte(m.Info[key]), 0))
+		}
+		appendChunk(&buf, "LIST", list.Bytes())
+	}
+
+	if b := m.Broadcast; b != nil {
+		data := make([]byte, bextSize, bextSize+len(b.CodingHistory))
+		copy(data[0:256], b.Description)
+		copy(data[256:288], b.Originator)
+		copy(data[288:320], b.OriginatorReference)
+		copy(data[320:330], b.OriginationDate)
+		copy(data[330:338], b.OriginationTime)
+		le.PutUint32(data[338:342], uint32(b.TimeReference))
+		le.PutUint32(data[342:346], uint32(b.TimeReference>>32))
+		le.PutUint16(data[346:348], b.Version)
+		copy(data[348:412], b.UMID[:])
+		le.PutUint16(data[412:414], uint16(b.LoudnessValue))
+		le.PutUint16(data[414:416], uint16(b.LoudnessRange))
+		le.PutUint16(data[416:418], uint16(b.MaxTruePeakLevel))
+		le.PutUint16(data[418:420], uint16(b.MaxMomentaryLoudness))
+		le.PutUint16(data[420:422], uint16(b.MaxShortTermLoudness))
+		data = append(data, b.CodingHistory...)
+		appendChunk(&buf, "bext", data)
+	}
+
+	if len(m.CuePoints) > 0 {
+		data := make([]byte, 4+24*len(m.CuePoints))
+		le.PutUint32(data[0:4], uint32(len(m.CuePoints)))
+		var adtl bytes.Buffer
+		adtl.WriteString("adtl")
+		for i, cue := range m.CuePoints {
+			p := data[4+i*24 : 4+i*24+24]
+			le.PutUint32(p[0:4], cue.ID)
+			le.PutUint32(p[4:8], cue.Position)
+			copy(p[8:12], "data")
+			le.PutUint32(p[20:24], cue.Position)
+			if cue.Label != "" {
+				label := make([]byte, 4, 4+len(cue.Label)+1)
+				le.PutUint32(label[0:4], cue.ID)
+				label = append(append(label, cue.Label...), 0)
+				appendChunk(&adtl, "labl", label)
+			}
+		}
+		appendChunk(&buf, "cue ", data)
+		if adtl.Len() > 4 {
+			appendChunk(&buf, "LIST", adtl.Bytes())
+		}
+	}
+
+	if s := m.Sampler; s != nil {
+		data := make([]byte, 36+24*len(s.Loops), 36+24*len(s.Loops)+len(s.SamplerData))
+		for i, v := range []uint32{s.Manufacturer, s.Product, s.SamplePeriod, s.MIDIUnityNote, s.MIDIPitchFraction,
+			s.SMPTEFormat, s.SMPTEOffset, uint32(len(s.Loops)), uint32(len(s.SamplerData))} {
+			le.PutUint32(data[i*4:i*4+4], v)
+		}
+		for i, loop := range s.Loops {
+			p := data[36+i*24 : 36+i*24+24]
+			for j, v := range []uint32{loop.CuePointID, loop.Type, loop.Start, loop.End, loop.Fraction, loop.PlayCount} {
+				le.PutUint32(p[j*4:j*4+4], v)
+			}
+		}
+		data = append(data, s.SamplerData...)
+		appendChunk(&buf, "smpl", data)
+	}
+
+	if len(m.ID3) > 0 {
+		appendChunk(&buf, "id3 ", m.ID3)
+	}
+
+	if m.IXML != "" {
+		appendChunk(&buf, "iXML", []byte(m.IXML))
+	}
+
+	for _, chunk := range m.Other {
+		appendChunk(&buf, chunk.String(), chunk.Data)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,123 @@
+package wavecarve
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testMetadata returns metadata with every kind of chunk that is supported
+func testMetadata() *WavMetadata {
+	m := NewWavMetadata()
+	m.SetInfo(InfoTitle, "Title")
+	m.SetInfo(InfoArtist, "Artist")
+	m.SetInfo(InfoComment, "An odd length")
+	m.Broadcast = &BroadcastExtension{
+		Description:          "Description",
+		Originator:           "Originator",
+		OriginatorReference:  "Reference",
+		OriginationDate:      "2024-01-02",
+		OriginationTime:      "03:04:05",
+		TimeReference:        1<<32 + 5,
+		Version:              2,
+		LoudnessValue:        -2300,
+		LoudnessRange:        500,
+		MaxTruePeakLevel:     -100,
+		MaxMomentaryLoudness: -1800,
+		MaxShortTermLoudness: -2000,
+		CodingHistory:        "A=PCM,F=16000,W=16,M=stereo\r\n",
+	}
+	m.Broadcast.UMID[0], m.Broadcast.UMID[63] = 1, 2
+	m.AddCuePoint(100, "First")
+	m.AddCuePoint(2000, "")
+	m.AddCuePoint(3000, "Third")
+	m.Sampler = &SamplerInfo{
+		Manufacturer:  1,
+		Product:       2,
+		SamplePeriod:  62500,
+		MIDIUnityNote: 60,
+		Loops:         []SampleLoop{{CuePointID: 1, Start: 100, End: 1999, PlayCount: 3}, {Type: 1, Start: 2000, End: 2999}},
+		SamplerData:   []byte{1, 2, 3},
+	}
+	m.ID3 = []byte("ID3\x04\x00\x00\x00\x00\x00\x00")
+	m.IXML = "<BWFXML><PROJECT>Project</PROJECT></BWFXML>"
+	m.Other = []RawChunk{
+		{ID: [4]byte{'L', 'I', 'S', 'T'}, Data: []byte("exif\x00\x01\x02")},
+		{ID: [4]byte{'u', 'm', 'i', 'd'}, Data: []byte{1, 2, 3, 4, 5}},
+	}
+	return m
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	metadata := testMetadata()
+	header := NewWAVHeader(WaveFormatPCM, 2, 16000, 16)
+	header.Metadata = metadata
+	filePath := filepath.Join(t.TempDir(), "test.wav")
+	if err := WriteWavFileFloat64(filePath, testAudio(2).Channels, header); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadWavMetadata(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, metadata) {
+		t.Errorf("got the metadata\n%+v\nwant\n%+v", read, metadata)
+	}
+
+	// The metadata is kept when the file is read and written again
+	channels, header, err := ReadWavFileFloat64(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteWavFileFloat64(filePath, channels, header); err != nil {
+		t.Fatal(err)
+	}
+	if read, err = ReadWavMetadata(filePath); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, metadata) {
+		t.Errorf("got the metadata\n%+v\nafter writing it again, want\n%+v", read, metadata)
+	}
+
+	// Clone returns a deep copy
+	clone := metadata.Clone()
+	if !reflect.DeepEqual(clone, metadata) {
+		t.Errorf("got the clone\n%+v\nwant\n%+v", clone, metadata)
+	}
+	clone.Other[1].Data[0] = 9
+	clone.Sampler.Loops[0].Start = 9
+	clone.SetInfo(InfoTitle, "Other title")
+	if metadata.Other[1].Data[0] != 1 || metadata.Sampler.Loops[0].Start != 100 || metadata.InfoValue(InfoTitle) != "Title" {
+		t.Error("changing the clone changed the original")
+	}
+}
+
+func TestCueLabelsAndNotes(t *testing.T) {
+	le := binary.LittleEndian
+	cue := make([]byte, 4+2*24)
+	le.PutUint32(cue[0:4], 2)
+	for i, position := range []uint32{10, 20} {
+		le.PutUint32(cue[4+i*24:], uint32(i+1))
+		le.PutUint32(cue[4+i*24+20:], position)
+	}
+	label := func(id uint32, text string) []byte {
+		data := le.AppendUint32(nil, id)
+		return append(append(data, text...), 0)
+	}
+
+	// The adtl chunk comes before the cue chunk, and a labl takes precedence over a note
+	adtl := append([]byte("adtl"), riffChunk("note", label(1, "Note"))...)
+	adtl = append(adtl, riffChunk("note", label(2, "Only a note"))...)
+	adtl = append(adtl, riffChunk("labl", label(1, "Label"))...)
+	adtl = append(adtl, riffChunk("ltxt", make([]byte, 20))...)
+	data := riffFile("WAVE", fmtChunk(WaveFormatPCM, 1, 16), riffChunk("LIST", adtl), riffChunk("cue ", cue), riffChunk("data", make([]byte, 100)))
+	metadata, err := ReadWavMetadata(writeTestFile(t, "test.wav", data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []CuePoint{{ID: 1, Position: 10, Label: "Label"}, {ID: 2, Position: 20, Label: "Only a note"}}
+	if metadata == nil || !reflect.DeepEqual(metadata.CuePoints, want) {
+		t.Errorf("got the cue points %+v, want %+v", metadata, want)
+	}
+}
@@ -0,0 +1,212 @@
+package wavecarve
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Audio format codes, as used in the fmt chunk of a .wav file
+const (
+	WaveFormatPCM        = 0x0001
+	WaveFormatIEEEFloat  = 0x0003
+	WaveFormatExtensible = 0xFFFE
+)
+
+// The last 14 bytes of the KSDATAFORMAT_SUBTYPE_* GUIDs, which only differ in the first two bytes
+var subFormatGUIDSuffix = [14]byte{0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71}
+
+// SubFormatGUID returns the sub-format GUID that is used in a
+// WAVE_FORMAT_EXTENSIBLE fmt chunk for the given audio format code
+func SubFormatGUID(audioFormat uint16) [16]byte {
+	var guid [16]byte
+	binary.LittleEndian.PutUint16(guid[0:2], audioFormat)
+	copy(guid[2:], subFormatGUIDSuffix[:])
+	return guid
+}
+
+// SampleFormat returns the audio format code of the samples, which is either
+// AudioFormat or, for WAVE_FORMAT_EXTENSIBLE files, the code in the sub-format GUID
+func (h WAVHeader) SampleFormat() uint16 {
+	if h.AudioFormat == WaveFormatExtensible {
+		return binary.LittleEndian.Uint16(h.SubFormat[0:2])
+	}
+	return h.AudioFormat
+}
+
+// BytesPerSample returns the size of the container that holds a single sample,
+// which may be larger than BitsPerSample (for instance 20-bit audio in 24-bit containers)
+func (h WAVHeader) BytesPerSample() int {
+	if h.NumChannels > 0 && h.BlockAlign >= h.NumChannels {
+		return int(h.BlockAlign / h.NumChannels)
+	}
+	return (int(h.BitsPerSample) + 7) / 8
+}
+
+// MakeExtensible converts the header to a WAVE_FORMAT_EXTENSIBLE header,
+// keeping the sample format in the sub-format GUID
+func (h *WAVHeader) MakeExtensible() {
+	if h.AudioFormat == WaveFormatExtensible {
+		return
+	}
+	h.SubFormat = SubFormatGUID(h.AudioFormat)
+	h.AudioFormat = WaveFormatExtensible
+	h.ValidBitsPerSample = h.BitsPerSample
+	if h.ChannelMask == 0 && h.NumChannels <= 32 {
+		// Use the first speaker positions (front left, front right, front center etc.)
+		h.ChannelMask = uint32(1)<<h.NumChannels - 1
+	}
+}
+
+// NewWAVHeader returns a header for the given audio format
+// (WaveFormatPCM or WaveFormatIEEEFloat), channel count, sample rate and bit depth
+func NewWAVHeader(audioFormat, numChannels uint16, sampleRate uint32, bitsPerSample uint16) WAVHeader {
+	var header WAVHeader
+	copy(header.ChunkID[:], "RIFF")
+	copy(header.Format[:], "WAVE")
+	copy(header.Subchunk1ID[:], "fmt ")
+	copy(header.Subchunk2ID[:], "data")
+	header.AudioFormat = audioFormat
+	header.NumChannels = numChannels
+	header.SampleRate = sampleRate
+	header.BitsPerSample = bitsPerSample
+	header.BlockAlign = numChannels * ((bitsPerSample + 7) / 8)
+	header.ByteRate = sampleRate * uint32(header.BlockAlign)
+	return header
+}
+
+// checkSampleFormat returns an error if the combination of sample format and sample size is not supported
+func checkSampleFormat(format uint16, bytesPerSample int) error {
+	switch format {
+	case WaveFormatPCM:
+		if bytesPerSample >= 1 && bytesPerSample <= 4 {
+			return nil
+		}
+	case WaveFormatIEEEFloat:
+		if bytesPerSample == 4 || bytesPerSample == 8 {
+			return nil
+		}
+	}
+	return ErrUnsupportedFormat{AudioFormat: format, Bits: uint16(bytesPerSample * 8)}
+}
+
+// Convert a slice of bytes to a slice of float64s in the range [-1, 1].
+// 8-bit PCM samples are unsigned if unsigned8 is true, which is the case for .wav files.
+func bytesToFloat64s(bytes []byte, format uint16, bytesPerSample int, order binary.ByteOrder, unsigned8 bool) ([]float64, error) {
+	if err := checkSampleFormat(format, bytesPerSample); err != nil {
+		return nil, err
+	}
+	float64s := make([]float64, len(bytes)/bytesPerSample)
+	for i := range float64s {
+		b := bytes[i*bytesPerSample : (i+1)*bytesPerSample]
+		if format == WaveFormatIEEEFloat {
+			if bytesPerSample == 4 {
+				float64s[i] = float64(math.Float32frombits(order.Uint32(b)))
+			} else {
+				float64s[i] = math.Float64frombits(order.Uint64(b))
+			}
+			continue
+		}
+		switch bytesPerSample {
+		case 1:
+			if unsigned8 {
+				float64s[i] = (float64(b[0]) - 128) / 128
+			} else {
+				float64s[i] = float64(int8(b[0])) / 128
+			}
+		case 2:
+			float64s[i] = float64(int16(order.Uint16(b))) / (1 << 15)
+		case 3:
+			// Place the packed 24-bit sample in the upper bytes of an int32, to get the sign right
+			var v int32
+			if order == binary.BigEndian {
+				v = int32(uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8)
+			} else {
+				v = int32(uint32(b[2])<<24 | uint32(b[1])<<16 | uint32(b[0])<<8)
+			}
+			float64s[i] = float64(v>>8) / (1 << 23)
+		case 4:
+			float64s[i] = float64(int32(order.Uint32(b))) / (1 << 31)
+		}
+	}
+	return float64s, nil
+}
+
+// Convert a slice of float64s in the range [-1, 1] to a slice of bytes.
+// 8-bit PCM samples are written as unsigned if unsigned8 is true, which is the case for .wav files.
+// PCM samples are converted with the given quantizer, which must be for bytesPerSample*8 bits.
+func float64sToBytes(float64s []float64, format uint16, bytesPerSample int, order binary.ByteOrder, unsigned8 bool, q *Quantizer) ([]byte, error) {
+	if err := checkSampleFormat(format, bytesPerSample); err != nil {
+		return nil, err
+	}
+	bytes := make([]byte, len(float64s)*bytesPerSample)
+	for i, sample := range float64s {
+		b := bytes[i*bytesPerSample : (i+1)*bytesPerSample]
+		if format == WaveFormatIEEEFloat {
+			if bytesPerSample == 4 {
+				order.PutUint32(b, math.Float32bits(float32(sample)))
+			} else {
+				order.PutUint64(b, math.Float64bits(sample))
+			}
+			continue
+		}
+		// Scale, clip and round the sample to the range of the integer type
+		v := q.Quantize(sample)
+		switch bytesPerSample {
+		case 1:
+			if unsigned8 {
+				b[0] = uint8(int(v) + 128)
+			} else {
+				b[0] = uint8(int8(v))
+			}
+		case 2:
+			order.PutUint16(b, uint16(int16(v)))
+		case 3:
+			u := uint32(int32(v))
+			if order == binary.BigEndian {
+				b[0], b[1], b[2] = byte(u>>16), byte(u>>8), byte(u)
+			} else {
+				b[0], b[1], b[2] = byte(u), byte(u>>8), byte(u>>16)
+			}
+		case 4:
+			order.PutUint32(b, uint32(int32(v)))
+		}
+	}
+	return bytes, nil
+}
+
+// DeinterleaveFloat64s splits interleaved samples into one slice per channel
+func DeinterleaveFloat64s(float64s []float64, numChannels int) [][]float64 {
+	if numChannels < 1 {
+		numChannels = 1
+	}
+	frames := len(float64s) / numChannels
+	channels := make([][]float64, numChannels)
+	for c := range channels {
+		channels[c] = make([]float64, frames)
+		for i := range channels[c] {
+			channels[c][i] = float64s[i*numChannels+c]
+		}
+	}
+	return channels
+}
+
+// InterleaveFloat64s combines one slice of samples per channel into interleaved samples.
+// If the channels differ in length, the shortest length is used.
+func InterleaveFloat64s(channels [][]float64) []float64 {
+	if len(channels) == 0 {
+		return []float64{}
+	}
+	frames := len(channels[0])
+	for _, channel := range channels[1:] {
+		if len(channel) < frames {
+			frames = len(channel)
+		}
+	}
+	float64s := make([]float64, frames*len(channels))
+	for c, channel := range channels {
+		for i := 0; i < frames; i++ {
+			float64s[i*len(channels)+c] = channel[i]
+		}
+	}
+	return float64s
+}
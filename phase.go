@@ -0,0 +1,457 @@
+package wavecarve
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// PhaseMethod selects how the phase of a spectrogram is found when it is converted to audio
+type PhaseMethod int
+
+const (
+	// PhaseKeep uses the phase that is stored in the spectrogram, like the green channel of the images
+	PhaseKeep PhaseMethod = iota
+
+	// PhaseGriffinLim finds a phase that fits the magnitudes with the Griffin-Lim algorithm, which
+	// alternates between the inverse STFT and the STFT, and keeps the magnitudes in between
+	PhaseGriffinLim
+
+	// PhaseFastGriffinLim is Griffin-Lim with momentum, which usually converges much faster
+	PhaseFastGriffinLim
+
+	// PhasePGHI finds the phase with Phase Gradient Heap Integration, which integrates the phase
+	// derivatives that follow from the magnitudes, without iterating
+	PhasePGHI
+)
+
+var phaseMethodNames = [...]string{
+	PhaseKeep:           "keep",
+	PhaseGriffinLim:     "griffinlim",
+	PhaseFastGriffinLim: "fastgriffinlim",
+	PhasePGHI:           "pghi",
+}
+
+// String returns the name of the phase method, as accepted by ParsePhaseMethod
+func (m PhaseMethod) String() string {
+	if m >= 0 && int(m) < len(phaseMethodNames) {
+		return phaseMethodNames[m]
+	}
+	return fmt.Sprintf("PhaseMethod(%d)", int(m))
+}
+
+// ParsePhaseMethod returns the phase method with the given name, like "keep" or "pghi"
+func ParsePhaseMethod(name string) (PhaseMethod, error) {
+	for m, methodName := range phaseMethodNames {
+		if strings.EqualFold(name, methodName) {
+			return PhaseMethod(m), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown phase method %q, expected one of: %s", name, strings.Join(phaseMethodNames[:], ", "))
+}
+
+// InitialPhase selects the phase that Griffin-Lim and Fast Griffin-Lim start from
+type InitialPhase int
+
+const (
+	// InitialPhaseRandom starts from a random phase, given by the Seed of the PhaseOptions
+	InitialPhaseRandom InitialPhase = iota
+
+	// InitialPhaseZero starts from a phase of zero in every bin
+	InitialPhaseZero
+
+	// InitialPhaseExisting starts from the phase that is stored in the spectrogram, which
+	// is a warm start when the phase has only been damaged a little
+	InitialPhaseExisting
+
+	// InitialPhasePGHI starts from the phase that is found by Phase Gradient Heap Integration
+	InitialPhasePGHI
+)
+
+var initialPhaseNames = [...]string{
+	InitialPhaseRandom:   "random",
+	InitialPhaseZero:     "zero",
+	InitialPhaseExisting: "existing",
+	InitialPhasePGHI:     "pghi",
+}
+
+// String returns the name of the initial phase, as accepted by ParseInitialPhase
+func (p InitialPhase) String() string {
+	if p >= 0 && int(p) < len(initialPhaseNames) {
+		return initialPhaseNames[p]
+	}
+	return fmt.Sprintf("InitialPhase(%d)", int(p))
+}
+
+// ParseInitialPhase returns the initial phase with the given name, like "random" or "existing"
+func ParseInitialPhase(name string) (InitialPhase, error) {
+	for p, initialName := range initialPhaseNames {
+		if strings.EqualFold(name, initialName) {
+			return InitialPhase(p), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown initial phase %q, expected one of: %s", name, strings.Join(initialPhaseNames[:], ", "))
+}
+
+// PhaseOptions configures how the phase of a spectrogram is found when it is converted to audio.
+// Phase retrieval needs overlapping frames: without overlap, every spectrogram is consistent, so
+// Griffin-Lim has nothing to work with.
+type PhaseOptions struct {
+	Method PhaseMethod
+
+	// Iterations is the largest number of iterations of Griffin-Lim and Fast Griffin-Lim. They stop
+	// earlier when the spectral convergence changes by less than Tolerance, relative to its value.
+	Iterations int
+	Tolerance  float64
+
+	// Momentum is the alpha of Fast Griffin-Lim, typically 0.99. A momentum of 0 gives Griffin-Lim.
+	Momentum float64
+
+	// Initial is the phase that Griffin-Lim and Fast Griffin-Lim start from, and Seed is the
+	// seed of InitialPhaseRandom, so that the output can be reproduced
+	Initial InitialPhase
+	Seed    int64
+}
+
+// DefaultPhaseOptions are used by the functions that create audio from spectrograms without being
+// given phase options, like AudioFromSpectrograms, CreateAudioFromSpectrogram and
+// CreateAudioFromSpectrograms, and by STFTTransform and BandTransform if their Phase is not set. The defaults
+// keep the phase of the spectrograms, which is exact for spectrograms that have not been modified.
+var DefaultPhaseOptions = PhaseOptions{Method: PhaseKeep, Iterations: 100, Tolerance: 1e-4, Momentum: 0.99, Initial: InitialPhaseRandom}
+
+// Validate returns an error if the phase options can not be used
+func (o PhaseOptions) Validate() error {
+	switch {
+	case o.Method < 0 || int(o.Method) >= len(phaseMethodNames):
+		return fmt.Errorf("unknown phase method %d", int(o.Method))
+	case o.Initial < 0 || int(o.Initial) >= len(initialPhaseNames):
+		return fmt.Errorf("unknown initial phase %d", int(o.Initial))
+	case o.Iterations < 0:
+		return fmt.Errorf("invalid number of iterations %d, it can not be negative", o.Iterations)
+	case !(o.Tolerance >= 0):
+		return fmt.Errorf("invalid tolerance %g, it can not be negative", o.Tolerance)
+	case !(o.Momentum >= 0 && o.Momentum <= 1):
+		return fmt.Errorf("invalid momentum %g, expected 0 to 1", o.Momentum)
+	}
+	return nil
+}
+
+// RetrievePhase returns a copy of the spectrogram with the same magnitudes and a phase that is found
+// with the given options, which is useful when the phase has been damaged by carving or editing, or
+// when the spectrogram was decoded from an image without phase, like one with EncodingGray16.
+func (s *Spectrogram) RetrievePhase(options PhaseOptions) (*Spectrogram, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	retrieved := *s
+	switch options.Method {
+	case PhaseKeep:
+		retrieved.Bins = copyBins(s.Bins)
+	case PhasePGHI:
+		retrieved.Bins = pghi(s.Bins, s.Options)
+	case PhaseGriffinLim:
+		options.Momentum = 0
+		retrieved.Bins = griffinLim(s.Bins, s.Options, options)
+	case PhaseFastGriffinLim:
+		retrieved.Bins = griffinLim(s.Bins, s.Options, options)
+	}
+	return &retrieved, nil
+}
+
+// copyBins returns a copy of the bins of a spectrogram
+func copyBins(bins [][]complex128) [][]complex128 {
+	c := make([][]complex128, len(bins))
+	for i, column := range bins {
+		c[i] = append([]complex128(nil), column...)
+	}
+	return c
+}
+
+// initialPhase returns the magnitudes of the bins combined with the initial phase of the options
+func initialPhase(bins [][]complex128, spectrogramOptions SpectrogramOptions, options PhaseOptions) [][]complex128 {
+	switch options.Initial {
+	case InitialPhaseExisting:
+		return copyBins(bins)
+	case InitialPhasePGHI:
+		return pghi(bins, spectrogramOptions)
+	}
+	r := rand.New(rand.NewSource(options.Seed))
+	c := make([][]complex128, len(bins))
+	for i, column := range bins {
+		c[i] = make([]complex128, len(column))
+		for k, val := range column {
+			phase := 0.0
+			if options.Initial == InitialPhaseRandom {
+				phase = (2*r.Float64() - 1) * math.Pi
+			}
+			c[i][k] = cmplx.Rect(cmplx.Abs(val), phase)
+		}
+	}
+	return c
+}
+
+// griffinLim finds a phase for the magnitudes of the bins with Fast Griffin-Lim, or with
+// Griffin-Lim if the momentum is 0. Each iteration replaces the magnitudes with the target
+// magnitudes, and then projects the bins onto the consistent spectrograms, which are the ones
+// that are the STFT of a signal, with the inverse STFT followed by the STFT.
+func griffinLim(bins [][]complex128, spectrogramOptions SpectrogramOptions, options PhaseOptions) [][]complex128 {
+	if len(bins) == 0 {
+		return nil
+	}
+	// Iterate over all of the samples that the frames cover, from the start of the first frame,
+	// which is before the audio, so that the STFT gives back the same columns for any length
+	offset := spectrogramOptions.frameStart(0)
+	length := (len(bins)-1)*spectrogramOptions.hopSize() + spectrogramOptions.FFTSize
+	target := make([][]float64, len(bins))
+	for i, column := range bins {
+		target[i] = make([]float64, len(column))
+		for k, val := range column {
+			target[i][k] = cmplx.Abs(val)
+		}
+	}
+	withMagnitudes := func(c [][]complex128) [][]complex128 {
+		m := make([][]complex128, len(c))
+		for i, column := range c {
+			m[i] = make([]complex128, len(column))
+			for k, val := range column {
+				if mag := cmplx.Abs(val); mag > 0 {
+					m[i][k] = val * complex(target[i][k]/mag, 0)
+				} else {
+					m[i][k] = complex(target[i][k], 0)
+				}
+			}
+		}
+		return m
+	}
+
+	t := initialPhase(bins, spectrogramOptions, options)
+	var previous [][]complex128
+	convergence := math.Inf(1)
+	for iteration := 0; iteration < options.Iterations; iteration++ {
+		c := stftAt(istftAt(withMagnitudes(t), offset, length, spectrogramOptions), offset, len(bins), spectrogramOptions)
+
+		// Accelerate with the difference from the previous consistent bins
+		t = c
+		if previous != nil && options.Momentum > 0 {
+			t = make([][]complex128, len(c))
+			for i, column := range c {
+				t[i] = make([]complex128, len(column))
+				for k, val := range column {
+					t[i][k] = val + complex(options.Momentum, 0)*(val-previous[i][k])
+				}
+			}
+		}
+		previous = c
+
+		// Stop when the spectral convergence no longer changes
+		next := spectralConvergence(target, c)
+		if math.Abs(convergence-next) <= options.Tolerance*next {
+			break
+		}
+		convergence = next
+	}
+	if previous == nil {
+		return withMagnitudes(t)
+	}
+	return withMagnitudes(previous)
+}
+
+// spectralConvergence returns the distance between the target magnitudes and the magnitudes
+// of the bins, relative to the target magnitudes
+func spectralConvergence(target [][]float64, bins [][]complex128) float64 {
+	var diff, sum float64
+	for i, column := range target {
+		for k, mag := range column {
+			d := mag - cmplx.Abs(bins[i][k])
+			diff += d * d
+			sum += mag * mag
+		}
+	}
+	if sum == 0 {
+		return 0
+	}
+	return math.Sqrt(diff / sum)
+}
+
+// pghiTolerance is the level, relative to the loudest bin, below which PGHI does not integrate
+// the phase, since the phase derivatives of very quiet bins are unreliable. These bins get a random phase.
+const pghiTolerance = 1e-5
+
+// pghi finds a phase for the magnitudes of the bins with Phase Gradient Heap Integration
+// (Průša, Balazs and Søndergaard, 2017). For a Gaussian window exp(-pi*t^2/lambda), the
+// derivatives of the phase follow from the derivatives of the log-magnitude s:
+// the phase changes over time by 2*pi*f + ds/df / lambda and over frequency by -lambda * ds/dt.
+// Other windows are treated as the Gaussian window with the same spread. The phase is
+// integrated from the loudest bins, which have the most reliable derivatives, outwards.
+func pghi(bins [][]complex128, options SpectrogramOptions) [][]complex128 {
+	columns := len(bins)
+	if columns == 0 {
+		return nil
+	}
+	n, hop, m := options.FFTSize, float64(options.hopSize()), float64(options.TransformSize())
+	w := options.Window.Coefficients(n, options.WindowParameter)
+	lambda := gaussianLambda(w)
+
+	// The log-magnitudes, and the loudest magnitude
+	logMag := make([][]float64, columns)
+	maxMag := 0.0
+	for i, column := range bins {
+		logMag[i] = make([]float64, len(column))
+		for k, val := range column {
+			mag := cmplx.Abs(val)
+			if mag > maxMag {
+				maxMag = mag
+			}
+			logMag[i][k] = math.Log(mag + 1e-300)
+		}
+	}
+	threshold := maxMag * pghiTolerance
+
+	// The phase derivatives over time (radians per sample) and over frequency (radians per cycle per sample)
+	timeDeriv := make([][]float64, columns)
+	freqDeriv := make([][]float64, columns)
+	for i, column := range logMag {
+		timeDeriv[i] = make([]float64, len(column))
+		freqDeriv[i] = make([]float64, len(column))
+		for k := range column {
+			// The derivative of the log-magnitude over frequency, in cycles per sample
+			dsdf := centralDifference(column, k) * m
+			// The derivative of the log-magnitude over time, in samples
+			var dsdt float64
+			switch {
+			case columns == 1:
+			case i == 0:
+				dsdt = (logMag[1][k] - logMag[0][k]) / hop
+			case i == columns-1:
+				dsdt = (logMag[i][k] - logMag[i-1][k]) / hop
+			default:
+				dsdt = (logMag[i+1][k] - logMag[i-1][k]) / (2 * hop)
+			}
+			timeDeriv[i][k] = 2*math.Pi*float64(k)/m + dsdf/lambda
+			freqDeriv[i][k] = -lambda * dsdt
+		}
+	}
+
+	// Integrate the phase from the loudest bins outwards, with a heap of the bins that have a phase
+	phase := make([][]float64, columns)
+	done := make([][]bool, columns)
+	var order []tfBin
+	for i, column := range bins {
+		phase[i] = make([]float64, len(column))
+		done[i] = make([]bool, len(column))
+		for k, val := range column {
+			if cmplx.Abs(val) > threshold {
+				order = append(order, tfBin{i, k, logMag[i][k]})
+			} else {
+				done[i][k] = true
+			}
+		}
+	}
+	sort.Slice(order, func(a, b int) bool { return order[a].level > order[b].level })
+	h := &tfHeap{}
+	for _, start := range order {
+		if done[start.column][start.bin] {
+			continue
+		}
+		done[start.column][start.bin] = true
+		heap.Push(h, start)
+		for h.Len() > 0 {
+			b := heap.Pop(h).(tfBin)
+			i, k := b.column, b.bin
+			integrate := func(j, l int, step float64) {
+				if j < 0 || j >= columns || l < 0 || l >= len(done[j]) || done[j][l] {
+					return
+				}
+				phase[j][l] = phase[i][k] + step
+				done[j][l] = true
+				heap.Push(h, tfBin{j, l, logMag[j][l]})
+			}
+			if i+1 < columns {
+				integrate(i+1, k, hop*(timeDeriv[i][k]+timeDeriv[i+1][k])/2)
+			}
+			if i > 0 {
+				integrate(i-1, k, -hop*(timeDeriv[i][k]+timeDeriv[i-1][k])/2)
+			}
+			if k+1 < len(bins[i]) {
+				integrate(i, k+1, (freqDeriv[i][k]+freqDeriv[i][k+1])/(2*m))
+			}
+			if k > 0 {
+				integrate(i, k-1, -(freqDeriv[i][k]+freqDeriv[i][k-1])/(2*m))
+			}
+		}
+	}
+
+	// Combine the magnitudes and the phase. The derivatives are for frames that are centred on the
+	// window, while the FFT of a frame starts at its first sample, which shifts the phase of each bin.
+	r := rand.New(rand.NewSource(0))
+	centre := float64(n-1) / 2
+	c := make([][]complex128, columns)
+	for i, column := range bins {
+		c[i] = make([]complex128, len(column))
+		for k, val := range column {
+			mag := cmplx.Abs(val)
+			p := phase[i][k] - 2*math.Pi*centre*float64(k)/m
+			if mag <= threshold {
+				p = (2*r.Float64() - 1) * math.Pi
+			}
+			c[i][k] = cmplx.Rect(mag, p)
+		}
+	}
+	return c
+}
+
+// gaussianLambda returns the lambda of the Gaussian window exp(-pi*t^2/lambda), with t in samples,
+// that has the same spread as the given window. For a Gaussian, the variance of the squared
+// window is lambda/(4*pi), and this gives values close to the ones that are usually given for
+// the Hann, Hamming and Blackman windows.
+func gaussianLambda(w []float64) float64 {
+	centre := float64(len(w)-1) / 2
+	var moment, energy float64
+	for i, v := range w {
+		t := float64(i) - centre
+		moment += t * t * v * v
+		energy += v * v
+	}
+	if energy == 0 || moment == 0 {
+		return 1
+	}
+	return 4 * math.Pi * moment / energy
+}
+
+// centralDifference returns the derivative of the values at the given index, per index
+func centralDifference(values []float64, i int) float64 {
+	switch {
+	case len(values) < 2:
+		return 0
+	case i == 0:
+		return values[1] - values[0]
+	case i == len(values)-1:
+		return values[i] - values[i-1]
+	}
+	return (values[i+1] - values[i-1]) / 2
+}
+
+// tfBin is a bin of a spectrogram, with its level, like the magnitude or the log-magnitude
+type tfBin struct {
+	column, bin int
+	level       float64
+}
+
+// tfHeap is a max-heap of bins, by level
+type tfHeap []tfBin
+
+func (h tfHeap) Len() int           { return len(h) }
+func (h tfHeap) Less(i, j int) bool { return h[i].level > h[j].level }
+func (h tfHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *tfHeap) Push(x any)        { *h = append(*h, x.(tfBin)) }
+
+func (h *tfHeap) Pop() any {
+	old := *h
+	b := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return b
+}
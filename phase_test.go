@@ -0,0 +1,77 @@
+package wavecarve
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestRetrievePhaseOfNarrowSpectrograms(t *testing.T) {
+	// Carving 1000 samples down to one column gives fewer columns than frames that overlap each sample
+	audio := NewAudio(1, 1000, 16000)
+	copy(audio.Channels[0], testAudio(1).Channels[0])
+	spectrograms, err := audio.Spectrograms(SpectrogramOptions{FFTSize: 1024, HopSize: 256, Window: WindowHann})
+	if err != nil {
+		t.Fatal(err)
+	}
+	carved, err := CarveSpectrograms(spectrograms, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns := carved[0].Columns(); columns != 1 {
+		t.Fatalf("carved to %d columns, want 1", columns)
+	}
+	for _, method := range []PhaseMethod{PhaseGriffinLim, PhaseFastGriffinLim, PhasePGHI} {
+		options := DefaultPhaseOptions
+		options.Method = method
+		retrieved, err := carved[0].RetrievePhase(options)
+		if err != nil {
+			t.Errorf("%s: %v", method, err)
+			continue
+		}
+		if len(retrieved.Samples()) != carved[0].Length {
+			t.Errorf("%s: got %d samples, want %d", method, len(retrieved.Samples()), carved[0].Length)
+		}
+	}
+}
+
+func TestCreateAudioFromSpectrogramsWithPhase(t *testing.T) {
+	audio := testAudio(2)
+	imgs, err := audio.CreateSpectrograms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recreated, err := CreateAudioFromSpectrogramsWithPhase(imgs, audio.SampleRate, PhaseOptions{Method: PhaseKeep})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recreated.NumChannels() != 2 || recreated.Frames() < audio.Frames() {
+		t.Errorf("got %d channels of %d samples from %d samples", recreated.NumChannels(), recreated.Frames(), audio.Frames())
+	}
+
+	// Any phase is consistent with the magnitudes of frames that do not overlap, like the ones of
+	// DefaultSpectrogramOptions, so Griffin-Lim keeps the zero phase that it starts from
+	options := DefaultPhaseOptions
+	options.Method, options.Initial = PhaseGriffinLim, InitialPhaseZero
+	retrieved, err := CreateAudioFromSpectrogramsWithPhase(imgs, audio.SampleRate, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if difference := maxDifference(recreated, retrieved); difference < 0.1 {
+		t.Errorf("the given phase options were not used, the largest difference is %g", difference)
+	}
+	// Zero phase gives peaks that clip when the samples are quantized
+	int16s, err := CreateAudioFromSpectrogramWithPhase(imgs[0], options)
+	if err != nil && !errors.As(err, new(ErrClipped)) {
+		t.Fatal(err)
+	}
+	if len(int16s) != len(retrieved.Channels[0]) {
+		t.Fatalf("got %d samples for one channel, want %d", len(int16s), len(retrieved.Channels[0]))
+	}
+	for i, sample := range int16s {
+		want := math.Max(-1, math.Min(retrieved.Channels[0][i], 32767.0/32768))
+		if math.Abs(float64(sample)/32768-want) > 1.0/32768 {
+			t.Fatalf("sample %d is %d for one channel, want %g", i, sample, want*32768)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package wavecarve
+
+import (
+	"container/heap"
+	"image"
+	"image/color"
+	"math"
+	"math/cmplx"
+)
+
+// princarg wraps a phase to the range [-pi, pi]
+func princarg(phase float64) float64 {
+	return phase - 2*math.Pi*math.Round(phase/(2*math.Pi))
+}
+
+// phaseDerivatives returns the derivatives of the phase of the bins over time and frequency.
+// The time derivative is how much the phase advance from the previous column deviates from
+// the advance of the centre frequency of the bin over one hop, which is the instantaneous
+// frequency deviation. The column before the first one has a phase of zero. The frequency
+// derivative is how much the phase difference from the bin below deviates from the one of an
+// impulse at the centre of the frame, which is the local group delay. Both are wrapped to [-pi, pi].
+// Unlike the phase itself, the derivatives stay meaningful when columns are removed or repeated.
+func phaseDerivatives(bins [][]complex128, options SpectrogramOptions) (timeDeriv, freqDeriv [][]float64) {
+	hop, m := float64(options.hopSize()), float64(options.TransformSize())
+	centre := float64(options.FFTSize-1) / 2
+	timeDeriv = make([][]float64, len(bins))
+	freqDeriv = make([][]float64, len(bins))
+	for i, column := range bins {
+		timeDeriv[i] = make([]float64, len(column))
+		freqDeriv[i] = make([]float64, len(column))
+		for k, val := range column {
+			phase := cmplx.Phase(val)
+			previous := 0.0
+			if i > 0 {
+				previous = cmplx.Phase(bins[i-1][k])
+			}
+			timeDeriv[i][k] = princarg(phase - previous - 2*math.Pi*float64(k)*hop/m)
+			if k > 0 {
+				freqDeriv[i][k] = princarg(phase - cmplx.Phase(column[k-1]) + 2*math.Pi*centre/m)
+			}
+		}
+	}
+	return timeDeriv, freqDeriv
+}
+
+// integratePhase combines the magnitudes with a phase that is integrated from the derivatives that
+// are returned by phaseDerivatives. Without frequency derivatives, the phase of each bin is integrated
+// over time on its own, like in a phase vocoder. With them, the phase is integrated from the first
+// column and from the loudest bins outwards, over time or frequency, which keeps the bins of each
+// partial coherent. For the derivatives of an unmodified spectrogram, both give back its phase.
+func integratePhase(mags, timeDeriv, freqDeriv [][]float64, options SpectrogramOptions) [][]complex128 {
+	hop, m := float64(options.hopSize()), float64(options.TransformSize())
+	centre := float64(options.FFTSize-1) / 2
+	advance := func(k int) float64 {
+		return 2 * math.Pi * float64(k) * hop / m
+	}
+	phase := make([][]float64, len(mags))
+	for i, column := range mags {
+		phase[i] = make([]float64, len(column))
+	}
+	if len(mags) > 0 {
+		for k := range phase[0] {
+			phase[0][k] = advance(k) + timeDeriv[0][k]
+		}
+	}
+	if freqDeriv == nil {
+		for i := 1; i < len(phase); i++ {
+			for k := range phase[i] {
+				phase[i][k] = phase[i-1][k] + advance(k) + timeDeriv[i][k]
+			}
+		}
+	} else if len(mags) > 0 {
+		done := make([][]bool, len(mags))
+		for i, column := range mags {
+			done[i] = make([]bool, len(column))
+		}
+		h := &tfHeap{}
+		for k, mag := range mags[0] {
+			done[0][k] = true
+			heap.Push(h, tfBin{0, k, mag})
+		}
+		for h.Len() > 0 {
+			b := heap.Pop(h).(tfBin)
+			i, k := b.column, b.bin
+			integrate := func(j, l int, p float64) {
+				if j < 0 || j >= len(mags) || l < 0 || l >= len(mags[j]) || done[j][l] {
+					return
+				}
+				phase[j][l] = p
+				done[j][l] = true
+				heap.Push(h, tfBin{j, l, mags[j][l]})
+			}
+			if i+1 < len(mags) {
+				integrate(i+1, k, phase[i][k]+advance(k)+timeDeriv[i+1][k])
+			}
+			if i > 0 {
+				integrate(i-1, k, phase[i][k]-advance(k)-timeDeriv[i][k])
+			}
+			if k+1 < len(mags[i]) {
+				integrate(i, k+1, phase[i][k]-2*math.Pi*centre/m+freqDeriv[i][k+1])
+			}
+			if k > 0 {
+				integrate(i, k-1, phase[i][k]+2*math.Pi*centre/m-freqDeriv[i][k])
+			}
+		}
+	}
+	bins := make([][]complex128, len(mags))
+	for i, column := range mags {
+		bins[i] = make([]complex128, len(column))
+		for k, mag := range column {
+			bins[i][k] = cmplx.Rect(mag, phase[i][k])
+		}
+	}
+	return bins
+}
+
+// toPhaseDerivativeRGBA encodes the spectrogram with EncodingIF8, or with EncodingIFGD8 if groupDelay is true
+func (s *Spectrogram) toPhaseDerivativeRGBA(mapping MagnitudeMapping, groupDelay bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, s.Columns(), s.Options.Bins()))
+	gain := windowGain(s.Options.Window.Coefficients(s.Options.FFTSize, s.Options.WindowParameter))
+	timeDeriv, freqDeriv := phaseDerivatives(s.Bins, s.Options)
+	for i, fftFrame := range s.Bins {
+		volume := mapping.toByte(maxMagnitude(fftFrame) / gain)
+		for j, val := range fftFrame {
+			b := volume
+			if groupDelay {
+				b = phaseToByte(freqDeriv[i][j])
+			}
+			img.SetRGBA(i, j, color.RGBA{mapping.toByte(cmplx.Abs(val) / gain), phaseToByte(timeDeriv[i][j]), b, 255})
+		}
+	}
+	return img
+}
+
+// toPhaseDerivativeRGBA64 encodes the spectrogram with EncodingIF16, or with EncodingIFGD16 if groupDelay is true
+func (s *Spectrogram) toPhaseDerivativeRGBA64(mapping MagnitudeMapping, groupDelay bool) *image.RGBA64 {
+	img := image.NewRGBA64(image.Rect(0, 0, s.Columns(), s.Options.Bins()))
+	gain := windowGain(s.Options.Window.Coefficients(s.Options.FFTSize, s.Options.WindowParameter))
+	timeDeriv, freqDeriv := phaseDerivatives(s.Bins, s.Options)
+	for i, fftFrame := range s.Bins {
+		volume := mapping.toUint16(maxMagnitude(fftFrame) / gain)
+		for j, val := range fftFrame {
+			b := volume
+			if groupDelay {
+				b = phaseToUint16(freqDeriv[i][j])
+			}
+			img.SetRGBA64(i, j, color.RGBA64{mapping.toUint16(cmplx.Abs(val) / gain), phaseToUint16(timeDeriv[i][j]), b, 0xffff})
+		}
+	}
+	return img
+}
+
+// fromPhaseDerivativeImage decodes the bins of an image with one of the phase derivative encodings,
+// with 16 bits per channel if wide is true, and integrates the phase from the derivatives
+func fromPhaseDerivativeImage(img image.Image, wide, groupDelay bool, options SpectrogramOptions, mapping MagnitudeMapping) [][]complex128 {
+	bounds := img.Bounds()
+	gain := windowGain(options.Window.Coefficients(options.FFTSize, options.WindowParameter))
+	mags := make([][]float64, bounds.Dx())
+	timeDeriv := make([][]float64, bounds.Dx())
+	var freqDeriv [][]float64
+	if groupDelay {
+		freqDeriv = make([][]float64, bounds.Dx())
+	}
+	for x := range mags {
+		mags[x] = make([]float64, bounds.Dy())
+		timeDeriv[x] = make([]float64, bounds.Dy())
+		if groupDelay {
+			freqDeriv[x] = make([]float64, bounds.Dy())
+		}
+		for y := range mags[x] {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			if wide {
+				mags[x][y] = mapping.fromUint16(uint16(r)) * gain
+				timeDeriv[x][y] = uint16ToPhase(uint16(g))
+				if groupDelay {
+					freqDeriv[x][y] = uint16ToPhase(uint16(b))
+				}
+			} else {
+				mags[x][y] = mapping.fromByte(uint8(r>>8)) * gain
+				timeDeriv[x][y] = byteToPhase(uint8(g >> 8))
+				if groupDelay {
+					freqDeriv[x][y] = byteToPhase(uint8(b >> 8))
+				}
+			}
+		}
+	}
+	return integratePhase(mags, timeDeriv, freqDeriv, options)
+}
+
+// maxMagnitude returns the magnitude of the loudest bin of a column, which shows the volume
+func maxMagnitude(column []complex128) float64 {
+	maxMag := 0.0
+	for _, val := range column {
+		if mag := cmplx.Abs(val); mag > maxMag {
+			maxMag = mag
+		}
+	}
+	return maxMag
+}
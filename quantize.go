@@ -0,0 +1,180 @@
+package wavecarve
+
+import (
+	"math"
+	"math/rand"
+)
+
+// ClipMode selects what happens to samples outside of the range [-1, 1] when they are converted to integers
+type ClipMode int
+
+const (
+	// ClipHard clamps the samples to the largest and smallest integer values
+	ClipHard ClipMode = iota
+
+	// ClipSoft compresses the samples above softClipThreshold smoothly towards full scale,
+	// which sounds less harsh than hard clipping when resynthesised audio overshoots
+	ClipSoft
+)
+
+// DitherMode selects the noise that is added before samples are rounded to integers
+type DitherMode int
+
+const (
+	// DitherNone rounds the samples without adding noise, so that samples that already
+	// fit the integer grid (like audio that was read from a 16-bit file) are kept exactly
+	DitherNone DitherMode = iota
+
+	// DitherTPDF adds triangular noise of +/- 1 LSB, which turns the truncation
+	// distortion of quiet material into a constant low noise floor
+	DitherTPDF
+)
+
+// NoiseShaping selects the filter that shapes the spectrum of the quantization noise
+type NoiseShaping int
+
+const (
+	// NoiseShapingNone leaves the quantization noise white
+	NoiseShapingNone NoiseShaping = iota
+
+	// NoiseShapingFirstOrder moves the noise towards high frequencies, with the noise transfer function 1 - z^-1
+	NoiseShapingFirstOrder
+
+	// NoiseShapingSecondOrder moves the noise further towards high frequencies, with (1 - z^-1)^2
+	NoiseShapingSecondOrder
+
+	// NoiseShapingLipshitz is the 5-tap E-weighted filter by Lipshitz et al., which puts the
+	// noise where the ear is the least sensitive. It is designed for 44.1 kHz audio.
+	NoiseShapingLipshitz
+)
+
+// Error feedback coefficients for each noise shaping filter
+var noiseShapingFilters = [...][]float64{
+	NoiseShapingNone:        nil,
+	NoiseShapingFirstOrder:  {1},
+	NoiseShapingSecondOrder: {2, -1},
+	NoiseShapingLipshitz:    {2.033, -2.165, 1.959, -1.590, 0.6149},
+}
+
+// softClipThreshold is where ClipSoft starts to compress the samples
+const softClipThreshold = 0.9
+
+// QuantizeOptions configures how float64 samples are converted to integers
+type QuantizeOptions struct {
+	Clipping     ClipMode
+	Dither       DitherMode
+	NoiseShaping NoiseShaping
+	Seed         int64 // the seed for the dither noise, so that the output can be reproduced
+}
+
+// DefaultQuantizeOptions are used by all functions that convert float64 samples to integer samples,
+// like WriteWavFileFloat64, WriteFlacFile, WriteAiffFile, CreateAudioFromSpectrogram and ReadWavFile
+// (when the file is not 16-bit). The defaults clamp the samples and do not add dither, so that
+// integer audio that is converted to float64 and back is not changed.
+var DefaultQuantizeOptions = QuantizeOptions{Clipping: ClipHard, Dither: DitherNone, NoiseShaping: NoiseShapingNone}
+
+// Quantizer converts interleaved float64 samples in the range [-1, 1] to integers of a given bit depth,
+// with clipping, dither and noise shaping. It keeps the noise shaping state of each channel between
+// calls, so a stream of samples can be converted in blocks. It also counts the samples that clipped.
+type Quantizer struct {
+	options     QuantizeOptions
+	scale       float64 // 2^(bits-1)
+	numChannels int
+	channel     int         // the channel of the next sample
+	errors      [][]float64 // the most recent quantization errors, per channel
+	filter      []float64
+	rng         *rand.Rand
+	clipped     int
+}
+
+// NewQuantizer returns a Quantizer for samples with the given number of bits (1 to 32) and interleaved channels
+func NewQuantizer(bits, numChannels int, options QuantizeOptions) *Quantizer {
+	if bits < 1 {
+		bits = 1
+	} else if bits > 32 {
+		bits = 32
+	}
+	if numChannels < 1 {
+		numChannels = 1
+	}
+	q := &Quantizer{
+		options:     options,
+		scale:       float64(int64(1) << (bits - 1)),
+		numChannels: numChannels,
+		rng:         rand.New(rand.NewSource(options.Seed)),
+	}
+	if options.NoiseShaping > NoiseShapingNone && int(options.NoiseShaping) < len(noiseShapingFilters) {
+		q.filter = noiseShapingFilters[options.NoiseShaping]
+		q.errors = make([][]float64, numChannels)
+		for c := range q.errors {
+			q.errors[c] = make([]float64, len(q.filter))
+		}
+	}
+	return q
+}
+
+// Quantize converts the next sample to an integer in the range [-2^(bits-1), 2^(bits-1) - 1].
+// The samples of all channels are expected to be interleaved.
+func (q *Quantizer) Quantize(sample float64) int64 {
+	channel := q.channel
+	q.channel = (q.channel + 1) % q.numChannels
+
+	// Clip the samples that are out of range
+	if sample > 1 || sample < -1 || math.IsNaN(sample) {
+		q.clipped++
+	}
+	switch {
+	case math.IsNaN(sample):
+		sample = 0
+	case q.options.Clipping == ClipSoft && math.Abs(sample) > softClipThreshold:
+		// Compress the part above the threshold with tanh, which approaches full scale without reaching it
+		const knee = 1 - softClipThreshold
+		sample = math.Copysign(softClipThreshold+knee*math.Tanh((math.Abs(sample)-softClipThreshold)/knee), sample)
+	case sample > 1:
+		sample = 1
+	case sample < -1:
+		sample = -1
+	}
+	v := sample * q.scale
+
+	// Subtract the filtered quantization errors of the previous samples
+	var history []float64
+	if q.filter != nil {
+		history = q.errors[channel]
+		for i, coef := range q.filter {
+			v -= coef * history[i]
+		}
+	}
+
+	// Add dither and round
+	quantized := v
+	if q.options.Dither == DitherTPDF {
+		quantized += q.rng.Float64() - q.rng.Float64()
+	}
+	quantized = math.Round(quantized)
+
+	// The error is taken before the final clamp, so that clamping can not make the noise shaping unstable
+	if history != nil {
+		copy(history[1:], history)
+		history[0] = quantized - v
+	}
+	if quantized > q.scale-1 {
+		quantized = q.scale - 1
+	} else if quantized < -q.scale {
+		quantized = -q.scale
+	}
+	return int64(quantized)
+}
+
+// Clipped returns the number of samples that were outside of the range [-1, 1] so far
+func (q *Quantizer) Clipped() int {
+	return q.clipped
+}
+
+// err returns an ErrClipped error if any samples clipped, or nil
+func (q *Quantizer) err() error {
+	if q.clipped > 0 {
+		return ErrClipped{Samples: q.clipped}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package wavecarve
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestQuantizeClipping(t *testing.T) {
+	samples := []float64{-1.5, -1.2, -1, 0, 0.5, 0.9, 0.95, 1, 1.5, math.NaN()}
+	tests := []struct {
+		clipping ClipMode
+		want     []int64
+	}{
+		{ClipHard, []int64{-32768, -32768, -32768, 0, 16384, 29491, 31130, 32767, 32767, 0}},
+		{ClipSoft, []int64{-32768, -32752, -31987, 0, 16384, 29491, 31005, 31987, 32767, 0}},
+	}
+	for _, tt := range tests {
+		q := NewQuantizer(16, 1, QuantizeOptions{Clipping: tt.clipping})
+		for i, sample := range samples {
+			if got := q.Quantize(sample); got != tt.want[i] {
+				t.Errorf("clipping mode %d: %v is quantized to %d, want %d", tt.clipping, sample, got, tt.want[i])
+			}
+		}
+		// -1.5, -1.2, 1.5 and NaN are out of range
+		if q.Clipped() != 4 || q.err() != (ErrClipped{Samples: 4}) {
+			t.Errorf("clipping mode %d: %d samples clipped, with the error %v, want 4", tt.clipping, q.Clipped(), q.err())
+		}
+	}
+	if err := NewQuantizer(16, 1, DefaultQuantizeOptions).err(); err != nil {
+		t.Errorf("got the error %v when no samples clipped", err)
+	}
+
+	// The samples that clipped are reported after the file has been written
+	filePath := filepath.Join(t.TempDir(), "test.wav")
+	err := WriteWavFileFloat64(filePath, [][]float64{{0, 1.5, -2, 0.5}, {0, 0, 1.01, 0}}, NewWAVHeader(WaveFormatPCM, 2, 16000, 16))
+	var clipped ErrClipped
+	if !errors.As(err, &clipped) || clipped.Samples != 3 {
+		t.Errorf("got the error %v, want 3 clipped samples", err)
+	}
+	channels, _, err := ReadWavFileFloat64(filePath)
+	if err != nil || len(channels) != 2 || len(channels[0]) != 4 {
+		t.Errorf("the file was not written when samples clipped: %v", err)
+	}
+}
+
+func TestQuantizeDither(t *testing.T) {
+	const n = 10000
+	sample := func(i int) float64 {
+		return 0.3 * math.Sin(float64(i)*0.01)
+	}
+	quantize := func(options QuantizeOptions) []int64 {
+		q := NewQuantizer(16, 2, options)
+		quantized := make([]int64, n)
+		for i := range quantized {
+			quantized[i] = q.Quantize(sample(i))
+		}
+		return quantized
+	}
+
+	// TPDF dither adds noise of less than 1 LSB on each side before the samples are rounded,
+	// and the same seed gives the same noise
+	dithered := quantize(QuantizeOptions{Dither: DitherTPDF, Seed: 1})
+	mean := 0.0
+	for i, v := range dithered {
+		difference := float64(v) - sample(i)*32768
+		if math.Abs(difference) > 1.5 {
+			t.Fatalf("sample %d is %d, which is %g LSB from %g", i, v, difference, sample(i)*32768)
+		}
+		mean += difference / n
+	}
+	if math.Abs(mean) > 0.05 {
+		t.Errorf("the mean of the dither noise is %g LSB", mean)
+	}
+	same, other := quantize(QuantizeOptions{Dither: DitherTPDF, Seed: 1}), quantize(QuantizeOptions{Dither: DitherTPDF, Seed: 2})
+	differences := 0
+	for i := range dithered {
+		if same[i] != dithered[i] {
+			t.Fatalf("sample %d is %d with the same seed, want %d", i, same[i], dithered[i])
+		}
+		if other[i] != dithered[i] {
+			differences++
+		}
+	}
+	if differences == 0 {
+		t.Error("a different seed gave the same dither noise")
+	}
+
+	// Without dither, samples that are on the integer grid are kept exactly
+	q := NewQuantizer(16, 1, DefaultQuantizeOptions)
+	for _, v := range []int64{-32768, -12345, -1, 0, 1, 12345, 32767} {
+		if got := q.Quantize(float64(v) / 32768); got != v {
+			t.Errorf("%d is quantized to %d without dither", v, got)
+		}
+	}
+}
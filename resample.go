@@ -0,0 +1,169 @@
+package wavecarve
+
+import (
+	"math"
+)
+
+// ResampleQuality selects the length and steepness of the filter that is used by Resample
+type ResampleQuality int
+
+// Quality presets for Resample. Higher quality gives a steeper filter with less aliasing, but takes longer.
+const (
+	ResampleFast ResampleQuality = iota
+	ResampleMedium
+	ResampleHigh
+)
+
+// Filter settings for each quality preset: the number of zero crossings of the sinc function
+// on each side, the cutoff as a fraction of the Nyquist frequency, and the beta of the Kaiser window
+var resampleSettings = [...]struct {
+	zeroCrossings int
+	rolloff       float64
+	beta          float64
+}{
+	ResampleFast:   {8, 0.85, 5},
+	ResampleMedium: {16, 0.9, 7},
+	ResampleHigh:   {32, 0.95, 9.5},
+}
+
+// The largest number of filter phases that are computed once and then reused
+const maxCachedResamplePhases = 4096
+
+// resampler converts between two sample rates with a band-limited windowed-sinc filter,
+// arranged as a polyphase filter bank with one set of coefficients per output phase
+type resampler struct {
+	up, down   uint64 // the ratio between the sample rates, reduced
+	cutoff     float64
+	halfLength int // the number of filter taps on each side
+	beta       float64
+	phases     [][]float64
+}
+
+func newResampler(fromRate, toRate uint32, quality ResampleQuality) *resampler {
+	if quality < ResampleFast {
+		quality = ResampleFast
+	} else if quality > ResampleHigh {
+		quality = ResampleHigh
+	}
+	settings := resampleSettings[quality]
+
+	// Reduce the ratio between the sample rates
+	a, b := uint64(toRate), uint64(fromRate)
+	for b != 0 {
+		a, b = b, a%b
+	}
+	r := &resampler{up: uint64(toRate) / a, down: uint64(fromRate) / a, beta: settings.beta}
+
+	// When downsampling, the cutoff is lowered to the Nyquist frequency of the new sample rate
+	r.cutoff = settings.rolloff * math.Min(1, float64(toRate)/float64(fromRate))
+	r.halfLength = int(math.Ceil(float64(settings.zeroCrossings) / r.cutoff))
+	if r.up <= maxCachedResamplePhases {
+		r.phases = make([][]float64, r.up)
+	}
+	return r
+}
+
+// coefficients returns the filter taps for an output sample that falls phase/up input samples after an input sample
+func (r *resampler) coefficients(phase uint64) []float64 {
+	if r.phases != nil && r.phases[phase] != nil {
+		return r.phases[phase]
+	}
+	frac := float64(phase) / float64(r.up)
+	coefs := make([]float64, 2*r.halfLength)
+	for i := range coefs {
+		t := float64(i-r.halfLength+1) - frac
+		coefs[i] = r.cutoff * sinc(r.cutoff*t) * kaiser(t/float64(r.halfLength), r.beta)
+	}
+	if r.phases != nil {
+		r.phases[phase] = coefs
+	}
+	return coefs
+}
+
+// resample resamples one channel
+func (r *resampler) resample(samples []float64) []float64 {
+	out := make([]float64, (uint64(len(samples))*r.up+r.down-1)/r.down)
+	for n := range out {
+		// The position of the output sample, in units of 1/up input samples
+		pos := uint64(n) * r.down
+		i, phase := int(pos/r.up), pos%r.up
+		first := i - r.halfLength + 1
+		var sum float64
+		for k, coef := range r.coefficients(phase) {
+			if j := first + k; j >= 0 && j < len(samples) {
+				sum += samples[j] * coef
+			}
+		}
+		out[n] = sum
+	}
+	return out
+}
+
+// sinc is the normalized sinc function, sin(pi x) / (pi x)
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// kaiser returns the Kaiser window with the given beta at x, which is in the range [-1, 1]
+func kaiser(x, beta float64) float64 {
+	if x < -1 || x > 1 {
+		return 0
+	}
+	return besselI0(beta*math.Sqrt(1-x*x)) / besselI0(beta)
+}
+
+// besselI0 is the zeroth order modified Bessel function of the first kind
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; k < 100; k++ {
+		term *= (x / 2) * (x / 2) / float64(k*k)
+		sum += term
+		if term < sum*1e-16 {
+			break
+		}
+	}
+	return sum
+}
+
+// Resample converts samples in the range [-1, 1] from one sample rate to another,
+// using a band-limited windowed-sinc filter. The result has len(float64s) * toRate / fromRate
+// samples, rounded up. If the sample rates are the same, a copy of the samples is returned.
+func Resample(float64s []float64, fromRate, toRate uint32, quality ResampleQuality) []float64 {
+	if fromRate == toRate || fromRate == 0 || toRate == 0 {
+		return append([]float64{}, float64s...)
+	}
+	return newResampler(fromRate, toRate, quality).resample(float64s)
+}
+
+// ResampleChannels resamples one slice of samples per channel, like Resample
+func ResampleChannels(channels [][]float64, fromRate, toRate uint32, quality ResampleQuality) [][]float64 {
+	resampled := make([][]float64, len(channels))
+	if fromRate == toRate || fromRate == 0 || toRate == 0 {
+		for c, channel := range channels {
+			resampled[c] = append([]float64{}, channel...)
+		}
+		return resampled
+	}
+	r := newResampler(fromRate, toRate, quality)
+	for c, channel := range channels {
+		resampled[c] = r.resample(channel)
+	}
+	return resampled
+}
+
+// ResampleInt16Channels resamples one slice of 16-bit samples per channel, like Resample.
+// The samples are quantized with DefaultQuantizeOptions, and if any samples overshoot the
+// 16-bit range after filtering, an ErrClipped error is returned together with the samples.
+func ResampleInt16Channels(channels [][]int16, fromRate, toRate uint32, quality ResampleQuality) ([][]int16, error) {
+	return float64ChannelsToInt16s(ResampleChannels(int16ChannelsToFloat64s(channels), fromRate, toRate, quality))
+}
+
+// WithSampleRate returns a copy of the header with the given sample rate, and the byte rate that follows from it
+func (h WAVHeader) WithSampleRate(sampleRate uint32) WAVHeader {
+	h.SampleRate = sampleRate
+	h.ByteRate = sampleRate * uint32(h.BlockAlign)
+	return h
+}
@@ -0,0 +1,61 @@
+package wavecarve
+
+import (
+	"math"
+	"testing"
+)
+
+func TestResample(t *testing.T) {
+	tests := []struct {
+		fromRate, toRate uint32
+		quality          ResampleQuality
+		tolerance        float64
+	}{
+		{44100, 48000, ResampleFast, 1e-2},
+		{44100, 48000, ResampleHigh, 1e-3},
+		{48000, 44100, ResampleMedium, 1e-2},
+		{16000, 8000, ResampleHigh, 1e-3},
+		{8000, 22050, ResampleMedium, 1e-2},
+		{22050, 22050, ResampleFast, 0},
+	}
+	const frequency, amplitude = 1000, 0.8
+	for _, tt := range tests {
+		samples := make([]float64, int(tt.fromRate)/10+7)
+		for i := range samples {
+			samples[i] = amplitude * math.Sin(2*math.Pi*frequency*float64(i)/float64(tt.fromRate))
+		}
+		resampled := Resample(samples, tt.fromRate, tt.toRate, tt.quality)
+		wantLength := int(math.Ceil(float64(len(samples)) * float64(tt.toRate) / float64(tt.fromRate)))
+		if len(resampled) != wantLength {
+			t.Errorf("%d to %d Hz: got %d samples from %d, want %d", tt.fromRate, tt.toRate, len(resampled), len(samples), wantLength)
+			continue
+		}
+
+		// The sine keeps its frequency, phase and amplitude, except near the ends, where the filter runs past the samples
+		edge := len(resampled) / 10
+		difference := 0.0
+		for n := edge; n < len(resampled)-edge; n++ {
+			want := amplitude * math.Sin(2*math.Pi*frequency*float64(n)/float64(tt.toRate))
+			difference = math.Max(difference, math.Abs(resampled[n]-want))
+		}
+		if difference > tt.tolerance {
+			t.Errorf("%d to %d Hz with quality %d: the largest difference from the sine is %g", tt.fromRate, tt.toRate, tt.quality, difference)
+		}
+	}
+}
+
+func TestResampleAboveNyquist(t *testing.T) {
+	// A sine above the Nyquist frequency of the new sample rate is filtered out instead of aliasing
+	const fromRate, toRate = 48000, 16000
+	samples := make([]float64, fromRate/10)
+	for i := range samples {
+		samples[i] = math.Sin(2 * math.Pi * 12000 * float64(i) / fromRate)
+	}
+	resampled := Resample(samples, fromRate, toRate, ResampleHigh)
+	edge := len(resampled) / 10
+	for n := edge; n < len(resampled)-edge; n++ {
+		if math.Abs(resampled[n]) > 1e-3 {
+			t.Fatalf("sample %d is %g, want silence", n, resampled[n])
+		}
+	}
+}
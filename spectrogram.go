@@ -1,148 +1,277 @@
 package wavecarve
 
 import (
+	"errors"
+	"fmt"
 	"image"
-	"image/color"
+	"image/draw"
 	"math"
 	"math/cmplx"
-
-	"github.com/mjibson/go-dsp/fft"
 )
 
-// CreateSpectrogramFromAudio creates a spectrogram from an []int16 and
-// encodes the length of the audio data into the image.
-func CreateSpectrogramFromAudio(int16s []int16) (*image.RGBA, error) {
-	// Convert the int16s to float64s
-	float64s := int16sToFloat64s(int16s)
-
-	// Create a new image with the width of the number of samples and height of FFTSize
-	img := image.NewRGBA(image.Rect(0, 0, len(float64s)/FFTSize, FFTSize))
-
-	// Iterate over the audio data
-	for i := 0; i < len(float64s)/FFTSize; i++ {
-		// Get the audio frame
-		frame := float64s[i*FFTSize : (i+1)*FFTSize]
-
-		// Compute the FFT of the frame
-		fftFrame := fft.FFTReal(frame)
-		//if i == 0 {
-		//fmt.Printf("First FFT frame: %v\n", fftFrame[:10])
-		//}
-
-		// Find the maximum absolute sample in the frame
-		maxSample := 0.0
-		for _, val := range frame {
-			absVal := math.Abs(val)
-			if absVal > maxSample {
-				maxSample = absVal
-			}
-		}
-		// Normalize it to the range of 0-255
-		volume := maxSample * 255
-
-		// Set the pixels in the image
-		for j, val := range fftFrame {
-			// Compute the magnitude of the FFT value (log scale)
-			mag := 20 * math.Log10(cmplx.Abs(val))
-			// Normalize the magnitude to the range of 0-255
-			mag = (mag + 140) * 255 / 140
-
-			//fmt.Printf("Computed pixel color (r) from magnitude: %f\n", mag)
-
-			// Cap the values at 0 and 255
-			if mag < 0 {
-				mag = 0
-			} else if mag > 255 {
-				mag = 255
-			}
-
-			// Compute the phase of the FFT value and normalize it
-			phase := cmplx.Phase(val)
-			// Map phase from [-pi, pi] to [0, 255]
-			phase = (phase + math.Pi) * 255 / (2 * math.Pi)
-
-			//fmt.Printf("Computed pixel color (g) from phase: %f\n", phase)
-
-			// Set the pixel in the image, red for magnitude, green for phase, blue for volume
-			img.Set(i, j, color.RGBA{uint8(mag), uint8(phase), uint8(volume), 255})
-		}
+// BinFrequency returns the frequency, in Hz, of the given row of a spectrogram that was created
+// with an FFT of size fftSize (the TransformSize of the SpectrogramOptions), from audio with the
+// given sample rate. Bins above fftSize/2 mirror the bins below them, and have negative frequencies.
+func BinFrequency(bin, fftSize int, sampleRate uint32) float64 {
+	if bin > fftSize/2 {
+		bin -= fftSize
 	}
-
-	// Encode length of audio data into first pixel's RGB values
-	length := len(int16s)
-	img.Set(0, 0, color.RGBA{uint8(length >> 16), uint8(length >> 8), uint8(length), 255})
-
-	return img, nil
+	return float64(bin) * float64(sampleRate) / float64(fftSize)
 }
 
-// CreateAudioFromSpectrogram creates audio from a spectrogram and
-// extracts the length of the audio data from the image.
-func CreateAudioFromSpectrogram(img *image.RGBA) ([]int16, error) {
-	// Get the bounds of the image
-	bounds := img.Bounds()
+// ColumnTime returns the time, in seconds, where the given column of a spectrogram starts,
+// when the columns are hopSize samples apart and the audio has the given sample rate.
+// For the spectrograms created by CreateSpectrogramFromAudio, hopSize is the HopSize of DefaultSpectrogramOptions.
+func ColumnTime(column, hopSize int, sampleRate uint32) float64 {
+	return float64(column) * float64(hopSize) / float64(sampleRate)
+}
 
-	// Extract length of audio data from first pixel's RGB values
-	r, g, b, _ := img.At(0, 0).RGBA()
-	length := int(r)<<16 | int(g)<<8 | int(b)
+// Spectrogram is the short-time Fourier transform of one channel of audio, with the full precision
+// of the FFT. It can be converted to an image with ToImage, edited or carved, and converted back
+// to audio with Samples.
+type Spectrogram struct {
+	// Bins holds one slice of Options.Bins() frequency bins per column, from 0 Hz up to the
+	// Nyquist frequency. The bins are not scaled, so they are the FFT of the windowed frames.
+	Bins [][]complex128
 
-	// Create a slice to hold the audio data
-	float64s := make([]float64, length)
+	// Options are the STFT parameters that the spectrogram was created with
+	Options SpectrogramOptions
 
-	// Iterate over the pixels in the image
-	for x := 0; x < bounds.Dx(); x++ {
-		// Create a slice to hold the FFT frame
-		iframe := make([]float64, FFTSize)
+	// SampleRate is the sample rate of the audio, in Hz
+	SampleRate uint32
 
-		// Extract the volume from the blue value of the first pixel in the column
-		_, _, volume, _ := img.At(x, 0).RGBA()
-		// Shift right by 8 bits
-		volume = volume >> 8
-		// Convert to float64
-		fvolume := float64(volume) / 255
+	// Length is the number of samples of audio that the spectrogram represents
+	Length int
+}
 
-		for y := 0; y < FFTSize; y++ {
-			// Get the pixel color
-			r, g, _, _ := img.At(x, y).RGBA()
+// NewSpectrogram returns the spectrogram of samples in the range [-1, 1], with the given sample rate and STFT options
+func NewSpectrogram(float64s []float64, sampleRate uint32, options SpectrogramOptions) (*Spectrogram, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	return &Spectrogram{
+		Bins:       stft(float64s, options),
+		Options:    options,
+		SampleRate: sampleRate,
+		Length:     len(float64s),
+	}, nil
+}
 
-			// Shift right by 8 bits
-			r = r >> 8
-			g = g >> 8
+// Columns returns the number of columns (frames) of the spectrogram
+func (s *Spectrogram) Columns() int {
+	return len(s.Bins)
+}
 
-			// Compute the magnitude from the red value
-			mag := float64(r)*140.0/255.0 - 140.0
-			// Convert magnitude from dB to linear
-			mag = math.Pow(10.0, mag/20.0)
+// Samples creates audio from the spectrogram with the inverse STFT. If the spectrogram has not been
+// modified, this gives back the samples it was created from, to within floating point precision.
+// The samples are not clipped, so they may be outside of the range [-1, 1].
+func (s *Spectrogram) Samples() []float64 {
+	return istft(s.Bins, s.Length, s.Options)
+}
 
-			//fmt.Printf("Computed magnitude from pixel color (r: %d): %f\n", r, mag)
+// levels returns the magnitude of each bin in dB relative to full scale, as rows of
+// columns, which is what the seams are found in when the spectrogram is carved
+func (s *Spectrogram) levels() [][]float64 {
+	gain := windowGain(s.Options.Window.Coefficients(s.Options.FFTSize, s.Options.WindowParameter))
+	rows := make([][]float64, s.Options.Bins())
+	for y := range rows {
+		rows[y] = make([]float64, len(s.Bins))
+		for x, column := range s.Bins {
+			rows[y][x] = magnitudeToDB(cmplx.Abs(column[y]) / gain)
+		}
+	}
+	return rows
+}
 
-			// Compute the phase from the green value
-			// Map phase from [0, 255] to [-pi, pi]
-			phase := float64(g)*2.0*math.Pi/255.0 - math.Pi
+// magnitudeToDB converts a magnitude to dB, with a floor at minDB
+func magnitudeToDB(mag float64) float64 {
+	if db := 20 * math.Log10(mag); db > minDB {
+		return db
+	}
+	return minDB
+}
 
-			//fmt.Printf("Computed phase from pixel color (g: %d): %f\n", g, phase)
+// CreateSpectrogramFromAudio creates a spectrogram from an []int16.
+// The spectrogram is created with DefaultSpectrogramOptions. The image has no metadata,
+// so use WriteTransformImage for spectrograms that should be converted back to audio.
+func CreateSpectrogramFromAudio(int16s []int16) (*image.RGBA, error) {
+	// Convert the int16s to float64s
+	return createSpectrogram(int16sToFloat64s(int16s), DefaultSpectrogramOptions)
+}
 
-			// Compute the FFT value
-			fftVal := cmplx.Rect(mag, phase)
+// createSpectrogram creates a spectrogram from samples in the range [-1, 1]
+func createSpectrogram(float64s []float64, options SpectrogramOptions) (*image.RGBA, error) {
+	spectrogram, err := NewSpectrogram(float64s, SampleRate, options)
+	if err != nil {
+		return nil, err
+	}
+	return spectrogram.toRGBA(DefaultMagnitudeMapping.fixed()), nil
+}
 
-			// Add the FFT value to the frame
-			iframe[y] = real(fftVal)
+// CreateSpectrogramsFromChannels creates one spectrogram per channel
+func CreateSpectrogramsFromChannels(channels [][]int16) ([]*image.RGBA, error) {
+	imgs := make([]*image.RGBA, len(channels))
+	for i, channel := range channels {
+		img, err := CreateSpectrogramFromAudio(channel)
+		if err != nil {
+			return nil, fmt.Errorf("channel %d: %w", i, err)
 		}
+		imgs[i] = img
+	}
+	return imgs, nil
+}
 
-		// Scale the inverse FFT frame with the extracted volume
-		for i, val := range iframe {
-			iframe[i] = val * fvolume
+// StackSpectrograms places the given spectrograms on top of each other,
+// creating one multi-channel spectrogram. The width of the resulting image
+// is the width of the narrowest spectrogram.
+func StackSpectrograms(imgs []*image.RGBA) *image.RGBA {
+	if len(imgs) == 0 {
+		return image.NewRGBA(image.Rect(0, 0, 0, 0))
+	}
+	width, height := imgs[0].Bounds().Dx(), 0
+	for _, img := range imgs {
+		if img.Bounds().Dx() < width {
+			width = img.Bounds().Dx()
 		}
+		height += img.Bounds().Dy()
+	}
+	stacked := image.NewRGBA(image.Rect(0, 0, width, height))
+	y := 0
+	for _, img := range imgs {
+		b := img.Bounds()
+		draw.Draw(stacked, image.Rect(0, y, width, y+b.Dy()), img, b.Min, draw.Src)
+		y += b.Dy()
+	}
+	return stacked
+}
 
-		// Add the audio frame to the audio data
-		for i, val := range iframe {
-			if x*FFTSize+i < length {
-				float64s[x*FFTSize+i] = val
-			}
+// StackImages places the given images on top of each other, just like StackSpectrograms,
+// but for images of any type, like the ones that are returned by Spectrogram.ToImage.
+// The stacked image has the type of the first image if it is an *image.RGBA or an
+// *image.Gray16, and is an *image.RGBA64 otherwise, so that no precision is lost.
+func StackImages(imgs []image.Image) draw.Image {
+	width, height := 0, 0
+	for i, img := range imgs {
+		if i == 0 || img.Bounds().Dx() < width {
+			width = img.Bounds().Dx()
 		}
+		height += img.Bounds().Dy()
+	}
+	var stacked draw.Image
+	var first image.Image
+	if len(imgs) > 0 {
+		first = imgs[0]
+	}
+	switch first.(type) {
+	case *image.RGBA:
+		stacked = image.NewRGBA(image.Rect(0, 0, width, height))
+	case *image.Gray16:
+		stacked = image.NewGray16(image.Rect(0, 0, width, height))
+	default:
+		stacked = image.NewRGBA64(image.Rect(0, 0, width, height))
+	}
+	y := 0
+	for _, img := range imgs {
+		b := img.Bounds()
+		draw.Draw(stacked, image.Rect(0, y, width, y+b.Dy()), img, b.Min, draw.Src)
+		y += b.Dy()
+	}
+	return stacked
+}
+
+// SplitSpectrogram splits a multi-channel spectrogram that was created with
+// StackSpectrograms into one spectrogram per channel.
+func SplitSpectrogram(img *image.RGBA, numChannels int) ([]*image.RGBA, error) {
+	b := img.Bounds()
+	if numChannels < 1 || b.Dy()%numChannels != 0 {
+		return nil, fmt.Errorf("can not split an image with height %d into %d channels", b.Dy(), numChannels)
+	}
+	height := b.Dy() / numChannels
+	imgs := make([]*image.RGBA, numChannels)
+	for i := range imgs {
+		imgs[i] = image.NewRGBA(image.Rect(0, 0, b.Dx(), height))
+		draw.Draw(imgs[i], imgs[i].Bounds(), img, image.Pt(b.Min.X, b.Min.Y+i*height), draw.Src)
+	}
+	return imgs, nil
+}
+
+// SplitImage splits an image that was created with StackImages into one image per channel,
+// just like SplitSpectrogram, but for images of any type and without copying them if possible.
+// This is what the FromImage method of a Transform needs for images of stacked channels.
+func SplitImage(img image.Image, numChannels int) ([]image.Image, error) {
+	b := img.Bounds()
+	if numChannels < 1 || b.Dy()%numChannels != 0 {
+		return nil, fmt.Errorf("can not split an image with height %d into %d channels", b.Dy(), numChannels)
+	}
+	height := b.Dy() / numChannels
+	imgs := make([]image.Image, numChannels)
+	for i := range imgs {
+		imgs[i] = subImage(img, image.Rect(b.Min.X, b.Min.Y+i*height, b.Max.X, b.Min.Y+(i+1)*height))
+	}
+	return imgs, nil
+}
+
+// CreateAudioFromSpectrogram creates audio from a spectrogram.
+// The spectrogram must have been created with DefaultSpectrogramOptions, and since the length
+// of the audio is not stored in the image, the audio covers all of the columns of the image.
+// The phase is taken from the image, or found from the magnitudes with Griffin-Lim, Fast
+// Griffin-Lim or PGHI, when the Method of DefaultPhaseOptions is not PhaseKeep.
+// The samples are quantized with DefaultQuantizeOptions, and if any samples
+// clipped, an ErrClipped error is returned together with the audio data.
+func CreateAudioFromSpectrogram(img *image.RGBA) ([]int16, error) {
+	return CreateAudioFromSpectrogramWithPhase(img, DefaultPhaseOptions)
+}
+
+// CreateAudioFromSpectrogramWithPhase is like CreateAudioFromSpectrogram, but the phase is found
+// with the given phase options, unless their Method is PhaseKeep
+func CreateAudioFromSpectrogramWithPhase(img *image.RGBA, phase PhaseOptions) ([]int16, error) {
+	float64s, err := createAudio(img, DefaultSpectrogramOptions, phase)
+	if err != nil {
+		return nil, err
 	}
 
-	// Convert the float64s to int16s
-	int16s := float64sToInt16s(float64s)
+	// Convert the float64s to int16s. Resynthesised audio often overshoots, so the samples that clip are reported.
+	q := NewQuantizer(16, 1, DefaultQuantizeOptions)
+	int16s := float64sToInt16s(float64s, q)
+
+	return int16s, q.err()
+}
+
+// createAudio creates samples from a spectrogram that has no metadata, with the given STFT and phase options.
+// The samples are not clipped, so they may be outside of the range [-1, 1].
+func createAudio(img *image.RGBA, options SpectrogramOptions, phase PhaseOptions) ([]float64, error) {
+	// The length of the audio is not stored in the image, so the samples cover all of its columns
+	s := &Spectrogram{Options: options, SampleRate: SampleRate, Length: options.maxLength(img.Bounds().Dx())}
+	spectrogram, err := SpectrogramFromImage(img, s.header(EncodingRGBA8, DefaultMagnitudeMapping.fixed()))
+	if err != nil {
+		return nil, err
+	}
+	if phase.Method != PhaseKeep {
+		if spectrogram, err = spectrogram.RetrievePhase(phase); err != nil {
+			return nil, err
+		}
+	}
+	return spectrogram.Samples(), nil
+}
 
-	return int16s, nil
+// CreateChannelsFromSpectrograms creates audio from one spectrogram per channel.
+// If any samples clipped, an ErrClipped error with the total number of clipped
+// samples is returned together with the audio data.
+func CreateChannelsFromSpectrograms(imgs []*image.RGBA) ([][]int16, error) {
+	channels := make([][]int16, len(imgs))
+	clipped := 0
+	for i, img := range imgs {
+		int16s, err := CreateAudioFromSpectrogram(img)
+		var clipErr ErrClipped
+		if errors.As(err, &clipErr) {
+			clipped += clipErr.Samples
+		} else if err != nil {
+			return nil, fmt.Errorf("channel %d: %w", i, err)
+		}
+		channels[i] = int16s
+	}
+	if clipped > 0 {
+		return channels, ErrClipped{Samples: clipped}
+	}
+	return channels, nil
 }
@@ -0,0 +1,604 @@
+package wavecarve
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SpectrogramHeaderVersion is the version of the SpectrogramHeader that is written
+const SpectrogramHeaderVersion = 4
+
+// ErrNoSpectrogramHeader is returned when an image file has no spectrogram metadata and no sidecar JSON file
+var ErrNoSpectrogramHeader = errors.New("no spectrogram metadata")
+
+// SpectrogramHeader holds everything that is needed for converting a spectrogram image back to audio.
+// It is stored in an iTXt chunk of PNG files, in the ImageDescription tag of TIFF files, and in a
+// sidecar JSON file (the image file path with ".json" appended) for other formats.
+type SpectrogramHeader struct {
+	Version         int     `json:"wavecarve"` // the version of the header, which also identifies it
+	Encoding        string  `json:"encoding"`  // the name of the ImageEncoding
+	Channels        int     `json:"channels"`  // the number of spectrograms that are stacked on top of each other
+	Length          int     `json:"length"`    // the number of samples per channel
+	SampleRate      uint32  `json:"sampleRate"`
+	FFTSize         int     `json:"fftSize"`
+	HopSize         int     `json:"hopSize"`
+	Window          string  `json:"window"`
+	WindowParameter float64 `json:"windowParameter,omitempty"`
+	ZeroPadding     int     `json:"zeroPadding,omitempty"`
+	MinDB           float64 `json:"minDB"`           // the floor of the MagnitudeMapping, in dB
+	MaxDB           float64 `json:"maxDB"`           // the ceiling of the MagnitudeMapping, in dB
+	Curve           string  `json:"curve,omitempty"` // the name of the MagnitudeCurve, which is "db" for version 1
+	CurveParameter  float64 `json:"curveParameter,omitempty"`
+
+	// The frequency bands of a BandSpectrogram, which has one row per band instead of one per bin.
+	// Scale is the name of the FrequencyScale, and is empty for a Spectrogram.
+	Scale        string  `json:"scale,omitempty"`
+	Bands        int     `json:"bands,omitempty"`
+	MinFrequency float64 `json:"minFrequency,omitempty"`
+	MaxFrequency float64 `json:"maxFrequency,omitempty"`
+
+	// Transform is "cqt" for a ConstantQSpectrogram, which has BinsPerOctave and the frequency range
+	// above instead of the STFT options, "mdct" for an MDCTSpectrogram, which has the frame size in
+	// FFTSize and the name of the MDCTWindow in Window, "wpt" for a WaveletPacketSpectrogram, which has
+	// the name of the Wavelet and the Level, and is empty for the short-time Fourier transform.
+	// Other registered transforms have their own name here, and use the fields that fit their options.
+	Transform     string `json:"transform,omitempty"`
+	BinsPerOctave int    `json:"binsPerOctave,omitempty"`
+	Wavelet       string `json:"wavelet,omitempty"`
+	Level         int    `json:"level,omitempty"`
+}
+
+// Header returns the header for an image of the spectrogram with the given encoding and
+// DefaultMagnitudeMapping. If the mapping is automatic, the range is picked from this spectrogram.
+func (s *Spectrogram) Header(encoding ImageEncoding) SpectrogramHeader {
+	mapping, err := DefaultMagnitudeMapping.Resolve([]*Spectrogram{s})
+	if err != nil {
+		// Keep the invalid mapping, which Validate reports
+		mapping = DefaultMagnitudeMapping
+	}
+	return s.header(encoding, mapping)
+}
+
+// header returns the header for an image of the spectrogram with the given encoding and mapping
+func (s *Spectrogram) header(encoding ImageEncoding, mapping MagnitudeMapping) SpectrogramHeader {
+	return SpectrogramHeader{
+		Version:         SpectrogramHeaderVersion,
+		Encoding:        encoding.String(),
+		Channels:        1,
+		Length:          s.Length,
+		SampleRate:      s.SampleRate,
+		FFTSize:         s.Options.FFTSize,
+		HopSize:         s.Options.hopSize(),
+		Window:          s.Options.Window.String(),
+		WindowParameter: s.Options.WindowParameter,
+		ZeroPadding:     s.Options.ZeroPadding,
+		MinDB:           mapping.Floor,
+		MaxDB:           mapping.Ceiling,
+		Curve:           mapping.Curve.String(),
+		CurveParameter:  mapping.CurveParameter,
+	}
+}
+
+// ImageEncoding returns the image encoding of the header
+func (h SpectrogramHeader) ImageEncoding() (ImageEncoding, error) {
+	return ParseImageEncoding(h.Encoding)
+}
+
+// Options returns the STFT options of the header
+func (h SpectrogramHeader) Options() (SpectrogramOptions, error) {
+	window, err := ParseWindowFunction(h.Window)
+	if err != nil {
+		return SpectrogramOptions{}, err
+	}
+	options := SpectrogramOptions{
+		FFTSize:         h.FFTSize,
+		HopSize:         h.HopSize,
+		Window:          window,
+		WindowParameter: h.WindowParameter,
+		ZeroPadding:     h.ZeroPadding,
+	}
+	return options, options.Validate()
+}
+
+// FilterbankOptions returns the frequency bands of the header, or nil if it is the header of a Spectrogram
+func (h SpectrogramHeader) FilterbankOptions() (*FilterbankOptions, error) {
+	if h.Scale == "" {
+		return nil, nil
+	}
+	scale, err := ParseFrequencyScale(h.Scale)
+	if err != nil {
+		return nil, err
+	}
+	options := &FilterbankOptions{Scale: scale, Bands: h.Bands, MinFrequency: h.MinFrequency, MaxFrequency: h.MaxFrequency}
+	return options, options.Validate(h.SampleRate)
+}
+
+// ConstantQOptions returns the constant-Q options of the header, if it is the header of a ConstantQSpectrogram
+func (h SpectrogramHeader) ConstantQOptions() (ConstantQOptions, error) {
+	if h.Transform != constantQTransform {
+		return ConstantQOptions{}, fmt.Errorf("unknown transform %q in the spectrogram metadata, expected %q", h.Transform, constantQTransform)
+	}
+	options := ConstantQOptions{BinsPerOctave: h.BinsPerOctave, MinFrequency: h.MinFrequency, MaxFrequency: h.MaxFrequency}
+	return options, options.Validate(h.SampleRate)
+}
+
+// MDCTOptions returns the MDCT options of the header, if it is the header of an MDCTSpectrogram
+func (h SpectrogramHeader) MDCTOptions() (MDCTOptions, error) {
+	if h.Transform != mdctTransform {
+		return MDCTOptions{}, fmt.Errorf("unknown transform %q in the spectrogram metadata, expected %q", h.Transform, mdctTransform)
+	}
+	window, err := ParseMDCTWindow(h.Window)
+	if err != nil {
+		return MDCTOptions{}, err
+	}
+	options := MDCTOptions{FrameSize: h.FFTSize, Window: window, WindowParameter: h.WindowParameter}
+	if err := options.Validate(); err != nil {
+		return MDCTOptions{}, err
+	}
+	if h.HopSize != options.Bins() {
+		return MDCTOptions{}, fmt.Errorf("invalid hop size %d, the frames of the MDCT always overlap by half of the frame size %d", h.HopSize, h.FFTSize)
+	}
+	return options, nil
+}
+
+// WaveletPacketOptions returns the wavelet packet options of the header, if it is the header of a WaveletPacketSpectrogram
+func (h SpectrogramHeader) WaveletPacketOptions() (WaveletPacketOptions, error) {
+	if h.Transform != waveletPacketTransform {
+		return WaveletPacketOptions{}, fmt.Errorf("unknown transform %q in the spectrogram metadata, expected %q", h.Transform, waveletPacketTransform)
+	}
+	wavelet, err := ParseWavelet(h.Wavelet)
+	if err != nil {
+		return WaveletPacketOptions{}, err
+	}
+	options := WaveletPacketOptions{Wavelet: wavelet, Level: h.Level}
+	return options, options.Validate()
+}
+
+// Mapping returns the magnitude mapping of the header
+func (h SpectrogramHeader) Mapping() (MagnitudeMapping, error) {
+	curve := CurveDB
+	if h.Curve != "" {
+		var err error
+		if curve, err = ParseMagnitudeCurve(h.Curve); err != nil {
+			return MagnitudeMapping{}, err
+		}
+	}
+	mapping := MagnitudeMapping{Floor: h.MinDB, Ceiling: h.MaxDB, Curve: curve, CurveParameter: h.CurveParameter}
+	return mapping, mapping.Validate()
+}
+
+// Validate returns an error if the header is not valid, or does not match an image of the given size,
+// with one or more channels stacked on top of each other. Only the fields that all transforms have
+// are checked here. The options of the transform are validated by the transform, in its FromImage.
+func (h SpectrogramHeader) Validate(width, height int) error {
+	if h.Version < 1 || h.Version > SpectrogramHeaderVersion {
+		return fmt.Errorf("unsupported spectrogram metadata version %d, expected 1 to %d", h.Version, SpectrogramHeaderVersion)
+	}
+	if _, err := h.ImageEncoding(); err != nil {
+		return err
+	}
+	if _, err := h.Mapping(); err != nil {
+		return err
+	}
+	switch {
+	case h.Channels < 1:
+		return fmt.Errorf("invalid number of channels %d in the spectrogram metadata", h.Channels)
+	case h.SampleRate == 0:
+		return errors.New("the sample rate is missing from the spectrogram metadata")
+	case h.Length < 0:
+		return fmt.Errorf("invalid length %d in the spectrogram metadata", h.Length)
+	case int64(h.Length) > MaxDataSize/8/int64(h.Channels):
+		// The samples are float64s, and the limit is the same as for the audio data of files
+		return fmt.Errorf("the spectrogram metadata gives %d samples per channel, which is too large to be converted to audio (the limit is %d bytes)", h.Length, MaxDataSize)
+	case height%h.Channels != 0:
+		return fmt.Errorf("the image is %d pixels high, which can not be split into %d channels", height, h.Channels)
+	}
+	return nil
+}
+
+// validateChannel returns an error if the header is not for one channel, or does not match the image,
+// with the checks of Validate and the given checks of the transform, for the FromImage functions
+func validateChannel(img image.Image, header SpectrogramHeader, validate func(width, height int) error) error {
+	bounds := img.Bounds()
+	if header.Channels != 1 {
+		return fmt.Errorf("the spectrogram metadata gives %d channels, use SplitImage for stacked spectrograms", header.Channels)
+	}
+	if err := header.Validate(bounds.Dx(), bounds.Dy()); err != nil {
+		return err
+	}
+	return validate(bounds.Dx(), bounds.Dy())
+}
+
+// validateSpectrogram returns an error if a valid header is not for a Spectrogram,
+// or does not match an image of the given size
+func (h SpectrogramHeader) validateSpectrogram(width, height int) error {
+	if h.Scale != "" {
+		return fmt.Errorf("the spectrogram metadata gives %s bands, use BandSpectrogramFromImage for band spectrograms", h.Scale)
+	}
+	return h.validateSTFT(width, height)
+}
+
+// validateBands returns an error if a valid header is not for a BandSpectrogram,
+// or does not match an image of the given size
+func (h SpectrogramHeader) validateBands(width, height int) error {
+	if h.Scale == "" {
+		return errors.New("the spectrogram metadata has no frequency bands")
+	}
+	return h.validateSTFT(width, height)
+}
+
+// validateSTFT returns an error if a valid header is not for the short-time Fourier transform,
+// or if its options do not match an image of the given size
+func (h SpectrogramHeader) validateSTFT(width, height int) error {
+	if h.Transform != "" {
+		return fmt.Errorf("the spectrogram metadata is for the %q transform, not for the short-time Fourier transform", h.Transform)
+	}
+	encoding, _ := h.ImageEncoding()
+	options, err := h.Options()
+	if err != nil {
+		return err
+	}
+	if encoding == EncodingSigned8 || encoding == EncodingSigned16 {
+		return fmt.Errorf("the %s encoding can only be used for MDCT and wavelet packet spectrograms", encoding)
+	}
+	rows, what := options.Bins(), "frequency bins"
+	if h.Scale != "" {
+		filterbank, err := h.FilterbankOptions()
+		if err != nil {
+			return err
+		}
+		if encoding != EncodingGray16 {
+			return fmt.Errorf("the %s encoding can not be used for frequency bands, only %s", encoding, EncodingGray16)
+		}
+		rows, what = filterbank.Bands, filterbank.Scale.String()+" bands"
+	}
+	switch {
+	case height != h.Channels*rows:
+		return fmt.Errorf("the image is %d pixels high, but the metadata gives %d channels of %d %s", height, h.Channels, rows, what)
+	case width != options.Columns(h.Length) && !(h.Length == 0 && width < options.Columns(1)):
+		// A spectrogram that is carved down to fewer columns than a single sample gives is left with no samples
+		return fmt.Errorf("the image is %d pixels wide, but the metadata gives %d columns for %d samples", width, options.Columns(h.Length), h.Length)
+	}
+	return nil
+}
+
+// WriteTransformImage stacks images of one representation per channel, from the given transform and
+// with the given encoding, and writes them to a PNG or TIFF file, like WriteImageFile, with the
+// SpectrogramHeader that the transform returns in the metadata of the file. For other formats, like BMP,
+// the header is written to a sidecar JSON file, which has ".json" appended to the file path.
+func WriteTransformImage(filePath string, transform Transform, representations []Representation, encoding ImageEncoding) error {
+	imgs, header, err := transform.Images(representations, encoding)
+	if err != nil {
+		return err
+	}
+	return writeSpectrogramFile(filePath, StackImages(imgs), header)
+}
+
+// writeSpectrogramFile writes the image to a file, with the header in its metadata or in a sidecar JSON file
+func writeSpectrogramFile(filePath string, img image.Image, header SpectrogramHeader) error {
+	metadata, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, filePath, img); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	switch imageFormat(filePath) {
+	case formatPNG:
+		data = addPNGText(data, spectrogramMetadataKeyword, string(metadata))
+	case formatTIFF:
+		if data, err = addTIFFDescription(data, string(metadata)); err != nil {
+			return err
+		}
+	default:
+		if err := os.WriteFile(filePath+".json", metadata, 0o644); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(filePath, data, 0o644)
+}
+
+// ReadTransformImage reads an image that was written with WriteTransformImage, finds the registered
+// transform of its SpectrogramHeader with TransformForHeader, and converts the image back to one
+// representation per channel with the FromImage method of the transform, which validates the header.
+// The header is read from the metadata of PNG and TIFF files, and from the sidecar JSON file if the
+// image has no such metadata, which happens when it has been saved by an image editor that does not keep it.
+func ReadTransformImage(filePath string) (Transform, []Representation, error) {
+	img, header, err := readSpectrogramFile(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	transform, err := TransformForHeader(header)
+	if err != nil {
+		return nil, nil, err
+	}
+	representations, err := transform.FromImage(img, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	return transform, representations, nil
+}
+
+// readSpectrogramFile reads an image file together with its SpectrogramHeader
+func readSpectrogramFile(filePath string) (image.Image, SpectrogramHeader, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, SpectrogramHeader{}, err
+	}
+	img, err := decodeImage(bytes.NewReader(data), filePath)
+	if err != nil {
+		return nil, SpectrogramHeader{}, err
+	}
+	header, err := spectrogramHeader(data, filePath)
+	if err != nil {
+		return nil, SpectrogramHeader{}, err
+	}
+	return img, header, nil
+}
+
+// ReadSpectrogramHeader reads the SpectrogramHeader of an image file that was written with
+// WriteTransformImage, from the metadata of the file or from its sidecar JSON file
+func ReadSpectrogramHeader(filePath string) (SpectrogramHeader, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return SpectrogramHeader{}, err
+	}
+	return spectrogramHeader(data, filePath)
+}
+
+// spectrogramHeader finds the header in the data of an image file, or in its sidecar JSON file
+func spectrogramHeader(data []byte, filePath string) (SpectrogramHeader, error) {
+	var metadata string
+	switch imageFormat(filePath) {
+	case formatPNG:
+		metadata = pngText(data, spectrogramMetadataKeyword)
+	case formatTIFF:
+		metadata = tiffDescription(data)
+	}
+	source := filePath
+	if !strings.HasPrefix(metadata, "{") {
+		sidecar, err := os.ReadFile(filePath + ".json")
+		if errors.Is(err, os.ErrNotExist) {
+			return SpectrogramHeader{}, fmt.Errorf("%w in %s, and no sidecar file %s.json", ErrNoSpectrogramHeader, filepath.Base(filePath), filepath.Base(filePath))
+		} else if err != nil {
+			return SpectrogramHeader{}, err
+		}
+		metadata, source = string(sidecar), filePath+".json"
+	}
+	var header SpectrogramHeader
+	if err := json.Unmarshal([]byte(metadata), &header); err != nil {
+		return SpectrogramHeader{}, fmt.Errorf("invalid spectrogram metadata in %s: %w", source, err)
+	}
+	if header.Version == 0 {
+		return SpectrogramHeader{}, fmt.Errorf("%w in %s", ErrNoSpectrogramHeader, source)
+	}
+	return header, nil
+}
+
+// The keyword of the PNG text chunk that holds the SpectrogramHeader
+const spectrogramMetadataKeyword = "wavecarve"
+
+// addPNGText inserts an uncompressed iTXt chunk with the given keyword and text right after the IHDR chunk of a PNG file
+func addPNGText(data []byte, keyword, text string) []byte {
+	// The IHDR chunk always comes first, after the 8 byte signature, and is 25 bytes long
+	const ihdrEnd = 8 + 25
+	if len(data) < ihdrEnd {
+		return data
+	}
+	// Keyword, null separator, compression flag and method, empty language tag and translated keyword, and the text
+	chunk := []byte(keyword)
+	chunk = append(chunk, 0, 0, 0, 0, 0)
+	chunk = append(chunk, text...)
+
+	var buf bytes.Buffer
+	buf.Grow(len(data) + len(chunk) + 12)
+	buf.Write(data[:ihdrEnd])
+	writePNGChunk(&buf, "iTXt", chunk)
+	buf.Write(data[ihdrEnd:])
+	return buf.Bytes()
+}
+
+// writePNGChunk writes a PNG chunk with its length and CRC
+func writePNGChunk(buf *bytes.Buffer, chunkType string, chunk []byte) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(len(chunk)))
+	buf.Write(b[:])
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(chunk)
+	buf.WriteString(chunkType)
+	buf.Write(chunk)
+	binary.BigEndian.PutUint32(b[:], crc.Sum32())
+	buf.Write(b[:])
+}
+
+// pngText returns the text of the first uncompressed tEXt or iTXt chunk with the given keyword, or "" if there is none
+func pngText(data []byte, keyword string) string {
+	for pos := 8; pos+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		chunkType := string(data[pos+4 : pos+8])
+		if length < 0 || pos+12+length > len(data) || chunkType == "IEND" {
+			break
+		}
+		chunk := data[pos+8 : pos+8+length]
+		pos += 12 + length
+		if crc32.ChecksumIEEE(data[pos-8-length:pos-4]) != binary.BigEndian.Uint32(data[pos-4:]) {
+			continue
+		}
+		name, rest, found := bytes.Cut(chunk, []byte{0})
+		if !found || string(name) != keyword {
+			continue
+		}
+		switch chunkType {
+		case "tEXt":
+			return string(rest)
+		case "iTXt":
+			// Skip the compression flag and method, the language tag and the translated keyword
+			if len(rest) < 2 || rest[0] != 0 {
+				continue
+			}
+			if _, rest, found = bytes.Cut(rest[2:], []byte{0}); !found {
+				continue
+			}
+			if _, rest, found = bytes.Cut(rest, []byte{0}); !found {
+				continue
+			}
+			return string(rest)
+		}
+	}
+	return ""
+}
+
+// TIFF constants that are needed for reading and writing the ImageDescription tag
+const (
+	tiffImageDescription = 270
+	tiffASCII            = 2
+)
+
+// tiffByteOrder is the byte order of a TIFF file, which can be little or big endian
+type tiffByteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// addTIFFDescription adds an ImageDescription tag with the given text to a TIFF file. The text and a new
+// first IFD with the tag are appended to the file, so that the offsets in the existing IFD stay valid.
+func addTIFFDescription(data []byte, text string) ([]byte, error) {
+	order, ifdOffset, err := tiffHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if ifdOffset+2 > len(data) {
+		return nil, errors.New("invalid TIFF IFD offset")
+	}
+	count := int(order.Uint16(data[ifdOffset:]))
+	if ifdOffset+2+count*12+4 > len(data) {
+		return nil, errors.New("invalid TIFF IFD")
+	}
+
+	// Append the text, with a terminating null, at an even offset
+	out := append([]byte(nil), data...)
+	if len(out)%2 != 0 {
+		out = append(out, 0)
+	}
+	textOffset := len(out)
+	out = append(out, text...)
+	out = append(out, 0)
+	if len(out)%2 != 0 {
+		out = append(out, 0)
+	}
+
+	// Append the new IFD, with the tags in ascending order
+	newIFDOffset := len(out)
+	out = order.AppendUint16(out, uint16(count+1))
+	added := false
+	for i := 0; i < count; i++ {
+		entry := data[ifdOffset+2+i*12 : ifdOffset+2+(i+1)*12]
+		tag := order.Uint16(entry)
+		if tag == tiffImageDescription {
+			continue
+		}
+		if !added && tag > tiffImageDescription {
+			out = appendTIFFDescriptionEntry(out, order, len(text)+1, textOffset)
+			added = true
+		}
+		out = append(out, entry...)
+	}
+	if !added {
+		out = appendTIFFDescriptionEntry(out, order, len(text)+1, textOffset)
+	}
+	// Fix the count if an existing ImageDescription tag was replaced
+	entries := (len(out) - newIFDOffset - 2) / 12
+	order.PutUint16(out[newIFDOffset:], uint16(entries))
+	out = order.AppendUint32(out, 0) // no more IFDs
+
+	order.PutUint32(out[4:], uint32(newIFDOffset))
+	return out, nil
+}
+
+// appendTIFFDescriptionEntry appends an IFD entry for an ImageDescription tag
+func appendTIFFDescriptionEntry(out []byte, order tiffByteOrder, count, offset int) []byte {
+	out = order.AppendUint16(out, tiffImageDescription)
+	out = order.AppendUint16(out, tiffASCII)
+	out = order.AppendUint32(out, uint32(count))
+	return order.AppendUint32(out, uint32(offset))
+}
+
+// tiffDescription returns the ImageDescription tag of the first IFD of a TIFF file, or "" if there is none
+func tiffDescription(data []byte) string {
+	order, ifdOffset, err := tiffHeader(data)
+	if err != nil || ifdOffset+2 > len(data) {
+		return ""
+	}
+	count := int(order.Uint16(data[ifdOffset:]))
+	for i := 0; i < count; i++ {
+		pos := ifdOffset + 2 + i*12
+		if pos+12 > len(data) {
+			break
+		}
+		if order.Uint16(data[pos:]) != tiffImageDescription || order.Uint16(data[pos+2:]) != tiffASCII {
+			continue
+		}
+		n := int(order.Uint32(data[pos+4:]))
+		value := data[pos+8 : pos+12]
+		if n > 4 {
+			offset := int(order.Uint32(data[pos+8:]))
+			if offset < 0 || n < 0 || offset+n > len(data) {
+				return ""
+			}
+			value = data[offset : offset+n]
+		} else {
+			value = value[:n]
+		}
+		return string(bytes.TrimRight(value, "\x00"))
+	}
+	return ""
+}
+
+// tiffHeader returns the byte order and the offset of the first IFD of a TIFF file
+func tiffHeader(data []byte) (tiffByteOrder, int, error) {
+	if len(data) < 8 {
+		return nil, 0, errors.New("not a TIFF file")
+	}
+	var order tiffByteOrder
+	switch string(data[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, 0, errors.New("not a TIFF file")
+	}
+	return order, int(order.Uint32(data[4:])), nil
+}
+
+// subImage returns the part of the image within the given rectangle, without copying it if possible
+func subImage(img image.Image, rect image.Rectangle) image.Image {
+	if s, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(rect)
+	}
+	return &offsetImage{img, rect}
+}
+
+// offsetImage is a part of an image, for images that do not have a SubImage method
+type offsetImage struct {
+	image.Image
+	rect image.Rectangle
+}
+
+func (o *offsetImage) Bounds() image.Rectangle {
+	return o.rect
+}
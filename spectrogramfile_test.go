@@ -0,0 +1,192 @@
+package wavecarve
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTransformImageRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		encoding  ImageEncoding
+		tolerance float64
+	}{
+		{"test.png", EncodingRGBA16, 5e-4},
+		{"test.tiff", EncodingRGBA16, 5e-4},
+		{"test.bmp", EncodingRGBA8, 0.05},
+	}
+	audio := testAudio(2)
+	transform := STFTTransform{Options: SpectrogramOptions{FFTSize: 1024, HopSize: 256, Window: WindowHann}}
+	representations, err := transform.Analyze(audio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		filePath := filepath.Join(t.TempDir(), tt.name)
+		if err := WriteTransformImage(filePath, transform, representations, tt.encoding); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		_, sidecarErr := os.Stat(filePath + ".json")
+		if wantSidecar := imageFormat(filePath) == formatBMP; wantSidecar != (sidecarErr == nil) {
+			t.Errorf("%s: a sidecar file was written: %v, want %v", tt.name, sidecarErr == nil, wantSidecar)
+		}
+		readTransform, readRepresentations, err := ReadTransformImage(filePath)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if readTransform.Name() != transform.Name() || len(readRepresentations) != audio.NumChannels() {
+			t.Fatalf("%s: read %d representations for the %q transform", tt.name, len(readRepresentations), readTransform.Name())
+		}
+		recreated, err := readTransform.Synthesize(readRepresentations)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if difference := maxDifference(audio, recreated); difference > tt.tolerance {
+			t.Errorf("%s: the largest difference is %g", tt.name, difference)
+		}
+	}
+}
+
+// writeTestSpectrogramFile writes an image of the first channel of the test audio, with the given
+// changes to its header, and returns the file path together with the image and the header
+func writeTestSpectrogramFile(t *testing.T, name string, change func(*SpectrogramHeader)) (string, image.Image, SpectrogramHeader) {
+	t.Helper()
+	spectrograms, err := testAudio(1).Spectrograms(SpectrogramOptions{FFTSize: 512, HopSize: 128, Window: WindowHann})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := spectrograms[0].ToImage(EncodingRGBA8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := spectrograms[0].Header(EncodingRGBA8)
+	if change != nil {
+		change(&header)
+	}
+	filePath := filepath.Join(t.TempDir(), name)
+	if err := writeSpectrogramFile(filePath, img, header); err != nil {
+		t.Fatal(err)
+	}
+	return filePath, img, header
+}
+
+func TestReadTransformImageErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		change func(*SpectrogramHeader)
+	}{
+		{"wider image", "test.png", func(h *SpectrogramHeader) { h.Length -= 1024 }},
+		{"narrower image", "test.tiff", func(h *SpectrogramHeader) { h.Length += 1024 }},
+		{"higher image", "test.bmp", func(h *SpectrogramHeader) { h.FFTSize = 256 }},
+		{"image that can not be split into channels", "test.png", func(h *SpectrogramHeader) { h.Channels = 2 }},
+		{"unknown transform", "test.png", func(h *SpectrogramHeader) { h.Transform = "unknown" }},
+		{"newer version", "test.tiff", func(h *SpectrogramHeader) { h.Version = SpectrogramHeaderVersion + 1 }},
+	}
+	for _, tt := range tests {
+		filePath, _, _ := writeTestSpectrogramFile(t, tt.file, tt.change)
+		if _, _, err := ReadTransformImage(filePath); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+
+	// Images without metadata and without a sidecar file
+	for _, name := range []string{"test.png", "test.tiff", "test.bmp"} {
+		filePath, img, _ := writeTestSpectrogramFile(t, name, nil)
+		os.Remove(filePath + ".json")
+		if err := WriteImageFile(filePath, img); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := ReadTransformImage(filePath); !errors.Is(err, ErrNoSpectrogramHeader) {
+			t.Errorf("%s without metadata: got the error %v, want %v", name, err, ErrNoSpectrogramHeader)
+		}
+		// The sidecar file is used when the metadata is missing
+		_, _, header := writeTestSpectrogramFile(t, name, nil)
+		metadata, err := json.Marshal(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath+".json", metadata, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := ReadTransformImage(filePath); err != nil {
+			t.Errorf("%s with a sidecar file: %v", name, err)
+		}
+	}
+}
+
+func TestPNGText(t *testing.T) {
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, "test.png", image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	data := addPNGText(buf.Bytes(), "wavecarve", "first")
+	if got := pngText(data, "wavecarve"); got != "first" {
+		t.Errorf("got %q, want %q", got, "first")
+	}
+	if got := pngText(data, "other"); got != "" {
+		t.Errorf("got %q for a keyword that is not there", got)
+	}
+
+	// The chunk that is added last comes first, and a chunk with a corrupted CRC is skipped
+	data = addPNGText(data, "wavecarve", "second")
+	if got := pngText(data, "wavecarve"); got != "second" {
+		t.Errorf("got %q, want %q", got, "second")
+	}
+	const crcOffset = 8 + 25 + 8 + len("wavecarve") + 5 + len("second")
+	data[crcOffset] ^= 0xff
+	if got := pngText(data, "wavecarve"); got != "first" {
+		t.Errorf("got %q after the CRC was corrupted, want %q", got, "first")
+	}
+
+	// A chunk length that goes past the end of the file
+	if got := pngText(data[:8+25+8+4], "wavecarve"); got != "" {
+		t.Errorf("got %q from a truncated file", got)
+	}
+}
+
+func TestTIFFDescription(t *testing.T) {
+	var buf bytes.Buffer
+	img := image.NewGray(image.Rect(0, 0, 4, 3))
+	if err := encodeImage(&buf, "test.tiff", img); err != nil {
+		t.Fatal(err)
+	}
+	if got := tiffDescription(buf.Bytes()); got != "" {
+		t.Errorf("got the description %q before one was added", got)
+	}
+	data, err := addTIFFDescription(buf.Bytes(), "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tiffDescription(data); got != "first" {
+		t.Errorf("got %q, want %q", got, "first")
+	}
+
+	// An existing ImageDescription tag is replaced, and the image is unchanged
+	if data, err = addTIFFDescription(data, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if got := tiffDescription(data); got != "second" {
+		t.Errorf("got %q, want %q", got, "second")
+	}
+	decoded, err := decodeImage(bytes.NewReader(data), "test.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Bounds() != img.Bounds() {
+		t.Errorf("the image is %v after the description was replaced, want %v", decoded.Bounds(), img.Bounds())
+	}
+
+	// Files that are not TIFF files, or that have an IFD offset past the end of the file
+	if _, err := addTIFFDescription([]byte("not a TIFF file"), "text"); err == nil {
+		t.Error("no error for a file that is not a TIFF file")
+	}
+	if _, err := addTIFFDescription([]byte("II*\x00\xff\xff\x00\x00"), "text"); err == nil {
+		t.Error("no error for an IFD offset past the end of the file")
+	}
+}
@@ -0,0 +1,316 @@
+package wavecarve
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strings"
+
+	"github.com/mjibson/go-dsp/fft"
+	"github.com/mjibson/go-dsp/window"
+)
+
+// WindowFunction selects the window that is applied to each frame before the FFT
+type WindowFunction int
+
+const (
+	// WindowRectangular does not change the frame, which gives the sharpest peaks but the most leakage
+	WindowRectangular WindowFunction = iota
+
+	// WindowHann is a good default for overlapping frames
+	WindowHann
+
+	// WindowHamming has a lower first side lobe than Hann, but side lobes that fall off more slowly
+	WindowHamming
+
+	// WindowBlackman has lower side lobes than Hann and Hamming, at the cost of a wider main lobe
+	WindowBlackman
+
+	// WindowFlatTop has a very wide main lobe, which keeps the magnitudes of sinusoids accurate
+	WindowFlatTop
+
+	// WindowKaiser has an adjustable trade-off between main lobe width and side lobe level,
+	// given by the beta in WindowParameter (8.6 if it is 0)
+	WindowKaiser
+
+	// WindowGaussian has the best time and frequency localisation, with the standard deviation,
+	// relative to half the frame, given in WindowParameter (0.4 if it is 0)
+	WindowGaussian
+)
+
+var windowNames = [...]string{
+	WindowRectangular: "rectangular",
+	WindowHann:        "hann",
+	WindowHamming:     "hamming",
+	WindowBlackman:    "blackman",
+	WindowFlatTop:     "flattop",
+	WindowKaiser:      "kaiser",
+	WindowGaussian:    "gaussian",
+}
+
+// The window parameters that are used when WindowParameter is 0
+const (
+	defaultKaiserBeta    = 8.6
+	defaultGaussianSigma = 0.4
+)
+
+// String returns the name of the window function, as accepted by ParseWindowFunction
+func (w WindowFunction) String() string {
+	if w >= 0 && int(w) < len(windowNames) {
+		return windowNames[w]
+	}
+	return fmt.Sprintf("WindowFunction(%d)", int(w))
+}
+
+// ParseWindowFunction returns the window function with the given name, like "hann" or "kaiser"
+func ParseWindowFunction(name string) (WindowFunction, error) {
+	for w, windowName := range windowNames {
+		if strings.EqualFold(name, windowName) {
+			return WindowFunction(w), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown window function %q, expected one of: %s", name, strings.Join(windowNames[:], ", "))
+}
+
+// Coefficients returns the window as n symmetric coefficients. The parameter is the
+// beta of the Kaiser window or the relative standard deviation of the Gaussian window,
+// and the default is used if it is 0. The other windows have no parameter.
+func (w WindowFunction) Coefficients(n int, parameter float64) []float64 {
+	switch w {
+	case WindowHann:
+		return window.Hann(n)
+	case WindowHamming:
+		return window.Hamming(n)
+	case WindowBlackman:
+		return window.Blackman(n)
+	case WindowFlatTop:
+		return window.FlatTop(n)
+	case WindowKaiser:
+		if parameter == 0 {
+			parameter = defaultKaiserBeta
+		}
+		return symmetricWindow(n, func(x float64) float64 { return kaiser(x, parameter) })
+	case WindowGaussian:
+		if parameter == 0 {
+			parameter = defaultGaussianSigma
+		}
+		return symmetricWindow(n, func(x float64) float64 { return math.Exp(-0.5 * (x / parameter) * (x / parameter)) })
+	}
+	return window.Rectangular(n)
+}
+
+// symmetricWindow returns n coefficients of a window function that is defined over [-1, 1]
+func symmetricWindow(n int, f func(x float64) float64) []float64 {
+	w := make([]float64, n)
+	if n == 1 {
+		w[0] = 1
+		return w
+	}
+	for i := range w {
+		w[i] = f(2*float64(i)/float64(n-1) - 1)
+	}
+	return w
+}
+
+// SpectrogramOptions configures the short-time Fourier transform that is used for creating spectrograms
+type SpectrogramOptions struct {
+	// FFTSize is the number of samples in each frame. It does not have to be a power of two.
+	// A larger FFT size gives a better frequency resolution, but a worse time resolution.
+	FFTSize int
+
+	// HopSize is the number of samples between the starts of two frames, which is one
+	// column of the spectrogram. If it is smaller than FFTSize, the frames overlap.
+	// If it is 0, FFTSize is used, so that the frames do not overlap.
+	HopSize int
+
+	// Window is the window function that is applied to each frame, and WindowParameter
+	// is the parameter of the Kaiser and Gaussian windows, or 0 for the default
+	Window          WindowFunction
+	WindowParameter float64
+
+	// ZeroPadding is the number of zeros that are appended to each frame before the FFT,
+	// which interpolates the spectrum with (FFTSize + ZeroPadding)/2 + 1 bins
+	ZeroPadding int
+}
+
+// DefaultSpectrogramOptions are used by all functions that create spectrograms or create audio from them,
+// like CreateSpectrogramFromAudio and CreateAudioFromSpectrogram. The same options must be used for both.
+// The defaults use frames of FFTSize samples that do not overlap and are not windowed.
+var DefaultSpectrogramOptions = SpectrogramOptions{FFTSize: FFTSize, HopSize: FFTSize, Window: WindowRectangular}
+
+// WithOverlap returns a copy of the options where the hop size is set so that
+// the frames overlap by the given fraction, in the range [0, 1)
+func (o SpectrogramOptions) WithOverlap(overlap float64) SpectrogramOptions {
+	o.HopSize = int(math.Round(float64(o.FFTSize) * (1 - overlap)))
+	if o.HopSize < 1 {
+		o.HopSize = 1
+	}
+	return o
+}
+
+// Overlap returns the fraction of each frame that overlaps with the next frame
+func (o SpectrogramOptions) Overlap() float64 {
+	return 1 - float64(o.hopSize())/float64(o.FFTSize)
+}
+
+// TransformSize returns the size of the FFT of each frame, which is FFTSize + ZeroPadding
+func (o SpectrogramOptions) TransformSize() int {
+	return o.FFTSize + o.ZeroPadding
+}
+
+// Bins returns the number of frequency bins per frame, which is the height of the spectrogram.
+// The spectrum of real samples is Hermitian-symmetric, so only the bins from 0 Hz up to the
+// Nyquist frequency are kept, which is TransformSize()/2 + 1 bins.
+func (o SpectrogramOptions) Bins() int {
+	return o.TransformSize()/2 + 1
+}
+
+// Columns returns the number of columns of a spectrogram of the given number of samples.
+// The last frame is padded with zeros, so that no samples are dropped.
+func (o SpectrogramOptions) Columns(length int) int {
+	if length <= 0 {
+		return 0
+	}
+	hopSize := o.hopSize()
+	return (length + o.FFTSize - 1) / hopSize
+}
+
+// maxLength returns the largest number of samples that gives the given number of columns
+func (o SpectrogramOptions) maxLength(columns int) int {
+	if columns <= 0 {
+		return 0
+	}
+	return (columns+1)*o.hopSize() - o.FFTSize
+}
+
+// Validate returns an error if the options can not be used for creating a spectrogram
+func (o SpectrogramOptions) Validate() error {
+	switch {
+	case o.FFTSize < 2:
+		return fmt.Errorf("invalid FFT size %d, it must be at least 2", o.FFTSize)
+	case o.HopSize < 0 || o.HopSize > o.FFTSize:
+		return fmt.Errorf("invalid hop size %d, it can not be negative or larger than the FFT size %d", o.HopSize, o.FFTSize)
+	case o.ZeroPadding < 0:
+		return fmt.Errorf("invalid zero padding %d, it can not be negative", o.ZeroPadding)
+	case o.Window < 0 || int(o.Window) >= len(windowNames):
+		return fmt.Errorf("unknown window function %d", int(o.Window))
+	case o.WindowParameter < 0:
+		return fmt.Errorf("invalid window parameter %g, it can not be negative", o.WindowParameter)
+	}
+	return nil
+}
+
+// hopSize returns the hop size, which is FFTSize if HopSize is 0
+func (o SpectrogramOptions) hopSize() int {
+	if o.HopSize == 0 {
+		return o.FFTSize
+	}
+	return o.HopSize
+}
+
+// frameStart returns the position of the first sample of the frame of the given column.
+// Each frame ends where its hop ends, so the first frames start before the audio
+// (in the zero padding) and every sample is covered by the same number of frames.
+func (o SpectrogramOptions) frameStart(column int) int {
+	hopSize := o.hopSize()
+	return column*hopSize - (o.FFTSize - hopSize)
+}
+
+// frame copies the frame of the given column into buf, which has room for FFTSize + ZeroPadding samples,
+// with the window applied and with zeros where the frame is outside of the audio. The first sample of
+// float64s is at the given position of the audio, which is 0 unless it starts before the audio.
+func (o SpectrogramOptions) frame(float64s []float64, offset, column int, w, buf []float64) {
+	start := o.frameStart(column) - offset
+	for i := range buf {
+		buf[i] = 0
+		if i < o.FFTSize && start+i >= 0 && start+i < len(float64s) {
+			buf[i] = float64s[start+i] * w[i]
+		}
+	}
+}
+
+// windowGain returns the sum of the window coefficients, which is the magnitude of a
+// full scale DC signal in the spectrum. Dividing by it makes magnitudes independent of
+// the FFT size and the window.
+func windowGain(w []float64) float64 {
+	gain := 0.0
+	for _, v := range w {
+		gain += v
+	}
+	if gain <= 0 {
+		return 1
+	}
+	return gain
+}
+
+// stft returns the short-time Fourier transform of the samples, with Bins() bins per column
+func stft(float64s []float64, options SpectrogramOptions) [][]complex128 {
+	return stftAt(float64s, 0, options.Columns(len(float64s)), options)
+}
+
+// stftAt is like stft, but the first sample is at the given position, which can be negative,
+// and the given number of columns is returned
+func stftAt(float64s []float64, offset, columns int, options SpectrogramOptions) [][]complex128 {
+	bins := options.Bins()
+	w := options.Window.Coefficients(options.FFTSize, options.WindowParameter)
+	frame := make([]float64, options.TransformSize())
+	frames := make([][]complex128, columns)
+	for i := range frames {
+		options.frame(float64s, offset, i, w, frame)
+		frames[i] = fft.FFTReal(frame)[:bins]
+	}
+	return frames
+}
+
+// istft returns length samples from the short-time Fourier transform frames, which are typically
+// created by stft. The missing bins are mirrored from the stored bins, each frame is transformed
+// back with an inverse FFT, windowed with the synthesis window (which is the analysis window)
+// and added to the samples. Dividing by the sum of the squared windows that overlap each sample
+// then makes the analysis and synthesis exact, for any window and hop size. Samples where all
+// windows are zero, like the very first sample when using a Hann window without overlap, are 0.
+func istft(frames [][]complex128, length int, options SpectrogramOptions) []float64 {
+	return istftAt(frames, 0, length, options)
+}
+
+// istftAt is like istft, but the first of the length samples is at the given position, which can be negative
+func istftAt(frames [][]complex128, offset, length int, options SpectrogramOptions) []float64 {
+	n, bins := options.TransformSize(), options.Bins()
+	w := options.Window.Coefficients(options.FFTSize, options.WindowParameter)
+	float64s := make([]float64, length)
+	windowSum := make([]float64, length)
+	spectrum := make([]complex128, n)
+	for column, bin := range frames {
+		// Restore the Hermitian-symmetric spectrum of a real frame
+		for k := range spectrum {
+			switch {
+			case k < bins && k < len(bin):
+				spectrum[k] = bin[k]
+			case k >= bins && n-k < len(bin):
+				spectrum[k] = cmplx.Conj(bin[n-k])
+			default:
+				spectrum[k] = 0
+			}
+		}
+		frame := fft.IFFT(spectrum)
+
+		// Overlap-add the windowed frame
+		start := options.frameStart(column) - offset
+		for i := 0; i < options.FFTSize; i++ {
+			if pos := start + i; pos >= 0 && pos < length {
+				float64s[pos] += real(frame[i]) * w[i]
+				windowSum[pos] += w[i] * w[i]
+			}
+		}
+	}
+
+	// Normalise by the window sum
+	const minWindowSum = 1e-10
+	for i, sum := range windowSum {
+		if sum > minWindowSum {
+			float64s[i] /= sum
+		} else {
+			float64s[i] = 0
+		}
+	}
+	return float64s
+}
@@ -0,0 +1,150 @@
+package wavecarve
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"math"
+	"testing"
+)
+
+// testAudio returns audio with a different mix of sinusoids in each channel, with a length that
+// is not a multiple of the frame sizes
+func testAudio(numChannels int) *Audio {
+	audio := NewAudio(numChannels, 5003, 16000)
+	for c, samples := range audio.Channels {
+		for i := range samples {
+			samples[i] = 0.5*math.Sin(float64(i)*0.01*float64(c+1)) + 0.1*math.Cos(float64(i)*1.3)
+		}
+	}
+	return audio
+}
+
+// maxDifference returns the largest difference between the samples of the two audio signals,
+// or +Inf if they do not have the same number of channels and samples
+func maxDifference(a, b *Audio) float64 {
+	if len(a.Channels) != len(b.Channels) {
+		return math.Inf(1)
+	}
+	difference := 0.0
+	for c := range a.Channels {
+		if len(a.Channels[c]) != len(b.Channels[c]) {
+			return math.Inf(1)
+		}
+		for i, sample := range a.Channels[c] {
+			difference = math.Max(difference, math.Abs(sample-b.Channels[c][i]))
+		}
+	}
+	return difference
+}
+
+func TestInverseSTFT(t *testing.T) {
+	tests := []struct {
+		name    string
+		options SpectrogramOptions
+	}{
+		{"rectangular", SpectrogramOptions{FFTSize: 1024, HopSize: 1024, Window: WindowRectangular}},
+		{"rectangular with zero padding", SpectrogramOptions{FFTSize: 1000, HopSize: 1000, Window: WindowRectangular, ZeroPadding: 24}},
+		{"hann", SpectrogramOptions{FFTSize: 1024, HopSize: 256, Window: WindowHann}},
+		{"hamming", SpectrogramOptions{FFTSize: 512, HopSize: 128, Window: WindowHamming}},
+		{"blackman", SpectrogramOptions{FFTSize: 512, HopSize: 128, Window: WindowBlackman}},
+		{"kaiser with odd sizes", SpectrogramOptions{FFTSize: 999, HopSize: 333, Window: WindowKaiser}},
+	}
+	audio := testAudio(2)
+	for _, tt := range tests {
+		channels := make([][]float64, audio.NumChannels())
+		for c, samples := range audio.Channels {
+			channels[c] = istft(stft(samples, tt.options), len(samples), tt.options)
+		}
+		if difference := maxDifference(audio, audio.WithChannels(channels)); difference > 1e-12 {
+			t.Errorf("%s: the largest difference is %g", tt.name, difference)
+		}
+	}
+}
+
+// oneColumn returns the percentage that carves the given number of columns down to one
+func oneColumn(columns int) float64 {
+	return 150 / float64(columns)
+}
+
+func TestCarveSpectrograms(t *testing.T) {
+	audio := testAudio(2)
+	spectrograms, err := audio.Spectrograms(SpectrogramOptions{FFTSize: 512, HopSize: 128, Window: WindowHann})
+	if err != nil {
+		t.Fatal(err)
+	}
+	width := spectrograms[0].Columns()
+	tests := []struct {
+		name       string
+		percentage float64
+		columns    int
+	}{
+		{"unchanged", 100, width},
+		{"half", 50, width / 2},
+		{"one column", oneColumn(width), 1},
+	}
+	for _, tt := range tests {
+		carved, err := CarveSpectrograms(spectrograms, tt.percentage)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for c, s := range carved {
+			if s.Columns() != tt.columns {
+				t.Errorf("%s: channel %d has %d columns, want %d", tt.name, c, s.Columns(), tt.columns)
+			}
+		}
+		recreated, err := AudioFromSpectrograms(carved)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if recreated.NumChannels() != 2 || recreated.Frames() > audio.Frames() {
+			t.Errorf("%s: got %d channels of %d samples from %d samples", tt.name, recreated.NumChannels(), recreated.Frames(), audio.Frames())
+		}
+	}
+	if _, err := CarveSpectrograms(spectrograms, 0); err == nil {
+		t.Error("carving to no columns did not fail")
+	}
+}
+
+// pngRoundTrip encodes the image as a PNG file in memory and decodes it again
+func pngRoundTrip(t *testing.T, img image.Image) image.Image {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestSpectrogramImageRoundTrip(t *testing.T) {
+	tests := []struct {
+		encoding  ImageEncoding
+		tolerance float64
+	}{
+		{EncodingRGBA8, 0.05},
+		{EncodingRGBA16, 5e-4},
+	}
+	audio := testAudio(1)
+	spectrograms, err := audio.Spectrograms(SpectrogramOptions{FFTSize: 1024, HopSize: 256, Window: WindowHann})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		img, err := spectrograms[0].ToImage(tt.encoding)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.encoding, err)
+		}
+		spectrogram, err := SpectrogramFromImage(pngRoundTrip(t, img), spectrograms[0].Header(tt.encoding))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.encoding, err)
+		}
+		recreated := audio.WithChannels([][]float64{spectrogram.Samples()})
+		if difference := maxDifference(audio, recreated); difference > tt.tolerance {
+			t.Errorf("%s: the largest difference is %g", tt.encoding, difference)
+		}
+	}
+}
@@ -0,0 +1,579 @@
+package wavecarve
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The names of the short-time Fourier transform and of band spectrograms in the transform registry.
+// Their SpectrogramHeader has an empty Transform, for compatibility with older images.
+const (
+	stftTransform = "stft"
+	bandTransform = "bands"
+)
+
+// Representation is one channel of audio in the domain of a Transform, like a *Spectrogram,
+// a *BandSpectrogram, a *ConstantQSpectrogram, an *MDCTSpectrogram or a *WaveletPacketSpectrogram
+type Representation interface {
+	// Columns returns the number of columns, which is the width of an image of the representation
+	Columns() int
+}
+
+// Transform converts audio to one Representation per channel and back, and converts the
+// representations to and from images that can be carved or edited. New transforms can be
+// added with RegisterTransform, and are then used by the same code as the built-in ones.
+type Transform interface {
+	// Name returns the name of the transform in the registry, which is also the Transform of the
+	// SpectrogramHeader of its images
+	Name() string
+
+	// Analyze converts each channel of the audio to a representation
+	Analyze(audio *Audio) ([]Representation, error)
+
+	// Synthesize converts one representation per channel back to audio. The samples are not quantized.
+	Synthesize(representations []Representation) (*Audio, error)
+
+	// Images converts one representation per channel to images with the given encoding, and returns
+	// them together with the header that is needed for converting them back
+	Images(representations []Representation, encoding ImageEncoding) ([]image.Image, SpectrogramHeader, error)
+
+	// FromImage converts an image of one or more stacked representations back to one per channel.
+	// It validates the header, with SpectrogramHeader.Validate for the fields that all transforms
+	// have and with its own checks of the options, and SplitImage splits the image into channels.
+	FromImage(img image.Image, header SpectrogramHeader) ([]Representation, error)
+
+	// Carve removes seams from the representations to reduce their width by the given percentage,
+	// removing the same seams from all of the channels
+	Carve(representations []Representation, newWidthInPercentage float64) ([]Representation, error)
+}
+
+var (
+	transformsMutex sync.RWMutex
+
+	// transforms holds the registered transforms, by their name in lowercase
+	transforms = map[string]Transform{
+		stftTransform:          STFTTransform{},
+		bandTransform:          BandTransform{},
+		constantQTransform:     ConstantQTransform{},
+		mdctTransform:          MDCTTransform{},
+		waveletPacketTransform: WaveletPacketTransform{},
+	}
+)
+
+// RegisterTransform adds a transform to the registry, by its name. A transform that is already
+// registered with the same name is replaced, which can be used for changing the options of one
+// of the built-in transforms: "stft", "bands", "cqt", "mdct" and "wpt".
+func RegisterTransform(transform Transform) error {
+	name := strings.ToLower(transform.Name())
+	if name == "" {
+		return errors.New("a transform must have a name")
+	}
+	transformsMutex.Lock()
+	defer transformsMutex.Unlock()
+	transforms[name] = transform
+	return nil
+}
+
+// LookupTransform returns the registered transform with the given name, which is not case sensitive
+func LookupTransform(name string) (Transform, error) {
+	transformsMutex.RLock()
+	transform, ok := transforms[strings.ToLower(name)]
+	transformsMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown transform %q, expected one of: %s", name, strings.Join(TransformNames(), ", "))
+	}
+	return transform, nil
+}
+
+// TransformNames returns the names of the registered transforms, in alphabetical order
+func TransformNames() []string {
+	transformsMutex.RLock()
+	defer transformsMutex.RUnlock()
+	names := make([]string, 0, len(transforms))
+	for name := range transforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TransformForHeader returns the registered transform that can convert an image with the given header
+// back to audio. Images of a Spectrogram or a BandSpectrogram have no Transform in their header.
+func TransformForHeader(header SpectrogramHeader) (Transform, error) {
+	switch {
+	case header.Transform != "":
+		return LookupTransform(header.Transform)
+	case header.Scale != "":
+		return LookupTransform(bandTransform)
+	}
+	return LookupTransform(stftTransform)
+}
+
+// CarveAudioWithTransform is like CarveAudio, but with any transform instead of the STFT. The audio is
+// converted to one representation per channel, seams are removed from the representations to reduce their
+// width by the given percentage, and they are converted back, with the same format as the audio and the
+// positions in the metadata scaled to the new length, like with Audio.WithResizedChannels.
+func CarveAudioWithTransform(audio *Audio, transform Transform, newWidthInPercentage float64) (*Audio, error) {
+	representations, err := transform.Analyze(audio)
+	if err != nil {
+		return nil, err
+	}
+	if representations, err = transform.Carve(representations, newWidthInPercentage); err != nil {
+		return nil, err
+	}
+	carved, err := transform.Synthesize(representations)
+	if err != nil {
+		return nil, err
+	}
+	return audio.WithResizedChannels(carved.Channels), nil
+}
+
+// toRepresentations converts a slice of spectrograms to a slice of representations
+func toRepresentations[T Representation](spectrograms []T) []Representation {
+	representations := make([]Representation, len(spectrograms))
+	for i, s := range spectrograms {
+		representations[i] = s
+	}
+	return representations
+}
+
+// fromRepresentations converts a slice of representations back to spectrograms of the given transform
+func fromRepresentations[T Representation](name string, representations []Representation) ([]T, error) {
+	spectrograms := make([]T, len(representations))
+	for i, r := range representations {
+		s, ok := r.(T)
+		if !ok {
+			return nil, fmt.Errorf("the %s transform can not use a %T", name, r)
+		}
+		spectrograms[i] = s
+	}
+	return spectrograms, nil
+}
+
+// spectrogramTransform implements Transform for one type of representation, with the functions that
+// work with one representation per channel. The built-in transforms create one with their options.
+type spectrogramTransform[T Representation] struct {
+	name       string
+	analyze    func(audio *Audio) ([]T, error)
+	synthesize func(spectrograms []T) (*Audio, error)
+	images     func(spectrograms []T, encoding ImageEncoding) ([]image.Image, SpectrogramHeader, error)
+	validate   func(header SpectrogramHeader, width, height int) error
+	fromImage  func(img image.Image, header SpectrogramHeader) (T, error)
+	carve      func(spectrograms []T, newWidthInPercentage float64) ([]T, error)
+}
+
+func (t spectrogramTransform[T]) Name() string {
+	return t.name
+}
+
+func (t spectrogramTransform[T]) Analyze(audio *Audio) ([]Representation, error) {
+	spectrograms, err := t.analyze(audio)
+	if err != nil {
+		return nil, err
+	}
+	return toRepresentations(spectrograms), nil
+}
+
+func (t spectrogramTransform[T]) Synthesize(representations []Representation) (*Audio, error) {
+	spectrograms, err := fromRepresentations[T](t.name, representations)
+	if err != nil {
+		return nil, err
+	}
+	return t.synthesize(spectrograms)
+}
+
+func (t spectrogramTransform[T]) Images(representations []Representation, encoding ImageEncoding) ([]image.Image, SpectrogramHeader, error) {
+	spectrograms, err := fromRepresentations[T](t.name, representations)
+	if err != nil {
+		return nil, SpectrogramHeader{}, err
+	}
+	return t.images(spectrograms, encoding)
+}
+
+// FromImage validates the header against the whole image, with Validate and the checks of the
+// transform, and then converts the image of each channel back to a representation
+func (t spectrogramTransform[T]) FromImage(img image.Image, header SpectrogramHeader) ([]Representation, error) {
+	bounds := img.Bounds()
+	if err := header.Validate(bounds.Dx(), bounds.Dy()); err != nil {
+		return nil, err
+	}
+	if err := t.validate(header, bounds.Dx(), bounds.Dy()); err != nil {
+		return nil, err
+	}
+	imgs, err := SplitImage(img, header.Channels)
+	if err != nil {
+		return nil, err
+	}
+	channelHeader := header
+	channelHeader.Channels = 1
+	representations := make([]Representation, len(imgs))
+	for c, channelImg := range imgs {
+		spectrogram, err := t.fromImage(channelImg, channelHeader)
+		if err != nil {
+			return nil, fmt.Errorf("channel %d: %w", c, err)
+		}
+		representations[c] = spectrogram
+	}
+	return representations, nil
+}
+
+func (t spectrogramTransform[T]) Carve(representations []Representation, newWidthInPercentage float64) ([]Representation, error) {
+	spectrograms, err := fromRepresentations[T](t.name, representations)
+	if err != nil {
+		return nil, err
+	}
+	if spectrograms, err = t.carve(spectrograms, newWidthInPercentage); err != nil {
+		return nil, err
+	}
+	return toRepresentations(spectrograms), nil
+}
+
+// mappingOrDefault returns the given mapping, or DefaultMagnitudeMapping if it is the zero value
+func mappingOrDefault(mapping MagnitudeMapping) MagnitudeMapping {
+	if mapping == (MagnitudeMapping{}) {
+		return DefaultMagnitudeMapping
+	}
+	return mapping
+}
+
+// STFTTransform is the short-time Fourier transform of Spectrogram, with one row per frequency bin
+type STFTTransform struct {
+	// Options configures the STFT, or DefaultSpectrogramOptions is used if it is the zero value
+	Options SpectrogramOptions
+
+	// Phase configures how the phase is found when the spectrograms are converted back to audio,
+	// or DefaultPhaseOptions is used if it is the zero value
+	Phase PhaseOptions
+
+	// Mapping configures how the magnitudes are mapped to pixel values, or DefaultMagnitudeMapping is
+	// used if it is the zero value
+	Mapping MagnitudeMapping
+}
+
+// transform returns the implementation of the STFT transform, with the defaults filled in
+func (t STFTTransform) transform() spectrogramTransform[*Spectrogram] {
+	options, phase, mapping := t.Options, t.Phase, mappingOrDefault(t.Mapping)
+	if options == (SpectrogramOptions{}) {
+		options = DefaultSpectrogramOptions
+	}
+	if phase == (PhaseOptions{}) {
+		phase = DefaultPhaseOptions
+	}
+	return spectrogramTransform[*Spectrogram]{
+		name: stftTransform,
+		analyze: func(audio *Audio) ([]*Spectrogram, error) {
+			return audio.Spectrograms(options)
+		},
+		synthesize: func(spectrograms []*Spectrogram) (*Audio, error) {
+			return AudioFromSpectrogramsWithPhase(spectrograms, phase)
+		},
+		images: func(spectrograms []*Spectrogram, encoding ImageEncoding) ([]image.Image, SpectrogramHeader, error) {
+			return SpectrogramImagesWithMapping(spectrograms, encoding, mapping)
+		},
+		validate:  SpectrogramHeader.validateSpectrogram,
+		fromImage: SpectrogramFromImage,
+		carve:     CarveSpectrograms,
+	}
+}
+
+// Name returns "stft"
+func (t STFTTransform) Name() string {
+	return stftTransform
+}
+
+// Analyze creates one spectrogram per channel, with Audio.Spectrograms
+func (t STFTTransform) Analyze(audio *Audio) ([]Representation, error) {
+	return t.transform().Analyze(audio)
+}
+
+// Synthesize creates audio from one *Spectrogram per channel, with AudioFromSpectrogramsWithPhase
+func (t STFTTransform) Synthesize(representations []Representation) (*Audio, error) {
+	return t.transform().Synthesize(representations)
+}
+
+// Images converts one *Spectrogram per channel to images, with SpectrogramImagesWithMapping
+func (t STFTTransform) Images(representations []Representation, encoding ImageEncoding) ([]image.Image, SpectrogramHeader, error) {
+	return t.transform().Images(representations, encoding)
+}
+
+// FromImage validates the header and converts an image back to one *Spectrogram per channel, with SpectrogramFromImage
+func (t STFTTransform) FromImage(img image.Image, header SpectrogramHeader) ([]Representation, error) {
+	return t.transform().FromImage(img, header)
+}
+
+// Carve removes seams from one *Spectrogram per channel, with CarveSpectrograms
+func (t STFTTransform) Carve(representations []Representation, newWidthInPercentage float64) ([]Representation, error) {
+	return t.transform().Carve(representations, newWidthInPercentage)
+}
+
+// BandTransform gives the mel, Bark or log-frequency bands of BandSpectrogram, which are always
+// converted to images with EncodingGray16. The bins are estimated from the bands with the Inversion,
+// and the phase is found with the Phase options, when the bands are converted back to audio.
+type BandTransform struct {
+	// Options configures the STFT, or DefaultSpectrogramOptions is used if it is the zero value
+	Options SpectrogramOptions
+
+	// Filterbank configures the bands, or DefaultFilterbankOptions is used if it is the zero value
+	Filterbank FilterbankOptions
+
+	Inversion BandInversion
+
+	// Phase configures how the phase is found, or DefaultPhaseOptions is used if it is the zero value
+	Phase PhaseOptions
+
+	// Mapping configures how the magnitudes are mapped to pixel values, or DefaultMagnitudeMapping is
+	// used if it is the zero value
+	Mapping MagnitudeMapping
+}
+
+// transform returns the implementation of the band transform, with the defaults filled in
+func (t BandTransform) transform() spectrogramTransform[*BandSpectrogram] {
+	options, filterbank, phase, mapping := t.Options, t.Filterbank, t.Phase, mappingOrDefault(t.Mapping)
+	if options == (SpectrogramOptions{}) {
+		options = DefaultSpectrogramOptions
+	}
+	if filterbank == (FilterbankOptions{}) {
+		filterbank = DefaultFilterbankOptions
+	}
+	if phase == (PhaseOptions{}) {
+		phase = DefaultPhaseOptions
+	}
+	return spectrogramTransform[*BandSpectrogram]{
+		name: bandTransform,
+		analyze: func(audio *Audio) ([]*BandSpectrogram, error) {
+			return audio.BandSpectrograms(options, filterbank)
+		},
+		synthesize: func(spectrograms []*BandSpectrogram) (*Audio, error) {
+			return AudioFromBandSpectrogramsWithPhase(spectrograms, t.Inversion, phase)
+		},
+		images: func(spectrograms []*BandSpectrogram, encoding ImageEncoding) ([]image.Image, SpectrogramHeader, error) {
+			if encoding != EncodingGray16 {
+				return nil, SpectrogramHeader{}, fmt.Errorf("the %s encoding can not be used for frequency bands, only %s", encoding, EncodingGray16)
+			}
+			return BandSpectrogramImagesWithMapping(spectrograms, mapping)
+		},
+		validate:  SpectrogramHeader.validateBands,
+		fromImage: BandSpectrogramFromImage,
+		carve:     CarveBandSpectrograms,
+	}
+}
+
+// Name returns "bands"
+func (t BandTransform) Name() string {
+	return bandTransform
+}
+
+// Analyze creates one band spectrogram per channel, with Audio.BandSpectrograms
+func (t BandTransform) Analyze(audio *Audio) ([]Representation, error) {
+	return t.transform().Analyze(audio)
+}
+
+// Synthesize creates audio from one *BandSpectrogram per channel, with AudioFromBandSpectrogramsWithPhase
+func (t BandTransform) Synthesize(representations []Representation) (*Audio, error) {
+	return t.transform().Synthesize(representations)
+}
+
+// Images converts one *BandSpectrogram per channel to images, with BandSpectrogramImagesWithMapping.
+// The encoding must be EncodingGray16.
+func (t BandTransform) Images(representations []Representation, encoding ImageEncoding) ([]image.Image, SpectrogramHeader, error) {
+	return t.transform().Images(representations, encoding)
+}
+
+// FromImage validates the header and converts an image back to one *BandSpectrogram per channel, with BandSpectrogramFromImage
+func (t BandTransform) FromImage(img image.Image, header SpectrogramHeader) ([]Representation, error) {
+	return t.transform().FromImage(img, header)
+}
+
+// Carve removes seams from one *BandSpectrogram per channel, with CarveBandSpectrograms
+func (t BandTransform) Carve(representations []Representation, newWidthInPercentage float64) ([]Representation, error) {
+	return t.transform().Carve(representations, newWidthInPercentage)
+}
+
+// ConstantQTransform is the invertible constant-Q transform of ConstantQSpectrogram
+type ConstantQTransform struct {
+	// Options configures the constant-Q transform, or DefaultConstantQOptions is used if it is the zero value
+	Options ConstantQOptions
+
+	// Mapping configures how the magnitudes are mapped to pixel values, or DefaultMagnitudeMapping is
+	// used if it is the zero value
+	Mapping MagnitudeMapping
+}
+
+// transform returns the implementation of the constant-Q transform, with the defaults filled in
+func (t ConstantQTransform) transform() spectrogramTransform[*ConstantQSpectrogram] {
+	options, mapping := t.Options, mappingOrDefault(t.Mapping)
+	if options == (ConstantQOptions{}) {
+		options = DefaultConstantQOptions
+	}
+	return spectrogramTransform[*ConstantQSpectrogram]{
+		name: constantQTransform,
+		analyze: func(audio *Audio) ([]*ConstantQSpectrogram, error) {
+			return audio.ConstantQSpectrograms(options)
+		},
+		synthesize: AudioFromConstantQSpectrograms,
+		images: func(spectrograms []*ConstantQSpectrogram, encoding ImageEncoding) ([]image.Image, SpectrogramHeader, error) {
+			return ConstantQSpectrogramImagesWithMapping(spectrograms, encoding, mapping)
+		},
+		validate:  SpectrogramHeader.validateConstantQ,
+		fromImage: ConstantQSpectrogramFromImage,
+		carve:     CarveConstantQSpectrograms,
+	}
+}
+
+// Name returns "cqt"
+func (t ConstantQTransform) Name() string {
+	return constantQTransform
+}
+
+// Analyze creates one constant-Q spectrogram per channel, with Audio.ConstantQSpectrograms
+func (t ConstantQTransform) Analyze(audio *Audio) ([]Representation, error) {
+	return t.transform().Analyze(audio)
+}
+
+// Synthesize creates audio from one *ConstantQSpectrogram per channel, with AudioFromConstantQSpectrograms
+func (t ConstantQTransform) Synthesize(representations []Representation) (*Audio, error) {
+	return t.transform().Synthesize(representations)
+}
+
+// Images converts one *ConstantQSpectrogram per channel to images, with ConstantQSpectrogramImagesWithMapping
+func (t ConstantQTransform) Images(representations []Representation, encoding ImageEncoding) ([]image.Image, SpectrogramHeader, error) {
+	return t.transform().Images(representations, encoding)
+}
+
+// FromImage validates the header and converts an image back to one *ConstantQSpectrogram per channel, with ConstantQSpectrogramFromImage
+func (t ConstantQTransform) FromImage(img image.Image, header SpectrogramHeader) ([]Representation, error) {
+	return t.transform().FromImage(img, header)
+}
+
+// Carve removes seams from one *ConstantQSpectrogram per channel, with CarveConstantQSpectrograms
+func (t ConstantQTransform) Carve(representations []Representation, newWidthInPercentage float64) ([]Representation, error) {
+	return t.transform().Carve(representations, newWidthInPercentage)
+}
+
+// MDCTTransform is the modified discrete cosine transform of MDCTSpectrogram, which needs the
+// EncodingSigned8 or EncodingSigned16 encodings
+type MDCTTransform struct {
+	// Options configures the MDCT, or DefaultMDCTOptions is used if it is the zero value
+	Options MDCTOptions
+
+	// Mapping configures how the magnitudes are mapped to pixel values, or DefaultMagnitudeMapping is
+	// used if it is the zero value
+	Mapping MagnitudeMapping
+}
+
+// transform returns the implementation of the MDCT transform, with the defaults filled in
+func (t MDCTTransform) transform() spectrogramTransform[*MDCTSpectrogram] {
+	options, mapping := t.Options, mappingOrDefault(t.Mapping)
+	if options == (MDCTOptions{}) {
+		options = DefaultMDCTOptions
+	}
+	return spectrogramTransform[*MDCTSpectrogram]{
+		name: mdctTransform,
+		analyze: func(audio *Audio) ([]*MDCTSpectrogram, error) {
+			return audio.MDCTSpectrograms(options)
+		},
+		synthesize: AudioFromMDCTSpectrograms,
+		images: func(spectrograms []*MDCTSpectrogram, encoding ImageEncoding) ([]image.Image, SpectrogramHeader, error) {
+			return MDCTSpectrogramImagesWithMapping(spectrograms, encoding, mapping)
+		},
+		validate:  SpectrogramHeader.validateMDCT,
+		fromImage: MDCTSpectrogramFromImage,
+		carve:     CarveMDCTSpectrograms,
+	}
+}
+
+// Name returns "mdct"
+func (t MDCTTransform) Name() string {
+	return mdctTransform
+}
+
+// Analyze creates one MDCT spectrogram per channel, with Audio.MDCTSpectrograms
+func (t MDCTTransform) Analyze(audio *Audio) ([]Representation, error) {
+	return t.transform().Analyze(audio)
+}
+
+// Synthesize creates audio from one *MDCTSpectrogram per channel, with AudioFromMDCTSpectrograms
+func (t MDCTTransform) Synthesize(representations []Representation) (*Audio, error) {
+	return t.transform().Synthesize(representations)
+}
+
+// Images converts one *MDCTSpectrogram per channel to images, with MDCTSpectrogramImagesWithMapping
+func (t MDCTTransform) Images(representations []Representation, encoding ImageEncoding) ([]image.Image, SpectrogramHeader, error) {
+	return t.transform().Images(representations, encoding)
+}
+
+// FromImage validates the header and converts an image back to one *MDCTSpectrogram per channel, with MDCTSpectrogramFromImage
+func (t MDCTTransform) FromImage(img image.Image, header SpectrogramHeader) ([]Representation, error) {
+	return t.transform().FromImage(img, header)
+}
+
+// Carve removes seams from one *MDCTSpectrogram per channel, with CarveMDCTSpectrograms
+func (t MDCTTransform) Carve(representations []Representation, newWidthInPercentage float64) ([]Representation, error) {
+	return t.transform().Carve(representations, newWidthInPercentage)
+}
+
+// WaveletPacketTransform is the wavelet packet transform of WaveletPacketSpectrogram, which needs the
+// EncodingSigned8 or EncodingSigned16 encodings
+type WaveletPacketTransform struct {
+	// Options configures the wavelet packets, or DefaultWaveletPacketOptions is used if it is the zero value
+	Options WaveletPacketOptions
+
+	// Mapping configures how the magnitudes are mapped to pixel values, or DefaultMagnitudeMapping is
+	// used if it is the zero value
+	Mapping MagnitudeMapping
+}
+
+// transform returns the implementation of the wavelet packet transform, with the defaults filled in
+func (t WaveletPacketTransform) transform() spectrogramTransform[*WaveletPacketSpectrogram] {
+	options, mapping := t.Options, mappingOrDefault(t.Mapping)
+	if options == (WaveletPacketOptions{}) {
+		options = DefaultWaveletPacketOptions
+	}
+	return spectrogramTransform[*WaveletPacketSpectrogram]{
+		name: waveletPacketTransform,
+		analyze: func(audio *Audio) ([]*WaveletPacketSpectrogram, error) {
+			return audio.WaveletPacketSpectrograms(options)
+		},
+		synthesize: AudioFromWaveletPacketSpectrograms,
+		images: func(spectrograms []*WaveletPacketSpectrogram, encoding ImageEncoding) ([]image.Image, SpectrogramHeader, error) {
+			return WaveletPacketSpectrogramImagesWithMapping(spectrograms, encoding, mapping)
+		},
+		validate:  SpectrogramHeader.validateWaveletPacket,
+		fromImage: WaveletPacketSpectrogramFromImage,
+		carve:     CarveWaveletPacketSpectrograms,
+	}
+}
+
+// Name returns "wpt"
+func (t WaveletPacketTransform) Name() string {
+	return waveletPacketTransform
+}
+
+// Analyze creates one wavelet packet spectrogram per channel, with Audio.WaveletPacketSpectrograms
+func (t WaveletPacketTransform) Analyze(audio *Audio) ([]Representation, error) {
+	return t.transform().Analyze(audio)
+}
+
+// Synthesize creates audio from one *WaveletPacketSpectrogram per channel, with AudioFromWaveletPacketSpectrograms
+func (t WaveletPacketTransform) Synthesize(representations []Representation) (*Audio, error) {
+	return t.transform().Synthesize(representations)
+}
+
+// Images converts one *WaveletPacketSpectrogram per channel to images, with WaveletPacketSpectrogramImagesWithMapping
+func (t WaveletPacketTransform) Images(representations []Representation, encoding ImageEncoding) ([]image.Image, SpectrogramHeader, error) {
+	return t.transform().Images(representations, encoding)
+}
+
+// FromImage validates the header and converts an image back to one *WaveletPacketSpectrogram per channel, with WaveletPacketSpectrogramFromImage
+func (t WaveletPacketTransform) FromImage(img image.Image, header SpectrogramHeader) ([]Representation, error) {
+	return t.transform().FromImage(img, header)
+}
+
+// Carve removes seams from one *WaveletPacketSpectrogram per channel, with CarveWaveletPacketSpectrograms
+func (t WaveletPacketTransform) Carve(representations []Representation, newWidthInPercentage float64) ([]Representation, error) {
+	return t.transform().Carve(representations, newWidthInPercentage)
+}
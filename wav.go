@@ -1,9 +1,13 @@
 package wavecarve
 
 import (
+	"bufio"
 	"encoding/binary"
-	"math"
+	"errors"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 // WAVHeader represents the header of a WAV file.
@@ -21,8 +25,20 @@ type WAVHeader struct {
 	BitsPerSample uint16
 	Subchunk2ID   [4]byte
 	Subchunk2Size uint32
+
+	// These are only used when AudioFormat is WaveFormatExtensible
+	ValidBitsPerSample uint16
+	ChannelMask        uint32
+	SubFormat          [16]byte
+
+	// Metadata from the LIST/INFO, bext, cue, smpl, id3 and iXML chunks, and the other chunks
+	// that are not needed for reading the audio data, or nil.
+	// This is written back out by WriteWavFile and the other functions that write .wav files.
+	Metadata *WavMetadata
 }
 
+// Default header values. Files that are read keep their own values, which
+// are found in the WAVHeader, and SampleRate is only used when there is no header.
 const (
 	// Assume 44100 Hz sample rate
 	SampleRate = 44100
@@ -66,22 +82,179 @@ func int16sToBytes(int16s []int16) []byte {
 func int16sToFloat64s(int16s []int16) []float64 {
 	float64s := make([]float64, len(int16s))
 	for i, int16 := range int16s {
-		float64s[i] = float64(int16) / math.MaxInt16
+		float64s[i] = float64(int16) / (1 << 15)
 	}
 	return float64s
 }
 
-// Convert a slice of float64s to a slice of int16s
-func float64sToInt16s(float64s []float64) []int16 {
+// Convert a slice of float64s to a slice of int16s, using the given 16-bit quantizer
+func float64sToInt16s(float64s []float64, q *Quantizer) []int16 {
 	int16s := make([]int16, len(float64s))
 	for i, float64 := range float64s {
-		int16s[i] = int16(float64 * math.MaxInt16)
+		int16s[i] = int16(q.Quantize(float64))
 	}
 	return int16s
 }
 
-// Read a .wav file
+// Duration returns the duration of the given number of samples per channel, at the sample rate in the header
+func (h WAVHeader) Duration(frames int) time.Duration {
+	if h.SampleRate == 0 {
+		return 0
+	}
+	return time.Duration(float64(frames) * float64(time.Second) / float64(h.SampleRate))
+}
+
+// int16ChannelsToFloat64s converts one slice of 16-bit samples per channel to samples in the range [-1, 1)
+func int16ChannelsToFloat64s(channels [][]int16) [][]float64 {
+	float64Channels := make([][]float64, len(channels))
+	for c, channel := range channels {
+		float64Channels[c] = make([]float64, len(channel))
+		for i, sample := range channel {
+			float64Channels[c][i] = float64(sample) / (1 << 15)
+		}
+	}
+	return float64Channels
+}
+
+// float64ChannelsToInt16s converts one slice of samples in the range [-1, 1] per channel to
+// 16-bit samples, with DefaultQuantizeOptions. The float64 slices are released as they are converted.
+// If any samples clipped, an ErrClipped error is returned together with the samples.
+func float64ChannelsToInt16s(channels [][]float64) ([][]int16, error) {
+	int16Channels := make([][]int16, len(channels))
+	clipped := 0
+	for c, channel := range channels {
+		options := DefaultQuantizeOptions
+		options.Seed += int64(c) // use different dither noise for each channel
+		q := NewQuantizer(16, 1, options)
+		int16Channels[c] = float64sToInt16s(channel, q)
+		clipped += q.Clipped()
+		channels[c] = nil
+	}
+	if clipped > 0 {
+		return int16Channels, ErrClipped{Samples: clipped}
+	}
+	return int16Channels, nil
+}
+
+// Deinterleave splits interleaved samples into one slice per channel
+func Deinterleave(int16s []int16, numChannels int) [][]int16 {
+	if numChannels < 1 {
+		numChannels = 1
+	}
+	frames := len(int16s) / numChannels
+	channels := make([][]int16, numChannels)
+	for c := range channels {
+		channels[c] = make([]int16, frames)
+		for i := range channels[c] {
+			channels[c][i] = int16s[i*numChannels+c]
+		}
+	}
+	return channels
+}
+
+// Interleave combines one slice of samples per channel into interleaved samples.
+// If the channels differ in length, the shortest length is used.
+func Interleave(channels [][]int16) []int16 {
+	if len(channels) == 0 {
+		return []int16{}
+	}
+	frames := len(channels[0])
+	for _, channel := range channels[1:] {
+		if len(channel) < frames {
+			frames = len(channel)
+		}
+	}
+	int16s := make([]int16, frames*len(channels))
+	for c, channel := range channels {
+		for i := 0; i < frames; i++ {
+			int16s[i*len(channels)+c] = channel[i]
+		}
+	}
+	return int16s
+}
+
+// ReadWavChunks returns a list of all the chunks in a .wav file
+func ReadWavChunks(filePath string) ([]RIFFChunk, error) {
+	// Open the .wav file
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Walk all the chunks, skipping the audio data
+	wr, err := NewWavReader(bufio.NewReader(file))
+	if err != nil {
+		return nil, err
+	}
+	if err := wr.ReadTrailingChunks(); err != nil {
+		return nil, err
+	}
+	return wr.Chunks(), nil
+}
+
+// ReadWavMetadata returns the metadata of a .wav file, or nil if there is none
+func ReadWavMetadata(filePath string) (*WavMetadata, error) {
+	// Open the .wav file
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Walk all the chunks, skipping the audio data
+	wr, err := NewWavReader(bufio.NewReader(file))
+	if err != nil {
+		return nil, err
+	}
+	if err := wr.ReadTrailingChunks(); err != nil {
+		return nil, err
+	}
+	return wr.Metadata(), nil
+}
+
+// Audio file formats, which are chosen by the file extension
+const (
+	formatWAV = iota
+	formatAIFF
+	formatAIFC
+	formatFLAC
+)
+
+// fileFormat returns the audio file format for the extension of the given path.
+// Files with other extensions than .aif, .aiff, .aifc and .flac are .wav files.
+func fileFormat(filePath string) int {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".aif", ".aiff":
+		return formatAIFF
+	case ".aifc":
+		return formatAIFC
+	case ".flac":
+		return formatFLAC
+	}
+	return formatWAV
+}
+
+// Read a .wav file. Metadata chunks are available in the Metadata field of the header.
+// AIFF and FLAC files are read too, if the file has one of their extensions.
+// Audio data that is not 16-bit is converted with DefaultQuantizeOptions, and if any
+// samples clipped, an ErrClipped error is returned together with the audio data.
+// If the file is truncated, the audio data that could be read is returned together
+// with an ErrTruncated error, which can be treated as a warning.
 func ReadWavFile(filePath string) ([]int16, WAVHeader, error) {
+	// AIFF and FLAC files are read by ReadWavFileFloat64, and converted to 16-bit samples
+	if fileFormat(filePath) != formatWAV {
+		channels, header, err := ReadWavFileFloat64(filePath)
+		if channels == nil {
+			return nil, WAVHeader{}, err
+		}
+		int16Channels, clipErr := float64ChannelsToInt16s(channels)
+		if err == nil {
+			err = clipErr
+		}
+		return Interleave(int16Channels), header, err
+	}
+
 	// Open the .wav file
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -89,26 +262,97 @@ func ReadWavFile(filePath string) ([]int16, WAVHeader, error) {
 	}
 	defer file.Close()
 
-	// Read the header
-	var header WAVHeader
-	if err := binary.Read(file, binary.LittleEndian, &header); err != nil {
+	// Walk the chunks and read the header
+	wr, err := NewWavReader(bufio.NewReader(file))
+	if err != nil {
 		return nil, WAVHeader{}, err
 	}
 
-	// Read the audio data
-	data := make([]byte, header.Subchunk2Size)
-	if _, err := file.Read(data); err != nil {
+	// Read the audio data. If the file is truncated, the audio data that is there is used.
+	data, err := wr.readAllData()
+	if err != nil && !errors.As(err, new(ErrTruncated)) {
 		return nil, WAVHeader{}, err
 	}
 
+	// Read the metadata chunks that follow the audio data. The audio data has
+	// already been read at this point, so a broken trailing chunk is not an error.
+	_ = wr.ReadTrailingChunks()
+	header := wr.Header()
+
 	// Convert the audio data to int16s
-	int16s := bytesToInt16s(data)
+	if header.SampleFormat() == WaveFormatPCM && header.BytesPerSample() == 2 {
+		return bytesToInt16s(data), header, err
+	}
+	float64s, convErr := bytesToFloat64s(data, header.SampleFormat(), header.BytesPerSample(), binary.LittleEndian, true)
+	if convErr != nil {
+		return nil, WAVHeader{}, convErr
+	}
+	q := NewQuantizer(16, int(header.NumChannels), DefaultQuantizeOptions)
+	int16s := float64sToInt16s(float64s, q)
+	if err == nil {
+		err = q.err()
+	}
+	return int16s, header, err
+}
+
+// Read a .wav file in any of the supported sample formats (8, 16, 24 and 32-bit PCM
+// and 32 and 64-bit IEEE float) and return one slice of samples in the range [-1, 1] per channel.
+// If the file is truncated, the audio data that could be read is returned together
+// with an ErrTruncated error, which can be treated as a warning.
+// Files with the .aif, .aiff, .aifc or .flac extension are read with ReadAiffFile or ReadFlacFile.
+func ReadWavFileFloat64(filePath string) ([][]float64, WAVHeader, error) {
+	switch fileFormat(filePath) {
+	case formatAIFF, formatAIFC:
+		return ReadAiffFile(filePath)
+	case formatFLAC:
+		return ReadFlacFile(filePath)
+	}
+
+	// Open the .wav file
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, WAVHeader{}, err
+	}
+	defer file.Close()
+
+	// Walk the chunks and read the header
+	wr, err := NewWavReader(bufio.NewReader(file))
+	if err != nil {
+		return nil, WAVHeader{}, err
+	}
+
+	// Read the audio data. If the file is truncated, the audio data that is there is used.
+	data, err := wr.readAllData()
+	if err != nil && !errors.As(err, new(ErrTruncated)) {
+		return nil, WAVHeader{}, err
+	}
 
-	return int16s, header, nil
+	// Read the metadata chunks that follow the audio data. The audio data has
+	// already been read at this point, so a broken trailing chunk is not an error.
+	_ = wr.ReadTrailingChunks()
+	header := wr.Header()
+
+	// Convert the audio data to float64s
+	float64s, convErr := bytesToFloat64s(data, header.SampleFormat(), header.BytesPerSample(), binary.LittleEndian, true)
+	if convErr != nil {
+		return nil, WAVHeader{}, convErr
+	}
+	return DeinterleaveFloat64s(float64s, int(header.NumChannels)), header, err
 }
 
-// Write a .wav file
-func WriteWavFile(filePath string, int16s []int16, header WAVHeader) error {
+// Read a .wav file and deinterleave the audio data into one slice per channel.
+// If the file is truncated, the audio data that could be read is returned together
+// with an ErrTruncated error, which can be treated as a warning.
+func ReadWavFileChannels(filePath string) ([][]int16, WAVHeader, error) {
+	int16s, header, err := ReadWavFile(filePath)
+	if int16s == nil {
+		return nil, WAVHeader{}, err
+	}
+	return Deinterleave(int16s, int(header.NumChannels)), header, err
+}
+
+// writeWavData creates a .wav file with the given header and audio data
+func writeWavData(filePath string, bytes []byte, header WAVHeader) error {
 	// Open the .wav file
 	file, err := os.Create(filePath)
 	if err != nil {
@@ -116,22 +360,110 @@ func WriteWavFile(filePath string, int16s []int16, header WAVHeader) error {
 	}
 	defer file.Close()
 
-	// Convert the int16s to bytes
-	bytes := int16sToBytes(int16s)
+	// Write the header
+	ww, err := NewWavWriter(file, header)
+	if err != nil {
+		return err
+	}
 
-	// Update the ChunkSize and Subchunk2Size in the header
-	header.ChunkSize = 36 + uint32(len(bytes)) // 4 (ChunkID) + (8 + Subchunk1Size) + (8 + Subchunk2Size)
-	header.Subchunk2Size = uint32(len(bytes))
+	// Write the audio data
+	if err := ww.writeData(bytes); err != nil {
+		return err
+	}
 
-	// Write the header
-	if err := binary.Write(file, binary.LittleEndian, &header); err != nil {
+	// Update the sizes in the header
+	if err := ww.Close(); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// Write a .wav file. The samples are converted to the sample format given in
+// the header (which is normally 16-bit PCM). AIFF and FLAC files are written
+// instead if the file path has one of their extensions.
+func WriteWavFile(filePath string, int16s []int16, header WAVHeader) error {
+	// AIFF and FLAC files are written by WriteWavFileFloat64
+	if fileFormat(filePath) != formatWAV {
+		channels := int16ChannelsToFloat64s(Deinterleave(int16s, int(header.NumChannels)))
+		return WriteWavFileFloat64(filePath, channels, header)
+	}
+	if header.SampleFormat() == WaveFormatPCM && header.BytesPerSample() == 2 {
+		return writeWavData(filePath, int16sToBytes(int16s), header)
+	}
+	q := NewQuantizer(header.BytesPerSample()*8, int(header.NumChannels), DefaultQuantizeOptions)
+	bytes, err := float64sToBytes(int16sToFloat64s(int16s), header.SampleFormat(), header.BytesPerSample(), binary.LittleEndian, true, q)
+	if err != nil {
+		return err
+	}
+	if err := writeWavData(filePath, bytes, header); err != nil {
+		return err
+	}
+	return q.err()
+}
+
+// Write a .wav file, where the audio data is given as one slice of samples in
+// the range [-1, 1] per channel. The samples are written in the sample format
+// given in the header, which can be 8, 16, 24 or 32-bit PCM or 32 or 64-bit IEEE float.
+// Files with the .aif, .aiff, .aifc or .flac extension are written with WriteAiffFile,
+// WriteAiffcFile or WriteFlacFile. Integer samples are quantized with DefaultQuantizeOptions,
+// and if any samples clipped, an ErrClipped error is returned after the file has been written.
+func WriteWavFileFloat64(filePath string, channels [][]float64, header WAVHeader) error {
+	return WriteWavFileFloat64WithOptions(filePath, channels, header, DefaultQuantizeOptions)
+}
+
+// WriteWavFileFloat64WithOptions is like WriteWavFileFloat64, but integer samples
+// are quantized with the given clipping, dither and noise shaping options.
+func WriteWavFileFloat64WithOptions(filePath string, channels [][]float64, header WAVHeader, options QuantizeOptions) error {
+	switch fileFormat(filePath) {
+	case formatAIFF:
+		return writeAiffFile(filePath, channels, header, options)
+	case formatAIFC:
+		if header.SampleFormat() == WaveFormatIEEEFloat {
+			return writeAiffFile(filePath, channels, header, options)
+		}
+		return writeAiff(filePath, channels, header, "AIFC", AiffCompressionNone, options)
+	case formatFLAC:
+		return writeFlacFile(filePath, channels, header, options)
+	}
+
+	// Open the .wav file
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Write the header, for the number of channels that are given
+	header.NumChannels = uint16(len(channels))
+	ww, err := NewWavWriterWithOptions(file, header, options)
+	if err != nil {
 		return err
 	}
 
 	// Write the audio data
-	if _, err := file.Write(bytes); err != nil {
+	if err := ww.WriteSamples(channels); err != nil {
 		return err
 	}
 
-	return nil
+	// Update the sizes in the header
+	if err := ww.Close(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	// Report the samples that clipped
+	return ww.quantizer.err()
+}
+
+// Write a .wav file, where the audio data is given as one slice per channel
+func WriteWavFileChannels(filePath string, channels [][]int16, header WAVHeader) error {
+	// Update the channel count and the values that depend on it
+	header.NumChannels = uint16(len(channels))
+	header.BlockAlign = header.NumChannels * ((header.BitsPerSample + 7) / 8)
+	header.ByteRate = header.SampleRate * uint32(header.BlockAlign)
+
+	return WriteWavFile(filePath, Interleave(channels), header)
 }
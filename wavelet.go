@@ -0,0 +1,596 @@
+package wavecarve
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"math/cmplx"
+	"strconv"
+	"strings"
+)
+
+// The name of the wavelet packet transform in the SpectrogramHeader
+const waveletPacketTransform = "wpt"
+
+// WaveletFamily selects the family of orthogonal wavelets of a Wavelet
+type WaveletFamily int
+
+const (
+	// WaveletDaubechies has the shortest filters for a given number of vanishing moments,
+	// with most of the energy at the start of the filter (minimum phase)
+	WaveletDaubechies WaveletFamily = iota
+
+	// WaveletSymlet has the same filter lengths and vanishing moments as WaveletDaubechies,
+	// but is as close to symmetric as an orthogonal wavelet can be (nearly linear phase)
+	WaveletSymlet
+)
+
+var waveletFamilyNames = [...]string{
+	WaveletDaubechies: "db",
+	WaveletSymlet:     "sym",
+}
+
+// The highest order of a Wavelet, where the roots of the filters can still be found precisely
+const maxWaveletOrder = 10
+
+// String returns the short name of the wavelet family, like "db"
+func (f WaveletFamily) String() string {
+	if f >= 0 && int(f) < len(waveletFamilyNames) {
+		return waveletFamilyNames[f]
+	}
+	return fmt.Sprintf("WaveletFamily(%d)", int(f))
+}
+
+// Wavelet is an orthogonal wavelet, with the number of vanishing moments in Order.
+// The filters are 2*Order coefficients long. Order 1 of both families is the Haar wavelet.
+type Wavelet struct {
+	Family WaveletFamily
+	Order  int
+}
+
+// String returns the name of the wavelet, as accepted by ParseWavelet, like "db4" or "sym8"
+func (w Wavelet) String() string {
+	return w.Family.String() + strconv.Itoa(w.Order)
+}
+
+// ParseWavelet returns the wavelet with the given name, like "db4", "sym8" or "haar"
+func ParseWavelet(name string) (Wavelet, error) {
+	if strings.EqualFold(name, "haar") {
+		return Wavelet{WaveletDaubechies, 1}, nil
+	}
+	for f, familyName := range waveletFamilyNames {
+		if len(name) > len(familyName) && strings.EqualFold(name[:len(familyName)], familyName) {
+			order, err := strconv.Atoi(name[len(familyName):])
+			if err != nil {
+				break
+			}
+			w := Wavelet{WaveletFamily(f), order}
+			return w, w.Validate()
+		}
+	}
+	return Wavelet{}, fmt.Errorf("unknown wavelet %q, expected haar, db1 to db%d or sym1 to sym%d", name, maxWaveletOrder, maxWaveletOrder)
+}
+
+// Validate returns an error if the wavelet is not known
+func (w Wavelet) Validate() error {
+	switch {
+	case w.Family < 0 || int(w.Family) >= len(waveletFamilyNames):
+		return fmt.Errorf("unknown wavelet family %d", int(w.Family))
+	case w.Order < 1 || w.Order > maxWaveletOrder:
+		return fmt.Errorf("invalid wavelet order %d, it must be from 1 to %d", w.Order, maxWaveletOrder)
+	}
+	return nil
+}
+
+// Filter returns the low-pass (scaling) filter of the wavelet, which sums to the square root of 2.
+// The filter is found by spectral factorization: its squared magnitude is given by the Daubechies
+// polynomial, and it is built from one root of each pair of reciprocal roots of that polynomial.
+// The Daubechies wavelets use the roots inside of the unit circle, and the Symlets use the roots
+// that give the most linear phase. The high-pass filter is the low-pass filter reversed, with
+// every other coefficient negated.
+func (w Wavelet) Filter() []float64 {
+	n := w.Order
+
+	// The Daubechies polynomial P(y) = sum(binomial(n-1+k, k) y^k), where y = sin^2(omega/2)
+	p := make([]float64, n)
+	binomial := 1.0
+	for k := range p {
+		p[k] = binomial
+		binomial = binomial * float64(n+k) / float64(k+1)
+	}
+
+	// Each root of P in y gives two reciprocal roots in z, since y = (2 - z - 1/z) / 4.
+	// The roots come in complex conjugate pairs, which must be chosen together.
+	var groups [][2]complex128 // the root inside of the unit circle, and its conjugate if it is complex
+	for _, y := range polynomialRoots(p) {
+		if imag(y) < -1e-9 {
+			continue
+		}
+		b := 2 - 4*y
+		z := (b - cmplx.Sqrt(b*b-4)) / 2
+		if cmplx.Abs(z) > 1 {
+			z = 1 / z
+		}
+		if math.Abs(imag(y)) <= 1e-9 {
+			groups = append(groups, [2]complex128{z, 0})
+		} else {
+			groups = append(groups, [2]complex128{z, cmplx.Conj(z)})
+		}
+	}
+
+	build := func(choice int) []float64 {
+		h := []complex128{1}
+		for i := 0; i < n; i++ {
+			h = multiplyPolynomial(h, 1)
+		}
+		for g, pair := range groups {
+			for i, z := range pair {
+				if i == 1 && pair[1] == 0 {
+					break
+				}
+				if choice&(1<<g) != 0 {
+					z = 1 / z
+				}
+				h = multiplyPolynomial(h, -z)
+			}
+		}
+		filter := make([]float64, len(h))
+		sum := 0.0
+		for i, v := range h {
+			filter[i] = real(v)
+			sum += filter[i]
+		}
+		for i := range filter {
+			filter[i] *= math.Sqrt2 / sum
+		}
+		return filter
+	}
+	if w.Family == WaveletDaubechies {
+		return build(0)
+	}
+	best, bestDeviation := build(0), math.Inf(1)
+	for choice := 0; choice < 1<<len(groups); choice++ {
+		filter := build(choice)
+		if deviation := phaseNonlinearity(filter); deviation < bestDeviation-1e-12 {
+			best, bestDeviation = filter, deviation
+		}
+	}
+	return best
+}
+
+// multiplyPolynomial multiplies a polynomial in 1/z, from the constant term up, by (1 + c/z)
+func multiplyPolynomial(p []complex128, c complex128) []complex128 {
+	result := make([]complex128, len(p)+1)
+	for i, v := range p {
+		result[i] += v
+		result[i+1] += c * v
+	}
+	return result
+}
+
+// polynomialRoots returns the complex roots of the polynomial with the given real coefficients,
+// from the constant term up, with the Durand-Kerner method
+func polynomialRoots(coefficients []float64) []complex128 {
+	degree := len(coefficients) - 1
+	if degree < 1 {
+		return nil
+	}
+	eval := func(z complex128) complex128 {
+		v := complex(coefficients[degree], 0)
+		for i := degree - 1; i >= 0; i-- {
+			v = v*z + complex(coefficients[i], 0)
+		}
+		return v / complex(coefficients[degree], 0)
+	}
+	roots := make([]complex128, degree)
+	for i := range roots {
+		roots[i] = cmplx.Pow(0.4+0.9i, complex(float64(i), 0))
+	}
+	for iteration := 0; iteration < 1000; iteration++ {
+		maxStep := 0.0
+		for i := range roots {
+			denominator := complex(1, 0)
+			for j := range roots {
+				if j != i {
+					denominator *= roots[i] - roots[j]
+				}
+			}
+			step := eval(roots[i]) / denominator
+			roots[i] -= step
+			maxStep = math.Max(maxStep, cmplx.Abs(step))
+		}
+		if maxStep < 1e-15 {
+			break
+		}
+	}
+	return roots
+}
+
+// phaseNonlinearity returns how far the phase of the frequency response of the filter is from
+// a straight line, as the residual of a least squares fit that is weighted by the magnitude
+func phaseNonlinearity(filter []float64) float64 {
+	const points = 64
+	var omegas, phases, weights []float64
+	previous := 0.0
+	for m := 0; m < points; m++ {
+		omega := math.Pi * (float64(m) + 0.5) / points
+		var response complex128
+		for i, v := range filter {
+			response += complex(v, 0) * cmplx.Exp(complex(0, -omega*float64(i)))
+		}
+		// Unwrap the phase
+		phase := cmplx.Phase(response)
+		if m > 0 {
+			phase = previous + princarg(phase-previous)
+		}
+		previous = phase
+		omegas = append(omegas, omega)
+		phases = append(phases, phase)
+		weights = append(weights, cmplx.Abs(response)*cmplx.Abs(response))
+	}
+	var sw, sx, sy, sxx, sxy float64
+	for i, x := range omegas {
+		sw += weights[i]
+		sx += weights[i] * x
+		sy += weights[i] * phases[i]
+		sxx += weights[i] * x * x
+		sxy += weights[i] * x * phases[i]
+	}
+	slope := (sw*sxy - sx*sy) / (sw*sxx - sx*sx)
+	intercept := (sy - slope*sx) / sw
+	deviation := 0.0
+	for i, x := range omegas {
+		r := phases[i] - intercept - slope*x
+		deviation += weights[i] * r * r
+	}
+	return deviation
+}
+
+// WaveletPacketOptions configures the wavelet packet transform of a WaveletPacketSpectrogram
+type WaveletPacketOptions struct {
+	Wavelet Wavelet
+
+	// Level is the number of times the audio is split into a low and a high half of the spectrum,
+	// which gives 2^Level rows of equally wide frequency bands, with 2^Level samples per column.
+	// A lower level gives sharper transients and a higher level gives sharper partials.
+	Level int
+}
+
+// The highest Level of WaveletPacketOptions
+const maxWaveletPacketLevel = 14
+
+// DefaultWaveletPacketOptions use the sym8 wavelet with 512 rows, like DefaultMDCTOptions
+var DefaultWaveletPacketOptions = WaveletPacketOptions{Wavelet: Wavelet{WaveletSymlet, 8}, Level: 9}
+
+// Validate returns an error if the options can not be used for creating a wavelet packet spectrogram
+func (o WaveletPacketOptions) Validate() error {
+	if err := o.Wavelet.Validate(); err != nil {
+		return err
+	}
+	if o.Level < 1 || o.Level > maxWaveletPacketLevel {
+		return fmt.Errorf("invalid wavelet packet level %d, it must be from 1 to %d", o.Level, maxWaveletPacketLevel)
+	}
+	return nil
+}
+
+// Bins returns the number of rows of a wavelet packet spectrogram, which is 2^Level
+func (o WaveletPacketOptions) Bins() int {
+	return 1 << o.Level
+}
+
+// Columns returns the number of columns of a wavelet packet spectrogram of the given number of samples.
+// The audio is padded with zeros up to a multiple of Bins() samples.
+func (o WaveletPacketOptions) Columns(length int) int {
+	if length <= 0 {
+		return 0
+	}
+	return (length + o.Bins() - 1) / o.Bins()
+}
+
+// waveletSplit splits the samples into their low and high half of the spectrum, with half as many
+// samples each, by filtering them with the low-pass and high-pass filters and keeping every other
+// sample. The samples wrap around at the end (periodization), which keeps the transform orthogonal.
+func waveletSplit(float64s, h []float64) (low, high []float64) {
+	n := len(float64s)
+	low, high = make([]float64, n/2), make([]float64, n/2)
+	last := len(h) - 1
+	for k := range low {
+		for j, c := range h {
+			v := float64s[(2*k+j)%n]
+			low[k] += c * v
+			if j%2 == 0 {
+				high[k] += h[last-j] * v
+			} else {
+				high[k] -= h[last-j] * v
+			}
+		}
+	}
+	return low, high
+}
+
+// waveletMerge is the inverse of waveletSplit
+func waveletMerge(low, high, h []float64) []float64 {
+	n := 2 * len(low)
+	float64s := make([]float64, n)
+	last := len(h) - 1
+	for k := range low {
+		for j, c := range h {
+			g := h[last-j]
+			if j%2 != 0 {
+				g = -g
+			}
+			float64s[(2*k+j)%n] += c*low[k] + g*high[k]
+		}
+	}
+	return float64s
+}
+
+// waveletPacketAnalyze returns the wavelet packet coefficients of the samples, with Bins() coefficients
+// per column. Every band is split again on each level. The high half of a band is mirrored in frequency
+// by the split, so the order of its halves is swapped, which keeps the rows in order of frequency.
+func waveletPacketAnalyze(float64s []float64, options WaveletPacketOptions) [][]float64 {
+	columns := options.Columns(len(float64s))
+	h := options.Wavelet.Filter()
+	padded := make([]float64, columns*options.Bins())
+	copy(padded, float64s)
+	bands := [][]float64{padded}
+	for level := 0; level < options.Level; level++ {
+		split := make([][]float64, 0, 2*len(bands))
+		for i, band := range bands {
+			low, high := waveletSplit(band, h)
+			if i%2 == 0 {
+				split = append(split, low, high)
+			} else {
+				split = append(split, high, low)
+			}
+		}
+		bands = split
+	}
+	coefficients := make([][]float64, columns)
+	for x := range coefficients {
+		coefficients[x] = make([]float64, len(bands))
+		for y, band := range bands {
+			coefficients[x][y] = band[x]
+		}
+	}
+	return coefficients
+}
+
+// waveletPacketSynthesize returns length samples from the wavelet packet coefficients,
+// which are typically created by waveletPacketAnalyze
+func waveletPacketSynthesize(coefficients [][]float64, length int, options WaveletPacketOptions) []float64 {
+	float64s := make([]float64, length)
+	if len(coefficients) == 0 {
+		return float64s
+	}
+	h := options.Wavelet.Filter()
+	bands := make([][]float64, options.Bins())
+	for y := range bands {
+		bands[y] = make([]float64, len(coefficients))
+		for x, column := range coefficients {
+			if y < len(column) {
+				bands[y][x] = column[y]
+			}
+		}
+	}
+	for len(bands) > 1 {
+		merged := make([][]float64, len(bands)/2)
+		for i := range merged {
+			if i%2 == 0 {
+				merged[i] = waveletMerge(bands[2*i], bands[2*i+1], h)
+			} else {
+				merged[i] = waveletMerge(bands[2*i+1], bands[2*i], h)
+			}
+		}
+		bands = merged
+	}
+	copy(float64s, bands[0])
+	return float64s
+}
+
+// WaveletPacketSpectrogram holds the coefficients of the wavelet packet transform of audio, which splits
+// the spectrum into equally wide bands with orthogonal wavelet filters. Like the MDCT, the transform is
+// real-valued and has as many coefficients as samples, and it can be inverted exactly. The filters are
+// shorter than the frames of a Spectrogram, which smears transients less.
+type WaveletPacketSpectrogram struct {
+	// Coefficients holds one slice of Options.Bins() coefficients per column, from 0 Hz at index 0
+	// up to the Nyquist frequency
+	Coefficients [][]float64
+
+	Options    WaveletPacketOptions
+	SampleRate uint32
+	Length     int
+}
+
+// NewWaveletPacketSpectrogram creates a wavelet packet spectrogram from samples in the range [-1, 1]
+func NewWaveletPacketSpectrogram(float64s []float64, sampleRate uint32, options WaveletPacketOptions) (*WaveletPacketSpectrogram, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	return &WaveletPacketSpectrogram{
+		Coefficients: waveletPacketAnalyze(float64s, options),
+		Options:      options,
+		SampleRate:   sampleRate,
+		Length:       len(float64s),
+	}, nil
+}
+
+// WaveletPacketSpectrograms creates one wavelet packet spectrogram per channel, with the given options
+func (a *Audio) WaveletPacketSpectrograms(options WaveletPacketOptions) ([]*WaveletPacketSpectrogram, error) {
+	spectrograms := make([]*WaveletPacketSpectrogram, a.NumChannels())
+	for c, channel := range a.Channels {
+		spectrogram, err := NewWaveletPacketSpectrogram(channel, a.SampleRate, options)
+		if err != nil {
+			return nil, err
+		}
+		spectrograms[c] = spectrogram
+	}
+	return spectrograms, nil
+}
+
+// AudioFromWaveletPacketSpectrograms creates audio from one wavelet packet spectrogram per channel,
+// with the sample rate of the first spectrogram. The samples are not quantized.
+func AudioFromWaveletPacketSpectrograms(spectrograms []*WaveletPacketSpectrogram) (*Audio, error) {
+	if len(spectrograms) == 0 {
+		return nil, errors.New("no spectrograms to create audio from")
+	}
+	audio := NewAudio(0, 0, spectrograms[0].SampleRate)
+	audio.Channels = make([][]float64, len(spectrograms))
+	for c, spectrogram := range spectrograms {
+		audio.Channels[c] = spectrogram.Samples()
+	}
+	return audio, nil
+}
+
+// Columns returns the number of columns of the wavelet packet spectrogram
+func (s *WaveletPacketSpectrogram) Columns() int {
+	return len(s.Coefficients)
+}
+
+// Samples creates audio from the wavelet packet spectrogram with the inverse transform. If the spectrogram
+// has not been modified, this gives back the samples it was created from, to within floating point precision.
+// The samples are not clipped, so they may be outside of the range [-1, 1].
+func (s *WaveletPacketSpectrogram) Samples() []float64 {
+	return waveletPacketSynthesize(s.Coefficients, s.Length, s.Options)
+}
+
+// gain returns what the coefficients are divided by in the images, so that a full scale sinusoid is at
+// up to about -6 dB, like in the images of a Spectrogram. The transform is orthonormal, so the energy of
+// a sinusoid ends up in one band with 2^Level times fewer samples.
+func (s *WaveletPacketSpectrogram) gain() float64 {
+	return 2 * math.Sqrt(float64(s.Options.Bins()))
+}
+
+// plane returns the coefficients, the number of rows and the gain of the wavelet packet spectrogram
+func (s *WaveletPacketSpectrogram) plane() ([][]float64, int, float64) {
+	return s.Coefficients, s.Options.Bins(), s.gain()
+}
+
+// kind returns what a wavelet packet spectrogram is called in error messages
+func (s *WaveletPacketSpectrogram) kind() string {
+	return "wavelet packet spectrogram"
+}
+
+// sameOptions returns true if the other wavelet packet spectrogram was created with the same options
+func (s *WaveletPacketSpectrogram) sameOptions(other *WaveletPacketSpectrogram) bool {
+	return s.Options == other.Options
+}
+
+// withCoefficients returns a copy of the wavelet packet spectrogram with the given coefficients,
+// which is 2^Level samples shorter per removed column
+func (s *WaveletPacketSpectrogram) withCoefficients(coefficients [][]float64, removedColumns int) *WaveletPacketSpectrogram {
+	c := *s
+	c.Coefficients = coefficients
+	c.Length -= removedColumns * s.Options.Bins()
+	if c.Length < 0 {
+		c.Length = 0
+	}
+	return &c
+}
+
+// ToImage converts the wavelet packet spectrogram to an image with one column per time step and one row
+// per band, with 0 Hz at the top, with DefaultMagnitudeMapping. Only EncodingSigned8 and EncodingSigned16
+// can be used, like for an MDCTSpectrogram.
+func (s *WaveletPacketSpectrogram) ToImage(encoding ImageEncoding) (image.Image, error) {
+	imgs, _, err := planeImages([]*WaveletPacketSpectrogram{s}, encoding, DefaultMagnitudeMapping)
+	if err != nil {
+		return nil, err
+	}
+	return imgs[0], nil
+}
+
+// header returns the header for an image of the wavelet packet spectrogram with the given encoding and mapping
+func (s *WaveletPacketSpectrogram) header(encoding ImageEncoding, mapping MagnitudeMapping) SpectrogramHeader {
+	return SpectrogramHeader{
+		Version:        SpectrogramHeaderVersion,
+		Encoding:       encoding.String(),
+		Channels:       1,
+		Length:         s.Length,
+		SampleRate:     s.SampleRate,
+		MinDB:          mapping.Floor,
+		MaxDB:          mapping.Ceiling,
+		Curve:          mapping.Curve.String(),
+		CurveParameter: mapping.CurveParameter,
+		Transform:      waveletPacketTransform,
+		Wavelet:        s.Options.Wavelet.String(),
+		Level:          s.Options.Level,
+	}
+}
+
+// WaveletPacketSpectrogramImages converts one wavelet packet spectrogram per channel to images with the
+// given encoding and DefaultMagnitudeMapping, and returns them together with the header that is needed
+// for converting them back, like SpectrogramImages
+func WaveletPacketSpectrogramImages(spectrograms []*WaveletPacketSpectrogram, encoding ImageEncoding) ([]image.Image, SpectrogramHeader, error) {
+	return WaveletPacketSpectrogramImagesWithMapping(spectrograms, encoding, DefaultMagnitudeMapping)
+}
+
+// WaveletPacketSpectrogramImagesWithMapping is like WaveletPacketSpectrogramImages, but with the given magnitude mapping
+func WaveletPacketSpectrogramImagesWithMapping(spectrograms []*WaveletPacketSpectrogram, encoding ImageEncoding, mapping MagnitudeMapping) ([]image.Image, SpectrogramHeader, error) {
+	return planeImages(spectrograms, encoding, mapping)
+}
+
+// validateWaveletPacket returns an error if a valid header is not for a WaveletPacketSpectrogram,
+// or if its options do not match an image of the given size
+func (h SpectrogramHeader) validateWaveletPacket(width, height int) error {
+	if h.Transform != waveletPacketTransform {
+		return errors.New("the spectrogram metadata is not for a wavelet packet spectrogram")
+	}
+	encoding, _ := h.ImageEncoding()
+	options, err := h.WaveletPacketOptions()
+	if err != nil {
+		return err
+	}
+	if encoding != EncodingSigned8 && encoding != EncodingSigned16 {
+		return fmt.Errorf("the %s encoding can not be used for wavelet packet spectrograms, only %s and %s", encoding, EncodingSigned8, EncodingSigned16)
+	}
+	switch {
+	case height != h.Channels*options.Bins():
+		return fmt.Errorf("the image is %d pixels high, but the metadata gives %d channels of %d wavelet packets", height, h.Channels, options.Bins())
+	case width != options.Columns(h.Length):
+		return fmt.Errorf("the image is %d pixels wide, but the metadata gives %d columns for %d samples", width, options.Columns(h.Length), h.Length)
+	}
+	return nil
+}
+
+// WaveletPacketSpectrogramFromImage converts an image that was created with WaveletPacketSpectrogram.ToImage
+// back to a wavelet packet spectrogram, with the options of the header, which is validated against the
+// size of the image
+func WaveletPacketSpectrogramFromImage(img image.Image, header SpectrogramHeader) (*WaveletPacketSpectrogram, error) {
+	if err := validateChannel(img, header, header.validateWaveletPacket); err != nil {
+		return nil, err
+	}
+	options, _ := header.WaveletPacketOptions()
+	return planeFromImage(img, header, &WaveletPacketSpectrogram{Options: options, SampleRate: header.SampleRate, Length: header.Length}), nil
+}
+
+// CarveWaveletPacketSpectrograms removes seams from one wavelet packet spectrogram per channel to reduce
+// their width by the given percentage, like CarveMDCTSpectrograms. The lengths of the carved spectrograms
+// are reduced by 2^Level samples per removed column.
+func CarveWaveletPacketSpectrograms(spectrograms []*WaveletPacketSpectrogram, newWidthInPercentage float64) ([]*WaveletPacketSpectrogram, error) {
+	return carvePlanes(spectrograms, newWidthInPercentage)
+}
+
+// CreateWaveletImageFromAudio creates an image of the wavelet packet coefficients of an []int16, with
+// DefaultWaveletPacketOptions, and the EncodingSigned8 encoding, like CreateMDCTImageFromAudio
+func CreateWaveletImageFromAudio(int16s []int16) (*image.RGBA, error) {
+	spectrogram, err := NewWaveletPacketSpectrogram(int16sToFloat64s(int16s), SampleRate, DefaultWaveletPacketOptions)
+	if err != nil {
+		return nil, err
+	}
+	return encodeSigned8(spectrogram.Coefficients, spectrogram.Options.Bins(), spectrogram.gain(), DefaultMagnitudeMapping.fixed()), nil
+}
+
+// CreateAudioFromWaveletImage creates audio from an image that was created with CreateWaveletImageFromAudio,
+// and possibly carved with CarveSeams, like CreateAudioFromMDCTImage
+func CreateAudioFromWaveletImage(img *image.RGBA) ([]int16, error) {
+	options := DefaultWaveletPacketOptions
+	s := &WaveletPacketSpectrogram{Options: options, SampleRate: SampleRate, Length: img.Bounds().Dx() * options.Bins()}
+	spectrogram, err := WaveletPacketSpectrogramFromImage(img, s.header(EncodingSigned8, DefaultMagnitudeMapping.fixed()))
+	if err != nil {
+		return nil, err
+	}
+	q := NewQuantizer(16, 1, DefaultQuantizeOptions)
+	int16s := float64sToInt16s(spectrogram.Samples(), q)
+	return int16s, q.err()
+}
@@ -0,0 +1,95 @@
+package wavecarve
+
+import "testing"
+
+func TestWaveletPacketPerfectReconstruction(t *testing.T) {
+	tests := []struct {
+		name    string
+		options WaveletPacketOptions
+	}{
+		{"default", DefaultWaveletPacketOptions},
+		{"haar", WaveletPacketOptions{Wavelet: Wavelet{WaveletDaubechies, 1}, Level: 4}},
+		{"db4", WaveletPacketOptions{Wavelet: Wavelet{WaveletDaubechies, 4}, Level: 6}},
+		{"db10", WaveletPacketOptions{Wavelet: Wavelet{WaveletDaubechies, 10}, Level: 3}},
+		{"sym4", WaveletPacketOptions{Wavelet: Wavelet{WaveletSymlet, 4}, Level: 5}},
+	}
+	audio := testAudio(2)
+	for _, tt := range tests {
+		spectrograms, err := audio.WaveletPacketSpectrograms(tt.options)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		recreated, err := AudioFromWaveletPacketSpectrograms(spectrograms)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if difference := maxDifference(audio, recreated); difference > 1e-12 {
+			t.Errorf("%s: the largest difference is %g", tt.name, difference)
+		}
+	}
+}
+
+func TestWaveletPacketImageRoundTrip(t *testing.T) {
+	tests := []struct {
+		encoding  ImageEncoding
+		tolerance float64
+	}{
+		{EncodingSigned8, 0.05},
+		{EncodingSigned16, 5e-4},
+	}
+	audio := testAudio(1)
+	spectrograms, err := audio.WaveletPacketSpectrograms(DefaultWaveletPacketOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		imgs, header, err := WaveletPacketSpectrogramImages(spectrograms, tt.encoding)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.encoding, err)
+		}
+		spectrogram, err := WaveletPacketSpectrogramFromImage(pngRoundTrip(t, imgs[0]), header)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.encoding, err)
+		}
+		recreated := audio.WithChannels([][]float64{spectrogram.Samples()})
+		if difference := maxDifference(audio, recreated); difference > tt.tolerance {
+			t.Errorf("%s: the largest difference is %g", tt.encoding, difference)
+		}
+	}
+}
+
+func TestCarveWaveletPacketSpectrograms(t *testing.T) {
+	audio := testAudio(2)
+	spectrograms, err := audio.WaveletPacketSpectrograms(WaveletPacketOptions{Wavelet: Wavelet{WaveletDaubechies, 4}, Level: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	width := spectrograms[0].Columns()
+	tests := []struct {
+		name       string
+		percentage float64
+		columns    int
+	}{
+		{"unchanged", 100, width},
+		{"half", 50, width / 2},
+		{"one column", oneColumn(width), 1},
+	}
+	for _, tt := range tests {
+		carved, err := CarveWaveletPacketSpectrograms(spectrograms, tt.percentage)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for c, s := range carved {
+			if s.Columns() != tt.columns {
+				t.Errorf("%s: channel %d has %d columns, want %d", tt.name, c, s.Columns(), tt.columns)
+			}
+		}
+		recreated, err := AudioFromWaveletPacketSpectrograms(carved)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if recreated.NumChannels() != 2 || recreated.Frames() > audio.Frames() {
+			t.Errorf("%s: got %d channels of %d samples from %d samples", tt.name, recreated.NumChannels(), recreated.Frames(), audio.Frames())
+		}
+	}
+}
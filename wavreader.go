@@ -0,0 +1,342 @@
+package wavecarve
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// RIFFChunk describes a chunk that was found while walking a RIFF file
+type RIFFChunk struct {
+	ID     [4]byte
+	Size   uint64 // for RF64 and BW64 files, this is the size from the ds64 chunk, if needed
+	Offset int64  // the position of the chunk data, relative to the start of the file
+}
+
+// String returns the four character ID of the chunk
+func (c RIFFChunk) String() string {
+	return string(c.ID[:])
+}
+
+// ds64 holds the 64-bit sizes from the ds64 chunk of an RF64 or BW64 file
+type ds64 struct {
+	dataSize uint64
+	table    map[[4]byte]uint64 // sizes of other chunks that are larger than 4 GiB
+}
+
+// WavReader reads the audio data of a .wav file in blocks, from any io.Reader.
+// The chunks are walked by ID and size, so that the "fmt " and "data" chunks
+// are found wherever they are. RF64 and BW64 files, which can be larger than
+// 4 GiB, are also supported.
+type WavReader struct {
+	r         io.Reader
+	header    WAVHeader
+	chunks    []RIFFChunk
+	ds64      *ds64 // only set for RF64 and BW64 files
+	offset    int64 // the current position in the stream
+	remaining int64 // the number of bytes left to read from the data chunk
+	dataSize  int64 // the size of the data chunk
+	dataPad   bool  // true if the data chunk is followed by a pad byte
+	dataDone  bool  // true if all of the data chunk has been read or skipped
+	truncated *ErrTruncated
+}
+
+// NewWavReader reads the RIFF header and walks the chunks of r up to the
+// start of the "data" chunk. The audio data can then be read with ReadSamples.
+func NewWavReader(r io.Reader) (*WavReader, error) {
+	wr := &WavReader{r: r}
+	h := &wr.header
+
+	// Read the RIFF header
+	var riffHeader [12]byte
+	if _, err := io.ReadFull(r, riffHeader[:]); err != nil {
+		return nil, err
+	}
+	copy(h.ChunkID[:], riffHeader[0:4])
+	h.ChunkSize = binary.LittleEndian.Uint32(riffHeader[4:8])
+	copy(h.Format[:], riffHeader[8:12])
+	wr.offset = 12
+	switch string(h.ChunkID[:]) {
+	case "RIFF":
+	case "RF64", "BW64":
+		// The first chunk must be a ds64 chunk with the 64-bit sizes
+		wr.ds64 = &ds64{}
+	default:
+		return nil, ErrNotRIFF
+	}
+	if string(h.Format[:]) != "WAVE" {
+		return nil, ErrNotWAVE
+	}
+
+	// Walk the chunks until the start of the data chunk
+	found, err := wr.walkChunks(true)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("no data chunk found")
+	}
+	return wr, nil
+}
+
+// walkChunks reads chunks until the end of the stream, or until the start of
+// the data chunk if untilData is true. Returns true if the data chunk was found.
+func (wr *WavReader) walkChunks(untilData bool) (bool, error) {
+	h := &wr.header
+	for {
+		// Read the chunk ID and size
+		var chunkHeader [8]byte
+		if _, err := io.ReadFull(wr.r, chunkHeader[:]); err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		var chunk RIFFChunk
+		copy(chunk.ID[:], chunkHeader[0:4])
+		size32 := binary.LittleEndian.Uint32(chunkHeader[4:8])
+		chunk.Size = uint64(size32)
+		chunk.Offset = wr.offset + 8
+		wr.offset += 8
+
+		// For RF64 and BW64 files, a size of 0xFFFFFFFF means that the size is in the ds64 chunk
+		if wr.ds64 != nil && size32 == math.MaxUint32 {
+			if chunk.String() == "data" {
+				chunk.Size = wr.ds64.dataSize
+			} else if size, ok := wr.ds64.table[chunk.ID]; ok {
+				chunk.Size = size
+			}
+		}
+		// For RF64 and BW64 files, the sizes are in the ds64 chunk, which must come first
+		if wr.ds64 != nil && len(wr.chunks) == 0 && chunk.String() != "ds64" {
+			return false, fmt.Errorf("the %s file has no ds64 chunk", string(h.ChunkID[:]))
+		}
+		wr.chunks = append(wr.chunks, chunk)
+
+		// Chunks with an odd size are followed by a pad byte
+		paddedSize := int64(chunk.Size) + int64(chunk.Size&1)
+
+		switch {
+		case chunk.String() == "ds64" && wr.ds64 != nil:
+			if chunk.Size < 28 || chunk.Size > maxFormatChunkSize {
+				return false, fmt.Errorf("invalid ds64 chunk size: %d bytes", chunk.Size)
+			}
+			ds64Data := make([]byte, paddedSize)
+			n, err := io.ReadFull(wr.r, ds64Data)
+			if err != nil {
+				return false, err
+			}
+			wr.offset += int64(n)
+			// The first 8 bytes are the RIFF size, and 16:24 is the sample count
+			wr.ds64.dataSize = binary.LittleEndian.Uint64(ds64Data[8:16])
+			tableLength := int(binary.LittleEndian.Uint32(ds64Data[24:28]))
+			wr.ds64.table = make(map[[4]byte]uint64)
+			for i := 0; i < tableLength && 28+i*12+12 <= int(chunk.Size); i++ {
+				entry := ds64Data[28+i*12 : 28+i*12+12]
+				var id [4]byte
+				copy(id[:], entry[0:4])
+				wr.ds64.table[id] = binary.LittleEndian.Uint64(entry[4:12])
+			}
+		case chunk.String() == "fmt ":
+			if chunk.Size < 16 || chunk.Size > maxFormatChunkSize {
+				return false, fmt.Errorf("invalid fmt chunk size: %d bytes", chunk.Size)
+			}
+			fmtData := make([]byte, paddedSize)
+			n, err := io.ReadFull(wr.r, fmtData)
+			if err != nil && !(err == io.ErrUnexpectedEOF && int64(n) >= int64(chunk.Size)) {
+				return false, err
+			}
+			wr.offset += int64(n)
+			h.Subchunk1ID = chunk.ID
+			h.Subchunk1Size = size32
+			h.AudioFormat = binary.LittleEndian.Uint16(fmtData[0:2])
+			h.NumChannels = binary.LittleEndian.Uint16(fmtData[2:4])
+			h.SampleRate = binary.LittleEndian.Uint32(fmtData[4:8])
+			h.ByteRate = binary.LittleEndian.Uint32(fmtData[8:12])
+			h.BlockAlign = binary.LittleEndian.Uint16(fmtData[12:14])
+			h.BitsPerSample = binary.LittleEndian.Uint16(fmtData[14:16])
+			if h.AudioFormat == WaveFormatExtensible {
+				if chunk.Size < 40 {
+					return false, fmt.Errorf("the fmt chunk is too small for WAVE_FORMAT_EXTENSIBLE: %d bytes", chunk.Size)
+				}
+				h.ValidBitsPerSample = binary.LittleEndian.Uint16(fmtData[18:20])
+				h.ChannelMask = binary.LittleEndian.Uint32(fmtData[20:24])
+				copy(h.SubFormat[:], fmtData[24:40])
+			}
+			if h.NumChannels == 0 || h.BlockAlign == 0 || h.BlockAlign%h.NumChannels != 0 {
+				return false, fmt.Errorf("invalid fmt chunk: %d channels with a block alignment of %d", h.NumChannels, h.BlockAlign)
+			}
+		case chunk.String() == "data" && untilData:
+			if h.Subchunk1ID != [4]byte{'f', 'm', 't', ' '} {
+				return false, errors.New("the data chunk comes before the fmt chunk")
+			}
+			h.Subchunk2ID = chunk.ID
+			h.Subchunk2Size = size32
+			wr.remaining = int64(chunk.Size)
+			wr.dataSize = int64(chunk.Size)
+			wr.dataPad = chunk.Size&1 == 1
+			return true, nil
+		case isMetadataChunk(chunk.String()) && int64(chunk.Size) <= MaxMetadataChunkSize:
+			metadataData := make([]byte, paddedSize)
+			n, err := io.ReadFull(wr.r, metadataData)
+			wr.offset += int64(n)
+			if err != nil && !(err == io.ErrUnexpectedEOF && int64(n) >= int64(chunk.Size)) {
+				return false, err
+			}
+			if h.Metadata == nil {
+				h.Metadata = NewWavMetadata()
+			}
+			h.Metadata.parseChunk(chunk.String(), metadataData[:chunk.Size])
+		default:
+			// Skip the chunks that are written by WavWriter, padding, and chunks that are too large to be kept
+			n, err := io.CopyN(io.Discard, wr.r, paddedSize)
+			wr.offset += n
+			if err == io.EOF && n >= int64(chunk.Size) {
+				// A missing pad byte at the end of the file is fine
+				return false, nil
+			} else if err != nil {
+				return false, err
+			}
+		}
+	}
+}
+
+// Header returns the header of the .wav file, as described by the "fmt " and "data" chunks
+func (wr *WavReader) Header() WAVHeader {
+	return wr.header
+}
+
+// DataSize returns the size of the audio data in bytes. For RF64 and BW64
+// files, this is the 64-bit size from the ds64 chunk.
+func (wr *WavReader) DataSize() uint64 {
+	for _, chunk := range wr.chunks {
+		if chunk.String() == "data" {
+			return chunk.Size
+		}
+	}
+	return 0
+}
+
+// Metadata returns the metadata that has been found so far, or nil.
+// Metadata that follows the data chunk is only included after ReadTrailingChunks has been called.
+func (wr *WavReader) Metadata() *WavMetadata {
+	return wr.header.Metadata
+}
+
+// Chunks returns the chunks that have been found so far. Chunks that follow
+// the data chunk are only included after ReadTrailingChunks has been called.
+func (wr *WavReader) Chunks() []RIFFChunk {
+	return wr.chunks
+}
+
+// readData reads raw audio data from the data chunk, up to len(p) bytes.
+// Returns io.EOF when there is no more audio data. If the stream ends
+// before the data chunk does, the audio data that is there is returned,
+// and the next call returns an ErrTruncated error instead of io.EOF.
+func (wr *WavReader) readData(p []byte) (int, error) {
+	if wr.remaining <= 0 {
+		if wr.truncated != nil {
+			return 0, *wr.truncated
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > wr.remaining {
+		p = p[:wr.remaining]
+	}
+	n, err := io.ReadFull(wr.r, p)
+	wr.offset += int64(n)
+	wr.remaining -= int64(n)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		// The stream ends before the data chunk does, keep what is there
+		wr.setTruncated()
+		if n == 0 {
+			return 0, *wr.truncated
+		}
+		return n, nil
+	}
+	return n, err
+}
+
+// setTruncated records that the stream ended before the data chunk did
+func (wr *WavReader) setTruncated() {
+	got := wr.dataSize - wr.remaining
+	wr.truncated = &ErrTruncated{Want: wr.dataSize, Got: got}
+	wr.remaining = 0
+	wr.dataDone = true
+}
+
+// readAllData reads the rest of the data chunk. If the stream ends before the
+// data chunk does, the audio data that is there is returned together with an
+// ErrTruncated error.
+func (wr *WavReader) readAllData() ([]byte, error) {
+	// The data is not allocated up front, since the size in the file can not be trusted
+	limit := wr.remaining
+	if limit > MaxDataSize {
+		limit = MaxDataSize + 1
+	}
+	data, err := io.ReadAll(io.LimitReader(wr.r, limit))
+	wr.offset += int64(len(data))
+	wr.remaining -= int64(len(data))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > MaxDataSize {
+		return nil, fmt.Errorf("the audio data is too large to be read into memory (the limit is %d bytes)", MaxDataSize)
+	}
+	if wr.remaining > 0 {
+		wr.setTruncated()
+		return data, *wr.truncated
+	}
+	return data, nil
+}
+
+// ReadSamples reads up to the given number of sample frames and returns one
+// slice of samples in the range [-1, 1] per channel. Returns io.EOF when
+// there is no more audio data, or an ErrTruncated error if the stream
+// ended before the data chunk did.
+func (wr *WavReader) ReadSamples(frames int) ([][]float64, error) {
+	h := wr.header
+	blockAlign := int(h.BlockAlign)
+	if blockAlign < 1 {
+		return nil, fmt.Errorf("invalid block alignment: %d", blockAlign)
+	}
+	if frames < 1 {
+		return nil, fmt.Errorf("invalid number of sample frames: %d", frames)
+	}
+	buf := make([]byte, frames*blockAlign)
+	n, err := wr.readData(buf)
+	if err != nil {
+		return nil, err
+	}
+	// Only whole sample frames are used
+	buf = buf[:n-n%blockAlign]
+	float64s, err := bytesToFloat64s(buf, h.SampleFormat(), h.BytesPerSample(), binary.LittleEndian, true)
+	if err != nil {
+		return nil, err
+	}
+	return DeinterleaveFloat64s(float64s, int(h.NumChannels)), nil
+}
+
+// ReadTrailingChunks skips any audio data that has not been read yet and
+// walks the chunks that follow the data chunk, so that they are included in Chunks.
+func (wr *WavReader) ReadTrailingChunks() error {
+	if wr.dataDone {
+		return nil
+	}
+	skip := wr.remaining
+	if wr.dataPad {
+		skip++
+	}
+	n, err := io.CopyN(io.Discard, wr.r, skip)
+	wr.offset += n
+	wr.remaining = 0
+	wr.dataDone = true
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_, err = wr.walkChunks(false)
+	return err
+}
@@ -0,0 +1,184 @@
+package wavecarve
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// riffFile returns a RIFF file with the given form type and chunks
+func riffFile(format string, chunks ...[]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	size := 4
+	for _, chunk := range chunks {
+		size += len(chunk)
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(size))
+	buf.WriteString(format)
+	for _, chunk := range chunks {
+		buf.Write(chunk)
+	}
+	return buf.Bytes()
+}
+
+// riffChunk returns a chunk with the given ID and data, followed by a pad byte if needed
+func riffChunk(id string, data []byte) []byte {
+	var buf bytes.Buffer
+	appendChunk(&buf, id, data)
+	return buf.Bytes()
+}
+
+// fmtChunk returns a fmt chunk for the given sample format
+func fmtChunk(audioFormat, numChannels uint16, bitsPerSample uint16) []byte {
+	h := NewWAVHeader(audioFormat, numChannels, 16000, bitsPerSample)
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint16(data[0:2], h.AudioFormat)
+	binary.LittleEndian.PutUint16(data[2:4], h.NumChannels)
+	binary.LittleEndian.PutUint32(data[4:8], h.SampleRate)
+	binary.LittleEndian.PutUint32(data[8:12], h.ByteRate)
+	binary.LittleEndian.PutUint16(data[12:14], h.BlockAlign)
+	binary.LittleEndian.PutUint16(data[14:16], h.BitsPerSample)
+	return riffChunk("fmt ", data)
+}
+
+// writeTestFile writes the data to a file in a temporary directory and returns its path
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filePath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func TestReadWavFileErrors(t *testing.T) {
+	pcm16 := fmtChunk(WaveFormatPCM, 1, 16)
+	audioData := make([]byte, 100)
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+		frames  int // the number of sample frames that are returned together with the error
+	}{
+		{"empty file", nil, nil, 0},
+		{"truncated RIFF header", []byte("RIFF\x10\x00"), nil, 0},
+		{"not RIFF", append([]byte("FORM\x00\x00\x00\x04"), "AIFF"...), ErrNotRIFF, 0},
+		{"not WAVE", riffFile("AVI ", pcm16), ErrNotWAVE, 0},
+		{"no data chunk", riffFile("WAVE", pcm16), nil, 0},
+		{"data before fmt", riffFile("WAVE", riffChunk("data", audioData), pcm16), nil, 0},
+		{"RF64 without a ds64 chunk", append([]byte("RF64\xff\xff\xff\xff"), riffFile("WAVE", pcm16, append([]byte("data\xff\xff\xff\xff"), audioData...))[8:]...), nil, 0},
+		{"truncated fmt chunk", riffFile("WAVE", pcm16[:14]), nil, 0},
+		{"fmt chunk that is too small", riffFile("WAVE", riffChunk("fmt ", make([]byte, 8))), nil, 0},
+		{"fmt chunk that is too large", riffFile("WAVE", append([]byte("fmt \x00\x00\x00\x01"), pcm16[8:]...)), nil, 0},
+		{"unsupported sample format", riffFile("WAVE", fmtChunk(2, 1, 4), riffChunk("data", audioData)), ErrUnsupportedFormat{AudioFormat: 2, Bits: 8}, 0},
+		{"24-bit float", riffFile("WAVE", fmtChunk(WaveFormatIEEEFloat, 1, 24), riffChunk("data", make([]byte, 99))), ErrUnsupportedFormat{AudioFormat: WaveFormatIEEEFloat, Bits: 24}, 0},
+		{"truncated data chunk", riffFile("WAVE", pcm16, riffChunk("data", audioData))[:44+50], ErrTruncated{Want: 100, Got: 50}, 25},
+		{"data chunk that is larger than the file", riffFile("WAVE", pcm16, append([]byte("data\xff\xff\xff\x7f"), audioData...)), ErrTruncated{Want: 0x7fffffff, Got: 100}, 50},
+	}
+	for _, tt := range tests {
+		channels, _, err := ReadWavFileFloat64(writeTestFile(t, "test.wav", tt.data))
+		if err == nil {
+			t.Errorf("%s: no error", tt.name)
+			continue
+		}
+		if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got the error %q, want %q", tt.name, err, tt.wantErr)
+		}
+		frames := 0
+		if channels != nil {
+			frames = len(channels[0])
+		}
+		if frames != tt.frames {
+			t.Errorf("%s: got %d sample frames together with the error, want %d", tt.name, frames, tt.frames)
+		}
+	}
+}
+
+func TestReadWavFilePadBytes(t *testing.T) {
+	// An 8-bit mono file with odd sized chunks before and after the odd sized data chunk
+	audioData := []byte{0x80, 0xc0, 0x40}
+	data := riffFile("WAVE",
+		riffChunk("junk", []byte{1, 2, 3}),
+		fmtChunk(WaveFormatPCM, 1, 8),
+		riffChunk("data", audioData),
+		riffChunk("iXML", []byte("<BWFXML/>")),
+	)
+	if len(data)%2 != 0 {
+		t.Fatalf("the test file has an odd size: %d bytes", len(data))
+	}
+	filePath := writeTestFile(t, "test.wav", data)
+	channels, header, err := ReadWavFileFloat64(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{0, 0.5, -0.5}; len(channels) != 1 || len(channels[0]) != len(want) {
+		t.Fatalf("got %d channel(s), want 1 channel with %d samples", len(channels), len(want))
+	} else {
+		for i, sample := range channels[0] {
+			if sample != want[i] {
+				t.Errorf("sample %d is %v, want %v", i, sample, want[i])
+			}
+		}
+	}
+	if header.Metadata == nil || header.Metadata.IXML != "<BWFXML/>" {
+		t.Errorf("the iXML chunk after the pad byte of the data chunk was not read: %+v", header.Metadata)
+	}
+	chunks, err := ReadWavChunks(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, chunk := range chunks {
+		ids = append(ids, chunk.String())
+	}
+	if got := strings.Join(ids, ","); got != "junk,fmt ,data,iXML" {
+		t.Errorf("got the chunks %q", got)
+	}
+	// The pad byte of the last chunk may be missing
+	if _, err := ReadWavChunks(writeTestFile(t, "unpadded.wav", data[:len(data)-1])); err != nil {
+		t.Errorf("a missing pad byte at the end of the file: %v", err)
+	}
+}
+
+func TestReadWavFileLimits(t *testing.T) {
+	defer func(maxDataSize, maxMetadataChunkSize int64) {
+		MaxDataSize = maxDataSize
+		MaxMetadataChunkSize = maxMetadataChunkSize
+	}(MaxDataSize, MaxMetadataChunkSize)
+	MaxDataSize = 1000
+	MaxMetadataChunkSize = 100
+
+	pcm16 := fmtChunk(WaveFormatPCM, 1, 16)
+	ixml := riffChunk("iXML", []byte(strings.Repeat("x", 101)))
+
+	// A data chunk that is larger than MaxDataSize
+	_, _, err := ReadWavFileFloat64(writeTestFile(t, "large.wav", riffFile("WAVE", pcm16, riffChunk("data", make([]byte, 1002)))))
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("a data chunk that is larger than MaxDataSize: got the error %v", err)
+	}
+
+	// A data chunk that claims to be larger than MaxDataSize, but is not, is read as a truncated file
+	channels, _, err := ReadWavFileFloat64(writeTestFile(t, "claimed.wav", riffFile("WAVE", pcm16, append([]byte("data\xff\xff\xff\x7f"), make([]byte, 100)...))))
+	if !errors.As(err, new(ErrTruncated)) || len(channels) != 1 || len(channels[0]) != 50 {
+		t.Errorf("a data chunk that claims to be larger than MaxDataSize: got the error %v", err)
+	}
+
+	// Metadata chunks that are larger than MaxMetadataChunkSize are skipped
+	for _, data := range [][]byte{
+		riffFile("WAVE", ixml, pcm16, riffChunk("data", make([]byte, 100))),
+		riffFile("WAVE", pcm16, riffChunk("data", make([]byte, 100)), ixml),
+	} {
+		_, header, err := ReadWavFileFloat64(writeTestFile(t, "metadata.wav", data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Metadata != nil && header.Metadata.IXML != "" {
+			t.Error("a metadata chunk that is larger than MaxMetadataChunkSize was parsed")
+		}
+	}
+}
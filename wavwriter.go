@@ -0,0 +1,221 @@
+package wavecarve
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// WavWriter writes audio data to a .wav file in blocks. The sizes in the
+// header are written when the writer is closed. If the file ends up larger
+// than what fits in the 32-bit sizes of a RIFF file, it is written as an RF64 file.
+type WavWriter struct {
+	w        io.WriteSeeker
+	header   WAVHeader
+	start    int64 // the position of the RIFF header
+	dataSize int64 // the number of bytes of audio data written so far
+	closed   bool
+
+	quantizer *Quantizer // converts the samples given to WriteSamples to integers
+}
+
+// rf64Threshold is the largest RIFF size that is written as a regular RIFF file
+var rf64Threshold uint64 = math.MaxUint32
+
+// ds64Size is the size of a ds64 chunk without a table, which is also the
+// size of the JUNK chunk that reserves space for it
+const ds64Size = 28
+
+// writeWavHeader writes the RIFF header, a JUNK or ds64 chunk, a fmt chunk that
+// matches the audio format of the given header, a fact chunk with the number of
+// sample frames if the samples are not PCM, and the start of the data chunk.
+// trailingSize is the size of the chunks that follow the data chunk.
+// If the sizes do not fit in 32 bits, or if the ChunkID of the header is
+// "RF64" or "BW64", an RF64 (or BW64) header with a ds64 chunk is written.
+func writeWavHeader(w io.Writer, header WAVHeader, dataSize, trailingSize uint64) error {
+	// The size of the fmt chunk depends on the audio format
+	var fmtSize uint32 = 16
+	switch header.AudioFormat {
+	case WaveFormatPCM:
+	case WaveFormatExtensible:
+		fmtSize = 40
+	default:
+		fmtSize = 18
+	}
+
+	// The WAVE specification requires a fact chunk for formats other than PCM, like IEEE float
+	var factSize uint64
+	if header.SampleFormat() != WaveFormatPCM {
+		factSize = 8 + 4
+	}
+
+	// 4 (Format) + (8 + ds64Size) + (8 + Subchunk1Size) + fact chunk + (8 + Subchunk2Size, including the pad byte) + trailing chunks
+	riffSize := 4 + (8 + ds64Size) + (8 + uint64(fmtSize)) + factSize + (8 + dataSize + dataSize&1) + trailingSize
+
+	fmtData := make([]byte, fmtSize)
+	binary.LittleEndian.PutUint16(fmtData[0:2], header.AudioFormat)
+	binary.LittleEndian.PutUint16(fmtData[2:4], header.NumChannels)
+	binary.LittleEndian.PutUint32(fmtData[4:8], header.SampleRate)
+	binary.LittleEndian.PutUint32(fmtData[8:12], header.ByteRate)
+	binary.LittleEndian.PutUint16(fmtData[12:14], header.BlockAlign)
+	binary.LittleEndian.PutUint16(fmtData[14:16], header.BitsPerSample)
+	if fmtSize == 40 {
+		binary.LittleEndian.PutUint16(fmtData[16:18], 22) // the size of the extension
+		binary.LittleEndian.PutUint16(fmtData[18:20], header.ValidBitsPerSample)
+		binary.LittleEndian.PutUint32(fmtData[20:24], header.ChannelMask)
+		copy(fmtData[24:40], header.SubFormat[:])
+	}
+
+	// Either reserve space for a ds64 chunk with a JUNK chunk, or write the ds64 chunk
+	riffID, junkID := "RIFF", "JUNK"
+	riffSize32, dataSize32 := uint32(riffSize), uint32(dataSize)
+	var sampleCount uint64
+	if header.BlockAlign > 0 {
+		sampleCount = dataSize / uint64(header.BlockAlign)
+	}
+	sampleCount32 := uint32(sampleCount)
+	ds64Data := make([]byte, ds64Size)
+	if id := string(header.ChunkID[:]); id == "RF64" || id == "BW64" || riffSize > rf64Threshold {
+		riffID, junkID = "RF64", "ds64"
+		if id == "BW64" {
+			riffID = "BW64"
+		}
+		riffSize32, dataSize32, sampleCount32 = math.MaxUint32, math.MaxUint32, math.MaxUint32
+		binary.LittleEndian.PutUint64(ds64Data[0:8], riffSize)
+		binary.LittleEndian.PutUint64(ds64Data[8:16], dataSize)
+		binary.LittleEndian.PutUint64(ds64Data[16:24], sampleCount)
+	}
+
+	chunks := []interface{}{
+		[]byte(riffID), riffSize32, []byte("WAVE"),
+		[]byte(junkID), uint32(ds64Size), ds64Data,
+		[]byte("fmt "), fmtSize, fmtData,
+	}
+	if factSize > 0 {
+		chunks = append(chunks, []byte("fact"), uint32(4), sampleCount32)
+	}
+	chunks = append(chunks, []byte("data"), dataSize32)
+	for _, v := range chunks {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewWavWriter writes a .wav header for the audio format given in the header
+// (sample format, bit depth, channel count and sample rate) to w.
+// The audio data can then be written with WriteSamples, and the writer must
+// be closed with Close, which updates the sizes in the header.
+// Integer samples are quantized with DefaultQuantizeOptions.
+func NewWavWriter(w io.WriteSeeker, header WAVHeader) (*WavWriter, error) {
+	return NewWavWriterWithOptions(w, header, DefaultQuantizeOptions)
+}
+
+// NewWavWriterWithOptions is like NewWavWriter, but integer samples are
+// quantized with the given clipping, dither and noise shaping options.
+func NewWavWriterWithOptions(w io.WriteSeeker, header WAVHeader, options QuantizeOptions) (*WavWriter, error) {
+	if header.NumChannels < 1 {
+		return nil, errors.New("the number of channels must be at least 1")
+	}
+	// Update the values that depend on the channel count and the bit depth
+	header.BlockAlign = header.NumChannels * ((header.BitsPerSample + 7) / 8)
+	header.ByteRate = header.SampleRate * uint32(header.BlockAlign)
+	if err := checkSampleFormat(header.SampleFormat(), header.BytesPerSample()); err != nil {
+		return nil, err
+	}
+
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeWavHeader(w, header, 0, 0); err != nil {
+		return nil, err
+	}
+	quantizer := NewQuantizer(header.BytesPerSample()*8, int(header.NumChannels), options)
+	return &WavWriter{w: w, header: header, start: start, quantizer: quantizer}, nil
+}
+
+// Header returns the header that is used for writing the .wav file
+func (ww *WavWriter) Header() WAVHeader {
+	return ww.header
+}
+
+// writeData writes raw audio data to the data chunk
+func (ww *WavWriter) writeData(p []byte) error {
+	if ww.closed {
+		return errors.New("the wav writer is closed")
+	}
+	n, err := ww.w.Write(p)
+	ww.dataSize += int64(n)
+	return err
+}
+
+// WriteSamples writes one slice of samples in the range [-1, 1] per channel.
+// The samples are converted to the sample format given in the header, with the
+// QuantizeOptions that were given when the writer was created. Samples outside
+// of the range [-1, 1] are clipped, and counted by Clipped.
+func (ww *WavWriter) WriteSamples(channels [][]float64) error {
+	h := ww.header
+	if len(channels) != int(h.NumChannels) {
+		return fmt.Errorf("got %d channels, but the header says %d", len(channels), h.NumChannels)
+	}
+	bytes, err := float64sToBytes(InterleaveFloat64s(channels), h.SampleFormat(), h.BytesPerSample(), binary.LittleEndian, true, ww.quantizer)
+	if err != nil {
+		return err
+	}
+	return ww.writeData(bytes)
+}
+
+// Clipped returns the number of samples given to WriteSamples so far that were outside of the range [-1, 1]
+func (ww *WavWriter) Clipped() int {
+	return ww.quantizer.Clipped()
+}
+
+// SetMetadata sets the metadata that is written after the audio data when the writer is closed
+func (ww *WavWriter) SetMetadata(metadata *WavMetadata) {
+	ww.header.Metadata = metadata
+}
+
+// Close writes the pad byte of the data chunk, if needed, followed by the
+// metadata chunks, and updates the sizes in the header.
+// The underlying io.WriteSeeker is not closed.
+func (ww *WavWriter) Close() error {
+	if ww.closed {
+		return nil
+	}
+	ww.closed = true
+
+	// Chunks with an odd size are followed by a pad byte
+	if ww.dataSize%2 == 1 {
+		if _, err := ww.w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+
+	// Write the metadata chunks
+	var trailing []byte
+	if ww.header.Metadata != nil {
+		trailing = ww.header.Metadata.encodeChunks()
+		if _, err := ww.w.Write(trailing); err != nil {
+			return err
+		}
+	}
+
+	end, err := ww.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	// Rewrite the header, now that the size of the data is known
+	if _, err := ww.w.Seek(ww.start, io.SeekStart); err != nil {
+		return err
+	}
+	if err := writeWavHeader(ww.w, ww.header, uint64(ww.dataSize), uint64(len(trailing))); err != nil {
+		return err
+	}
+	_, err = ww.w.Seek(end, io.SeekStart)
+	return err
+}
@@ -0,0 +1,83 @@
+package wavecarve
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteRF64(t *testing.T) {
+	defer func(threshold uint64) { rf64Threshold = threshold }(rf64Threshold)
+	rf64Threshold = 1000
+
+	tests := []struct {
+		name      string
+		frames    int
+		metadata  *WavMetadata
+		wantRF64  bool
+		wantChunk string // the ID of the chunk that reserves the space for the ds64 chunk
+	}{
+		{"below the threshold", 100, nil, false, "JUNK"},
+		{"above the threshold", 1001, nil, true, "ds64"},
+		{"above the threshold with metadata", 1001, &WavMetadata{IXML: "<BWFXML/>"}, true, "ds64"},
+	}
+	le := binary.LittleEndian
+	for _, tt := range tests {
+		audio := testAudio(2)
+		channels := [][]float64{audio.Channels[0][:tt.frames], audio.Channels[1][:tt.frames]}
+		header := NewWAVHeader(WaveFormatPCM, 2, 16000, 16)
+		header.Metadata = tt.metadata
+		filePath := filepath.Join(t.TempDir(), "test.wav")
+		if err := WriteWavFileFloat64(filePath, channels, header); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The RIFF header and the ds64 chunk, which is where the JUNK chunk is for a regular RIFF file
+		riffSize, dataSize := uint64(le.Uint32(data[4:8])), uint64(le.Uint32(data[76:80]))
+		if got := string(data[72:76]); got != "data" {
+			t.Fatalf("%s: got the chunk %q where the data chunk should be", tt.name, got)
+		}
+		if got := string(data[12:16]); got != tt.wantChunk {
+			t.Errorf("%s: the first chunk is %q, want %q", tt.name, got, tt.wantChunk)
+		}
+		if tt.wantRF64 {
+			if string(data[0:4]) != "RF64" || riffSize != math.MaxUint32 || dataSize != math.MaxUint32 {
+				t.Errorf("%s: got %q with the sizes 0x%x and 0x%x, want RF64 with the sizes in the ds64 chunk", tt.name, data[0:4], riffSize, dataSize)
+			}
+			riffSize, dataSize = le.Uint64(data[20:28]), le.Uint64(data[28:36])
+			if sampleCount := le.Uint64(data[36:44]); sampleCount != uint64(tt.frames) {
+				t.Errorf("%s: the ds64 chunk gives %d sample frames, want %d", tt.name, sampleCount, tt.frames)
+			}
+		} else if string(data[0:4]) != "RIFF" {
+			t.Errorf("%s: got %q, want RIFF", tt.name, data[0:4])
+		}
+		if riffSize != uint64(len(data)-8) || dataSize != uint64(tt.frames*4) {
+			t.Errorf("%s: got the sizes %d and %d, want %d and %d", tt.name, riffSize, dataSize, len(data)-8, tt.frames*4)
+		}
+
+		// The file is read back with the same samples and metadata
+		read, readHeader, err := ReadWavFileFloat64(filePath)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(read) != 2 || len(read[0]) != tt.frames {
+			t.Fatalf("%s: read %d channel(s), want 2 channels of %d samples", tt.name, len(read), tt.frames)
+		}
+		for c := range channels {
+			for i, sample := range channels[c] {
+				if math.Abs(read[c][i]-sample) > 1.0/32768 {
+					t.Fatalf("%s: sample %d of channel %d is %v, want %v", tt.name, i, c, read[c][i], sample)
+				}
+			}
+		}
+		if tt.metadata != nil && (readHeader.Metadata == nil || readHeader.Metadata.IXML != tt.metadata.IXML) {
+			t.Errorf("%s: the metadata was not read back: %+v", tt.name, readHeader.Metadata)
+		}
+	}
+}